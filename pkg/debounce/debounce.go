@@ -0,0 +1,386 @@
+// Package debounce decides when a persistent failure should trigger a
+// one-time action, such as reverting the commit a resource fails on.
+//
+// Callers report the health of a key (for example a commit SHA) with Observe.
+// A key that keeps failing for the whole window fires exactly once; a key that
+// recovers before the window expires is forgotten. Fired keys are remembered
+// as completed and never fire again until cleared or re-armed, so the same bad
+// commit is not reverted twice. All methods are safe for concurrent use.
+//
+// Windows are measured on the monotonic clock, not the wall clock: with
+// clock.RealClock an NTP step or a VM pause that moves the wall clock neither
+// fires a window early nor stalls it. Wall-clock times are only reported, in
+// Decision and Pending. A clock without monotonic readings that goes back,
+// e.g. a fake clock in tests, counts as no time passing.
+//
+//	d := debounce.New(5*time.Minute, clock.RealClock{})
+//	switch dec := d.Observe(sha, !ready); dec.Action {
+//	case debounce.Detected, debounce.Waiting:
+//		return ctrl.Result{RequeueAfter: dec.RequeueAfter}, nil
+//	case debounce.Fire:
+//		revert(sha)
+//	}
+package debounce
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// Action is what Observe decided for a key.
+type Action int
+
+const (
+	// None: the key is healthy and was not pending, or it failed but has
+	// already fired. Nothing to do.
+	None Action = iota
+	// Detected: the key failed for the first time; its window started.
+	Detected
+	// Waiting: the key is still failing within its window.
+	Waiting
+	// Fire: the key failed for the whole window. The caller should act now;
+	// the key is marked completed, so Fire is returned only once.
+	Fire
+	// Recovered: a pending key became healthy before its window expired and
+	// was forgotten.
+	Recovered
+)
+
+func (a Action) String() string {
+	switch a {
+	case Detected:
+		return "Detected"
+	case Waiting:
+		return "Waiting"
+	case Fire:
+		return "Fire"
+	case Recovered:
+		return "Recovered"
+	}
+	return "None"
+}
+
+// Decision is the result of Observe.
+type Decision struct {
+	Action Action
+	// FirstSeen is when the key started failing, for Detected, Waiting, Fire
+	// and Recovered.
+	FirstSeen time.Time
+	// RequeueAfter is how long until the window expires, for Detected and
+	// Waiting. Observe the key again then to get Fire.
+	RequeueAfter time.Duration
+}
+
+// Pending is a key within its debounce window.
+type Pending struct {
+	Key       string
+	FirstSeen time.Time
+	Due       time.Time // when the window expires
+}
+
+// Debouncer tracks pending and completed keys.
+type Debouncer struct {
+	window time.Duration
+	clock  clock.PassiveClock
+
+	mu sync.Mutex
+	// epoch is the clock's time at New; elapsed measures from it. The
+	// durations below are elapsed values.
+	epoch     time.Time
+	last      time.Duration            // latest elapsed value
+	pending   map[string]time.Time     // key -> wall time first seen failing
+	started   map[string]time.Duration // pending key -> when first seen failing
+	seen      map[string]time.Duration // pending key -> last failing observation
+	windows   map[string]time.Duration // pending key -> window, if not the default
+	completed map[string]time.Duration // keys that already fired -> when
+	firedAt   map[string]time.Time     // completed key -> wall time it fired
+}
+
+// New returns a Debouncer that fires after a key has been failing for window.
+// A zero window fires on the second failing observation.
+func New(window time.Duration, clk clock.PassiveClock) *Debouncer {
+	return &Debouncer{
+		window:    window,
+		clock:     clk,
+		epoch:     clk.Now(),
+		pending:   make(map[string]time.Time),
+		started:   make(map[string]time.Duration),
+		seen:      make(map[string]time.Duration),
+		windows:   make(map[string]time.Duration),
+		completed: make(map[string]time.Duration),
+		firedAt:   make(map[string]time.Time),
+	}
+}
+
+// elapsed returns the monotonic time since New. It never goes back: if the
+// clock did, the epoch moves along so that no time passed. Callers must hold
+// d.mu.
+func (d *Debouncer) elapsed() time.Duration {
+	e := d.clock.Since(d.epoch)
+	if e < d.last {
+		d.epoch = d.epoch.Add(e - d.last)
+		e = d.last
+	}
+	d.last = e
+	return e
+}
+
+// Window returns the default debounce window.
+func (d *Debouncer) Window() time.Duration {
+	return d.window
+}
+
+// windowFor returns the window of key. Callers must hold d.mu.
+func (d *Debouncer) windowFor(key string) time.Duration {
+	if w, ok := d.windows[key]; ok {
+		return w
+	}
+	return d.window
+}
+
+// drop forgets a pending key. Callers must hold d.mu.
+func (d *Debouncer) drop(key string) {
+	delete(d.pending, key)
+	delete(d.started, key)
+	delete(d.seen, key)
+	delete(d.windows, key)
+}
+
+// complete marks key as fired at now. Callers must hold d.mu.
+func (d *Debouncer) complete(key string, now time.Duration) {
+	d.completed[key] = now
+	d.firedAt[key] = d.clock.Now()
+}
+
+// Observe records whether key is currently failing and decides what to do,
+// using the default window.
+func (d *Debouncer) Observe(key string, failing bool) Decision {
+	return d.ObserveWithin(key, failing, d.window)
+}
+
+// ObserveWithin is Observe with the window for key. A pending key takes the
+// window of its latest failing observation, so a changed window shortens or
+// extends a running one without restarting it.
+func (d *Debouncer) ObserveWithin(key string, failing bool, window time.Duration) Decision {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	first, pending := d.pending[key]
+	if !failing {
+		if !pending {
+			return Decision{Action: None}
+		}
+		d.drop(key)
+		return Decision{Action: Recovered, FirstSeen: first}
+	}
+	if _, done := d.completed[key]; done {
+		return Decision{Action: None}
+	}
+	now := d.elapsed()
+	d.seen[key] = now
+	if window == d.window {
+		delete(d.windows, key)
+	} else {
+		d.windows[key] = window
+	}
+	if !pending {
+		first = d.clock.Now()
+		d.pending[key] = first
+		d.started[key] = now
+		return Decision{Action: Detected, FirstSeen: first, RequeueAfter: window}
+	}
+	if elapsed := now - d.started[key]; elapsed < window {
+		return Decision{Action: Waiting, FirstSeen: first, RequeueAfter: window - elapsed}
+	}
+	d.drop(key)
+	d.complete(key, now)
+	return Decision{Action: Fire, FirstSeen: first}
+}
+
+// Due reports whether key is pending and its window has expired, i.e. its
+// next failing observation fires.
+func (d *Debouncer) Due(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	start, ok := d.started[key]
+	return ok && d.elapsed()-start >= d.windowFor(key)
+}
+
+// Expire moves the window of a pending key back so that it fires on its next
+// failing observation. It reports whether key was pending.
+func (d *Debouncer) Expire(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[key]; !ok {
+		return false
+	}
+	w := d.windowFor(key)
+	d.pending[key] = d.clock.Now().Add(-w)
+	d.started[key] = d.elapsed() - w
+	return true
+}
+
+// Complete marks key as already fired, cancelling any pending window. Use it
+// to restore state, e.g. from actions found in an external system.
+func (d *Debouncer) Complete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drop(key)
+	d.complete(key, d.elapsed())
+}
+
+// Forget drops a pending key without firing it, e.g. when the thing failing
+// on it went away. It reports whether key was pending.
+func (d *Debouncer) Forget(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.pending[key]
+	d.drop(key)
+	return ok
+}
+
+// DropStale forgets pending keys that were not observed failing for ttl, so
+// keys nobody observes anymore don't stay pending forever. It returns the
+// dropped keys, sorted.
+func (d *Debouncer) DropStale(ttl time.Duration) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.elapsed()
+	var dropped []string
+	for k := range d.pending {
+		if now-d.seen[k] >= ttl {
+			d.drop(k)
+			dropped = append(dropped, k)
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+// Restore merges previously saved state, e.g. after a restart: pending keys
+// keep their original first-seen time, so a window that expired while the
+// process was down fires on the next failing observation. A key already
+// observed since the restart takes the earlier first-seen time, so its
+// window does not start over; completed wins over pending. Restored keys
+// count as completed or last observed now for Rearm and DropStale.
+//
+// Saved times are wall-clock times, the only ones that survive a restart, so
+// the time a key was pending before is measured on the wall clock.
+func (d *Debouncer) Restore(pending map[string]time.Time, completed []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.elapsed()
+	wall := d.clock.Now()
+	for _, k := range completed {
+		d.drop(k)
+		if _, ok := d.completed[k]; !ok {
+			d.complete(k, now)
+		}
+	}
+	for k, t := range pending {
+		_, done := d.completed[k]
+		if done {
+			continue
+		}
+		if first, ok := d.pending[k]; !ok {
+			d.pending[k] = t
+			d.started[k] = now - wall.Sub(t)
+			d.seen[k] = now
+		} else if t.Before(first) {
+			d.pending[k] = t
+			d.started[k] = now - wall.Sub(t)
+		}
+	}
+}
+
+// IsCompleted reports whether key has fired or was marked completed.
+func (d *Debouncer) IsCompleted(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.completed[key]
+	return ok
+}
+
+// ClearCompleted forgets that key fired, so it can fire again. It reports
+// whether key was completed.
+func (d *Debouncer) ClearCompleted(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.completed[key]
+	delete(d.completed, key)
+	delete(d.firedAt, key)
+	return ok
+}
+
+// Rearm forgets that key fired if it fired at least after ago, so a later
+// failure starts a new window. Call it when the key is seen healthy again,
+// e.g. after a reverted commit was re-applied and deployed. It reports
+// whether key was re-armed.
+func (d *Debouncer) Rearm(key string, after time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	at, ok := d.completed[key]
+	if !ok || d.elapsed()-at < after {
+		return false
+	}
+	delete(d.completed, key)
+	delete(d.firedAt, key)
+	return true
+}
+
+// ClearAllCompleted forgets all completed keys and returns how many there were.
+func (d *Debouncer) ClearAllCompleted() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.completed)
+	d.completed = make(map[string]time.Duration)
+	d.firedAt = make(map[string]time.Time)
+	return n
+}
+
+// Pending returns the keys within their window, soonest due first. Due is
+// the wall-clock time the window expires at if the clock doesn't jump.
+func (d *Debouncer) Pending() []Pending {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now, wall := d.elapsed(), d.clock.Now()
+	out := make([]Pending, 0, len(d.pending))
+	for k, t := range d.pending {
+		due := wall.Add(d.started[k] + d.windowFor(k) - now)
+		out = append(out, Pending{Key: k, FirstSeen: t, Due: due})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Due.Equal(out[j].Due) {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Due.Before(out[j].Due)
+	})
+	return out
+}
+
+// Completed returns the completed keys, sorted.
+func (d *Debouncer) Completed() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]string, 0, len(d.completed))
+	for k := range d.completed {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CompletedAt returns the wall-clock time each completed key fired or was
+// marked completed. Restored keys count as completed at the time of the
+// restore. The times are recorded once, so repeated calls return the same.
+func (d *Debouncer) CompletedAt() map[string]time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make(map[string]time.Time, len(d.firedAt))
+	for k, t := range d.firedAt {
+		out[k] = t
+	}
+	return out
+}
@@ -0,0 +1,295 @@
+package debounce
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestObserve(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(time.Minute, clk)
+
+	steps := []struct {
+		advance time.Duration
+		key     string
+		failing bool
+		want    Decision
+	}{
+		{0, "a", true, Decision{Action: Detected, FirstSeen: start, RequeueAfter: time.Minute}},
+		{20 * time.Second, "a", true, Decision{Action: Waiting, FirstSeen: start, RequeueAfter: 40 * time.Second}},
+		{0, "b", false, Decision{Action: None}},
+		{40 * time.Second, "a", true, Decision{Action: Fire, FirstSeen: start}},
+		{0, "a", true, Decision{Action: None}},
+		{0, "a", false, Decision{Action: None}},
+		{0, "b", true, Decision{Action: Detected, FirstSeen: start.Add(time.Minute), RequeueAfter: time.Minute}},
+		{10 * time.Second, "b", false, Decision{Action: Recovered, FirstSeen: start.Add(time.Minute)}},
+		{0, "b", true, Decision{Action: Detected, FirstSeen: start.Add(70 * time.Second), RequeueAfter: time.Minute}},
+	}
+	for i, s := range steps {
+		clk.SetTime(clk.Now().Add(s.advance))
+		if got := d.Observe(s.key, s.failing); got != s.want {
+			t.Errorf("step %d: Observe(%q, %v) = %+v, want %+v", i, s.key, s.failing, got, s.want)
+		}
+	}
+}
+
+func TestZeroWindow(t *testing.T) {
+	d := New(0, clocktesting.NewFakePassiveClock(time.Now()))
+	if got := d.Observe("a", true); got.Action != Detected || got.RequeueAfter != 0 {
+		t.Errorf("first observation = %+v", got)
+	}
+	if got := d.Observe("a", true); got.Action != Fire {
+		t.Errorf("second observation = %+v, want Fire", got)
+	}
+}
+
+func TestExpireAndComplete(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(time.Minute, clk)
+
+	if d.Expire("a") {
+		t.Error("Expire of unknown key reported true")
+	}
+	d.Observe("a", true)
+	d.Observe("b", true)
+	clk.SetTime(start.Add(10 * time.Second))
+	d.Observe("c", true)
+	want := []Pending{
+		{Key: "a", FirstSeen: start, Due: start.Add(time.Minute)},
+		{Key: "b", FirstSeen: start, Due: start.Add(time.Minute)},
+		{Key: "c", FirstSeen: start.Add(10 * time.Second), Due: start.Add(70 * time.Second)},
+	}
+	if got := d.Pending(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Pending() = %+v, want %+v", got, want)
+	}
+
+	if !d.Expire("a") {
+		t.Error("Expire of pending key reported false")
+	}
+	if got := d.Observe("a", true); got.Action != Fire {
+		t.Errorf("after Expire: %+v, want Fire", got)
+	}
+	d.Complete("b")
+	if got := d.Observe("b", true); got.Action != None {
+		t.Errorf("after Complete: %+v, want None", got)
+	}
+	if got := d.Completed(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Completed() = %v", got)
+	}
+	if len(d.Pending()) != 1 {
+		t.Errorf("completed keys should leave Pending, got %+v", d.Pending())
+	}
+
+	if !d.ClearCompleted("a") || d.ClearCompleted("a") {
+		t.Error("ClearCompleted should report only the first removal")
+	}
+	if got := d.Observe("a", true); got.Action != Detected {
+		t.Errorf("after ClearCompleted: %+v, want Detected", got)
+	}
+	if n := d.ClearAllCompleted(); n != 1 || d.IsCompleted("b") {
+		t.Errorf("ClearAllCompleted() = %d, b completed %v", n, d.IsCompleted("b"))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(time.Minute, clk)
+	d.Observe("live", true)
+
+	d.Restore(map[string]time.Time{
+		"live":  start.Add(-time.Hour),
+		"old":   start.Add(-2 * time.Minute),
+		"fired": start.Add(-time.Hour),
+	}, []string{"fired", "done"})
+
+	// "live" failed before the restart; its window doesn't start over.
+	want := []Pending{
+		{Key: "live", FirstSeen: start.Add(-time.Hour), Due: start.Add(-59 * time.Minute)},
+		{Key: "old", FirstSeen: start.Add(-2 * time.Minute), Due: start.Add(-time.Minute)},
+	}
+	if got := d.Pending(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Pending() = %+v, want %+v", got, want)
+	}
+	if got := d.Completed(); !reflect.DeepEqual(got, []string{"done", "fired"}) {
+		t.Errorf("Completed() = %v", got)
+	}
+	if got := d.Observe("old", true); got.Action != Fire {
+		t.Errorf("restored key past its window: %+v, want Fire", got)
+	}
+}
+
+func TestCompletedAt(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(time.Minute, clk)
+	d.Restore(nil, []string{"restored"})
+	clk.SetTime(start.Add(time.Hour))
+	d.Complete("done")
+	clk.SetTime(start.Add(2 * time.Hour))
+
+	want := map[string]time.Time{"restored": start, "done": start.Add(time.Hour)}
+	if got := d.CompletedAt(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CompletedAt() = %v, want %v", got, want)
+	}
+
+	// On a real clock the times must not move between calls.
+	d = New(time.Minute, clock.RealClock{})
+	d.Complete("done")
+	first := d.CompletedAt()
+	time.Sleep(time.Millisecond)
+	if got := d.CompletedAt(); !reflect.DeepEqual(got, first) {
+		t.Errorf("CompletedAt() moved from %v to %v", first, got)
+	}
+}
+
+func TestRearm(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(0, clk)
+	d.Observe("a", true)
+	d.Observe("a", true) // fires
+	if d.Rearm("a", time.Hour) {
+		t.Error("Rearm before the period reported true")
+	}
+	if d.Rearm("b", 0) {
+		t.Error("Rearm of a key that never fired reported true")
+	}
+	clk.SetTime(start.Add(time.Hour))
+	if !d.Rearm("a", time.Hour) || d.IsCompleted("a") {
+		t.Error("Rearm after the period should forget the key")
+	}
+	if got := d.Observe("a", true); got.Action != Detected {
+		t.Errorf("after Rearm: %+v, want Detected", got)
+	}
+}
+
+func TestDropStaleAndForget(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(time.Hour, clk)
+	d.Observe("a", true)
+	d.Observe("b", true)
+	d.Observe("c", true)
+	clk.SetTime(start.Add(20 * time.Minute))
+	d.Observe("b", true) // still observed
+
+	if got := d.DropStale(30 * time.Minute); len(got) != 0 {
+		t.Errorf("DropStale before the TTL = %v", got)
+	}
+	clk.SetTime(start.Add(30 * time.Minute))
+	if got := d.DropStale(30 * time.Minute); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("DropStale() = %v, want [a c]", got)
+	}
+	if !d.Forget("b") || d.Forget("b") {
+		t.Error("Forget should report whether the key was pending")
+	}
+	if got := d.Pending(); len(got) != 0 {
+		t.Errorf("Pending() = %+v, want none", got)
+	}
+	if got := d.Observe("a", true); got.Action != Detected {
+		t.Errorf("dropped key observed again: %+v, want Detected", got)
+	}
+}
+
+func TestDue(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(time.Minute, clk)
+	d.Observe("a", true)
+	if d.Due("a") || d.Due("b") {
+		t.Error("Due within the window")
+	}
+	clk.SetTime(start.Add(time.Minute))
+	if !d.Due("a") {
+		t.Error("Due after the window should be true")
+	}
+}
+
+func TestObserveWithin(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(5*time.Minute, clk)
+	if got := d.ObserveWithin("a", true, time.Minute); got.RequeueAfter != time.Minute {
+		t.Errorf("first observation = %+v, want 1m requeue", got)
+	}
+	if p := d.Pending(); len(p) != 1 || !p[0].Due.Equal(start.Add(time.Minute)) {
+		t.Errorf("pending = %+v", p)
+	}
+	// A longer window extends the running one from its first failure.
+	clk.SetTime(start.Add(time.Minute))
+	if got := d.ObserveWithin("a", true, 3*time.Minute); got.Action != Waiting || got.RequeueAfter != 2*time.Minute {
+		t.Errorf("extended window = %+v, want Waiting with 2m requeue", got)
+	}
+	if d.Due("a") {
+		t.Error("Due within the extended window")
+	}
+	// A shorter one fires right away once it has passed.
+	if got := d.ObserveWithin("a", true, 30*time.Second); got.Action != Fire {
+		t.Errorf("shortened window = %+v, want Fire", got)
+	}
+	if len(d.windows) != 0 {
+		t.Errorf("windows left after firing: %v", d.windows)
+	}
+}
+
+// skewedClock is a clock.RealClock stand-in: Since measures on the fake
+// monotonic clock, Now adds the skew of the wall clock to it.
+type skewedClock struct {
+	*clocktesting.FakePassiveClock
+	skew time.Duration
+}
+
+func (c *skewedClock) Now() time.Time { return c.FakePassiveClock.Now().Add(c.skew) }
+
+func TestClockSkew(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := &skewedClock{FakePassiveClock: clocktesting.NewFakePassiveClock(start)}
+	d := New(time.Minute, clk)
+	d.Observe("a", true)
+
+	// An NTP step forward doesn't fire the window early.
+	clk.SetTime(start.Add(10 * time.Second))
+	clk.skew = time.Hour
+	if got := d.Observe("a", true); got.Action != Waiting || got.RequeueAfter != 50*time.Second {
+		t.Errorf("after a forward jump: %+v, want Waiting with 50s requeue", got)
+	}
+	if p := d.Pending(); len(p) != 1 || !p[0].Due.Equal(start.Add(time.Hour+time.Minute)) {
+		t.Errorf("pending = %+v, want due a minute after the first failure on the new wall clock", p)
+	}
+	// One back doesn't stall it.
+	clk.skew = -time.Hour
+	clk.SetTime(start.Add(time.Minute))
+	if got := d.Observe("a", true); got.Action != Fire {
+		t.Errorf("after a backward jump: %+v, want Fire", got)
+	}
+}
+
+func TestClockBack(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	d := New(time.Minute, clk)
+	d.Observe("a", true)
+	clk.SetTime(start.Add(20 * time.Second))
+	d.Observe("a", true)
+
+	// A clock without monotonic readings goes back: no time passed, so the
+	// window neither restarts nor waits out the jump.
+	clk.SetTime(start.Add(-time.Hour))
+	if got := d.Observe("a", true); got.Action != Waiting || got.RequeueAfter != 40*time.Second {
+		t.Errorf("after the clock went back: %+v, want Waiting with 40s requeue", got)
+	}
+	if d.DropStale(time.Minute) != nil {
+		t.Error("DropStale dropped a key observed just now")
+	}
+	clk.SetTime(start.Add(-time.Hour + 40*time.Second))
+	if got := d.Observe("a", true); got.Action != Fire {
+		t.Errorf("40s later: %+v, want Fire", got)
+	}
+}
@@ -0,0 +1,64 @@
+package rollback
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+)
+
+// actionEvents are the audit events of changes the controller made, or
+// failed to make, in Git, the cluster or a provider. They form the
+// low-volume action channel, logged by logAction apart from the
+// observations (detections, debounce decisions) on the controller log.
+var actionEvents = map[string]bool{
+	auditReverted:       true,
+	auditSuspended:      true,
+	auditAutoMerged:     true,
+	auditHelmRolledBack: true,
+	auditWebhookCalled:  true,
+	auditJobStarted:     true,
+	auditRebased:        true,
+	auditRecreated:      true,
+	auditMRUpdated:      true,
+	auditBranchDeleted:  true,
+	auditMergeTrain:     true,
+	auditRevertFailed:   true,
+	auditProviderFailed: true,
+	auditDeadLettered:   true,
+	auditAdmin:          true,
+}
+
+// newActionLog returns the extra sink of the action channel for ACTION_LOG:
+// "" for none, "stdout" for one JSON object per line on stdout. It logs
+// every action whatever the controller's log level, for log-based alerting.
+func newActionLog(spec string) (logr.Logger, error) {
+	switch spec {
+	case "":
+		return logr.Discard(), nil
+	case "stdout":
+		return jsonActionLog(os.Stdout), nil
+	}
+	return logr.Logger{}, fmt.Errorf("ACTION_LOG %q: want stdout", spec)
+}
+
+// jsonActionLog writes JSON log lines to w.
+func jsonActionLog(w io.Writer) logr.Logger {
+	return funcr.NewJSON(func(obj string) { fmt.Fprintln(w, obj) }, funcr.Options{LogTimestamp: true}).WithName("actions")
+}
+
+// logAction logs an action on the action channel: the controller log under
+// the "actions" name and ActionLog. Callers must hold r.mu.
+func (r *RollbackController) logAction(e auditEntry) {
+	kv := []interface{}{"event", e.Event, "kind", e.Kind, "namespace", e.Namespace, "name", e.Name, "sha", e.SHA}
+	if e.Message != "" {
+		kv = append(kv, "message", e.Message)
+	}
+	if e.Cluster != "" {
+		kv = append(kv, "cluster", e.Cluster)
+	}
+	r.log.WithName("actions").Info("Action", kv...)
+	r.ActionLog.Info("Action", kv...)
+}
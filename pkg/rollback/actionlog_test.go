@@ -0,0 +1,46 @@
+package rollback
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestActionLog(t *testing.T) {
+	var out bytes.Buffer
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.ActionLog = jsonActionLog(&out)
+	r.Metadata = clusterMetadata{Cluster: "prod-eu"}
+
+	r.recordAudit(auditDetected, "Kustomization", "apps", "web", "abc", "")
+	r.recordAudit(auditDebounced, "Kustomization", "apps", "web", "abc", "")
+	r.recordAudit(auditReverted, "Kustomization", "apps", "web", "abc", "MR !7")
+	r.recordAudit(auditSuspended, "HelmRelease", "api", "api", "def", "")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("action log has %d lines, want 2:\n%s", len(lines), out.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"logger": "actions", "msg": "Action", "event": auditReverted, "name": "web", "sha": "abc", "message": "MR !7", "cluster": "prod-eu"} {
+		if entry[k] != want {
+			t.Errorf("%s = %v, want %q", k, entry[k], want)
+		}
+	}
+	if _, ok := entry["ts"]; !ok {
+		t.Errorf("entry has no timestamp: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"event":"suspended"`) {
+		t.Errorf("second entry %s", lines[1])
+	}
+
+	if _, err := newActionLog("syslog"); err == nil {
+		t.Error("ACTION_LOG=syslog accepted")
+	}
+}
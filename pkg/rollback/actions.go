@@ -0,0 +1,312 @@
+package rollback
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotations and labels set by remediation actions.
+const (
+	// helmRollbackAnnotation marks HelmReleases rolled back by HelmRollback
+	// with the failing revision.
+	helmRollbackAnnotation = "rollback.eumel8.io/helm-rollback-for"
+	// resetAnnotation makes helm-controller reset its failure counters when
+	// it matches reconcileRequestAnnotation (Flux 2.2+).
+	resetAnnotation = "reconcile.fluxcd.io/resetAt"
+	// actionJobLabel marks JobRun Jobs with the failing SHA.
+	actionJobLabel = "rollback.eumel8.io/action-for"
+)
+
+// remediationAction is what an escalation step does once its After has
+// elapsed. run is called with r.mu held, releases it around calls to
+// Kubernetes and providers, and records its own audit entries; a returned
+// error is logged and the step is not retried unless it is a
+// retryableError.
+type remediationAction interface {
+	run(ctx context.Context, r *RollbackController, a actionRun) error
+}
+
+// actionRun is one run of an escalation step on a failing resource.
+type actionRun struct {
+	res    resourceRef
+	sha    string
+	step   EscalationStep
+	policy *RollbackPolicy
+	revert func(sha string)
+	log    logr.Logger
+}
+
+// Retries of steps failing with a retryableError.
+const (
+	actionRetryInterval = time.Minute
+	maxActionAttempts   = 5
+)
+
+// retryableError marks an action that did not complete but may on a later
+// attempt, e.g. a remediation endpoint that answered 5xx.
+type retryableError struct{ err error }
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// remediationActions maps EscalationStep.Action to its implementation.
+var remediationActions = map[string]remediationAction{
+	EscalationNotify:       notifyAction{},
+	EscalationSuspend:      suspendAction{},
+	EscalationRevert:       gitRevertAction{},
+	EscalationGitRevert:    gitRevertAction{},
+	EscalationAutoMerge:    autoMergeAction{},
+	EscalationHelmRollback: helmRollbackAction{},
+	EscalationWebhookCall:  webhookCallAction{},
+	EscalationJobRun:       jobRunAction{},
+}
+
+// unlocked runs fn with r.mu released; callers must hold it.
+func (r *RollbackController) unlocked(fn func() error) error {
+	r.mu.Unlock()
+	defer r.mu.Lock()
+	return fn()
+}
+
+// echo logs and records what a dry-run action would do, like suspendResource.
+func (a actionRun) echo(ctx context.Context, r *RollbackController, action, msg string, kv ...interface{}) {
+	a.log.Info("ECHO: would "+msg, kv...)
+	_ = r.unlocked(func() error {
+		r.recordAction(r.project(ctx, a.res.Kind, a.res.Namespace, a.res.Name), recordedAction{Action: action, SHA: a.sha, Namespace: a.res.Namespace, Name: a.res.Name})
+		return nil
+	})
+}
+
+// record adds an audit entry and lifecycle event for the run.
+func (a actionRun) record(r *RollbackController, event, message string) {
+	r.recordAudit(event, a.res.Kind, a.res.Namespace, a.res.Name, a.sha, message)
+	r.emitEvent(event, a.res.Kind, a.res.Namespace, a.res.Name, a.sha)
+}
+
+type notifyAction struct{}
+
+func (notifyAction) run(_ context.Context, r *RollbackController, a actionRun) error {
+	a.log.Info("Escalation: failure persists")
+	a.record(r, auditNotified, "failing for "+a.step.After.Duration.String())
+	return nil
+}
+
+type suspendAction struct{}
+
+func (suspendAction) run(ctx context.Context, r *RollbackController, a actionRun) error {
+	a.log.Info("Escalation: suspending resource")
+	if err := r.unlocked(func() error { return r.suspendResource(ctx, a.res, a.sha) }); err != nil {
+		return fmt.Errorf("suspend resource: %w", err)
+	}
+	a.record(r, auditSuspended, "")
+	return nil
+}
+
+// gitRevertAction runs the policy's remediation; a commit is reverted once
+// even if several resources escalate on it.
+type gitRevertAction struct{}
+
+func (gitRevertAction) run(_ context.Context, r *RollbackController, a actionRun) error {
+	if r.debounce.IsCompleted(a.sha) || r.debounce.IsCompleted(gitCommitSHA(a.sha)) {
+		a.log.V(1).Info("Escalation: revision already reverted")
+		return nil
+	}
+	a.log.Info("Escalation: creating revert")
+	r.debounce.Complete(a.sha)
+	r.runRevert(a.res.Kind, a.res.Namespace, a.res.Name, a.sha, a.revert)
+	return nil
+}
+
+type autoMergeAction struct{}
+
+func (autoMergeAction) run(ctx context.Context, r *RollbackController, a actionRun) error {
+	iid := r.revertMR(a.sha)
+	if iid == 0 && !dryRun() {
+		a.log.Info("Escalation: no revert MR to merge")
+		return nil
+	}
+	a.log.Info("Escalation: merging revert MR", "mr", iid)
+	if err := r.unlocked(func() error { return r.autoMergeRevert(ctx, a.res, a.sha, iid, a.policy) }); err != nil {
+		return fmt.Errorf("merge revert MR !%d: %w", iid, err)
+	}
+	a.record(r, auditAutoMerged, fmt.Sprintf("!%d", iid))
+	return nil
+}
+
+// helmRollbackAction has helm-controller roll a HelmRelease back to its last
+// successful release: it sets the upgrade remediation strategy to rollback
+// and requests a reconcile with reset failure counters, so the next failed
+// upgrade is rolled back. Git is not changed; the spec change stays in the
+// cluster until the HelmRelease manifest is applied over it.
+type helmRollbackAction struct{}
+
+func (helmRollbackAction) run(ctx context.Context, r *RollbackController, a actionRun) error {
+	if a.res.Kind != "HelmRelease" {
+		return fmt.Errorf("HelmRollback needs a HelmRelease, not a %s", a.res.Kind)
+	}
+	a.log.Info("Escalation: rolling back HelmRelease")
+	if dryRun() {
+		a.echo(ctx, r, "helmRollback", "roll back HelmRelease")
+		return nil
+	}
+	token := r.clock.Now().Format(time.RFC3339Nano)
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]string{
+			helmRollbackAnnotation:     a.sha,
+			reconcileRequestAnnotation: token,
+			resetAnnotation:            token,
+		}},
+		"spec": map[string]interface{}{"upgrade": map[string]interface{}{"remediation": map[string]interface{}{
+			"retries":              1,
+			"strategy":             "rollback",
+			"remediateLastFailure": true,
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.APIs.gvkFor(a.res.Kind))
+	obj.SetNamespace(a.res.Namespace)
+	obj.SetName(a.res.Name)
+	if err := r.unlocked(func() error { return r.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch)) }); err != nil {
+		return fmt.Errorf("patch HelmRelease: %w", err)
+	}
+	a.record(r, auditHelmRolledBack, "")
+	return nil
+}
+
+// webhookSignatureHeader carries the HMAC-SHA256 of a WebhookCall body as
+// "sha256=<hex>", like Flux's generic-hmac alerts.
+const webhookSignatureHeader = "X-Signature"
+
+// webhookCall is the JSON body of a WebhookCall.
+type webhookCall struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Revision  string    `json:"revision"`
+	Policy    string    `json:"policy"`
+	Time      time.Time `json:"time"`
+	// Conditions are the resource's status conditions when the step ran.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// webhookCallAction posts the failure as webhookCall to the URL in the
+// step's webhookSecret, e.g. an internal remediation service, signed with
+// the Secret's "token" key if it has one. Only a 2xx answer completes the
+// step; anything else is retried.
+type webhookCallAction struct{}
+
+func (webhookCallAction) run(ctx context.Context, r *RollbackController, a actionRun) error {
+	a.log.Info("Escalation: calling webhook", "secret", a.step.WebhookSecret)
+	if dryRun() {
+		a.echo(ctx, r, "webhookCall", "call webhook", "secret", a.step.WebhookSecret)
+		return nil
+	}
+	body := webhookCall{
+		Kind: a.res.Kind, Namespace: a.res.Namespace, Name: a.res.Name, Revision: a.sha,
+		Policy: a.policy.Namespace + "/" + a.policy.Name, Time: r.clock.Now().UTC(),
+	}
+	err := r.unlocked(func() error {
+		u, token, err := r.secretWebhook(ctx, a.step.WebhookSecret)
+		if err != nil {
+			return err
+		}
+		body.Conditions = r.resourceConditions(ctx, a.res)
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		var header http.Header
+		if token != "" {
+			header = http.Header{webhookSignatureHeader: {"sha256=" + hex.EncodeToString(hmacSHA256([]byte(token), string(payload)))}}
+		}
+		return postJSON(ctx, "POST", u, header, json.RawMessage(payload), nil)
+	})
+	if err != nil {
+		return &retryableError{fmt.Errorf("call webhook: %w", err)}
+	}
+	a.record(r, auditWebhookCalled, a.step.WebhookSecret)
+	return nil
+}
+
+// resourceConditions returns the status conditions of a Kustomization or
+// HelmRelease, or nil if it can't be read.
+func (r *RollbackController) resourceConditions(ctx context.Context, res resourceRef) []metav1.Condition {
+	key := client.ObjectKey{Namespace: res.Namespace, Name: res.Name}
+	switch res.Kind {
+	case "Kustomization":
+		var ks kustomizev1.Kustomization
+		if err := r.getConverted(ctx, res.Kind, key, &ks); err == nil {
+			return ks.Status.Conditions
+		}
+	case "HelmRelease":
+		var hr helmv2.HelmRelease
+		if err := r.getConverted(ctx, res.Kind, key, &hr); err == nil {
+			return hr.Status.Conditions
+		}
+	}
+	return nil
+}
+
+// jobRunAction creates a Job from the step's jobTemplate in the policy's
+// namespace. Its containers get ROLLBACK_KIND, ROLLBACK_NAMESPACE,
+// ROLLBACK_NAME and ROLLBACK_REVISION. The Job's result is not awaited.
+type jobRunAction struct{}
+
+func (jobRunAction) run(ctx context.Context, r *RollbackController, a actionRun) error {
+	tmpl := a.step.JobTemplate
+	job := &batchv1.Job{
+		ObjectMeta: *tmpl.ObjectMeta.DeepCopy(),
+		Spec:       *tmpl.Spec.DeepCopy(),
+	}
+	job.Namespace = a.policy.Namespace
+	job.Name = ""
+	job.GenerateName = "rollback-action-"
+	if sha := gitCommitSHA(a.sha); fullSHA.MatchString(sha) {
+		job.GenerateName += sha[:7] + "-"
+		if job.Labels == nil {
+			job.Labels = map[string]string{}
+		}
+		job.Labels[actionJobLabel] = sha
+	}
+	if job.Spec.TTLSecondsAfterFinished == nil {
+		ttl := verificationJobTTL
+		job.Spec.TTLSecondsAfterFinished = &ttl
+	}
+	env := []corev1.EnvVar{
+		{Name: "ROLLBACK_KIND", Value: a.res.Kind},
+		{Name: "ROLLBACK_NAMESPACE", Value: a.res.Namespace},
+		{Name: "ROLLBACK_NAME", Value: a.res.Name},
+		{Name: "ROLLBACK_REVISION", Value: a.sha},
+	}
+	for i := range job.Spec.Template.Spec.Containers {
+		c := &job.Spec.Template.Spec.Containers[i]
+		c.Env = append(c.Env, env...)
+	}
+	a.log.Info("Escalation: running Job", "jobNamespace", job.Namespace)
+	if dryRun() {
+		a.echo(ctx, r, "jobRun", "run Job", "jobNamespace", job.Namespace, "generateName", job.GenerateName)
+		return nil
+	}
+	if err := r.unlocked(func() error { return r.Create(ctx, job) }); err != nil {
+		return fmt.Errorf("create Job: %w", err)
+	}
+	a.record(r, auditJobStarted, job.Namespace+"/"+job.Name)
+	return nil
+}
@@ -0,0 +1,118 @@
+package rollback
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestActionList(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	var calls []webhookCall
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		if req.Header.Get(webhookSignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("signature %q does not match the body", req.Header.Get(webhookSignatureHeader))
+		}
+		if attempts++; attempts == 1 {
+			http.Error(w, "remediation service busy", http.StatusServiceUnavailable)
+			return
+		}
+		var call webhookCall
+		_ = json.Unmarshal(body, &call)
+		calls = append(calls, call)
+	}))
+	defer srv.Close()
+	failed := metav1.Condition{Type: "Ready", Status: metav1.ConditionFalse, Reason: "UpgradeFailed", LastTransitionTime: metav1.NewTime(start)}
+	hr := &helmv2.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"},
+		Status:     helmv2.HelmReleaseStatus{Conditions: []metav1.Condition{failed}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "runbook"},
+		Data:       map[string][]byte{"url": []byte(srv.URL), "token": []byte("s3cret")},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(hr, secret).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.Namespace = "flux-system"
+	r.setClock(clocktesting.NewFakeClock(start))
+	ctx := context.Background()
+	res := resourceRef{Kind: "HelmRelease", Namespace: "apps", Name: "web"}
+	policy := &RollbackPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"}, Spec: RollbackPolicySpec{Escalation: []EscalationStep{
+		{Action: EscalationHelmRollback},
+		{Action: EscalationWebhookCall, WebhookSecret: "runbook"},
+		{Action: EscalationJobRun, JobTemplate: &batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers:    []corev1.Container{{Name: "runbook", Image: "busybox"}},
+		}}}}},
+	}}}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+	revert := func(string) { t.Error("the action list has no revert") }
+
+	// The webhook answers 503 first: the step is retried, not skipped.
+	if d := r.handleEscalation(ctx, res, "main@sha1:"+sha, false, policy, revert); d.Action != DecisionWaiting || d.Reason != EscalationWebhookCall || d.RequeueAfter != actionRetryInterval {
+		t.Errorf("decision = %+v, want a retry of the webhook", d)
+	}
+	if d := r.handleEscalation(ctx, res, "main@sha1:"+sha, false, policy, revert); d.Action != DecisionEscalated || d.Reason != EscalationJobRun {
+		t.Errorf("decision = %+v", d)
+	}
+
+	var got helmv2.HelmRelease
+	if err := c.Get(ctx, client.ObjectKeyFromObject(hr), &got); err != nil {
+		t.Fatal(err)
+	}
+	if rem := got.Spec.Upgrade; rem == nil || rem.Remediation == nil || rem.Remediation.Strategy == nil || *rem.Remediation.Strategy != helmv2.RollbackRemediationStrategy {
+		t.Errorf("upgrade remediation = %+v", got.Spec.Upgrade)
+	}
+	if a := got.Annotations; a[helmRollbackAnnotation] != "main@sha1:"+sha || a[resetAnnotation] == "" || a[resetAnnotation] != a[reconcileRequestAnnotation] {
+		t.Errorf("annotations = %v", a)
+	}
+	for i, call := range calls {
+		// metav1.Time decodes in the local zone; compare the reasons only.
+		if len(call.Conditions) != 1 || call.Conditions[0].Reason != failed.Reason {
+			t.Errorf("webhook call conditions %+v, want %+v", call.Conditions, failed)
+		}
+		calls[i].Conditions = nil
+	}
+	want := []webhookCall{{Kind: "HelmRelease", Namespace: "apps", Name: "web", Revision: "main@sha1:" + sha, Policy: "apps/web", Time: start}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("webhook calls %+v, want %+v", calls, want)
+	}
+	var jobs batchv1.JobList
+	if err := c.List(ctx, &jobs, client.InNamespace("apps"), client.MatchingLabels{actionJobLabel: sha}); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 1 || len(jobs.Items[0].Spec.Template.Spec.Containers[0].Env) != 4 {
+		t.Errorf("Jobs = %+v", jobs.Items)
+	}
+	var events []string
+	for _, e := range r.auditLog {
+		events = append(events, e.Event)
+	}
+	if want := []string{auditDetected, auditHelmRolledBack, auditWebhookCalled, auditJobStarted}; !reflect.DeepEqual(events, want) {
+		t.Errorf("audit events %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,141 @@
+package rollback
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// auditAdmin is recorded for every state change made through the admin API.
+const auditAdmin = "admin"
+
+// requestReconcile enqueues a reconcile of namespace/name. Reconcile looks the
+// name up as Kustomization and HelmRelease, so the kind doesn't matter.
+func (r *RollbackController) requestReconcile(namespace, name string) {
+	obj := &unstructured.Unstructured{}
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	select {
+	case r.enqueue <- event.GenericEvent{Object: obj}:
+	default:
+		r.log.Info("Reconcile queue full, dropping request", "namespace", namespace, "name", name)
+	}
+}
+
+// expirePending moves the first-seen time of a pending SHA back by the
+// debounce window and requeues the resources failing on it, so the revert
+// happens on the next reconcile. It reports whether sha was pending.
+func (r *RollbackController) expirePending(sha string) bool {
+	if !r.debounce.Expire(sha) {
+		return false
+	}
+	r.mu.Lock()
+	r.recordAudit(auditAdmin, "", "", "", sha, "debounce timer force-expired")
+	targets := r.revisionTargets(sha)
+	r.mu.Unlock()
+	for _, t := range targets {
+		r.requestReconcile(t.Namespace, t.Name)
+	}
+	return true
+}
+
+// markCompleted records sha as already reverted, cancelling any pending timer
+// and dropping it from the dead-letter queue.
+func (r *RollbackController) markCompleted(sha string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.debounce.Complete(sha)
+	delete(r.deadLetters, sha)
+	r.recordAudit(auditAdmin, "", "", "", sha, "marked as completed")
+}
+
+// clearCompleted forgets completed SHAs so they can trigger a revert again.
+// An empty sha clears all of them. It returns how many were removed.
+func (r *RollbackController) clearCompleted(sha string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, msg := 0, "completed state cleared"
+	if sha == "" {
+		n, msg = r.debounce.ClearAllCompleted(), "all completed state cleared"
+	} else if r.debounce.ClearCompleted(sha) {
+		n = 1
+	}
+	r.recordAudit(auditAdmin, "", "", "", sha, msg)
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// adminHandler serves the admin API. SHAs are taken from the rest of the path
+// since tracking keys may contain slashes.
+//
+//	GET    /admin/pending                 list pending SHAs
+//	POST   /admin/pending/expire/{sha}    force-expire a debounce timer
+//	GET    /admin/completed               list completed SHAs
+//	POST   /admin/completed/{sha}         mark a SHA as completed
+//	DELETE /admin/completed/{sha}         clear one completed SHA
+//	DELETE /admin/completed               clear all completed SHAs
+//	GET    /admin/deadletters             list dead-lettered reverts
+//	POST   /admin/deadletters/retry/{sha} retry a dead-lettered revert
+//	GET    /admin/timeline/{sha}          incident timeline as Markdown
+func (r *RollbackController) adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/pending", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.snapshot().Pending)
+	})
+	mux.HandleFunc("POST /admin/pending/expire/{sha...}", func(w http.ResponseWriter, req *http.Request) {
+		sha := req.PathValue("sha")
+		if !r.expirePending(sha) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "sha is not pending"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"expired": sha})
+	})
+	mux.HandleFunc("GET /admin/completed", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.debounce.Completed())
+	})
+	mux.HandleFunc("POST /admin/completed/{sha...}", func(w http.ResponseWriter, req *http.Request) {
+		sha := req.PathValue("sha")
+		if sha == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "sha required"})
+			return
+		}
+		r.markCompleted(sha)
+		writeJSON(w, http.StatusOK, map[string]string{"completed": sha})
+	})
+	mux.HandleFunc("DELETE /admin/completed/{sha...}", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]int{"cleared": r.clearCompleted(req.PathValue("sha"))})
+	})
+	mux.HandleFunc("DELETE /admin/completed", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]int{"cleared": r.clearCompleted("")})
+	})
+	mux.HandleFunc("GET /admin/deadletters", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.deadLetterList())
+	})
+	mux.HandleFunc("POST /admin/deadletters/retry/{sha...}", func(w http.ResponseWriter, req *http.Request) {
+		sha := req.PathValue("sha")
+		if !r.retryDeadLetter(sha) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "sha is not dead-lettered"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"retried": sha})
+	})
+	mux.HandleFunc("GET /admin/timeline/{sha...}", func(w http.ResponseWriter, req *http.Request) {
+		sha := req.PathValue("sha")
+		s := r.snapshot()
+		timeline := incidentTimeline(s.Audit, sha)
+		if len(timeline) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no audit entries for sha"})
+			return
+		}
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		_ = writeTimeline(w, sha, timeline, s.Reverts)
+	})
+	return bearerAuth(token, mux)
+}
@@ -0,0 +1,49 @@
+package rollback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func adminRequest(t *testing.T, h http.Handler, method, path string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAdminExpireAndComplete(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	h := r.adminHandler("admin")
+	r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", false, func(string) {})
+
+	if code := adminRequest(t, h, "POST", "/admin/pending/expire/unknown"); code != http.StatusNotFound {
+		t.Errorf("expire unknown: status %d", code)
+	}
+	if code := adminRequest(t, h, "POST", "/admin/pending/expire/main@sha1:abc"); code != http.StatusOK {
+		t.Fatalf("expire: status %d", code)
+	}
+	if len(r.enqueue) != 1 {
+		t.Errorf("expected the failing resource to be requeued")
+	}
+	reverted := ""
+	r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", false, func(sha string) { reverted = sha })
+	if reverted != "main@sha1:abc" {
+		t.Errorf("expired timer did not trigger a revert")
+	}
+
+	if code := adminRequest(t, h, "DELETE", "/admin/completed"); code != http.StatusOK || len(r.debounce.Completed()) != 0 {
+		t.Errorf("clear all: status %d, completed %v", code, r.debounce.Completed())
+	}
+	if code := adminRequest(t, h, "POST", "/admin/completed/ns/app@1.0.0"); code != http.StatusOK || !r.debounce.IsCompleted("ns/app@1.0.0") {
+		t.Errorf("mark completed: status %d, completed %v", code, r.debounce.Completed())
+	}
+	if code := adminRequest(t, h, "DELETE", "/admin/completed/ns/app@1.0.0"); code != http.StatusOK || len(r.debounce.Completed()) != 0 {
+		t.Errorf("clear one: status %d, completed %v", code, r.debounce.Completed())
+	}
+}
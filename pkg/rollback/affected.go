@@ -0,0 +1,169 @@
+package rollback
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// The affected resources section of a revert MR description is delimited by
+// these hidden lines, so it can be rewritten when resources join.
+const (
+	affectedStart = "<!-- rollback-controller-affected -->"
+	affectedEnd   = "<!-- /rollback-controller-affected -->"
+)
+
+// updateMergeRequestDescription replaces the description of MR iid.
+func (g gitlabProject) updateMergeRequestDescription(iid int, description string) error {
+	return g.request("PUT", fmt.Sprintf("/merge_requests/%d", iid), map[string]string{"description": description}, nil)
+}
+
+// affectedSection renders the MR description section listing the resources
+// failing on the reverted commit.
+func affectedSection(resources []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n### Affected resources\n\nFailing on this commit:\n\n", affectedStart)
+	for _, res := range resources {
+		fmt.Fprintf(&b, "- `%s`\n", res)
+	}
+	b.WriteString(affectedEnd)
+	return b.String()
+}
+
+// withAffected returns description with its affected resources section
+// replaced by one listing resources, or with one appended.
+func withAffected(description string, resources []string) string {
+	section := affectedSection(resources)
+	start := strings.Index(description, affectedStart)
+	end := strings.Index(description, affectedEnd)
+	if start < 0 || end < start {
+		return description + "\n\n" + section
+	}
+	return description[:start] + section + description[end+len(affectedEnd):]
+}
+
+// describedAffected returns the resources the affected resources section of
+// description lists, or nil if it has none.
+func describedAffected(description string) []string {
+	start := strings.Index(description, affectedStart)
+	end := strings.Index(description, affectedEnd)
+	if start < 0 || end < start {
+		return nil
+	}
+	var out []string
+	s := bufio.NewScanner(strings.NewReader(description[start:end]))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "- `") && strings.HasSuffix(line, "`") {
+			out = append(out, strings.TrimSuffix(strings.TrimPrefix(line, "- `"), "`"))
+		}
+	}
+	return out
+}
+
+// resourcesFailingOn returns the tracked resources not Ready on the commit
+// of revision, as "Kind/namespace/name", sorted. Callers must hold r.mu.
+func (r *RollbackController) resourcesFailingOn(revision string) []string {
+	var out []string
+	for key, st := range r.resources {
+		if !st.Ready && st.Revision != "" && gitCommitSHA(st.Revision) == gitCommitSHA(revision) {
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// affectedResources returns the MR description section listing res and the
+// other resources failing on sha, or "" if res is the only one.
+func (r *RollbackController) affectedResources(res resourceRef, sha string) string {
+	if sha == "" {
+		return ""
+	}
+	r.mu.Lock()
+	resources := addString(r.resourcesFailingOn(sha), res.String())
+	r.mu.Unlock()
+	if len(resources) < 2 {
+		return ""
+	}
+	sort.Strings(resources)
+	return "\n\n" + affectedSection(resources)
+}
+
+// checkAffectedResources adds res to the affected resources of the open
+// GitLab revert MR of sha when res fails on sha after the MR was opened,
+// e.g. a second namespace deploying the same commit, so the MR lists every
+// resource it fixes. The MR description is rewritten with a PUT and an
+// mrUpdated audit entry is recorded. Lookup and update failures are logged
+// and retried on the next reconcile.
+func (r *RollbackController) checkAffectedResources(ctx context.Context, res resourceRef, sha string, ready bool) {
+	if ready || sha == "" {
+		return
+	}
+	r.mu.Lock()
+	var rec revertRecord
+	for i := len(r.reverts) - 1; i >= 0; i-- {
+		if gitCommitSHA(r.reverts[i].SHA) == gitCommitSHA(sha) && r.reverts[i].MRIID > 0 {
+			rec = r.reverts[i]
+			break
+		}
+	}
+	listed := r.mrAffected[rec.URL]
+	opener := resourceRef{Kind: rec.Kind, Namespace: rec.Namespace, Name: rec.Name}
+	if rec.MRIID == 0 || res == opener || r.mrSettled[rec.URL] || slices.Contains(listed, res.String()) {
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+
+	log := r.log.WithValues("kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "mr", rec.URL)
+	gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+	if !gl.isGitLab() {
+		r.setAffected(rec.URL, addString(slices.Clone(listed), res.String()))
+		return
+	}
+	if dryRun() {
+		log.Info("ECHO: would add the resource to the revert MR description")
+		r.setAffected(rec.URL, addString(slices.Clone(listed), res.String()))
+		return
+	}
+	mr, err := gl.mergeRequestState(rec.MRIID)
+	if err != nil {
+		log.Error(err, "failed to get revert MR")
+		return
+	}
+	if mr.State != "opened" {
+		r.setAffected(rec.URL, addString(slices.Clone(listed), res.String()))
+		return
+	}
+	listed = describedAffected(mr.Description)
+	if listed == nil {
+		// Opened for one resource: its Failing resource section names it.
+		listed = []string{opener.String()}
+	}
+	if slices.Contains(listed, res.String()) {
+		r.setAffected(rec.URL, listed)
+		return
+	}
+	listed = append(listed, res.String())
+	sort.Strings(listed)
+	if err := gl.updateMergeRequestDescription(rec.MRIID, withAffected(mr.Description, listed)); err != nil {
+		log.Error(err, "failed to update revert MR description")
+		return
+	}
+	log.Info("Added the resource to the revert MR", "affected", len(listed))
+	r.setAffected(rec.URL, listed)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordAudit(auditMRUpdated, res.Kind, res.Namespace, res.Name, sha, fmt.Sprintf("!%d lists %d affected resources", rec.MRIID, len(listed)))
+}
+
+// setAffected records the resources the revert MR at webURL lists.
+func (r *RollbackController) setAffected(webURL string, resources []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mrAffected[webURL] = resources
+}
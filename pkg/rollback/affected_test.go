@@ -0,0 +1,73 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestAffectedResources(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	var calls []string
+	description := "Flux resources failed after main@sha1:" + sha + "; reverting it."
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		call := req.Method + " " + strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42")
+		calls = append(calls, call)
+		switch call {
+		case "GET /merge_requests/7":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"iid": 7, "state": "opened", "description": description})
+		case "PUT /merge_requests/7":
+			var body map[string]string
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			description = body["description"]
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRollbackController(nil, logr.Discard(), "", "42", srv.URL, "revert", 300)
+	frontend := resourceRef{Kind: "Kustomization", Namespace: "web", Name: "frontend"}
+	backend := resourceRef{Kind: "Kustomization", Namespace: "web", Name: "backend"}
+	api := resourceRef{Kind: "HelmRelease", Namespace: "api", Name: "api"}
+	r.setStatus(frontend.Kind, frontend.Name, frontend.Namespace, "main@sha1:"+sha, false)
+	if got := r.affectedResources(frontend, sha); got != "" {
+		t.Errorf("single failing resource: %q, want no section", got)
+	}
+	r.setStatus(backend.Kind, backend.Name, backend.Namespace, "main@sha1:"+sha, false)
+	if got := describedAffected(r.affectedResources(frontend, sha)); !reflect.DeepEqual(got, []string{backend.String(), frontend.String()}) {
+		t.Errorf("MR opened while both fail lists %q", got)
+	}
+
+	// The MR was opened for frontend alone; backend and api join later.
+	r.reverts = []revertRecord{{Kind: frontend.Kind, Namespace: frontend.Namespace, Name: frontend.Name, SHA: "main@sha1:" + sha, URL: srv.URL + "/mr/7", MRIID: 7}}
+	ctx := context.Background()
+	r.checkAffectedResources(ctx, frontend, "main@sha1:"+sha, false)
+	r.checkAffectedResources(ctx, backend, "main@sha1:"+sha, false)
+	r.checkAffectedResources(ctx, backend, "main@sha1:"+sha, false)
+	r.checkAffectedResources(ctx, api, sha, false)
+	want := []string{"GET /merge_requests/7", "PUT /merge_requests/7", "GET /merge_requests/7", "PUT /merge_requests/7"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if got := describedAffected(description); !reflect.DeepEqual(got, []string{api.String(), backend.String(), frontend.String()}) {
+		t.Errorf("MR lists %q", got)
+	}
+	if strings.Count(description, affectedStart) != 1 || !strings.HasPrefix(description, "Flux resources failed after") {
+		t.Errorf("section not replaced in place:\n%s", description)
+	}
+	updates := 0
+	for _, e := range r.auditLog {
+		if e.Event == auditMRUpdated {
+			updates++
+		}
+	}
+	if updates != 2 {
+		t.Errorf("%d mrUpdated audit entries, want 2", updates)
+	}
+}
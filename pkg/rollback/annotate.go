@@ -0,0 +1,52 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotations set on the failing resource once a revert MR is opened, so
+// cluster users can find the pending fix without GitLab access.
+const (
+	revertMRAnnotation    = "rollback.eumel8.io/revert-mr"
+	revertMRIIDAnnotation = "rollback.eumel8.io/revert-mr-iid"
+)
+
+// trackMergeRequest attaches the MR to the revert record of key and
+// annotates the resource with it. A nil MR (no MR opened) is ignored. The
+// patch targets the served API version, which may be a legacy one.
+func (r *RollbackController) trackMergeRequest(ctx context.Context, res resourceRef, key string, mr *gitlabMergeRequest) {
+	if mr == nil {
+		return
+	}
+	r.setRevertMR(key, mr)
+	if !r.Features.Enabled(ResourceAnnotations) {
+		return
+	}
+	annotations := map[string]string{
+		revertMRAnnotation:    mr.WebURL,
+		revertMRIIDAnnotation: strconv.Itoa(mr.IID),
+	}
+	if r.Instance != "" {
+		annotations[instanceAnnotation] = r.Instance
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		r.log.Error(err, "failed to encode annotation patch")
+		return
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.APIs.gvkFor(res.Kind))
+	obj.SetNamespace(res.Namespace)
+	obj.SetName(res.Name)
+	if err := r.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		r.log.Error(err, "failed to annotate resource with revert MR", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+	}
+}
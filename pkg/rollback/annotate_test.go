@@ -0,0 +1,35 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTrackMergeRequest(t *testing.T) {
+	ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "apps", Annotations: map[string]string{"keep": "me"}}}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(ks).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.reverts = []revertRecord{{SHA: "abc"}}
+
+	r.trackMergeRequest(context.Background(), resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}, "abc", &gitlabMergeRequest{IID: 7, WebURL: "https://gitlab/mr/7"})
+
+	var got kustomizev1.Kustomization
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(ks), &got); err != nil {
+		t.Fatal(err)
+	}
+	a := got.GetAnnotations()
+	if a[revertMRAnnotation] != "https://gitlab/mr/7" || a[revertMRIIDAnnotation] != "7" || a["keep"] != "me" {
+		t.Errorf("unexpected annotations %v", a)
+	}
+	if r.reverts[0].URL != "https://gitlab/mr/7" {
+		t.Errorf("revert record URL = %q", r.reverts[0].URL)
+	}
+
+	r.trackMergeRequest(context.Background(), resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}, "abc", nil) // no MR: nothing to do
+}
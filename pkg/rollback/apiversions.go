@@ -0,0 +1,143 @@
+package rollback
+
+import (
+	"context"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Supported Flux API versions per kind, newest first. Legacy versions are
+// read as unstructured and converted to the GA Go types; the fields the
+// controller uses have the same JSON names across these versions.
+var (
+	kustomizationVersions = []string{"v1", "v1beta2"}
+	helmReleaseVersions   = []string{"v2", "v2beta2", "v2beta1"}
+	sourceVersions        = []string{"v1", "v1beta2"}
+	// RollbackPolicy versions; older ones are converted with convertPolicy.
+	policyVersions = []string{"v1beta1", "v1alpha1"}
+)
+
+// fluxAPIs are the Flux API versions served by the cluster.
+type fluxAPIs struct {
+	Kustomization schema.GroupVersionKind
+	HelmRelease   schema.GroupVersionKind
+	Source        schema.GroupVersion
+	Policy        schema.GroupVersionKind // RollbackPolicy
+}
+
+// gaFluxAPIs are the Flux v2 GA APIs, assumed when discovery is unavailable.
+var gaFluxAPIs = fluxAPIs{
+	Kustomization: kustomizev1.GroupVersion.WithKind("Kustomization"),
+	HelmRelease:   helmv2.GroupVersion.WithKind("HelmRelease"),
+	Source:        schema.GroupVersion{Group: "source.toolkit.fluxcd.io", Version: "v1"},
+	Policy:        policyHubVersion.WithKind("RollbackPolicy"),
+}
+
+// servedFluxAPIs picks the newest served version of each Flux API and of
+// RollbackPolicy, so clusters running older Flux or mid-upgrade keep working.
+func servedFluxAPIs(mapper meta.RESTMapper) fluxAPIs {
+	apis := gaFluxAPIs
+	if m, err := mapper.RESTMapping(apis.Kustomization.GroupKind(), kustomizationVersions...); err == nil {
+		apis.Kustomization = m.GroupVersionKind
+	}
+	if m, err := mapper.RESTMapping(apis.HelmRelease.GroupKind(), helmReleaseVersions...); err == nil {
+		apis.HelmRelease = m.GroupVersionKind
+	}
+	if m, err := mapper.RESTMapping(schema.GroupKind{Group: apis.Source.Group, Kind: "GitRepository"}, sourceVersions...); err == nil {
+		apis.Source = m.GroupVersionKind.GroupVersion()
+	}
+	apis.Policy = servedPolicyAPI(mapper)
+	return apis
+}
+
+// servedPolicyAPI returns the newest served RollbackPolicy version. It is
+// discovered regardless of the LegacyFluxAPIs gate, so the controller keeps
+// reading policies while the v1beta1 CRD is rolled out.
+func servedPolicyAPI(mapper meta.RESTMapper) schema.GroupVersionKind {
+	if m, err := mapper.RESTMapping(gaFluxAPIs.Policy.GroupKind(), policyVersions...); err == nil {
+		return m.GroupVersionKind
+	}
+	return gaFluxAPIs.Policy
+}
+
+// legacy reports whether gvk is not the GA version of its kind.
+func (a fluxAPIs) legacy(gvk schema.GroupVersionKind) bool {
+	return gvk != gaFluxAPIs.Kustomization && gvk != gaFluxAPIs.HelmRelease
+}
+
+// gvkFor returns the served GroupVersionKind of a watched kind.
+func (a fluxAPIs) gvkFor(kind string) schema.GroupVersionKind {
+	switch kind {
+	case "HelmRelease":
+		return a.HelmRelease
+	case "GitRepository":
+		return a.Source.WithKind(kind)
+	}
+	return a.Kustomization
+}
+
+// watchObject returns the object to watch for kind: the typed GA object, or
+// an unstructured one for legacy versions.
+func (a fluxAPIs) watchObject(kind string) client.Object {
+	gvk := a.gvkFor(kind)
+	if !a.legacy(gvk) {
+		if kind == "HelmRelease" {
+			return &helmv2.HelmRelease{}
+		}
+		return &kustomizev1.Kustomization{}
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	return u
+}
+
+// getConverted reads a Kustomization or HelmRelease in its served version
+// into out, a GA Go object.
+func (r *RollbackController) getConverted(ctx context.Context, kind string, key client.ObjectKey, out client.Object) error {
+	gvk := r.APIs.gvkFor(kind)
+	if !r.APIs.legacy(gvk) {
+		return r.Get(ctx, key, out)
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	if err := r.Get(ctx, key, u); err != nil {
+		return err
+	}
+	return convertLegacy(u, out)
+}
+
+// listConverted lists Kustomizations or HelmReleases in their served version
+// and converts each with newItem, which returns a fresh GA object.
+func (r *RollbackController) listConverted(ctx context.Context, kind string, newItem func() client.Object) ([]client.Object, error) {
+	gvk := r.APIs.gvkFor(kind)
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := r.List(ctx, list); err != nil {
+		return nil, err
+	}
+	items := make([]client.Object, 0, len(list.Items))
+	for i := range list.Items {
+		obj := newItem()
+		if err := convertLegacy(&list.Items[i], obj); err != nil {
+			return nil, err
+		}
+		items = append(items, obj)
+	}
+	return items, nil
+}
+
+// convertLegacy converts an object of any supported version to the GA Go
+// type. Fields removed in GA are dropped; renamed ones are not needed by the
+// controller.
+func convertLegacy(u *unstructured.Unstructured, out client.Object) error {
+	obj := u.DeepCopy().Object
+	delete(obj, "apiVersion")
+	delete(obj, "kind")
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(obj, out)
+}
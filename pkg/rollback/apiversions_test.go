@@ -0,0 +1,70 @@
+package rollback
+
+import (
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestServedFluxAPIs(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	legacyKs := schema.GroupVersionKind{Group: "kustomize.toolkit.fluxcd.io", Version: "v1beta2", Kind: "Kustomization"}
+	legacyHr := schema.GroupVersionKind{Group: "helm.toolkit.fluxcd.io", Version: "v2beta1", Kind: "HelmRelease"}
+	legacySrc := schema.GroupVersionKind{Group: "source.toolkit.fluxcd.io", Version: "v1beta2", Kind: "GitRepository"}
+	for _, gvk := range []schema.GroupVersionKind{legacyKs, legacyHr, legacySrc} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+
+	apis := servedFluxAPIs(mapper)
+	if apis.Kustomization != legacyKs || apis.HelmRelease != legacyHr || apis.Source != legacySrc.GroupVersion() {
+		t.Errorf("unexpected APIs %+v", apis)
+	}
+	if _, ok := apis.watchObject("HelmRelease").(*unstructured.Unstructured); !ok {
+		t.Error("legacy HelmRelease should be watched as unstructured")
+	}
+
+	mapper.Add(gaFluxAPIs.HelmRelease, meta.RESTScopeNamespace)
+	if apis := servedFluxAPIs(mapper); apis.HelmRelease != gaFluxAPIs.HelmRelease {
+		t.Errorf("GA version should win, got %v", apis.HelmRelease)
+	}
+	if _, ok := gaFluxAPIs.watchObject("HelmRelease").(*helmv2.HelmRelease); !ok {
+		t.Error("GA HelmRelease should be watched typed")
+	}
+}
+
+func TestConvertLegacyHelmRelease(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "helm.toolkit.fluxcd.io/v2beta1",
+		"kind":       "HelmRelease",
+		"metadata":   map[string]interface{}{"namespace": "apps", "name": "web"},
+		"spec": map[string]interface{}{
+			"chart": map[string]interface{}{"spec": map[string]interface{}{
+				"chart":      "web",
+				"version":    "1.2.3",
+				"valuesFile": "values-prod.yaml", // removed in v2
+				"sourceRef":  map[string]interface{}{"kind": "GitRepository", "name": "repo"},
+			}},
+		},
+		"status": map[string]interface{}{
+			"lastAttemptedRevision": "1.2.3",
+			"helmChart":             "flux-system/apps-web",
+			"conditions": []interface{}{map[string]interface{}{
+				"type": "Ready", "status": "False", "reason": "InstallFailed", "message": "boom",
+				"lastTransitionTime": "2024-01-02T03:04:05Z",
+			}},
+		},
+	}}
+	var hr helmv2.HelmRelease
+	if err := convertLegacy(u, &hr); err != nil {
+		t.Fatal(err)
+	}
+	if hr.Name != "web" || hr.Spec.Chart.Spec.Version != "1.2.3" || hr.Status.LastAttemptedRevision != "1.2.3" || defaultReadyCondition.ready(hr.Status.Conditions) {
+		t.Errorf("unexpected conversion %+v", hr)
+	}
+	if ns, name, ok := helmChartRef(&hr); !ok || ns != "flux-system" || name != "apps-web" {
+		t.Errorf("helmChartRef = %s/%s %v", ns, name, ok)
+	}
+}
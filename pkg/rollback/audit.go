@@ -0,0 +1,153 @@
+package rollback
+
+import "time"
+
+// maxAuditEntries bounds the in-memory audit log.
+const maxAuditEntries = 200
+
+// Audit events recorded by handleResource. They are also emitted as
+// CloudEvents when a sink is configured.
+const (
+	auditDetected  = "detected"
+	auditDebounced = "debounced" // failure outlasted the debounce window
+	auditReverted  = "reverted"
+	auditRecovered = "recovered"
+	auditSkipped   = "skipped" // the commit carries the skip marker
+	auditRearmed   = "rearmed" // a reverted commit is healthy again (RearmAfter)
+	// auditSuppressed: a dependency fails on the same revision and owns the
+	// incident (DependencySuppression).
+	auditSuppressed = "suppressed"
+	auditCancelled  = "cancelled" // the failing resource was deleted
+	auditHeld       = "held"      // a metrics gate or Flagger Canary holds the revert
+	// auditStabilizing: a failure within the post-revert stabilization
+	// window of its source, not acted upon.
+	auditStabilizing = "stabilizing"
+	// Escalation steps (RollbackPolicy escalation).
+	auditNotified   = "notified"
+	auditSuspended  = "suspended"
+	auditAutoMerged = "automerged"
+	// Further escalation actions: HelmRollback, WebhookCall and JobRun.
+	auditHelmRolledBack = "helmRolledBack"
+	auditWebhookCalled  = "webhookCalled"
+	auditJobStarted     = "jobStarted"
+	// Revert MR maintenance (MR_REBASE_CHECK_SECONDS).
+	auditRebased   = "rebased"
+	auditRecreated = "recreated"
+	auditMerged    = "merged"
+	// auditMRUpdated: a resource failing on a reverted commit was added to
+	// the description of its open revert MR.
+	auditMRUpdated = "mrUpdated"
+	// auditBranchDeleted: the branch of a merged revert MR was deleted
+	// (CLEANUP_MERGED_REVERTS).
+	auditBranchDeleted = "branchDeleted"
+	// auditMergeTrain: a revert MR joined the merge train of its project, or
+	// rejoined it after GitLab dropped it.
+	auditMergeTrain = "mergeTrain"
+	// auditStale: a revert MR outlasted the policy's mergeRequest.staleAfter
+	// while the resource kept failing.
+	auditStale = "stale"
+	// auditFlapping: the resource keeps flipping between Ready and not
+	// Ready (FLAP_THRESHOLD); its revert is held.
+	auditFlapping = "flapping"
+	// auditProviderFailed: the provider rejected the token or project of a
+	// revert (401, 403, 404), or the revert was held back because it does.
+	auditProviderFailed = "providerFailed"
+	// auditRevertFailed: the provider rejected the revert for another
+	// reason, e.g. the branch already exists; the message carries it.
+	auditRevertFailed = "revertFailed"
+	// auditDeadLettered: a revert failed on its last retry (REVERT_RETRIES)
+	// and waits in the dead-letter queue for an operator to retry it.
+	auditDeadLettered = "deadLettered"
+	// auditFenced: a newer leader claimed the state store, or already
+	// reverted the commit; this replica does not revert it.
+	auditFenced = "fenced"
+	// Rollback verification (RollbackPolicy verification): the Job's result
+	// after the revert landed.
+	auditVerified           = "verified"
+	auditVerificationFailed = "verificationFailed"
+	// auditBudgetExhausted: a revert is due, but the resource's failure
+	// domain spent its revert budget (FAILURE_DOMAIN_BUDGETS).
+	auditBudgetExhausted = "budgetExhausted"
+	// auditAlreadyRequested: another controller instance, e.g. in another
+	// cluster, already opened the revert MR or branch; none is created.
+	auditAlreadyRequested = "alreadyRequested"
+	// auditRefailed: a reverted revision the resource had recovered on fails
+	// again with another failure fingerprint; it is a new incident.
+	auditRefailed = "refailed"
+)
+
+// auditEntry is one step of a resource's rollback lifecycle.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	SHA       string    `json:"sha"`
+	Message   string    `json:"message,omitempty"`
+	clusterMetadata
+}
+
+// resourceStatus is the last observed state of a watched resource.
+type resourceStatus struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Ready     bool      `json:"ready"`
+	Revision  string    `json:"revision"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// RevisionSince is when Revision was first seen on the resource.
+	RevisionSince time.Time `json:"revisionSince"`
+}
+
+// revertRecord describes a revert the controller triggered.
+type revertRecord struct {
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	SHA       string    `json:"sha"`
+	URL       string    `json:"url,omitempty"` // MR link, if the action opened one
+	MRIID     int       `json:"mrIID,omitempty"`
+}
+
+// recordAudit appends an audit entry, dropping the oldest beyond
+// maxAuditEntries, and logs actions on the action channel. Callers must
+// hold r.mu.
+func (r *RollbackController) recordAudit(event, kind, namespace, name, sha, message string) {
+	e := auditEntry{
+		Time: r.clock.Now(), Event: event, Kind: kind, Namespace: namespace, Name: name, SHA: sha, Message: message, clusterMetadata: r.Metadata,
+	}
+	r.auditLog = append(r.auditLog, e)
+	if actionEvents[event] {
+		r.logAction(e)
+	}
+	if n := len(r.auditLog); n > maxAuditEntries {
+		r.auditLog = append([]auditEntry(nil), r.auditLog[n-maxAuditEntries:]...)
+	}
+}
+
+// setRevertMR attaches the MR to the revert record of sha. Actions call
+// this after opening an MR.
+func (r *RollbackController) setRevertMR(sha string, mr *gitlabMergeRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.reverts) - 1; i >= 0; i-- {
+		if r.reverts[i].SHA == sha {
+			r.reverts[i].URL = mr.WebURL
+			r.reverts[i].MRIID = mr.IID
+			return
+		}
+	}
+}
+
+// revertMR returns the IID of the MR recorded for the revert of sha, or 0.
+// Callers must hold r.mu.
+func (r *RollbackController) revertMR(sha string) int {
+	for i := len(r.reverts) - 1; i >= 0; i-- {
+		if r.reverts[i].SHA == sha {
+			return r.reverts[i].MRIID
+		}
+	}
+	return 0
+}
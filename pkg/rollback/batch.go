@@ -0,0 +1,171 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// batchItem is one bad commit waiting in a revert batch.
+type batchItem struct {
+	Res      resourceRef
+	Revision string // tracking key (Flux revision) of the failure
+	SHA      string
+}
+
+// revertBatch collects commit reverts for one project, target branch and
+// strategy until its deadline.
+type revertBatch struct {
+	gl       gitlabProject
+	policy   *RollbackPolicy // of the first item; supplies MR settings
+	items    []batchItem
+	deadline time.Time
+}
+
+func batchKey(gl gitlabProject, policy *RollbackPolicy) string {
+	return strings.Join([]string{gl.BaseURL, gl.ProjectID, policy.targetBranch(), policy.revertStrategy(RevertStrategyBranch)}, "|")
+}
+
+// revertCommit reverts the commit of revision for res. With a batch window
+// configured the revert joins the open batch for the project and is
+// delivered by runRevertBatcher; otherwise it is created right away.
+func (r *RollbackController) revertCommit(ctx context.Context, gl gitlabProject, res resourceRef, policy *RollbackPolicy, revision string) {
+	if gl.Provider == providerGerrit {
+		// Gerrit reverts one change per change; there is no batch branch.
+		r.trackMergeRequest(ctx, res, revision, r.createGerritRevert(gl, res, policy, revision))
+		return
+	}
+	if gl.Provider == providerCodeCommit {
+		r.trackMergeRequest(ctx, res, revision, r.createCodeCommitRevert(ctx, gl, res, policy, revision))
+		return
+	}
+	if gl.Provider == providerSSH {
+		r.createSSHRevert(ctx, gl, res, policy, revision)
+		return
+	}
+	if policy.pinImages() {
+		if mr, ok := r.pinImages(ctx, gl, res, policy, revision); ok {
+			r.trackMergeRequest(ctx, res, revision, mr)
+			return
+		}
+	}
+	if r.RevertBatchWindow <= 0 || r.criticalResource(res) {
+		r.trackMergeRequest(ctx, res, revision, r.createGitlabRevertMR(gl, res, policy, revision))
+		return
+	}
+	sha := gitCommitSHA(revision)
+	r.batchMu.Lock()
+	defer r.batchMu.Unlock()
+	key := batchKey(gl, policy)
+	b, ok := r.batches[key]
+	if !ok {
+		b = &revertBatch{gl: gl, policy: policy, deadline: r.clock.Now().Add(r.RevertBatchWindow)}
+		r.batches[key] = b
+	}
+	for _, it := range b.items {
+		if it.SHA == sha {
+			return // same commit broke several resources; revert it once
+		}
+	}
+	b.items = append(b.items, batchItem{Res: res, Revision: revision, SHA: sha})
+	r.log.Info("Revert added to batch", "sha", sha, "project", gl.ProjectID, "batchSize", len(b.items), "flushAt", b.deadline)
+}
+
+// dueBatches removes and returns the batches whose deadline has passed.
+func (r *RollbackController) dueBatches(now time.Time) []*revertBatch {
+	r.batchMu.Lock()
+	defer r.batchMu.Unlock()
+	var due []*revertBatch
+	for key, b := range r.batches {
+		if !now.Before(b.deadline) {
+			due = append(due, b)
+			delete(r.batches, key)
+		}
+	}
+	return due
+}
+
+// runRevertBatcher flushes revert batches once their window has passed.
+func (r *RollbackController) runRevertBatcher(ctx context.Context) error {
+	ticker := r.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C():
+			for _, b := range r.dueBatches(now) {
+				r.flushBatch(ctx, b)
+			}
+		}
+	}
+}
+
+// flushBatch reverts all commits of the batch on one branch, newest first so
+// later commits are undone before the ones they build on, and delivers the
+// branch with the batch's strategy. A single item is reverted as usual.
+func (r *RollbackController) flushBatch(ctx context.Context, b *revertBatch) {
+	defer r.lockProject(b.gl)()
+	if len(b.items) == 1 {
+		it := b.items[0]
+		r.trackMergeRequest(ctx, it.Res, it.Revision, r.createGitlabRevertMR(b.gl, it.Res, b.policy, it.Revision))
+		return
+	}
+	newest := b.items[len(b.items)-1]
+	strategy := b.policy.revertStrategy(RevertStrategyBranch)
+	branch := r.revertBranchName(branchNameData{Action: branchActionBatch, Strategy: strategy, Kind: newest.Res.Kind, Namespace: newest.Res.Namespace, Name: newest.Res.Name, SHA: newest.SHA},
+		fmt.Sprintf("%s-batch-%s", r.RevertBranchPrefix, newest.SHA))
+	var shas []string
+	for i := len(b.items) - 1; i >= 0; i-- {
+		shas = append(shas, b.items[i].SHA)
+	}
+	if dryRun() {
+		r.log.Info("ECHO: would revert batch", "shas", shas, "branch", branch, "strategy", strategy)
+		r.recordAction(b.gl, recordedAction{Action: "revertBatch", Strategy: strategy, Branch: branch, SHA: strings.Join(shas, ",")})
+		return
+	}
+	target, err := targetBranch(b.gl, b.policy)
+	if err != nil {
+		r.log.Error(err, "failed to get default branch")
+		return
+	}
+
+	var lines []string
+	for i := len(b.items) - 1; i >= 0; i-- {
+		it := b.items[i]
+		lines = append(lines, fmt.Sprintf("- `%s` (%s)", it.SHA, it.Res))
+	}
+	mr := gitlabMergeRequestOptions{
+		Title:       fmt.Sprintf("Revert %d commits", len(b.items)),
+		Description: "Flux resources failed after these commits; reverting them newest first:\n\n" + strings.Join(lines, "\n"),
+	}
+	if strategy == RevertStrategyMergeRequest {
+		r.prepareMergeRequest(b.gl, newest.Res, b.policy, newest.SHA, &mr)
+	}
+	created, err := deliverChange(b.gl, strategy, branch, target, mr, func(branch, start string) error {
+		if start != "" {
+			if err := b.gl.createBranch(branch, start); err != nil {
+				return err
+			}
+		}
+		for _, sha := range shas {
+			if err := b.gl.revertCommit(sha, branch); err != nil {
+				return fmt.Errorf("reverting %s: %w", sha, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.log.Error(err, "GitLab batch revert failed", "shas", shas, "strategy", strategy)
+		for _, it := range b.items {
+			r.recordRevertFailure(it.Res, it.Revision, err)
+		}
+		return
+	}
+	r.log.Info("Batch revert created successfully", "shas", shas, "strategy", strategy, "mr", created.webURL())
+	for _, it := range b.items {
+		r.reportBadCommit(b.gl, it.Res, it.SHA, created)
+		r.trackMergeRequest(ctx, it.Res, it.Revision, created)
+	}
+}
@@ -0,0 +1,48 @@
+package rollback
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestRevertBatch(t *testing.T) {
+	gl, calls := fakeGitLab(t)
+	r := NewRollbackController(nil, logr.Discard(), "", "42", "", "revert", 300)
+	clk := clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	r.setClock(clk)
+	r.RevertBatchWindow = time.Minute
+	sha1, sha2 := strings.Repeat("1", 40), strings.Repeat("2", 40)
+	ks := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+	hr := resourceRef{Kind: "HelmRelease", Namespace: "apps", Name: "web"}
+
+	r.revertCommit(context.Background(), gl, ks, nil, "main@sha1:"+sha1)
+	r.revertCommit(context.Background(), gl, hr, nil, "main@sha1:"+sha2)
+	r.revertCommit(context.Background(), gl, hr, nil, "main@sha1:"+sha1) // already batched
+	if len(*calls) != 0 {
+		t.Fatalf("batched reverts must not call GitLab before the window ends: %q", *calls)
+	}
+	if due := r.dueBatches(clk.Now().Add(59 * time.Second)); len(due) != 0 {
+		t.Fatalf("batch flushed before its deadline")
+	}
+
+	due := r.dueBatches(clk.Now().Add(time.Minute))
+	if len(due) != 1 || len(due[0].items) != 2 {
+		t.Fatalf("unexpected due batches %+v", due)
+	}
+	r.flushBatch(context.Background(), due[0])
+	want := []string{
+		"GET ",
+		"POST /repository/branches branch=revert-batch-" + sha2 + " ref=main",
+		"POST /repository/commits/" + sha2 + "/revert branch=revert-batch-" + sha2,
+		"POST /repository/commits/" + sha1 + "/revert branch=revert-batch-" + sha2,
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %q, want %q", *calls, want)
+	}
+}
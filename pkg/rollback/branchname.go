@@ -0,0 +1,95 @@
+package rollback
+
+import (
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Revert branch actions, passed to the branch name template as .Action.
+const (
+	branchActionRevert = "revert" // a commit revert
+	branchActionBatch  = "batch"  // a batch of commit reverts
+	branchActionFiles  = "files"  // a file revert of a HelmRelease
+	branchActionChart  = "chart"  // a chart version pin
+	branchActionPin    = "pin"    // an image pin
+)
+
+// branchNameData is passed to the REVERT_BRANCH_TEMPLATE template.
+type branchNameData struct {
+	Prefix    string // REVERT_BRANCH_PREFIX
+	Action    string // revert, batch, files, chart or pin
+	Strategy  string // the revert strategy delivering the branch
+	Kind      string
+	Namespace string
+	Name      string
+	SHA       string // the reverted commit; the newest one of a batch
+	ShortSHA  string // the first 7 characters of SHA
+	Version   string // the pinned chart version; chart pins only
+	Date      string // the current date as YYYYMMDD
+	clusterMetadata
+}
+
+// parseBranchNameTemplate parses a text/template revert branch name; an
+// empty spec returns nil, keeping the fixed names derived from the prefix.
+func parseBranchNameTemplate(spec string) (*template.Template, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	return template.New("branch name").Option("missingkey=error").Parse(spec)
+}
+
+var (
+	invalidRefChars = regexp.MustCompile(`[\x00-\x20\x7f~^:?*\[\\]+|@\{`)
+	repeatedSlashes = regexp.MustCompile(`/{2,}`)
+)
+
+// sanitizeBranchName makes name a valid git branch name following
+// git-check-ref-format: invalid characters become "-", components must not
+// start with "." or end with ".lock", and the name must not start or end
+// with "/" or ".".
+func sanitizeBranchName(name string) string {
+	name = invalidRefChars.ReplaceAllString(name, "-")
+	for strings.Contains(name, "..") {
+		name = strings.ReplaceAll(name, "..", ".")
+	}
+	name = repeatedSlashes.ReplaceAllString(name, "/")
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		p = strings.TrimLeft(p, ".")
+		for strings.HasSuffix(p, ".lock") {
+			p = strings.TrimSuffix(p, ".lock")
+		}
+		parts[i] = p
+	}
+	name = repeatedSlashes.ReplaceAllString(strings.Join(parts, "/"), "/")
+	return strings.Trim(name, "/.-")
+}
+
+// revertBranchName returns the name of the branch carrying a revert. Without
+// a branch name template it is fallback, the fixed name derived from the
+// prefix. A template that fails to render or renders an empty name is logged
+// and fallback is used instead.
+func (r *RollbackController) revertBranchName(data branchNameData, fallback string) string {
+	if r.BranchNameTemplate == nil {
+		return fallback
+	}
+	data.Prefix = r.RevertBranchPrefix
+	data.clusterMetadata = r.Metadata
+	data.Date = r.clock.Now().UTC().Format("20060102")
+	data.ShortSHA = data.SHA
+	if len(data.ShortSHA) > 7 {
+		data.ShortSHA = data.ShortSHA[:7]
+	}
+	var b strings.Builder
+	if err := r.BranchNameTemplate.Execute(&b, data); err != nil {
+		r.log.Error(err, "failed to render branch name template, using the default", "branch", fallback)
+		return fallback
+	}
+	name := sanitizeBranchName(b.String())
+	if name == "" {
+		r.log.Error(nil, "Branch name template rendered an empty name, using the default", "branch", fallback)
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,47 @@
+package rollback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestRevertBranchName(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 3, 5, 23, 0, 0, 0, time.UTC)))
+	data := branchNameData{Action: branchActionRevert, Strategy: RevertStrategyBranch, Kind: "Kustomization", Namespace: "apps", Name: "web", SHA: sha}
+
+	if got := r.revertBranchName(data, "revert-"+sha); got != "revert-"+sha {
+		t.Errorf("without a template got %q", got)
+	}
+
+	r.BranchNameTemplate, _ = parseBranchNameTemplate("{{.Prefix}}/{{.Namespace}}/{{.Name}}/{{.Action}}-{{.ShortSHA}}/{{.Date}}")
+	if got, want := r.revertBranchName(data, "revert-"+sha), "revert/apps/web/revert-0123456/20240305"; got != want {
+		t.Errorf("templated name %q, want %q", got, want)
+	}
+	pin := data
+	pin.Action = branchActionPin
+	if a, b := r.revertBranchName(data, ""), r.revertBranchName(pin, ""); a == b {
+		t.Errorf("revert and image pin of the same SHA share branch %q", a)
+	}
+
+	r.BranchNameTemplate, _ = parseBranchNameTemplate("{{.Prefix}}//{{.Kind}} {{.Name}}:{{.Version}}..lock/")
+	if got, want := r.revertBranchName(data, "revert-"+sha), "revert/Kustomization-web"; got != want {
+		t.Errorf("sanitized name %q, want %q", got, want)
+	}
+
+	r.BranchNameTemplate, _ = parseBranchNameTemplate("{{.Missing}}")
+	if got := r.revertBranchName(data, "revert-"+sha); got != "revert-"+sha {
+		t.Errorf("failing template must fall back to the default, got %q", got)
+	}
+	r.BranchNameTemplate, _ = parseBranchNameTemplate("{{if false}}x{{end}}")
+	if got := r.revertBranchName(data, "revert-"+sha); got != "revert-"+sha {
+		t.Errorf("empty name must fall back to the default, got %q", got)
+	}
+	if _, err := parseBranchNameTemplate("{{"); err == nil {
+		t.Error("invalid template accepted")
+	}
+}
@@ -0,0 +1,53 @@
+package rollback
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// buildInfo is constant 1, labelled with the platform the binary runs on, so
+// mixed amd64/arm64/s390x fleets show which image variant each replica uses.
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "rollback_controller_build_info",
+	Help: "Build and platform of the running controller; always 1.",
+}, []string{"goos", "goarch", "goversion", "revision"})
+
+func init() {
+	metrics.Registry.MustRegister(buildInfo)
+}
+
+// platform describes the running binary. The controller is pure Go (built
+// with CGO_ENABLED=0) and has no architecture-specific code paths; the
+// platform is only reported, never branched on.
+type platform struct {
+	OS        string
+	Arch      string
+	GoVersion string
+	Revision  string // VCS revision embedded by go build, if any
+	CGO       bool
+}
+
+// currentPlatform reads the platform from the runtime and the embedded build
+// settings.
+func currentPlatform() platform {
+	p := platform{OS: runtime.GOOS, Arch: runtime.GOARCH, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				p.Revision = s.Value
+			case "CGO_ENABLED":
+				p.CGO = s.Value == "1"
+			}
+		}
+	}
+	return p
+}
+
+// reportPlatform exports p as rollback_controller_build_info.
+func reportPlatform(p platform) {
+	buildInfo.WithLabelValues(p.OS, p.Arch, p.GoVersion, p.Revision).Set(1)
+}
@@ -0,0 +1,19 @@
+package rollback
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCurrentPlatform(t *testing.T) {
+	p := currentPlatform()
+	if p.OS != runtime.GOOS || p.Arch != runtime.GOARCH || p.GoVersion != runtime.Version() {
+		t.Errorf("unexpected platform %+v", p)
+	}
+	reportPlatform(p)
+	if v := testutil.ToFloat64(buildInfo.WithLabelValues(p.OS, p.Arch, p.GoVersion, p.Revision)); v != 1 {
+		t.Errorf("rollback_controller_build_info = %v, want 1", v)
+	}
+}
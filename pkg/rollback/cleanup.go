@@ -0,0 +1,170 @@
+package rollback
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// defaultPendingTTL is how long a pending failure or escalation is kept
+// without being observed failing again (PENDING_TTL_SECONDS).
+const defaultPendingTTL = 24 * time.Hour
+
+// isGone reports whether a Get failed because the object doesn't exist,
+// including because its kind is not served.
+func isGone(err error) bool {
+	return apierrors.IsNotFound(err) || meta.IsNoMatchError(err)
+}
+
+// forgetResource drops the tracking state of a deleted resource. If it was
+// failing, its rollback is cancelled: its pending failure and queued reverts
+// are dropped unless another resource still fails on the same revision, in
+// which case the queued revert is handed over to that resource. It reports
+// whether a pending failure or queued revert was dropped.
+func (r *RollbackController) forgetResource(res resourceRef) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := res.String()
+	st := r.resources[key]
+	delete(r.resources, key)
+	delete(r.suppressed, key)
+	delete(r.nudges, key)
+	delete(r.notifyOnly, key)
+	delete(r.metricsHeld, key)
+	delete(r.canaryHeld, key)
+	delete(r.budgetHeld, key)
+	delete(r.settling, key)
+	delete(r.critical, key)
+	delete(r.fingerprints, key)
+	r.forgetFlaps(res)
+	r.forgetMergeTrain(res)
+	if e := r.escalations[key]; e != nil {
+		delete(r.escalations, key)
+		delete(r.skipMarked, e.SHA)
+	}
+	if st == nil || st.Ready || st.Revision == "" {
+		return false
+	}
+	heir := r.failingOn(st.Revision)
+	cancelled := r.cancelQueuedReverts(res, heir)
+	for _, rev := range cancelled {
+		r.debounce.ClearCompleted(rev)
+	}
+	dropped := false
+	if heir == nil {
+		dropped = r.debounce.Forget(st.Revision)
+		delete(r.skipMarked, st.Revision)
+		delete(r.revertAttempts, st.Revision)
+	}
+	msg := "rollback cancelled — resource deleted"
+	if len(cancelled) > 0 {
+		msg += "; queued revert dropped"
+	}
+	r.log.Info("Resource deleted while failing, cancelling its rollback", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", st.Revision, "pendingDropped", dropped, "queuedDropped", len(cancelled))
+	r.recordAudit(auditCancelled, res.Kind, res.Namespace, res.Name, st.Revision, msg)
+	r.emitEvent(auditCancelled, res.Kind, res.Namespace, res.Name, st.Revision)
+	return dropped || len(cancelled) > 0
+}
+
+// failingOn returns another tracked resource failing on revision, or nil.
+// Callers must hold r.mu.
+func (r *RollbackController) failingOn(revision string) *resourceRef {
+	for _, st := range r.resources {
+		if !st.Ready && st.Revision == revision {
+			return &resourceRef{Kind: st.Kind, Namespace: st.Namespace, Name: st.Name}
+		}
+	}
+	return nil
+}
+
+// cancelQueuedReverts removes the batched reverts queued for res and returns
+// their revisions. With an heir, the reverts stay queued for it instead.
+func (r *RollbackController) cancelQueuedReverts(res resourceRef, heir *resourceRef) []string {
+	r.batchMu.Lock()
+	defer r.batchMu.Unlock()
+	var cancelled []string
+	for key, b := range r.batches {
+		items := b.items[:0]
+		for _, it := range b.items {
+			if it.Res == res {
+				if heir == nil {
+					cancelled = append(cancelled, it.Revision)
+					continue
+				}
+				it.Res = *heir
+			}
+			items = append(items, it)
+		}
+		b.items = items
+		if len(items) == 0 {
+			delete(r.batches, key)
+		}
+	}
+	return cancelled
+}
+
+// deletionHandler handles delete events of kind, cancelling the rollback of
+// deleted resources right away. The reconcile of the deletion does the same
+// when it can't tell the kind, e.g. after a missed event.
+func (r *RollbackController) deletionHandler(kind string) handler.EventHandler {
+	return handler.Funcs{
+		DeleteFunc: func(_ context.Context, e event.DeleteEvent, _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			r.forgetResource(resourceRef{Kind: kind, Namespace: e.Object.GetNamespace(), Name: e.Object.GetName()})
+		},
+	}
+}
+
+// expireStale drops pending failures and escalations that were not observed
+// failing for PendingTTL, e.g. of resources deleted while the controller was
+// down or whose requeue got lost, and ended stabilization windows.
+func (r *RollbackController) expireStale() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireStabilization()
+	for _, sha := range r.debounce.DropStale(r.PendingTTL) {
+		delete(r.skipMarked, sha)
+		r.log.Info("Pending failure expired without being observed again", "sha", sha, "ttl", r.PendingTTL)
+	}
+	now := r.clock.Now()
+	for key, e := range r.escalations {
+		if st := r.resources[key]; st != nil && now.Sub(st.LastSeen) < r.PendingTTL {
+			continue
+		}
+		delete(r.escalations, key)
+		delete(r.skipMarked, e.SHA)
+		r.log.Info("Escalation expired without being observed again", "resource", key, "sha", e.SHA, "ttl", r.PendingTTL)
+	}
+}
+
+// capRequeue shortens a requeue so that tracked state is observed again well
+// within PendingTTL and doesn't expire while the resource is still failing.
+func (r *RollbackController) capRequeue(d time.Duration) time.Duration {
+	if r.PendingTTL > 0 && d > r.PendingTTL/2 {
+		return r.PendingTTL / 2
+	}
+	return d
+}
+
+// runPendingExpiry periodically expires stale pending state.
+func (r *RollbackController) runPendingExpiry(ctx context.Context) error {
+	interval := r.PendingTTL / 10
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			r.expireStale()
+		}
+	}
+}
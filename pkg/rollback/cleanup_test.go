@@ -0,0 +1,110 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestForgetResource(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	noop := func(string) {}
+	r.handleResource("Kustomization", "apps", "flux-system", "main@sha1:bad", false, noop)
+	r.handleResource("Kustomization", "infra", "flux-system", "main@sha1:bad", false, noop)
+
+	if r.forgetResource(resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}) {
+		t.Error("dropped a pending failure another resource still fails on")
+	}
+	if _, ok := r.resources["Kustomization/flux-system/apps"]; ok {
+		t.Error("deleted resource still tracked")
+	}
+	if !r.forgetResource(resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "infra"}) {
+		t.Error("pending failure of the last failing resource not dropped")
+	}
+	if len(r.debounce.Pending()) != 0 {
+		t.Errorf("pending after deletion: %+v", r.debounce.Pending())
+	}
+}
+
+func TestReconcileDeletedResource(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newScheme()).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	r.handleResource("HelmRelease", "podinfo", "default", "main@sha1:bad", false, func(string) {})
+
+	req := ctrl.Request{}
+	req.Namespace, req.Name = "default", "podinfo"
+	if _, err := (&GenericReconciler{r}).Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.resources) != 0 || len(r.debounce.Pending()) != 0 {
+		t.Errorf("state left after deletion: resources %v, pending %+v", r.resources, r.debounce.Pending())
+	}
+}
+
+func TestExpireStale(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clk)
+	r.PendingTTL = time.Hour
+	r.handleResource("Kustomization", "apps", "flux-system", "main@sha1:old", false, func(string) {})
+	r.escalations["Kustomization/flux-system/apps"] = &escalation{SHA: "main@sha1:old", FirstSeen: start}
+
+	clk.SetTime(start.Add(30 * time.Minute))
+	r.handleResource("Kustomization", "web", "flux-system", "main@sha1:new", false, func(string) {})
+	clk.SetTime(start.Add(time.Hour))
+	r.expireStale()
+	pending := r.debounce.Pending()
+	if len(pending) != 1 || pending[0].Key != "main@sha1:new" {
+		t.Errorf("pending after expiry: %+v, want only main@sha1:new", pending)
+	}
+	if len(r.escalations) != 0 {
+		t.Errorf("stale escalation kept: %v", r.escalations)
+	}
+	if got := r.capRequeue(2 * time.Hour); got != 30*time.Minute {
+		t.Errorf("capRequeue(2h) = %v, want 30m", got)
+	}
+}
+
+func TestForgetResourceCancelsQueuedRevert(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	r.RevertBatchWindow = time.Minute
+	gl := gitlabProject{BaseURL: "https://gitlab", ProjectID: "7"}
+	apps := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+	web := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "web"}
+	r.handleResource(apps.Kind, apps.Name, apps.Namespace, "main@sha1:bad", false, func(string) {})
+	r.handleResource(web.Kind, web.Name, web.Namespace, "main@sha1:bad", false, func(sha string) {
+		r.revertCommit(context.Background(), gl, web, nil, sha)
+	})
+	if len(r.batches) != 1 {
+		t.Fatalf("expected a queued revert, got %d batches", len(r.batches))
+	}
+
+	// apps still fails on the commit: the queued revert is handed over.
+	if r.forgetResource(web) {
+		t.Error("dropped a queued revert another resource still needs")
+	}
+	for _, b := range r.batches {
+		if len(b.items) != 1 || b.items[0].Res != apps {
+			t.Errorf("queued revert not handed over: %+v", b.items)
+		}
+	}
+	if !r.forgetResource(apps) || len(r.batches) != 0 {
+		t.Errorf("queued revert of the last failing resource not cancelled: %d batches", len(r.batches))
+	}
+	if r.debounce.IsCompleted("main@sha1:bad") {
+		t.Error("cancelled revision still completed")
+	}
+	last := r.auditLog[len(r.auditLog)-1]
+	if last.Event != auditCancelled || last.Name != "apps" || last.Message != "rollback cancelled — resource deleted; queued revert dropped" {
+		t.Errorf("unexpected audit entry %+v", last)
+	}
+}
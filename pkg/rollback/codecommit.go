@@ -0,0 +1,266 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/codecommit"
+	cctypes "github.com/aws/aws-sdk-go-v2/service/codecommit/types"
+)
+
+// providerCodeCommit routes reverts to an AWS CodeCommit repository.
+const providerCodeCommit = "codecommit"
+
+// codeCommitRepo talks to one CodeCommit repository. Credentials come from
+// the AWS SDK default chain, which picks up IRSA (AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE injected by EKS) without extra configuration.
+type codeCommitRepo struct {
+	client *codecommit.Client
+	Name   string
+	Region string
+}
+
+// codeCommit returns the CodeCommit view of a routed project: ProjectID is
+// the repository name and BaseURL, if set, overrides the API endpoint (e.g.
+// a VPC endpoint).
+func (g gitlabProject) codeCommit(ctx context.Context) (*codeCommitRepo, error) {
+	var opts []func(*config.LoadOptions) error
+	if g.Region != "" {
+		opts = append(opts, config.WithRegion(g.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	httpClient, err := g.api.httpClient(g.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	client := codecommit.NewFromConfig(cfg, func(o *codecommit.Options) {
+		o.HTTPClient = httpClient
+		if g.BaseURL != "" {
+			o.BaseEndpoint = aws.String(g.BaseURL)
+		}
+	})
+	return &codeCommitRepo{client: client, Name: g.ProjectID, Region: cfg.Region}, nil
+}
+
+// commit returns the commit sha.
+func (c *codeCommitRepo) commit(ctx context.Context, sha string) (*cctypes.Commit, error) {
+	out, err := c.client.GetCommit(ctx, &codecommit.GetCommitInput{RepositoryName: &c.Name, CommitId: &sha})
+	if err != nil {
+		return nil, err
+	}
+	return out.Commit, nil
+}
+
+// commitMessage returns the message of sha.
+func (c *codeCommitRepo) commitMessage(ctx context.Context, sha string) (string, error) {
+	commit, err := c.commit(ctx, sha)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(commit.Message), nil
+}
+
+// diff returns the files changed between parent and sha in the form
+// revertActions expects.
+func (c *codeCommitRepo) diff(ctx context.Context, parent, sha string) ([]gitlabDiff, error) {
+	var diffs []gitlabDiff
+	p := codecommit.NewGetDifferencesPaginator(c.client, &codecommit.GetDifferencesInput{
+		RepositoryName:        &c.Name,
+		BeforeCommitSpecifier: &parent,
+		AfterCommitSpecifier:  &sha,
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range page.Differences {
+			var gd gitlabDiff
+			if d.BeforeBlob != nil {
+				gd.OldPath = aws.ToString(d.BeforeBlob.Path)
+			}
+			if d.AfterBlob != nil {
+				gd.NewPath = aws.ToString(d.AfterBlob.Path)
+			}
+			switch d.ChangeType {
+			case cctypes.ChangeTypeEnumAdded:
+				gd.NewFile = true
+			case cctypes.ChangeTypeEnumDeleted:
+				gd.DeletedFile = true
+			}
+			diffs = append(diffs, gd)
+		}
+	}
+	return diffs, nil
+}
+
+// fileContent returns the content of path at commit.
+func (c *codeCommitRepo) fileContent(ctx context.Context, path, commit string) (string, error) {
+	out, err := c.client.GetFile(ctx, &codecommit.GetFileInput{RepositoryName: &c.Name, FilePath: &path, CommitSpecifier: &commit})
+	if err != nil {
+		return "", err
+	}
+	return string(out.FileContent), nil
+}
+
+// defaultBranch returns the repository's default branch.
+func (c *codeCommitRepo) defaultBranch(ctx context.Context) (string, error) {
+	out, err := c.client.GetRepository(ctx, &codecommit.GetRepositoryInput{RepositoryName: &c.Name})
+	if err != nil {
+		return "", err
+	}
+	if out.RepositoryMetadata == nil || out.RepositoryMetadata.DefaultBranch == nil {
+		return "", errors.New("repository has no default branch")
+	}
+	return *out.RepositoryMetadata.DefaultBranch, nil
+}
+
+// branchHead returns the commit branch points to.
+func (c *codeCommitRepo) branchHead(ctx context.Context, branch string) (string, error) {
+	out, err := c.client.GetBranch(ctx, &codecommit.GetBranchInput{RepositoryName: &c.Name, BranchName: &branch})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Branch.CommitId), nil
+}
+
+// createBranch creates branch at commit.
+func (c *codeCommitRepo) createBranch(ctx context.Context, branch, commit string) error {
+	_, err := c.client.CreateBranch(ctx, &codecommit.CreateBranchInput{RepositoryName: &c.Name, BranchName: &branch, CommitId: &commit})
+	return err
+}
+
+// commitActions commits actions on branch, whose head must be parent.
+func (c *codeCommitRepo) commitActions(ctx context.Context, branch, parent, message string, actions []gitlabCommitAction) error {
+	in := &codecommit.CreateCommitInput{
+		RepositoryName: &c.Name,
+		BranchName:     &branch,
+		ParentCommitId: &parent,
+		CommitMessage:  &message,
+		AuthorName:     aws.String(revertAuthor.Name),
+	}
+	for _, a := range actions {
+		if a.Action == "delete" {
+			in.DeleteFiles = append(in.DeleteFiles, cctypes.DeleteFileEntry{FilePath: aws.String(a.FilePath)})
+			continue
+		}
+		in.PutFiles = append(in.PutFiles, cctypes.PutFileEntry{FilePath: aws.String(a.FilePath), FileContent: []byte(a.Content)})
+	}
+	_, err := c.client.CreateCommit(ctx, in)
+	return err
+}
+
+// createPullRequest opens a pull request from branch into target.
+func (c *codeCommitRepo) createPullRequest(ctx context.Context, branch, target, title, description string) (*gitlabMergeRequest, error) {
+	out, err := c.client.CreatePullRequest(ctx, &codecommit.CreatePullRequestInput{
+		Title:       &title,
+		Description: &description,
+		Targets:     []cctypes.Target{{RepositoryName: &c.Name, SourceReference: &branch, DestinationReference: &target}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(aws.ToString(out.PullRequest.PullRequestId))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected pull request id %q", aws.ToString(out.PullRequest.PullRequestId))
+	}
+	return &gitlabMergeRequest{IID: id, WebURL: c.pullRequestURL(id)}, nil
+}
+
+// pullRequestURL returns the console link of a pull request.
+func (c *codeCommitRepo) pullRequestURL(id int) string {
+	return fmt.Sprintf("https://%s.console.aws.amazon.com/codesuite/codecommit/repositories/%s/pull-requests/%d?region=%s", c.Region, c.Name, id, c.Region)
+}
+
+// createCodeCommitRevert reverts the commit of badSHA in a CodeCommit
+// repository. CodeCommit has no revert API, so the commit is undone by
+// restoring every file it changed to its parent's content, then delivered
+// following the policy's revert strategy like a GitLab revert. It returns the
+// pull request, if one was opened.
+func (r *RollbackController) createCodeCommitRevert(ctx context.Context, gl gitlabProject, res resourceRef, policy *RollbackPolicy, badSHA string) *gitlabMergeRequest {
+	sha := gitCommitSHA(badSHA)
+	strategy := policy.revertStrategy(RevertStrategyBranch)
+	branch := r.revertBranchName(branchNameData{Action: branchActionRevert, Strategy: strategy, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: sha},
+		fmt.Sprintf("%s-%s", r.RevertBranchPrefix, sha))
+	if dryRun() {
+		r.log.Info("ECHO: would revert CodeCommit commit", "repository", gl.ProjectID, "sha", sha, "branch", branch, "strategy", strategy)
+		r.recordAction(gl, recordedAction{Action: "revert", Strategy: strategy, Branch: branch, SHA: sha, Namespace: res.Namespace, Name: res.Name})
+		return nil
+	}
+	created, err := r.revertCodeCommit(ctx, gl, res, policy, sha, branch, strategy)
+	if err != nil {
+		r.log.Error(err, "CodeCommit revert failed", "repository", gl.ProjectID, "sha", sha, "strategy", strategy)
+		r.recordRevertFailure(res, badSHA, err)
+		return nil
+	}
+	r.log.Info("Revert commit created successfully", "repository", gl.ProjectID, "sha", sha, "strategy", strategy, "mr", created.webURL())
+	return created
+}
+
+// revertCodeCommit does the work of createCodeCommitRevert.
+func (r *RollbackController) revertCodeCommit(ctx context.Context, gl gitlabProject, res resourceRef, policy *RollbackPolicy, sha, branch, strategy string) (*gitlabMergeRequest, error) {
+	repo, err := gl.codeCommit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bad, err := repo.commit(ctx, sha)
+	if err != nil {
+		return nil, fmt.Errorf("getting commit: %w", err)
+	}
+	if len(bad.Parents) == 0 {
+		return nil, errors.New("cannot revert a root commit")
+	}
+	parent := bad.Parents[0]
+	diffs, err := repo.diff(ctx, parent, sha)
+	if err != nil {
+		return nil, fmt.Errorf("getting differences: %w", err)
+	}
+	actions, err := revertActions(diffs, func(path string) (string, error) {
+		return repo.fileContent(ctx, path, parent)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading files at parent commit %s: %w", parent, err)
+	}
+	target := policy.targetBranch()
+	if target == "" {
+		if target, err = repo.defaultBranch(ctx); err != nil {
+			return nil, fmt.Errorf("getting default branch: %w", err)
+		}
+	}
+	head, err := repo.branchHead(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("getting head of %s: %w", target, err)
+	}
+	message := r.revertMessage(res, sha, firstLine(aws.ToString(bad.Message)), "")
+	if strategy == RevertStrategyDirect {
+		return nil, repo.commitActions(ctx, target, head, message, actions)
+	}
+	if strategy != RevertStrategyBranch && strategy != RevertStrategyMergeRequest {
+		return nil, fmt.Errorf("unknown revert strategy %q", strategy)
+	}
+	if err := repo.createBranch(ctx, branch, head); err != nil {
+		return nil, fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+	if err := repo.commitActions(ctx, branch, head, message, actions); err != nil {
+		return nil, err
+	}
+	if strategy == RevertStrategyBranch {
+		return nil, nil
+	}
+	description := fmt.Sprintf("Flux resources failed after %s; reverting it.", sha) + r.resourceLinks(res, sha)
+	return repo.createPullRequest(ctx, branch, target, fmt.Sprintf("Revert %s", sha), description)
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,114 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+// fakeCodeCommit answers CodeCommit JSON API calls with canned responses by
+// operation and records "Operation body" of every call.
+func fakeCodeCommit(t *testing.T, responses map[string]string) (string, *[]string) {
+	t.Helper()
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		op := strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "CodeCommit_20150413.")
+		var body map[string]interface{}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		delete(body, "clientRequestToken")
+		data, _ := json.Marshal(body)
+		calls = append(calls, op+" "+string(data))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		resp, ok := responses[op]
+		if !ok {
+			resp = "{}"
+		}
+		_, _ = w.Write([]byte(resp))
+	}))
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return srv.URL, &calls
+}
+
+func TestCreateCodeCommitRevert(t *testing.T) {
+	url, calls := fakeCodeCommit(t, map[string]string{
+		"GetCommit":         `{"commit":{"commitId":"abc","parents":["p1"],"message":"Bump web\n\ndetails"}}`,
+		"GetDifferences":    `{"differences":[{"changeType":"M","beforeBlob":{"path":"apps/web.yaml"},"afterBlob":{"path":"apps/web.yaml"}},{"changeType":"A","afterBlob":{"path":"apps/new.yaml"}}]}`,
+		"GetFile":           `{"fileContent":"b2xkCg=="}`,
+		"GetRepository":     `{"repositoryMetadata":{"defaultBranch":"main"}}`,
+		"GetBranch":         `{"branch":{"branchName":"main","commitId":"head1"}}`,
+		"CreatePullRequest": `{"pullRequest":{"pullRequestId":"17"}}`,
+	})
+	gl := gitlabProject{BaseURL: url, ProjectID: "fleet", Provider: providerCodeCommit, Region: "eu-west-1"}
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{RevertStrategy: RevertStrategyMergeRequest}}
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+
+	mr := r.createCodeCommitRevert(context.Background(), gl, res, policy, "main@sha1:abc")
+	if mr == nil || mr.IID != 17 || !strings.Contains(mr.WebURL, "eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/fleet/pull-requests/17") {
+		t.Fatalf("unexpected pull request %+v", mr)
+	}
+	var ops []string
+	for _, c := range *calls {
+		ops = append(ops, strings.Fields(c)[0])
+	}
+	want := []string{"GetCommit", "GetDifferences", "GetFile", "GetRepository", "GetBranch", "CreateBranch", "CreateCommit", "CreatePullRequest"}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("operations %v, want %v", ops, want)
+	}
+	var commit struct {
+		BranchName     string
+		ParentCommitID string `json:"parentCommitId"`
+		CommitMessage  string
+		PutFiles       []struct{ FilePath, FileContent string }
+		DeleteFiles    []struct{ FilePath string }
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix((*calls)[6], "CreateCommit ")), &commit); err != nil {
+		t.Fatal(err)
+	}
+	if commit.BranchName != "revert-abc" || commit.ParentCommitID != "head1" || !strings.HasPrefix(commit.CommitMessage, "Revert \"Bump web\"\n\nThis reverts commit abc.") || !isControllerRevert(commit.CommitMessage) {
+		t.Errorf("unexpected commit %+v", commit)
+	}
+	if len(commit.PutFiles) != 1 || commit.PutFiles[0].FilePath != "apps/web.yaml" || commit.PutFiles[0].FileContent != "b2xkCg==" {
+		t.Errorf("put files %+v, want apps/web.yaml restored", commit.PutFiles)
+	}
+	if len(commit.DeleteFiles) != 1 || commit.DeleteFiles[0].FilePath != "apps/new.yaml" {
+		t.Errorf("delete files %+v, want apps/new.yaml", commit.DeleteFiles)
+	}
+}
+
+func TestCodeCommitRevertDirect(t *testing.T) {
+	url, calls := fakeCodeCommit(t, map[string]string{
+		"GetCommit":      `{"commit":{"commitId":"abc","parents":["p1"],"message":"Bump"}}`,
+		"GetDifferences": `{"differences":[{"changeType":"D","beforeBlob":{"path":"a.yaml"}}]}`,
+		"GetFile":        `{"fileContent":"YQ=="}`,
+		"GetBranch":      `{"branch":{"commitId":"head1"}}`,
+	})
+	gl := gitlabProject{BaseURL: url, ProjectID: "fleet", Provider: providerCodeCommit, Region: "eu-west-1"}
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{RevertStrategy: RevertStrategyDirect, TargetBranch: "prod"}}
+
+	if mr := r.createCodeCommitRevert(context.Background(), gl, resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}, policy, "abc"); mr != nil {
+		t.Errorf("direct revert opened %+v", mr)
+	}
+	last := (*calls)[len(*calls)-1]
+	if !strings.HasPrefix(last, "CreateCommit ") || !strings.Contains(last, `"branchName":"prod"`) || !strings.Contains(last, `"filePath":"a.yaml"`) {
+		t.Errorf("last call %s, want a commit on prod restoring a.yaml", last)
+	}
+}
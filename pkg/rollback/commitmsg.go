@@ -0,0 +1,84 @@
+package rollback
+
+import (
+	"bufio"
+	"strings"
+	"text/template"
+)
+
+// revertTrailer ends every revert commit message the controller writes, so a
+// failure on one of its own reverts is recognised and not reverted again.
+const revertTrailer = "Rollback-Controller: true"
+
+// defaultCommitMessageTemplate is used when REVERT_COMMIT_MESSAGE_TEMPLATE is
+// not set. It follows "git revert" and adds the failing resource.
+const defaultCommitMessageTemplate = `Revert {{if .Subject}}"{{.Subject}}"{{else}}{{.SHA}}{{end}}
+
+This reverts commit {{.SHA}}.
+
+Automated revert: {{.Kind}} {{.Namespace}}/{{.Name}}{{with .Cluster}} in cluster {{.}}{{end}} failed on this commit.{{.Links}}`
+
+// commitMessageData is passed to the commit message template.
+type commitMessageData struct {
+	SHA       string
+	Subject   string // first line of the bad commit's message, if known
+	Kind      string
+	Namespace string
+	Name      string
+	clusterMetadata
+	// Links is the failing resource section otherwise put in the MR
+	// description; only set where the commit message is the only
+	// description (Gerrit).
+	Links string
+}
+
+// parseCommitMessageTemplate parses a text/template commit message; an empty
+// spec selects the default.
+func parseCommitMessageTemplate(spec string) (*template.Template, error) {
+	if spec == "" {
+		spec = defaultCommitMessageTemplate
+	}
+	return template.New("commit message").Option("missingkey=error").Parse(spec)
+}
+
+var defaultCommitMessage = template.Must(parseCommitMessageTemplate(""))
+
+// revertMessage renders the commit message reverting sha for res and ends it
+// with revertTrailer. A failing custom template is logged and the default is
+// used instead.
+func (r *RollbackController) revertMessage(res resourceRef, sha, subject, links string) string {
+	data := commitMessageData{SHA: sha, Subject: subject, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, Links: links, clusterMetadata: r.Metadata}
+	tmpl := r.CommitMessageTemplate
+	if tmpl == nil {
+		tmpl = defaultCommitMessage
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		r.log.Error(err, "failed to render commit message template, using the default")
+		b.Reset()
+		_ = defaultCommitMessage.Execute(&b, data)
+	}
+	return withRevertTrailer(b.String())
+}
+
+// withRevertTrailer appends revertTrailer to message unless it already has
+// it.
+func withRevertTrailer(message string) string {
+	message = strings.TrimRight(message, "\n")
+	if isControllerRevert(message) {
+		return message
+	}
+	return message + "\n\n" + revertTrailer
+}
+
+// isControllerRevert reports whether message carries revertTrailer on a line
+// of its own.
+func isControllerRevert(message string) bool {
+	s := bufio.NewScanner(strings.NewReader(message))
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == revertTrailer {
+			return true
+		}
+	}
+	return false
+}
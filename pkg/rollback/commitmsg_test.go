@@ -0,0 +1,50 @@
+package rollback
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRevertMessage(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	res := resourceRef{Kind: "HelmRelease", Namespace: "apps", Name: "web"}
+
+	want := "Revert \"Bump chart\"\n\nThis reverts commit abc.\n\nAutomated revert: HelmRelease apps/web failed on this commit.\n\n" + revertTrailer
+	if got := r.revertMessage(res, "abc", "Bump chart", ""); got != want {
+		t.Errorf("default message\n%q\nwant\n%q", got, want)
+	}
+
+	tmpl, err := parseCommitMessageTemplate("rollback({{.Namespace}}/{{.Name}}): revert {{.SHA}} on {{.Cluster}}\n\n" + revertTrailer + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.CommitMessageTemplate = tmpl
+	r.Metadata.Cluster = "prod-eu"
+	want = "rollback(apps/web): revert abc on prod-eu\n\n" + revertTrailer
+	if got := r.revertMessage(res, "abc", "", ""); got != want {
+		t.Errorf("custom message %q, want %q (trailer not duplicated)", got, want)
+	}
+
+	r.CommitMessageTemplate, _ = parseCommitMessageTemplate("{{.Missing}}")
+	if got := r.revertMessage(res, "abc", "", ""); !strings.HasPrefix(got, "Revert abc\n") || !isControllerRevert(got) {
+		t.Errorf("failing template must fall back to the default, got %q", got)
+	}
+	if _, err := parseCommitMessageTemplate("{{"); err == nil {
+		t.Error("invalid template accepted")
+	}
+}
+
+func TestIsControllerRevert(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"Revert x\n\nRollback-Controller: true":       true,
+		"Revert x\n\nRollback-Controller: true\n":     true,
+		"Mentions Rollback-Controller: true in prose": false,
+		"Bump replicas": false,
+	} {
+		if got := isControllerRevert(msg); got != want {
+			t.Errorf("isControllerRevert(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package rollback
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultCommitStatusName is the name of the commit status set on bad
+// commits (COMMIT_STATUS_NAME).
+const defaultCommitStatusName = "cluster-health"
+
+// gitlabCommitStatus is the body of POST /statuses/:sha.
+type gitlabCommitStatus struct {
+	State       string `json:"state"`
+	Name        string `json:"name"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// setCommitStatus sets a commit status on sha.
+func (g gitlabProject) setCommitStatus(sha string, status gitlabCommitStatus) error {
+	return g.request("POST", "/statuses/"+url.PathEscape(sha), status, nil)
+}
+
+// statusTargetURL returns the link of the commit status for res: the first
+// resource link template, else the revert MR.
+func (r *RollbackController) statusTargetURL(res resourceRef, sha string, mr *gitlabMergeRequest) string {
+	data := linkData{Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: sha, clusterMetadata: r.Metadata}
+	for _, l := range r.LinkTemplates {
+		var u strings.Builder
+		if err := l.URL.Execute(&u, data); err == nil {
+			return u.String()
+		}
+	}
+	return mr.webURL()
+}
+
+// reportBadCommit sets a failed CommitStatusName status on the reverted
+// commit sha, so the failure shows on the original commit and MR pages too.
+// It is best effort: errors are logged, the revert stands.
+func (r *RollbackController) reportBadCommit(gl gitlabProject, res resourceRef, sha string, mr *gitlabMergeRequest) {
+	if r.CommitStatusName == "" || !gl.isGitLab() {
+		return
+	}
+	description := fmt.Sprintf("%s failed on this commit; reverted", res)
+	if u := mr.webURL(); u != "" {
+		description = fmt.Sprintf("%s failed on this commit; revert %s", res, u)
+	}
+	if len(description) > 255 {
+		description = description[:255]
+	}
+	status := gitlabCommitStatus{
+		State:       "failed",
+		Name:        r.CommitStatusName,
+		TargetURL:   r.statusTargetURL(res, sha, mr),
+		Description: description,
+	}
+	if err := gl.setCommitStatus(sha, status); err != nil {
+		r.log.Error(err, "failed to set commit status", "sha", sha, "name", r.CommitStatusName)
+	}
+}
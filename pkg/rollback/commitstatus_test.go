@@ -0,0 +1,72 @@
+package rollback
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestReportBadCommit(t *testing.T) {
+	sha := strings.Repeat("b", 40)
+	var statuses []gitlabCommitStatus
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42")
+		switch {
+		case path == "/statuses/"+sha:
+			var s gitlabCommitStatus
+			if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+				t.Error(err)
+			}
+			statuses = append(statuses, s)
+			_, _ = w.Write([]byte(`{}`))
+		case path == "" && req.Method == "GET":
+			_, _ = w.Write([]byte(`{"default_branch":"main"}`))
+		case path == "/merge_requests":
+			_, _ = w.Write([]byte(`{"iid":1,"web_url":"https://gitlab/mr/1"}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	gl := gitlabProject{BaseURL: srv.URL, ProjectID: "42"}
+	r := NewRollbackController(nil, logr.Discard(), "", "42", "", "revert", 300)
+	r.CommitStatusName = defaultCommitStatusName
+	links, err := parseLinkTemplates("Grafana=https://grafana/d/flux?var-name={{.Name}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.LinkTemplates = links
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{RevertStrategy: RevertStrategyMergeRequest}}
+
+	r.createGitlabRevertMR(gl, res, policy, "main@sha1:"+sha)
+	want := gitlabCommitStatus{
+		State:       "failed",
+		Name:        "cluster-health",
+		TargetURL:   "https://grafana/d/flux?var-name=apps",
+		Description: "Kustomization/flux-system/apps failed on this commit; revert https://gitlab/mr/1",
+	}
+	if len(statuses) != 1 || statuses[0] != want {
+		t.Errorf("statuses = %+v, want %+v", statuses, want)
+	}
+
+	// Without link templates the status links the revert MR; other
+	// providers and an empty name set none.
+	r.LinkTemplates = nil
+	r.reportBadCommit(gl, res, sha, &gitlabMergeRequest{IID: 1, WebURL: "https://gitlab/mr/1"})
+	if len(statuses) != 2 || statuses[1].TargetURL != "https://gitlab/mr/1" {
+		t.Errorf("statuses = %+v", statuses)
+	}
+	gerrit := gl
+	gerrit.Provider = providerGerrit
+	r.reportBadCommit(gerrit, res, sha, nil)
+	r.CommitStatusName = ""
+	r.reportBadCommit(gl, res, sha, nil)
+	if len(statuses) != 2 {
+		t.Errorf("unexpected statuses %+v", statuses[2:])
+	}
+}
@@ -0,0 +1,58 @@
+package rollback
+
+import (
+	"context"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// failingRequests returns reconcile requests for the failing Kustomizations
+// and HelmReleases in namespace, or in all namespaces if it is "".
+func (r *RollbackController) failingRequests(namespace string) []reconcile.Request {
+	r.mu.Lock()
+	seen := make(map[types.NamespacedName]bool)
+	for _, res := range r.resources {
+		if res.Ready || (res.Kind != "Kustomization" && res.Kind != "HelmRelease") {
+			continue
+		}
+		if namespace == "" || res.Namespace == namespace {
+			seen[types.NamespacedName{Namespace: res.Namespace, Name: res.Name}] = true
+		}
+	}
+	r.mu.Unlock()
+	reqs := make([]reconcile.Request, 0, len(seen))
+	for key := range seen {
+		reqs = append(reqs, reconcile.Request{NamespacedName: key})
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].String() < reqs[j].String() })
+	return reqs
+}
+
+// namespaceConfigRequests maps a change of a Namespace's annotations to
+// reconciles of the resources failing in it, so their pending debounce
+// windows are re-evaluated against the new default-debounce right away:
+// a shortened window that already passed reverts, a longer one keeps
+// waiting from the original failure.
+func (r *RollbackController) namespaceConfigRequests(_ context.Context, obj client.Object) []reconcile.Request {
+	reqs := r.failingRequests(obj.GetName())
+	if len(reqs) > 0 {
+		r.log.Info("Namespace defaults changed, re-evaluating failing resources", "namespace", obj.GetName(), "resources", len(reqs))
+	}
+	return reqs
+}
+
+// policyConfigRequests maps a RollbackPolicy change to reconciles of all
+// failing resources: a change of its targets can move resources in or out
+// of the policy, and running escalations are re-timed against the new steps.
+// Target kinds not watched yet are watched from now on.
+func (r *RollbackController) policyConfigRequests(_ context.Context, obj client.Object) []reconcile.Request {
+	r.watchPolicyTargets(obj)
+	reqs := r.failingRequests("")
+	if len(reqs) > 0 {
+		r.log.Info("RollbackPolicy changed, re-evaluating failing resources", "namespace", obj.GetNamespace(), "name", obj.GetName(), "resources", len(reqs))
+	}
+	return reqs
+}
@@ -0,0 +1,56 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConfigChangeRequests(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	noop := func(string) {}
+	r.handleResource("Kustomization", "web", "team-a", "main@sha1:aaa", false, noop)
+	r.handleResource("HelmRelease", "web", "team-a", "main@sha1:aaa", false, noop)
+	r.handleResource("Kustomization", "api", "team-b", "main@sha1:bbb", false, noop)
+	r.handleResource("Kustomization", "ok", "team-a", "main@sha1:ccc", true, noop)
+	r.handleResource("GitRepository", "fleet", "team-a", "main@sha1:aaa", false, noop)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	if got := r.namespaceConfigRequests(context.Background(), ns); len(got) != 1 || got[0].String() != "team-a/web" {
+		t.Errorf("namespace change requests = %v, want team-a/web", got)
+	}
+	if got := r.policyConfigRequests(context.Background(), &unstructured.Unstructured{}); len(got) != 2 || got[0].String() != "team-a/web" || got[1].String() != "team-b/api" {
+		t.Errorf("policy change requests = %v", got)
+	}
+}
+
+func TestNamespaceDebounceChangeRetimesPending(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(ns).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "team-a", Name: "web"}
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+
+	r.remediate(ctx, res, "main@sha1:aaa", false, nil, revert)
+	clk.Step(2 * time.Minute)
+	ns.Annotations = map[string]string{namespaceDebounceAnnotation: "1m"}
+	if err := c.Update(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+	// The reconcile requested by the change fires the shortened window.
+	if got := r.remediate(ctx, res, "main@sha1:aaa", false, nil, revert); got.Action != DecisionReverted || len(reverted) != 1 {
+		t.Errorf("after shortening: %+v, reverted %v", got, reverted)
+	}
+}
@@ -0,0 +1,745 @@
+package rollback
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/eumel8/rollback-controller/pkg/debounce"
+)
+
+type RollbackController struct {
+	client.Client
+	log                logr.Logger
+	GitlabToken        string
+	GitlabProjectID    string
+	GitlabBaseURL      string
+	RevertBranchPrefix string
+	DebounceSeconds    int
+	Namespace          string         // namespace the controller runs in (Secrets, ConfigMaps)
+	RoutingConfigMap   string         // optional ConfigMap with resource-to-project routing rules
+	RecordFile         string         // REVERT_MODE=record: JSON lines file for would-be actions
+	RecordConfigMap    string         // REVERT_MODE=record: ConfigMap for would-be actions
+	LinkTemplates      []linkTemplate // deep links added to MR descriptions
+	APIs               fluxAPIs       // Flux API versions served by the cluster
+	Features           featureGates   // --feature-gates / FEATURE_GATES
+	RevertBatchWindow  time.Duration  // collect commit reverts per project for this long; 0 = off
+	// RearmAfter lets a completed SHA that is seen Ready again at least this
+	// long after its revert trigger a new revert if it fails later, e.g.
+	// after a force-push or an intentional revert of the revert; 0 = never.
+	RearmAfter time.Duration
+	// RevertRetries is how often a failed revert is retried, each after a
+	// new debounce window, before it is dead-lettered.
+	RevertRetries int
+	// SourceFailureThreshold is the percentage of a GitRepository's consumers
+	// that must fail on a revision before it is reverted (SourceAggregation).
+	SourceFailureThreshold int
+	// PendingTTL drops pending failures and escalations not observed failing
+	// for this long; 0 keeps them until they resolve.
+	PendingTTL time.Duration
+	// StateMaxAge drops pending and completed revisions older than this from
+	// the persisted state; 0 keeps them.
+	StateMaxAge time.Duration
+	// StateMaxEntries caps the completed revisions in the persisted state,
+	// dropping the oldest; 0 = no cap.
+	StateMaxEntries int
+	// StabilizationWindow is how long after a revert new failures on the
+	// same GitRepository are recorded but not acted upon; 0 = off.
+	StabilizationWindow time.Duration
+	// SkipMarker in a bad commit's message suppresses its revert; "" disables
+	// the check.
+	SkipMarker string
+	// CommitMessageTemplate renders revert commit messages where the provider
+	// accepts one; nil uses defaultCommitMessageTemplate.
+	CommitMessageTemplate *template.Template
+	// Metadata describes the cluster to templates, audit entries and
+	// metrics.
+	Metadata         clusterMetadata
+	EnvironmentLabel string // label naming the environment of resources and namespaces
+	PrometheusURL    string // default Prometheus for policy metricsGate queries
+	// ReadyConditions replace the Ready condition of a kind (READY_CONDITIONS).
+	ReadyConditions map[string]readyCondition
+	// Budgets bounds the reverts per failure domain; nil = unlimited.
+	Budgets *domainBudgets
+	// DomainNotifiers receive the incidents of resources in their failure
+	// domain whose policy sets no notifications, instead of INCIDENT_NOTIFIER.
+	DomainNotifiers map[string]incidentNotifier
+	// BranchNameTemplate renders revert branch names; nil keeps the fixed
+	// names derived from RevertBranchPrefix.
+	BranchNameTemplate *template.Template
+	// CommitStatusName is the failed commit status set on reverted commits
+	// in GitLab; "" disables it.
+	CommitStatusName string
+	// FlapThreshold holds the reverts of resources that failed this many
+	// times within FlapWindow; 0 = off.
+	FlapThreshold int
+	FlapWindow    time.Duration
+	// RevisionChangeWindow only notifies about failures that started longer
+	// than this after the resource's revision last changed; 0 = off.
+	RevisionChangeWindow time.Duration
+	// MRWatchOnly makes runMRRebaser only look for merged revert MRs
+	// (ReconcileOnMerge or CleanupMergedReverts without
+	// MR_REBASE_CHECK_SECONDS).
+	MRWatchOnly bool
+	// CleanupMergedReverts deletes the branch of a merged revert MR, if
+	// GitLab left it, and closes its incident (CLEANUP_MERGED_REVERTS).
+	CleanupMergedReverts bool
+	// ActionLog additionally receives the action channel (reverts,
+	// suspensions, MR changes) at every log level (ACTION_LOG).
+	ActionLog logr.Logger
+	// CriticalDebounce is the debounce window of resources labelled
+	// rollback.eumel8.io/critical=true; 0 reverts on the first failure.
+	CriticalDebounce time.Duration
+	// CriticalRevertStrategy is the revert strategy of critical resources.
+	CriticalRevertStrategy string
+	// APIReader reads objects not worth an informer, e.g. Flagger Canaries
+	// and their targets; nil uses the client.
+	APIReader client.Reader
+	// Instance identifies this controller, "<CLUSTER_NAME>/<deployment>" or
+	// CONTROLLER_INSTANCE (see instanceID); "" for a single cluster.
+	Instance string
+
+	// mu guards the tracking state below, which the dashboard reads
+	// concurrently with reconciles.
+	mu              sync.Mutex
+	debounce        *debounce.Debouncer        // pending and already-reverted SHAs
+	escalations     map[string]*escalation     // "Kind/namespace/name" -> running escalation
+	skipMarked      map[string]bool            // failing revisions checked for SkipMarker
+	suppressed      map[string]string          // "Kind/namespace/name" -> revision left to a failing dependency
+	nudges          map[string]*nudge          // "Kind/namespace/name" -> reconcile requested before reverting
+	notifyOnly      map[string]string          // "Kind/namespace/name" -> environment not reverted (revertEnvironments)
+	metricsHeld     map[string]string          // "Kind/namespace/name" -> revision held by the metrics gate
+	canaryHeld      map[string]string          // "Kind/namespace/name" -> revision held by a Flagger Canary
+	budgetHeld      map[string]string          // "Kind/namespace/name" -> revision held by its failure domain's budget
+	revertAttempts  map[string]int             // revision -> failed reverts retried so far
+	deadLetters     map[string]deadLetter      // revision -> revert that failed on every retry
+	stabilizing     map[string]time.Time       // GitRepository "namespace/name" -> end of its post-revert window
+	settling        map[string]string          // "Kind/namespace/name" -> revision failing within that window
+	nsDebounce      map[string]time.Duration   // namespace -> debounce set by its default-debounce annotation
+	mrSettled       map[string]bool            // revert MR URLs no longer checked for rebasing
+	staleEscalated  map[string]bool            // revert MR URLs already escalated as stale
+	mrAffected      map[string][]string        // revert MR URL -> resources its description lists
+	mergeTrains     map[string]*mergeTrainCar  // "Kind/namespace/name" -> revert MR it added to a merge train
+	completedAt     map[string]time.Time       // revision -> completion time restored from STATE_STORE
+	fingerprints    map[string]*failureHistory // "Kind/namespace/name" -> failure fingerprints
+	critical        map[string]bool            // "Kind/namespace/name" -> labelled rollback.eumel8.io/critical=true
+	flaps           map[string]*flapState      // "Kind/namespace/name" -> Ready history
+	verifications   map[string]*verification   // "Kind/namespace/name@sha" -> verification of that revert
+	notGitSourced   map[string]bool            // HelmReleases already reported as not Git-sourced
+	invalidPolicies map[string]string          // RollbackPolicy "namespace/name" -> validation error already reported
+	resources       map[string]*resourceStatus // "Kind/namespace/name" -> last observed state
+	reverts         []revertRecord
+	auditLog        []auditEntry
+
+	// enqueue feeds reconcile requests from outside the watches (admin API).
+	enqueue chan event.GenericEvent
+	// events buffers lifecycle CloudEvents for the sink; nil if none is set.
+	events chan cloudEvent
+	// incidents groups lifecycle events by bad revision; notifications
+	// buffers their updates for INCIDENT_NOTIFIER, nil if none is set.
+	incidents     *incidentTracker
+	notifications chan incidentUpdate
+	// kubeEvents records lifecycle events on Flux resources; nil if disabled.
+	kubeEvents record.EventRecorder
+	// watches adds the watches of kinds served after startup; nil in tests.
+	watches *dynamicWatches
+
+	// clock is the only source of time for debounce, batching, events and
+	// records; tests and the simulate subcommand inject a fake one via setClock.
+	clock clock.WithTicker
+
+	batchMu sync.Mutex
+	batches map[string]*revertBatch // open revert batches by batchKey
+
+	// fence guards reverts and the state store against stale leaders; nil
+	// without STATE_STORE.
+	fence *stateFence
+
+	// api holds the provider HTTP clients and health tracker; nil uses
+	// defaultProviderAPI.
+	api *providerAPI
+	// providers runs reverts off the reconcile path; nil runs them inline.
+	providers      *providerPool
+	projectLocksMu sync.Mutex
+	projectLocks   map[string]*sync.Mutex // "baseURL|projectID" -> held while changing the project
+}
+
+func NewRollbackController(c client.Client, log logr.Logger, token, projectID, baseURL, branchPrefix string, debounceSeconds int) *RollbackController {
+	r := &RollbackController{
+		Client:             c,
+		log:                log,
+		GitlabToken:        token,
+		GitlabProjectID:    projectID,
+		GitlabBaseURL:      baseURL,
+		RevertBranchPrefix: branchPrefix,
+		DebounceSeconds:    debounceSeconds,
+		notGitSourced:      make(map[string]bool),
+		invalidPolicies:    make(map[string]string),
+		resources:          make(map[string]*resourceStatus),
+		APIs:               gaFluxAPIs,
+		batches:            make(map[string]*revertBatch),
+		projectLocks:       make(map[string]*sync.Mutex),
+		EnvironmentLabel:   defaultEnvironmentLabel,
+		ActionLog:          logr.Discard(),
+		enqueue:            make(chan event.GenericEvent, 100),
+	}
+	r.setClock(clock.RealClock{})
+	return r
+}
+
+// setClock replaces the clock, resetting the debounce and escalation state.
+// Call it before the controller is used.
+func (r *RollbackController) setClock(c clock.WithTicker) {
+	r.clock = c
+	r.debounce = debounce.New(time.Duration(r.DebounceSeconds)*time.Second, c)
+	r.escalations = make(map[string]*escalation)
+	r.skipMarked = make(map[string]bool)
+	r.suppressed = make(map[string]string)
+	r.nudges = make(map[string]*nudge)
+	r.notifyOnly = make(map[string]string)
+	r.metricsHeld = make(map[string]string)
+	r.canaryHeld = make(map[string]string)
+	r.budgetHeld = make(map[string]string)
+	r.revertAttempts = make(map[string]int)
+	r.deadLetters = make(map[string]deadLetter)
+	r.stabilizing = make(map[string]time.Time)
+	r.settling = make(map[string]string)
+	r.nsDebounce = make(map[string]time.Duration)
+	r.mrSettled = make(map[string]bool)
+	r.staleEscalated = make(map[string]bool)
+	r.mrAffected = make(map[string][]string)
+	r.mergeTrains = make(map[string]*mergeTrainCar)
+	r.completedAt = make(map[string]time.Time)
+	r.fingerprints = make(map[string]*failureHistory)
+	r.critical = make(map[string]bool)
+	r.flaps = make(map[string]*flapState)
+	r.verifications = make(map[string]*verification)
+	r.incidents = newIncidentTracker()
+}
+
+// setStatus records the last seen state of a resource for the dashboard.
+// Callers must hold r.mu.
+func (r *RollbackController) setStatus(kind, name, namespace, sha string, ready bool) {
+	key := kind + "/" + namespace + "/" + name
+	r.observeReady(resourceRef{Kind: kind, Namespace: namespace, Name: name}, r.resources[key], ready)
+	prev := r.resources[key]
+	now := r.clock.Now()
+	since := now
+	if prev != nil && prev.Revision == sha {
+		since = prev.RevisionSince
+	}
+	r.resources[key] = &resourceStatus{
+		Kind: kind, Namespace: namespace, Name: name, Ready: ready, Revision: sha, LastSeen: now, RevisionSince: since,
+	}
+}
+
+// handleResource evaluates the resource state and decides what to do; the
+// decision's RequeueAfter is how long to wait before re-checking (0 = no
+// requeue needed). revert is called once the failure of sha has been stable
+// for the debounce window.
+func (r *RollbackController) handleResource(kind, name, namespace, sha string, ready bool, revert func(sha string)) Decision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setStatus(kind, name, namespace, sha, ready)
+	if sha == "" {
+		r.log.Info("WARNING: Cannot create revert without sha", "kind", kind, "namespace", namespace, "name", name, "debounceSeconds", r.DebounceSeconds, "sha", sha)
+		return Decision{Action: DecisionNone, Reason: "no revision"}
+	}
+	if ready {
+		r.rearmCompleted(kind, namespace, name, sha)
+		delete(r.revertAttempts, sha)
+	}
+	if !ready && (r.debounce.IsCompleted(sha) || r.debounce.IsCompleted(gitCommitSHA(sha))) {
+		return Decision{Action: DecisionNone, Reason: "already reverted"}
+	}
+	if !ready && r.deadLettered(sha) {
+		return Decision{Action: DecisionNone, Reason: "dead-lettered"}
+	}
+	res := resourceRef{Kind: kind, Namespace: namespace, Name: name}
+	window := r.resourceDebounce(res)
+	d := r.debounce.ObserveWithin(sha, !ready, window)
+	if d.Action == debounce.Detected {
+		r.log.Info("Failure detected", "kind", kind, "namespace", namespace, "name", name, "sha", sha, "debounce", window)
+		r.recordAudit(auditDetected, kind, namespace, name, sha, "")
+		r.emitEvent(auditDetected, kind, namespace, name, sha)
+		if window > 0 || !r.critical[res.String()] {
+			return Decision{Action: DecisionDetected, RequeueAfter: r.capRequeue(d.RequeueAfter)}
+		}
+		// A critical resource without a window reverts on the first failure.
+		d = r.debounce.ObserveWithin(sha, true, window)
+	}
+	// Waiting requeues when the window expires.
+	decision := Decision{RequeueAfter: r.capRequeue(d.RequeueAfter)}
+	switch d.Action {
+	case debounce.Waiting:
+		decision.Action = DecisionWaiting
+	case debounce.Fire:
+		r.log.Info("Failure stable, creating revert", "kind", kind, "namespace", namespace, "name", name, "debounceSeconds", r.DebounceSeconds, "sha", sha)
+		decision.Action, decision.Reason = r.runRevert(kind, namespace, name, sha, revert)
+	case debounce.Recovered:
+		delete(r.skipMarked, sha)
+		r.recordAudit(auditRecovered, kind, namespace, name, sha, "")
+		r.emitEvent(auditRecovered, kind, namespace, name, sha)
+		decision.Action = DecisionRecovered
+	default:
+		decision.Action = DecisionNone
+	}
+	return decision
+}
+
+// rearmCompleted forgets that sha was reverted once it is healthy again
+// RearmAfter after the revert, so a new failure on it is debounced afresh.
+// Callers must hold r.mu.
+func (r *RollbackController) rearmCompleted(kind, namespace, name, sha string) {
+	if r.RearmAfter <= 0 {
+		return
+	}
+	rearmed := r.debounce.Rearm(sha, r.RearmAfter)
+	if plain := gitCommitSHA(sha); plain != sha && r.debounce.Rearm(plain, r.RearmAfter) {
+		rearmed = true
+	}
+	if rearmed {
+		r.log.Info("Reverted commit is healthy again, re-arming it", "kind", kind, "namespace", namespace, "name", name, "sha", sha)
+		r.recordAudit(auditRearmed, kind, namespace, name, sha, "healthy "+r.RearmAfter.String()+" after its revert")
+	}
+}
+
+// runRevert records and runs the revert of sha, unless the commit carries the
+// skip marker or the resource's environment only gets notifications, and
+// returns what it did and why. Callers must hold r.mu; it is released around
+// the provider call so the dashboard stays responsive and actions can call
+// setRevertMR. With a provider pool, the revert is queued for a worker and
+// the reason is "queued".
+func (r *RollbackController) runRevert(kind, namespace, name, sha string, revert func(sha string)) (DecisionAction, string) {
+	if r.skipRevert(sha) {
+		r.log.Info("Failure stable, but the commit opted out of automated reverts", "kind", kind, "namespace", namespace, "name", name, "sha", sha, "marker", r.SkipMarker)
+		reason := "commit carries " + r.SkipMarker
+		r.recordAudit(auditSkipped, kind, namespace, name, sha, reason)
+		r.emitEvent(auditSkipped, kind, namespace, name, sha)
+		return DecisionSkipped, reason
+	}
+	if env, ok := r.notifyInstead(resourceRef{Kind: kind, Namespace: namespace, Name: name}); ok {
+		r.log.Info("Failure stable, but the environment is not reverted automatically, notifying only", "kind", kind, "namespace", namespace, "name", name, "sha", sha, "environment", env)
+		reason := fmt.Sprintf("environment %q is not in revertEnvironments; not reverted", env)
+		r.recordAudit(auditNotified, kind, namespace, name, sha, reason)
+		r.emitEvent(auditNotified, kind, namespace, name, sha)
+		return DecisionNotified, reason
+	}
+	if reason, ok := r.checkRevisionChange(resourceRef{Kind: kind, Namespace: namespace, Name: name}); ok {
+		r.log.Info("Failure stable, but the revision did not change recently, notifying only", "kind", kind, "namespace", namespace, "name", name, "sha", sha, "reason", reason)
+		r.recordAudit(auditNotified, kind, namespace, name, sha, reason)
+		r.emitEvent(auditNotified, kind, namespace, name, sha)
+		return DecisionNotified, reason
+	}
+	if reason, ok := r.checkFence(sha); ok {
+		r.log.Info("Failure stable, but this replica may not revert it", "kind", kind, "namespace", namespace, "name", name, "sha", sha, "reason", reason)
+		r.recordAudit(auditFenced, kind, namespace, name, sha, reason)
+		r.emitEvent(auditFenced, kind, namespace, name, sha)
+		return DecisionSkipped, reason
+	}
+	r.reverts = append(r.reverts, revertRecord{Time: r.clock.Now(), Kind: kind, Namespace: namespace, Name: name, SHA: sha})
+	r.recordAudit(auditDebounced, kind, namespace, name, sha, "")
+	r.emitEvent(auditDebounced, kind, namespace, name, sha)
+	run := func() {
+		revert(sha)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.recordAudit(auditReverted, kind, namespace, name, sha, "")
+		r.emitEvent(auditReverted, kind, namespace, name, sha)
+	}
+	if r.providers != nil && r.providers.submit(run) {
+		return DecisionReverted, "queued"
+	}
+	r.mu.Unlock()
+	run()
+	r.mu.Lock()
+	return DecisionReverted, ""
+}
+
+// createGitlabRevertMR reverts the commit of badSHA (a Flux revision or plain
+// SHA) following the policy's revert strategy, by default on a new branch
+// without an MR. It returns the MR, if one was opened.
+func (r *RollbackController) createGitlabRevertMR(gl gitlabProject, res resourceRef, policy *RollbackPolicy, badSHA string) *gitlabMergeRequest {
+	sha := gitCommitSHA(badSHA)
+	strategy := policy.revertStrategy(RevertStrategyBranch)
+	branch := r.revertBranchName(branchNameData{Action: branchActionRevert, Strategy: strategy, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: sha},
+		fmt.Sprintf("%s-%s", r.RevertBranchPrefix, sha))
+	if dryRun() {
+		r.log.Info("ECHO: would POST revert", "url", gl.url(fmt.Sprintf("/repository/commits/%s/revert", sha)), "branch", branch, "strategy", strategy)
+		r.recordAction(gl, recordedAction{Action: "revert", Strategy: strategy, Branch: branch, SHA: sha, Namespace: res.Namespace, Name: res.Name})
+		return nil
+	}
+	if r.revertRequested(gl, res, badSHA, strategy, branch) {
+		return nil
+	}
+	target, err := targetBranch(gl, policy)
+	if err != nil {
+		r.log.Error(err, "failed to get default branch")
+		return nil
+	}
+	mr := gitlabMergeRequestOptions{
+		Title:       fmt.Sprintf("Revert %s", sha),
+		Description: fmt.Sprintf("Flux resources failed after %s; reverting it.", badSHA),
+	}
+	if strategy == RevertStrategyMergeRequest {
+		r.prepareMergeRequest(gl, res, policy, sha, &mr)
+	}
+	created, err := deliverChange(gl, strategy, branch, target, mr, func(branch, start string) error {
+		if start != "" {
+			if err := gl.createBranch(branch, start); err != nil {
+				return err
+			}
+		}
+		return gl.revertCommit(sha, branch)
+	})
+	if err != nil {
+		r.log.Error(err, "GitLab revert failed", "sha", sha, "strategy", strategy)
+		r.recordRevertFailure(res, badSHA, err)
+		return nil
+	}
+	r.log.Info("Revert commit created successfully", "sha", sha, "strategy", strategy, "mr", created.webURL())
+	r.reportBadCommit(gl, res, sha, created)
+	return created
+}
+
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = AddToScheme(scheme)
+	return scheme
+}
+
+func controllerNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "flux-system"
+}
+
+// controllerFromEnv builds a RollbackController configured from the
+// environment. Invalid settings are returned as errors.
+func controllerFromEnv(c client.Client, log logr.Logger) (*RollbackController, error) {
+	token := os.Getenv("GITLAB_TOKEN")
+	projectID := os.Getenv("GITLAB_PROJECT_ID")
+	baseURL := os.Getenv("GITLAB_URL")
+	if baseURL == "" {
+		baseURL = "https://gitlab"
+	}
+	branchPrefix := os.Getenv("REVERT_BRANCH_PREFIX")
+	if branchPrefix == "" {
+		branchPrefix = "revert"
+	}
+	debounce := 300
+	if d := os.Getenv("DEBOUNCE_SECONDS"); d != "" {
+		if n, err := strconv.Atoi(d); err == nil {
+			debounce = n
+		}
+	}
+
+	rollback := NewRollbackController(c, log, token, projectID, baseURL, branchPrefix, debounce)
+	rollback.Namespace = controllerNamespace()
+	rollback.RoutingConfigMap = os.Getenv("ROUTING_CONFIGMAP")
+	rollback.RecordFile = os.Getenv("RECORD_FILE")
+	rollback.RecordConfigMap = os.Getenv("RECORD_CONFIGMAP")
+	if n, err := strconv.Atoi(os.Getenv("REVERT_BATCH_SECONDS")); err == nil && n > 0 {
+		rollback.RevertBatchWindow = time.Duration(n) * time.Second
+	}
+	rollback.SkipMarker = defaultSkipMarker
+	if m, ok := os.LookupEnv("REVERT_SKIP_MARKER"); ok {
+		rollback.SkipMarker = m
+	}
+	rollback.CommitStatusName = defaultCommitStatusName
+	if n, err := strconv.Atoi(os.Getenv("CRITICAL_DEBOUNCE_SECONDS")); err == nil && n >= 0 {
+		rollback.CriticalDebounce = time.Duration(n) * time.Second
+	}
+	rollback.FlapWindow = defaultFlapWindow
+	if n, err := strconv.Atoi(os.Getenv("FLAP_THRESHOLD")); err == nil && n > 0 {
+		rollback.FlapThreshold = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FLAP_WINDOW_SECONDS")); err == nil && n > 0 {
+		rollback.FlapWindow = time.Duration(n) * time.Second
+	}
+	if n, err := strconv.Atoi(os.Getenv("REVISION_CHANGE_WINDOW_SECONDS")); err == nil && n > 0 {
+		rollback.RevisionChangeWindow = time.Duration(n) * time.Second
+	}
+	rollback.CriticalRevertStrategy = RevertStrategyDirect
+	switch s := os.Getenv("CRITICAL_REVERT_STRATEGY"); s {
+	case "":
+	case RevertStrategyDirect, RevertStrategyBranch, RevertStrategyMergeRequest:
+		rollback.CriticalRevertStrategy = s
+	default:
+		return nil, fmt.Errorf("invalid CRITICAL_REVERT_STRATEGY %q", s)
+	}
+	if name, ok := os.LookupEnv("COMMIT_STATUS_NAME"); ok {
+		rollback.CommitStatusName = name
+	}
+	rollback.SourceFailureThreshold = 50
+	if n, err := strconv.Atoi(os.Getenv("COMPLETED_REARM_SECONDS")); err == nil && n > 0 {
+		rollback.RearmAfter = time.Duration(n) * time.Second
+	}
+	if n, err := strconv.Atoi(os.Getenv("SOURCE_FAILURE_THRESHOLD")); err == nil && n >= 0 && n < 100 {
+		rollback.SourceFailureThreshold = n
+	}
+	rollback.RevertRetries = defaultRevertRetries
+	if n, err := strconv.Atoi(os.Getenv("REVERT_RETRIES")); err == nil && n >= 0 {
+		rollback.RevertRetries = n
+	}
+	rollback.PendingTTL = defaultPendingTTL
+	if n, err := strconv.Atoi(os.Getenv("PENDING_TTL_SECONDS")); err == nil && n >= 0 {
+		rollback.PendingTTL = time.Duration(n) * time.Second
+	}
+	if n, err := strconv.Atoi(os.Getenv("STATE_MAX_AGE_DAYS")); err == nil && n > 0 {
+		rollback.StateMaxAge = time.Duration(n) * 24 * time.Hour
+	}
+	if n, err := strconv.Atoi(os.Getenv("STATE_MAX_ENTRIES")); err == nil && n > 0 {
+		rollback.StateMaxEntries = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("STABILIZATION_WINDOW_SECONDS")); err == nil && n > 0 {
+		rollback.StabilizationWindow = time.Duration(n) * time.Second
+	}
+	links, err := parseLinkTemplates(os.Getenv("RESOURCE_LINK_TEMPLATES"))
+	if err != nil {
+		return nil, err
+	}
+	rollback.LinkTemplates = links
+	rollback.Metadata = metadataFromEnv()
+	if l := os.Getenv("ENVIRONMENT_LABEL"); l != "" {
+		rollback.EnvironmentLabel = l
+	}
+	rollback.PrometheusURL = os.Getenv("PROMETHEUS_URL")
+	rollback.CleanupMergedReverts = os.Getenv("CLEANUP_MERGED_REVERTS") == "true"
+	if rollback.ReadyConditions, err = parseReadyConditions(os.Getenv("READY_CONDITIONS")); err != nil {
+		return nil, err
+	}
+	if rollback.Budgets, err = parseDomainBudgets(os.Getenv("FAILURE_DOMAIN_BUDGETS")); err != nil {
+		return nil, err
+	}
+	msg, err := parseCommitMessageTemplate(os.Getenv("REVERT_COMMIT_MESSAGE_TEMPLATE"))
+	if err != nil {
+		return nil, err
+	}
+	rollback.CommitMessageTemplate = msg
+	if rollback.BranchNameTemplate, err = parseBranchNameTemplate(os.Getenv("REVERT_BRANCH_TEMPLATE")); err != nil {
+		return nil, err
+	}
+	if rollback.ActionLog, err = newActionLog(os.Getenv("ACTION_LOG")); err != nil {
+		return nil, err
+	}
+	rollback.Instance = instanceID(os.Getenv("CONTROLLER_INSTANCE"), rollback.Metadata.Cluster, os.Getenv("DEPLOYMENT_NAME"))
+	rollback.api = newProviderAPI(httpClientOptionsFromEnv(os.Getenv))
+	rollback.api.discovery = discoveryOptionsFromEnv(os.Getenv)
+	if n, err := strconv.Atoi(os.Getenv("MISCONFIG_THRESHOLD")); err == nil && n > 0 {
+		rollback.api.health.Threshold = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MISCONFIG_RETRY_SECONDS")); err == nil && n >= 0 {
+		rollback.api.health.Retry = time.Duration(n) * time.Second
+	}
+	return rollback, nil
+}
+
+// Main runs the rollback-controller command with the arguments after the
+// program name: a subcommand (report, validate, ...) or the controller
+// itself. It returns the process exit code.
+func Main(args []string) int {
+	ctrl.SetLogger(recordErrors(zap.New(), lastError))
+	log := ctrl.Log.WithName("rollback-controller")
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "report":
+			return runReport(args[1:])
+		case "recordings":
+			return runRecordings(args[1:])
+		case "simulate":
+			return runSimulate(args[1:])
+		case "deadletters":
+			return runDeadLetters(args[1:])
+		case "state":
+			return runState(args[1:])
+		case "validate":
+			return runValidate(args[1:])
+		case "timeline":
+			return runTimeline(args[1:])
+		case "rbac":
+			return runRBAC(args[1:])
+		}
+	}
+
+	// flag.CommandLine, as controller-runtime registers --kubeconfig on it.
+	featureSpec := flag.String("feature-gates", os.Getenv("FEATURE_GATES"), "comma-separated Name=true|false feature gates")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 2
+	}
+	if err := run(log, *featureSpec); err != nil {
+		log.Error(err, "Rollback Controller failed")
+		return 1
+	}
+	return 0
+}
+
+// run starts the standalone controller in its own manager.
+func run(log logr.Logger, featureSpec string) error {
+	namespace := controllerNamespace()
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+	webhookPort, _ := strconv.Atoi(os.Getenv("WEBHOOK_PORT"))
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme: newScheme(),
+		// RollbackPolicies and Flux sources are read as unstructured; serve
+		// them from the informer cache instead of hitting the API server on
+		// every reconcile.
+		Client: client.Options{Cache: &client.CacheOptions{Unstructured: true}},
+		// ConfigMaps and Secrets are only read from the controller namespace.
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.ConfigMap{}: {Namespaces: map[string]cache.Config{namespace: {}}},
+			&corev1.Secret{}:    {Namespaces: map[string]cache.Config{namespace: {}}},
+		}},
+		// With several replicas only the leader reconciles and saves state.
+		LeaderElection:          os.Getenv("LEADER_ELECTION") == "true",
+		LeaderElectionID:        "rollback-controller.eumel8.io",
+		LeaderElectionNamespace: namespace,
+		// RollbackPolicy defaulting and conversion (WEBHOOK_PORT). The
+		// webhook server runs on every replica, not only the leader.
+		WebhookServer: webhook.NewServer(webhook.Options{Port: webhookPort, CertDir: os.Getenv("WEBHOOK_CERT_DIR")}),
+	})
+	if err != nil {
+		return err
+	}
+	if err := SetupWithManager(mgr, Options{Namespace: namespace, Log: log, FeatureGates: featureSpec, Webhooks: webhookPort > 0}); err != nil {
+		return err
+	}
+	log.Info("Starting Rollback Controller")
+	return mgr.Start(ctrl.SetupSignalHandler())
+}
+
+type GenericReconciler struct {
+	rollback *RollbackController
+}
+
+func (r *GenericReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	start := r.rollback.clock.Now()
+	var kind string
+	result, err := r.reconcile(ctx, req, &kind)
+	observeReconcile(kind, r.rollback.clock.Since(start))
+	return result, err
+}
+
+// reconcile evaluates the Kustomization or HelmRelease req and sets observed
+// to the kind it found.
+func (r *GenericReconciler) reconcile(ctx context.Context, req ctrl.Request, observed *string) (ctrl.Result, error) {
+	// Reverts may run on a provider worker after the reconcile returned.
+	revertCtx := context.WithoutCancel(ctx)
+	// Try Kustomization first
+	var ks kustomizev1.Kustomization
+	ksErr := r.rollback.getConverted(ctx, "Kustomization", req.NamespacedName, &ks)
+	if ksErr == nil {
+		*observed = "Kustomization"
+		res := resourceRef{Kind: "Kustomization", Namespace: ks.Namespace, Name: ks.Name}
+		policy := r.rollback.applyCritical(res, ks.GetLabels(), r.rollback.policyFor(ctx, "Kustomization", ks.Namespace, ks.Name))
+		ready := r.rollback.kustomizationReady(&ks, policy)
+		r.rollback.observeFingerprint(res, ready, r.rollback.conditionFingerprint("Kustomization", ks.Status.Conditions, policy))
+		sha := r.rollback.kustomizationRevision(ctx, &ks, ready)
+		src, hasSrc := kustomizationGitSource(&ks)
+		if hasSrc && r.rollback.Features.Enabled(SourceAggregation) {
+			r.rollback.recordStatus("Kustomization", ks.Name, ks.Namespace, sha, ready)
+			return r.rollback.reconciled(res, r.rollback.reconcileSource(ctx, src))
+		}
+		srcKey := sourceKey(src, hasSrc)
+		if requeue, ok := r.rollback.checkStabilization(res, srcKey, sha, ready); ok {
+			return r.rollback.reconciled(res, Decision{Action: DecisionSuppressed, RequeueAfter: requeue, Reason: "stabilizing"})
+		}
+		if requeue, ok := r.rollback.suppressDownstream(ctx, res, ks.GetDependsOn(), sha, ready); ok {
+			return r.rollback.reconciled(res, Decision{Action: DecisionSuppressed, RequeueAfter: requeue, Reason: "dependency"})
+		}
+		decision := r.rollback.remediate(ctx, res, sha, ready, policy, r.rollback.stabilizeAfter(srcKey, func(sha string) {
+			r.rollback.withProject(revertCtx, res, sha, func(gl gitlabProject) {
+				r.rollback.revertCommit(revertCtx, gl, res, policy, sha)
+			})
+		}))
+		return r.rollback.reconciled(res, decision)
+	}
+
+	// Try HelmRelease
+	var hr helmv2.HelmRelease
+	hrErr := r.rollback.getConverted(ctx, "HelmRelease", req.NamespacedName, &hr)
+	if hrErr == nil {
+		*observed = "HelmRelease"
+		ready := r.rollback.isReadyKind("HelmRelease", hr.Status.Conditions)
+		res := resourceRef{Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name}
+		policy := r.rollback.applyCritical(res, hr.GetLabels(), r.rollback.policyFor(ctx, "HelmRelease", hr.Namespace, hr.Name))
+		r.rollback.observeFingerprint(res, ready, r.rollback.conditionFingerprint("HelmRelease", hr.Status.Conditions, policy))
+		if policy.helmRemediation() == HelmRemediationPinChartVersion {
+			// Chart-repo sources have no Git SHA; track the failing chart version
+			// per release and pin the previous one in Git instead of reverting.
+			decision := r.rollback.remediate(ctx, res, chartPinKey(&hr), ready, policy, func(key string) {
+				r.rollback.withProject(revertCtx, res, key, func(gl gitlabProject) {
+					r.rollback.trackMergeRequest(revertCtx, res, key, r.rollback.pinHelmChartVersion(gl, &hr, policy))
+				})
+			})
+			return r.rollback.reconciled(res, decision)
+		}
+		sha, ok := r.rollback.helmRevision(ctx, &hr, policy)
+		if !ok {
+			r.rollback.logNotGitSourced(&hr)
+			return ctrl.Result{}, nil
+		}
+		// RevertFiles stays per release: it only touches the release's paths.
+		src, hasSrc := helmReleaseGitSource(&hr)
+		if hasSrc && policy.helmRemediation() == HelmRemediationRevert && r.rollback.Features.Enabled(SourceAggregation) {
+			r.rollback.recordStatus("HelmRelease", hr.Name, hr.Namespace, sha, ready)
+			return r.rollback.reconciled(res, r.rollback.reconcileSource(ctx, src))
+		}
+		srcKey := sourceKey(src, hasSrc)
+		if requeue, ok := r.rollback.checkStabilization(res, srcKey, sha, ready); ok {
+			return r.rollback.reconciled(res, Decision{Action: DecisionSuppressed, RequeueAfter: requeue, Reason: "stabilizing"})
+		}
+		if requeue, ok := r.rollback.suppressDownstream(ctx, res, hr.GetDependsOn(), sha, ready); ok {
+			return r.rollback.reconciled(res, Decision{Action: DecisionSuppressed, RequeueAfter: requeue, Reason: "dependency"})
+		}
+		decision := r.rollback.remediate(ctx, res, sha, ready, policy, r.rollback.stabilizeAfter(srcKey, func(sha string) {
+			r.rollback.withProject(revertCtx, res, sha, func(gl gitlabProject) {
+				if policy.helmRemediation() == HelmRemediationRevertFiles {
+					r.rollback.trackMergeRequest(revertCtx, res, sha, r.rollback.revertHelmFiles(gl, &hr, policy, sha))
+					return
+				}
+				r.rollback.revertCommit(revertCtx, gl, res, policy, sha)
+			})
+		}))
+		return r.rollback.reconciled(res, decision)
+	}
+
+	// Deleted: drop whatever was tracked for it under either kind.
+	if isGone(ksErr) && isGone(hrErr) {
+		for _, kind := range []string{"Kustomization", "HelmRelease"} {
+			r.rollback.forgetResource(resourceRef{Kind: kind, Namespace: req.Namespace, Name: req.Name})
+		}
+		return ctrl.Result{}, nil
+	}
+	// Neither could be read: retry with backoff.
+	if !isGone(ksErr) {
+		return ctrl.Result{}, ksErr
+	}
+	return ctrl.Result{}, hrErr
+}
@@ -0,0 +1,88 @@
+package rollback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestHandleResourceDebounce(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clk)
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+
+	if got := r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", false, revert); got != (Decision{Action: DecisionDetected, RequeueAfter: 5 * time.Minute}) {
+		t.Errorf("first failure = %+v, want Detected with 5m requeue", got)
+	}
+	clk.Step(299 * time.Second)
+	if got := r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", false, revert); got != (Decision{Action: DecisionWaiting, RequeueAfter: time.Second}) || len(reverted) != 0 {
+		t.Errorf("within window: %+v, reverted %v", got, reverted)
+	}
+	clk.Step(time.Second)
+	if got := r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", false, revert); got != (Decision{Action: DecisionReverted}) || len(reverted) != 1 {
+		t.Errorf("window expired: %+v, reverted %v", got, reverted)
+	}
+	clk.Step(time.Hour)
+	if got := r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", false, revert); got != (Decision{Action: DecisionNone, Reason: "already reverted"}) || len(reverted) != 1 {
+		t.Errorf("completed SHA: %+v, reverted %v", got, reverted)
+	}
+
+	s := r.snapshot()
+	if len(s.Reverts) != 1 || !s.Reverts[0].Time.Equal(start.Add(5*time.Minute)) {
+		t.Errorf("revert record not stamped by the injected clock: %+v", s.Reverts)
+	}
+	if !s.Time.Equal(start.Add(5*time.Minute + time.Hour)) {
+		t.Errorf("snapshot time = %v", s.Time)
+	}
+	for _, a := range s.Audit {
+		if a.Time.Before(start) {
+			t.Errorf("audit entry not stamped by the injected clock: %+v", a)
+		}
+	}
+
+	// A failure that recovers within the window never reverts.
+	r.handleResource("Kustomization", "app", "ns", "main@sha1:def", false, revert)
+	clk.Step(time.Minute)
+	if got := r.handleResource("Kustomization", "app", "ns", "main@sha1:def", true, revert); got.Action != DecisionRecovered {
+		t.Errorf("recovery: %+v", got)
+	}
+	clk.Step(time.Hour)
+	if got := r.handleResource("Kustomization", "app", "ns", "main@sha1:def", false, revert); got.RequeueAfter != 5*time.Minute || len(reverted) != 1 {
+		t.Errorf("recovered SHA: %+v, reverted %v", got, reverted)
+	}
+}
+
+func TestHandleResourceRearm(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.setClock(clk)
+	r.RearmAfter = time.Hour
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+	fail := func() { r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", false, revert) }
+
+	fail()
+	fail()
+	r.debounce.Complete("abc") // as restored from a revert branch
+	clk.Step(30 * time.Minute)
+	r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", true, revert)
+	fail()
+	fail()
+	if len(reverted) != 1 {
+		t.Fatalf("healthy within RearmAfter must not re-arm: %v", reverted)
+	}
+
+	clk.Step(time.Hour)
+	r.handleResource("Kustomization", "app", "ns", "main@sha1:abc", true, revert)
+	fail()
+	fail()
+	if len(reverted) != 2 {
+		t.Errorf("healthy after RearmAfter must re-arm the SHA: %v", reverted)
+	}
+}
@@ -0,0 +1,190 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// controllerStatus is the status of the RollbackControllerStatus object
+// (crds/rollbackcontrollerstatus.yaml). Its Ready condition follows kstatus,
+// so Flux health checks and kstatus-aware tools can gate on the controller.
+type controllerStatus struct {
+	LastUpdateTime    metav1.Time        `json:"lastUpdateTime"`
+	WatchedResources  int                `json:"watchedResources"`
+	FailingResources  int                `json:"failingResources"`
+	PendingFailures   int                `json:"pendingFailures"` // debounce timers and running escalations
+	RevertsLast24h    int                `json:"revertsLast24h"`
+	DegradedProviders []projectHealth    `json:"degradedProviders,omitempty"`
+	LastError         *recordedError     `json:"lastError,omitempty"`
+	Conditions        []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// recordedError is the last error the controller logged.
+type recordedError struct {
+	Time    metav1.Time `json:"time"`
+	Message string      `json:"message"`
+}
+
+// errorRecorder keeps the last error logged through a logger wrapped with
+// recordErrors.
+type errorRecorder struct {
+	clock clock.PassiveClock
+
+	mu   sync.Mutex
+	last *recordedError
+}
+
+// lastError records the errors of the controller's logger.
+var lastError = &errorRecorder{clock: clock.RealClock{}}
+
+func (e *errorRecorder) record(err error, msg string) {
+	if err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last = &recordedError{Time: metav1.NewTime(e.clock.Now()), Message: msg}
+}
+
+func (e *errorRecorder) get() *recordedError {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.last == nil {
+		return nil
+	}
+	last := *e.last
+	return &last
+}
+
+// recordErrors returns log with every Error call also recorded in e.
+func recordErrors(log logr.Logger, e *errorRecorder) logr.Logger {
+	return logr.New(errorRecordingSink{LogSink: log.GetSink(), errors: e})
+}
+
+type errorRecordingSink struct {
+	logr.LogSink
+	errors *errorRecorder
+}
+
+func (s errorRecordingSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.errors.record(err, msg)
+	s.LogSink.Error(err, msg, keysAndValues...)
+}
+
+func (s errorRecordingSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return errorRecordingSink{LogSink: s.LogSink.WithValues(keysAndValues...), errors: s.errors}
+}
+
+func (s errorRecordingSink) WithName(name string) logr.LogSink {
+	return errorRecordingSink{LogSink: s.LogSink.WithName(name), errors: s.errors}
+}
+
+// WithCallDepth keeps the caller annotations of the wrapped sink pointing at
+// the logging code rather than this wrapper.
+func (s errorRecordingSink) WithCallDepth(depth int) logr.LogSink {
+	if cd, ok := s.LogSink.(logr.CallDepthLogSink); ok {
+		return errorRecordingSink{LogSink: cd.WithCallDepth(depth + 1), errors: s.errors}
+	}
+	return s
+}
+
+// controllerStatus summarizes the tracking state, provider health and the
+// last error.
+func (r *RollbackController) controllerStatus() controllerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	s := controllerStatus{
+		LastUpdateTime:    metav1.NewTime(now),
+		WatchedResources:  len(r.resources),
+		PendingFailures:   len(r.debounce.Pending()) + len(r.escalations),
+		DegradedProviders: r.api.healthTracker().degraded(),
+		LastError:         lastError.get(),
+	}
+	for _, res := range r.resources {
+		if !res.Ready {
+			s.FailingResources++
+		}
+	}
+	for _, rec := range r.reverts {
+		if now.Sub(rec.Time) < 24*time.Hour {
+			s.RevertsLast24h++
+		}
+	}
+	ready := metav1.Condition{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Healthy",
+		Message: fmt.Sprintf("Watching %d resources, %d failing", s.WatchedResources, s.FailingResources)}
+	if len(s.DegradedProviders) > 0 {
+		degraded := degradedCondition(s.DegradedProviders)
+		ready = metav1.Condition{Type: "Ready", Status: metav1.ConditionFalse, Reason: degraded.Reason, Message: degraded.Message}
+	}
+	s.Conditions = []metav1.Condition{ready}
+	return s
+}
+
+// reportStatus writes s to the RollbackControllerStatus object name in the
+// controller namespace, creating the object if needed. Condition transition
+// times only change when a condition's status does.
+func (r *RollbackController) reportStatus(ctx context.Context, c client.Client, name string, s controllerStatus) error {
+	key := client.ObjectKey{Namespace: r.Namespace, Name: name}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(policyGroupVersion.WithKind("RollbackControllerStatus"))
+		err := c.Get(ctx, key, obj)
+		if apierrors.IsNotFound(err) {
+			obj.SetNamespace(key.Namespace)
+			obj.SetName(key.Name)
+			err = c.Create(ctx, obj)
+		}
+		if err != nil {
+			return err
+		}
+		var old controllerStatus
+		if raw, ok, _ := unstructured.NestedMap(obj.Object, "status"); ok {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &old); err != nil {
+				return err
+			}
+		}
+		conditions := old.Conditions
+		for _, cond := range s.Conditions {
+			cond.ObservedGeneration = obj.GetGeneration()
+			cond.LastTransitionTime = s.LastUpdateTime
+			meta.SetStatusCondition(&conditions, cond)
+		}
+		s.Conditions = conditions
+		raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&s)
+		if err != nil {
+			return err
+		}
+		obj.Object["status"] = raw
+		return c.Status().Update(ctx, obj)
+	})
+}
+
+// runStatusReporter periodically writes the controller status while this
+// replica is the leader.
+func (r *RollbackController) runStatusReporter(ctx context.Context, c client.Client, name string, interval time.Duration) error {
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.reportStatus(ctx, c, name, r.controllerStatus()); err != nil {
+			r.log.Error(err, "failed to update RollbackControllerStatus", "name", name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
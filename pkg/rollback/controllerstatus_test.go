@@ -0,0 +1,119 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecordErrors(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	e := &errorRecorder{clock: clocktesting.NewFakePassiveClock(start)}
+	var logged []string
+	log := recordErrors(funcr.New(func(prefix, args string) { logged = append(logged, args) }, funcr.Options{}), e)
+
+	log.Info("fine")
+	if e.get() != nil {
+		t.Fatal("Info recorded as error")
+	}
+	log.WithName("sub").WithValues("sha", "abc").Error(errors.New("boom"), "revert failed")
+	got := e.get()
+	if got == nil || got.Message != "revert failed: boom" || !got.Time.Time.Equal(start) {
+		t.Fatalf("unexpected last error %+v", got)
+	}
+	if len(logged) != 2 {
+		t.Errorf("wrapped sink got %d lines, want 2", len(logged))
+	}
+}
+
+func TestControllerStatus(t *testing.T) {
+	saved, savedErr := providerHealth, lastError
+	defer func() { providerHealth, lastError = saved, savedErr }()
+	start := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	providerHealth = newProviderHealthTracker(1, clocktesting.NewFakePassiveClock(start))
+	lastError = &errorRecorder{clock: clocktesting.NewFakePassiveClock(start)}
+
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(start))
+	r.setStatus("Kustomization", "apps", "flux-system", "abc", false)
+	r.setStatus("HelmRelease", "podinfo", "default", "def", true)
+	r.debounce.Observe("Kustomization/flux-system/apps/abc", true)
+	r.reverts = []revertRecord{{Time: start.Add(-25 * time.Hour), SHA: "old"}, {Time: start.Add(-time.Hour), SHA: "new"}}
+
+	s := r.controllerStatus()
+	if s.WatchedResources != 2 || s.FailingResources != 1 || s.PendingFailures != 1 || s.RevertsLast24h != 1 {
+		t.Errorf("unexpected counts %+v", s)
+	}
+	if ready := meta.FindStatusCondition(s.Conditions, "Ready"); ready == nil || ready.Status != metav1.ConditionTrue {
+		t.Errorf("unexpected Ready %+v", ready)
+	}
+
+	providerHealth.observe("https://gitlab/api/v4/projects/7", "tok", "GET", "https://gitlab/api/v4/projects/7", true, 401)
+	lastError.record(errors.New("401 Unauthorized"), "GitLab revert failed")
+	s = r.controllerStatus()
+	if len(s.DegradedProviders) != 1 || s.LastError == nil || s.LastError.Message != "GitLab revert failed: 401 Unauthorized" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if ready := meta.FindStatusCondition(s.Conditions, "Ready"); ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "ProviderMisconfigured" {
+		t.Errorf("unexpected Ready %+v", ready)
+	}
+}
+
+func TestReportStatus(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(policyGroupVersion.WithKind("RollbackControllerStatus"))
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithStatusSubresource(obj).Build()
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.Namespace = "flux-system"
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	ready := func(status metav1.ConditionStatus, now time.Time) controllerStatus {
+		return controllerStatus{LastUpdateTime: metav1.NewTime(now), WatchedResources: 3,
+			Conditions: []metav1.Condition{{Type: "Ready", Status: status, Reason: "Test", Message: "test"}}}
+	}
+	if err := r.reportStatus(ctx, c, "rollback", ready(metav1.ConditionTrue, start)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reportStatus(ctx, c, "rollback", ready(metav1.ConditionTrue, start.Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	s := readControllerStatus(t, c)
+	cond := meta.FindStatusCondition(s.Conditions, "Ready")
+	if s.WatchedResources != 3 || !s.LastUpdateTime.Time.Equal(start.Add(time.Minute)) || cond == nil || !cond.LastTransitionTime.Time.Equal(start) {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	if err := r.reportStatus(ctx, c, "rollback", ready(metav1.ConditionFalse, start.Add(2*time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	cond = meta.FindStatusCondition(readControllerStatus(t, c).Conditions, "Ready")
+	if cond == nil || cond.Status != metav1.ConditionFalse || !cond.LastTransitionTime.Time.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("unexpected condition after transition %+v", cond)
+	}
+}
+
+func readControllerStatus(t *testing.T, c client.Client) controllerStatus {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(policyGroupVersion.WithKind("RollbackControllerStatus"))
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "flux-system", Name: "rollback"}, obj); err != nil {
+		t.Fatal(err)
+	}
+	var s controllerStatus
+	raw, _, _ := unstructured.NestedMap(obj.Object, "status")
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
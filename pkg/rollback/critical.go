@@ -0,0 +1,60 @@
+package rollback
+
+import "time"
+
+// criticalLabel marks a Kustomization or HelmRelease as critical: its
+// failures are reverted after CRITICAL_DEBOUNCE_SECONDS (default: right
+// away) with CRITICAL_REVERT_STRATEGY, skipping the policy's escalation,
+// reconcileBeforeRevert and metricsGate, and without batching.
+const criticalLabel = "rollback.eumel8.io/critical"
+
+// isCritical reports whether labels mark a resource as critical.
+func isCritical(labels map[string]string) bool {
+	return labels[criticalLabel] == "true"
+}
+
+// applyCritical records whether the resource res, labelled with labels, is
+// critical and returns the policy to remediate it with: for critical
+// resources a copy of policy using CriticalRevertStrategy and without the
+// steps that delay a revert.
+func (r *RollbackController) applyCritical(res resourceRef, labels map[string]string, policy *RollbackPolicy) *RollbackPolicy {
+	critical := isCritical(labels)
+	r.mu.Lock()
+	if critical {
+		r.critical[res.String()] = true
+	} else {
+		delete(r.critical, res.String())
+	}
+	r.mu.Unlock()
+	if !critical {
+		return policy
+	}
+	p := &RollbackPolicy{}
+	if policy != nil {
+		// Only top-level fields are replaced, a shallow copy will do.
+		cp := *policy
+		p = &cp
+	}
+	p.Spec.RevertStrategy = r.CriticalRevertStrategy
+	p.Spec.Escalation = nil
+	p.Spec.ReconcileBeforeRevert = false
+	p.Spec.MetricsGate = nil
+	return p
+}
+
+// criticalResource reports whether res was labelled critical when last
+// reconciled.
+func (r *RollbackController) criticalResource(res resourceRef) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.critical[res.String()]
+}
+
+// resourceDebounce returns the debounce window of res: CriticalDebounce for
+// critical resources, else that of its namespace. Callers must hold r.mu.
+func (r *RollbackController) resourceDebounce(res resourceRef) time.Duration {
+	if r.critical[res.String()] {
+		return r.CriticalDebounce
+	}
+	return r.debounceWindow(res.Namespace)
+}
@@ -0,0 +1,54 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestCriticalFastPath(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	r.CriticalRevertStrategy = RevertStrategyDirect
+	r.Features = featureGates{FluxEvents: false} // no client to request reconciles with
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{
+		RevertStrategy:        RevertStrategyMergeRequest,
+		ReconcileBeforeRevert: true,
+		Escalation:            []EscalationStep{{Action: EscalationNotify, After: metav1.Duration{}}},
+	}}
+	payments := resourceRef{Kind: "Kustomization", Namespace: "shop", Name: "payments"}
+	web := resourceRef{Kind: "Kustomization", Namespace: "shop", Name: "web"}
+
+	p := r.applyCritical(payments, map[string]string{criticalLabel: "true"}, policy)
+	if p.Spec.RevertStrategy != RevertStrategyDirect || p.Spec.ReconcileBeforeRevert || len(p.escalation()) != 0 {
+		t.Errorf("critical policy = %+v", p.Spec)
+	}
+	if policy.Spec.RevertStrategy != RevertStrategyMergeRequest || len(policy.Spec.Escalation) != 1 {
+		t.Errorf("shared policy modified: %+v", policy.Spec)
+	}
+	if got := r.applyCritical(web, map[string]string{criticalLabel: "false"}, policy); got != policy {
+		t.Errorf("non-critical resource got a different policy")
+	}
+
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+	ctx := context.Background()
+	if got := r.remediate(ctx, payments, "main@sha1:bad", false, p, revert); got.Action != DecisionReverted || len(reverted) != 1 {
+		t.Errorf("critical resource: %+v, reverted %v", got, reverted)
+	}
+	if got := r.remediate(ctx, web, "main@sha1:other", false, nil, revert); got.Action != DecisionDetected || got.RequeueAfter != 5*time.Minute {
+		t.Errorf("non-critical resource: %+v", got)
+	}
+
+	// A short critical debounce instead of none.
+	r.CriticalDebounce = 10 * time.Second
+	r.forgetResource(payments)
+	r.applyCritical(payments, map[string]string{criticalLabel: "true"}, nil)
+	if got := r.remediate(ctx, payments, "main@sha1:next", false, p, revert); got.Action != DecisionDetected || got.RequeueAfter != 10*time.Second {
+		t.Errorf("critical debounce: %+v", got)
+	}
+}
@@ -0,0 +1,127 @@
+package rollback
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+// pendingEntry is a failing SHA still inside its debounce window.
+type pendingEntry struct {
+	SHA       string    `json:"sha"`
+	FirstSeen time.Time `json:"firstSeen"`
+	RevertAt  time.Time `json:"revertAt"`
+}
+
+// stateSnapshot is a consistent copy of the tracking state, served by the
+// dashboard as HTML and JSON.
+type stateSnapshot struct {
+	Time      time.Time        `json:"time"`
+	Resources []resourceStatus `json:"resources"`
+	Pending   []pendingEntry   `json:"pending"`
+	Reverts   []revertRecord   `json:"reverts"`
+	Audit     []auditEntry     `json:"audit"`
+	Incidents []incident       `json:"incidents"`
+}
+
+// snapshot copies the tracking state under the lock. Lists are sorted for a
+// stable display; reverts and audit entries are newest first.
+func (r *RollbackController) snapshot() stateSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := stateSnapshot{Time: r.clock.Now()}
+	for _, res := range r.resources {
+		s.Resources = append(s.Resources, *res)
+	}
+	sort.Slice(s.Resources, func(i, j int) bool {
+		a, b := s.Resources[i], s.Resources[j]
+		return a.Kind+"/"+a.Namespace+"/"+a.Name < b.Kind+"/"+b.Namespace+"/"+b.Name
+	})
+	for _, p := range r.debounce.Pending() {
+		s.Pending = append(s.Pending, pendingEntry{SHA: p.Key, FirstSeen: p.FirstSeen, RevertAt: p.Due})
+	}
+	for i := len(r.reverts) - 1; i >= 0; i-- {
+		s.Reverts = append(s.Reverts, r.reverts[i])
+	}
+	for i := len(r.auditLog) - 1; i >= 0; i-- {
+		s.Audit = append(s.Audit, r.auditLog[i])
+	}
+	s.Incidents = r.incidents.list()
+	return s
+}
+
+// bearerAuth rejects requests without "Authorization: Bearer <token>".
+func bearerAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// dashboardHandler serves the HTML dashboard on / and the JSON state on
+// /api/state.
+func (r *RollbackController) dashboardHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/state", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.snapshot())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, r.snapshot()); err != nil {
+			r.log.Error(err, "failed to render dashboard")
+		}
+	})
+	return bearerAuth(token, mux)
+}
+
+// serveHTTP runs handler on addr until ctx is cancelled, over TLS if tlsSetup
+// is set. It is added to the manager as a Runnable for the dashboard, the
+// admin API and the receiver.
+func (r *RollbackController) serveHTTP(ctx context.Context, name, addr string, handler http.Handler, tlsSetup *serverTLS) error {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if tlsSetup != nil {
+		srv.TLSConfig = tlsSetup.config
+		go func() {
+			if err := tlsSetup.watcher.Start(ctx); err != nil {
+				r.log.Error(err, "serving certificate watcher stopped", "server", name)
+			}
+		}()
+		r.log.Info("Serving "+name+" over TLS", "addr", addr, "mutualTLS", tlsSetup.mutual)
+		if err := srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	}
+	r.log.Info("Serving "+name, "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package rollback
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultRevertRetries is how often a failed revert is retried before it is
+// dead-lettered (REVERT_RETRIES).
+const defaultRevertRetries = 3
+
+// deadLetter is a revert that failed on every retry. Its revision is not
+// reverted again until it is retried through the admin API.
+type deadLetter struct {
+	SHA       string    `json:"sha"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Attempts  int       `json:"attempts"`
+	Reason    string    `json:"reason"`
+	Time      time.Time `json:"time"` // of the last failure
+}
+
+// revertFailed retries or dead-letters the failed revert of sha for res.
+// While retries are left, sha is no longer completed, so its next failing
+// observation starts a new debounce window and reverts again; the resources
+// failing on it are requeued for that. Reverts not driven by the debounce,
+// i.e. sha is not completed, are left alone. Callers must hold r.mu.
+func (r *RollbackController) revertFailed(res resourceRef, sha, reason string) {
+	if !r.debounce.IsCompleted(sha) {
+		return
+	}
+	r.debounce.ClearCompleted(sha)
+	attempts := r.revertAttempts[sha] + 1
+	if attempts <= r.RevertRetries {
+		r.revertAttempts[sha] = attempts
+		r.log.Info("Revert failed, retrying after the debounce window", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name,
+			"sha", sha, "retry", attempts, "retries", r.RevertRetries)
+		for _, t := range r.revisionTargets(sha) {
+			r.requestReconcile(t.Namespace, t.Name)
+		}
+		return
+	}
+	delete(r.revertAttempts, sha)
+	r.deadLetters[sha] = deadLetter{SHA: sha, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name,
+		Attempts: attempts, Reason: reason, Time: r.clock.Now()}
+	r.log.Error(nil, "Revert failed on every retry, moved to the dead-letter queue", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name,
+		"sha", sha, "attempts", attempts, "reason", reason)
+	r.recordAudit(auditDeadLettered, res.Kind, res.Namespace, res.Name, sha, fmt.Sprintf("failed %d times: %s", attempts, reason))
+	r.emitEvent(auditDeadLettered, res.Kind, res.Namespace, res.Name, sha)
+}
+
+// deadLettered reports whether the revert of sha waits in the dead-letter
+// queue. Callers must hold r.mu.
+func (r *RollbackController) deadLettered(sha string) bool {
+	_, ok := r.deadLetters[sha]
+	return ok
+}
+
+// deadLetterList returns the dead-lettered reverts, oldest first.
+func (r *RollbackController) deadLetterList() []deadLetter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]deadLetter, 0, len(r.deadLetters))
+	for _, d := range r.deadLetters {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Time.Equal(out[j].Time) {
+			return out[i].SHA < out[j].SHA
+		}
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out
+}
+
+// retryDeadLetter takes sha out of the dead-letter queue with a fresh set of
+// retries and re-drives its revert: sha is pending with its debounce window
+// expired, and the resources failing on it are requeued, so the revert runs
+// on their next reconcile. It reports whether sha was dead-lettered.
+func (r *RollbackController) retryDeadLetter(sha string) bool {
+	r.mu.Lock()
+	d, ok := r.deadLetters[sha]
+	if !ok {
+		r.mu.Unlock()
+		return false
+	}
+	delete(r.deadLetters, sha)
+	r.debounce.ObserveWithin(sha, true, r.debounce.Window())
+	r.debounce.Expire(sha)
+	r.recordAudit(auditAdmin, d.Kind, d.Namespace, d.Name, sha, "dead-lettered revert retried")
+	targets := r.revisionTargets(sha)
+	r.mu.Unlock()
+	for _, t := range targets {
+		r.requestReconcile(t.Namespace, t.Name)
+	}
+	return true
+}
+
+// revisionTargets returns the tracked resources on revision. Callers must
+// hold r.mu.
+func (r *RollbackController) revisionTargets(revision string) []metav1.ObjectMeta {
+	var targets []metav1.ObjectMeta
+	for _, st := range r.resources {
+		if st.Revision == revision {
+			targets = append(targets, metav1.ObjectMeta{Namespace: st.Namespace, Name: st.Name})
+		}
+	}
+	return targets
+}
+
+// runDeadLetters implements the "deadletters" subcommand, a client of the
+// admin API listing dead-lettered reverts ("list") and retrying one
+// ("retry <sha>").
+func runDeadLetters(args []string) int {
+	fs := flag.NewFlagSet("deadletters", flag.ExitOnError)
+	addr := fs.String("admin", "http://localhost:8083", "base URL of the admin API (ADMIN_ADDR)")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin API token, as ADMIN_TOKEN")
+	tlsDir := fs.String("tls-dir", "", "directory with ca.crt and, for mutual TLS, the client's tls.crt and tls.key (ADMIN_TLS_DIR)")
+	output := fs.String("o", "table", "list: output format, table, json or yaml")
+	_ = fs.Parse(args)
+	cmd := fs.Arg(0)
+	if (cmd != "list" && cmd != "retry") || (cmd == "retry") != (fs.NArg() == 2) {
+		fmt.Fprintln(os.Stderr, "usage: rollback-controller deadletters [flags] list|retry <sha>")
+		return 2
+	}
+	method, path := http.MethodGet, "/admin/deadletters"
+	if cmd == "retry" {
+		method, path = http.MethodPost, "/admin/deadletters/retry/"+fs.Arg(1)
+	}
+	err := func() error {
+		req, err := http.NewRequest(method, strings.TrimSuffix(*addr, "/")+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+*token)
+		c := http.DefaultClient
+		if *tlsDir != "" {
+			cfg, err := loadClientTLS(*tlsDir)
+			if err != nil {
+				return err
+			}
+			c = &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			var e struct {
+				Error string `json:"error"`
+			}
+			_ = json.NewDecoder(resp.Body).Decode(&e)
+			return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, e.Error)
+		}
+		if cmd == "retry" {
+			fmt.Printf("retrying %s\n", fs.Arg(1))
+			return nil
+		}
+		var letters []deadLetter
+		if err := json.NewDecoder(resp.Body).Decode(&letters); err != nil {
+			return err
+		}
+		return writeDeadLetters(os.Stdout, letters, *output)
+	}()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// writeDeadLetters renders dead-lettered reverts as "table", "json" or
+// "yaml".
+func writeDeadLetters(w io.Writer, letters []deadLetter, format string) error {
+	switch format {
+	case "json", "yaml":
+		return writeStructured(w, letters, format)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tSHA\tRESOURCE\tATTEMPTS\tREASON")
+		for _, d := range letters {
+			fmt.Fprintf(tw, "%s\t%s\t%s/%s/%s\t%d\t%s\n", d.Time.Format(time.RFC3339), d.SHA, d.Kind, d.Namespace, d.Name, d.Attempts, d.Reason)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q (want table, json or yaml)", format)
+}
@@ -0,0 +1,82 @@
+package rollback
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestDeadLetter(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	r.RevertRetries = 1
+	h := r.adminHandler("admin")
+	res := resourceRef{Kind: "Kustomization", Namespace: "ns", Name: "app"}
+	const sha = "main@sha1:abc"
+	attempts, broken := 0, true
+	revert := func(sha string) {
+		attempts++
+		if broken {
+			r.recordRevertFailure(res, sha, errors.New("401 Unauthorized"))
+		}
+	}
+	fail := func() Decision { return r.handleResource(res.Kind, res.Name, res.Namespace, sha, false, revert) }
+
+	fail()
+	fail() // fires and fails
+	if attempts != 1 || r.debounce.IsCompleted(sha) || len(r.enqueue) != 1 {
+		t.Fatalf("after the first failure: %d attempts, completed %v, %d requeued; want a retry", attempts, r.debounce.IsCompleted(sha), len(r.enqueue))
+	}
+	if d := fail(); d.Action != DecisionDetected {
+		t.Errorf("retry: %+v, want a new debounce window", d)
+	}
+	fail() // the retry fails too
+	if d := fail(); attempts != 2 || d.Reason != "dead-lettered" {
+		t.Fatalf("after the last retry: %d attempts, %+v; want it dead-lettered", attempts, d)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin/deadletters", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	h.ServeHTTP(rec, req)
+	var letters []deadLetter
+	if err := json.NewDecoder(rec.Body).Decode(&letters); err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].SHA != sha || letters[0].Attempts != 2 || letters[0].Reason != "401 Unauthorized" {
+		t.Errorf("dead letters %+v", letters)
+	}
+
+	// The credentials were fixed.
+	broken = false
+	if code := adminRequest(t, h, "POST", "/admin/deadletters/retry/main@sha1:unknown"); code != http.StatusNotFound {
+		t.Errorf("retry unknown: status %d", code)
+	}
+	if code := adminRequest(t, h, "POST", "/admin/deadletters/retry/"+sha); code != http.StatusOK {
+		t.Fatalf("retry: status %d", code)
+	}
+	if fail(); attempts != 3 || !r.debounce.IsCompleted(sha) || len(r.deadLetterList()) != 0 {
+		t.Errorf("after retrying: %d attempts, completed %v, dead letters %+v", attempts, r.debounce.IsCompleted(sha), r.deadLetterList())
+	}
+}
+
+func TestRunDeadLetters(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	srv := httptest.NewServer(r.adminHandler("admin"))
+	defer srv.Close()
+	if code := runDeadLetters([]string{"-admin", srv.URL, "-token", "admin", "list"}); code != 0 {
+		t.Errorf("list: exit %d", code)
+	}
+	if code := runDeadLetters([]string{"-admin", srv.URL, "-token", "admin", "retry", "main@sha1:abc"}); code != 1 {
+		t.Errorf("retry of a revision not dead-lettered: exit %d, want 1", code)
+	}
+	if code := runDeadLetters([]string{"retry"}); code != 2 {
+		t.Errorf("retry without sha: exit %d, want 2", code)
+	}
+}
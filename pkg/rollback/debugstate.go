@@ -0,0 +1,109 @@
+package rollback
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// queuedRevert is a revert waiting in a batch (REVERT_BATCH_SECONDS).
+type queuedRevert struct {
+	Project  string    `json:"project"`
+	Resource string    `json:"resource"`
+	Revision string    `json:"revision"`
+	SHA      string    `json:"sha"`
+	FlushAt  time.Time `json:"flushAt"`
+}
+
+// heldRevert is a failing resource whose revert is held or not acted upon,
+// and why.
+type heldRevert struct {
+	Resource string `json:"resource"`
+	Revision string `json:"revision"`
+	Reason   string `json:"reason"`
+}
+
+// debugState is the raw tracking state served on /debug/state.
+type debugState struct {
+	Time          time.Time             `json:"time"`
+	Pending       []pendingEntry        `json:"pending"`
+	Completed     []string              `json:"completed"`
+	QueuedReverts []queuedRevert        `json:"queuedReverts"`
+	Escalations   map[string]escalation `json:"escalations"`
+	Held          []heldRevert          `json:"held"`
+	Stabilizing   map[string]time.Time  `json:"stabilizing"`
+	DeadLetters   []deadLetter          `json:"deadLetters"`
+}
+
+// debugSnapshot copies the tracking state for /debug/state. Unlike the
+// dashboard snapshot it shows the debouncer's completed SHAs, queued batch
+// reverts, why reverts are held and the dead-letter queue.
+func (r *RollbackController) debugSnapshot() debugState {
+	r.mu.Lock()
+	s := debugState{
+		Time:        r.clock.Now(),
+		Completed:   r.debounce.Completed(),
+		Escalations: make(map[string]escalation, len(r.escalations)),
+		Stabilizing: make(map[string]time.Time, len(r.stabilizing)),
+	}
+	for _, p := range r.debounce.Pending() {
+		s.Pending = append(s.Pending, pendingEntry{SHA: p.Key, FirstSeen: p.FirstSeen, RevertAt: p.Due})
+	}
+	for key, e := range r.escalations {
+		s.Escalations[key] = *e
+	}
+	for src, until := range r.stabilizing {
+		s.Stabilizing[src] = until
+	}
+	held := func(m map[string]string, reason string) {
+		for key, rev := range m {
+			s.Held = append(s.Held, heldRevert{Resource: key, Revision: rev, Reason: reason})
+		}
+	}
+	held(r.suppressed, "dependency")
+	held(r.metricsHeld, "metricsGate")
+	held(r.canaryHeld, "canary")
+	held(r.budgetHeld, "failureDomainBudget")
+	held(r.settling, "stabilizing")
+	for key, env := range r.notifyOnly {
+		s.Held = append(s.Held, heldRevert{Resource: key, Reason: "environment " + env})
+	}
+	for key, n := range r.nudges {
+		s.Held = append(s.Held, heldRevert{Resource: key, Revision: n.SHA, Reason: "reconcileBeforeRevert"})
+	}
+	r.mu.Unlock()
+	sort.Slice(s.Held, func(i, j int) bool {
+		if s.Held[i].Resource == s.Held[j].Resource {
+			return s.Held[i].Reason < s.Held[j].Reason
+		}
+		return s.Held[i].Resource < s.Held[j].Resource
+	})
+	s.DeadLetters = r.deadLetterList()
+
+	r.batchMu.Lock()
+	for _, b := range r.batches {
+		for _, it := range b.items {
+			s.QueuedReverts = append(s.QueuedReverts, queuedRevert{
+				Project: b.gl.ProjectID, Resource: it.Res.String(), Revision: it.Revision, SHA: it.SHA, FlushAt: b.deadline,
+			})
+		}
+	}
+	r.batchMu.Unlock()
+	sort.Slice(s.QueuedReverts, func(i, j int) bool {
+		a, b := s.QueuedReverts[i], s.QueuedReverts[j]
+		if !a.FlushAt.Equal(b.FlushAt) {
+			return a.FlushAt.Before(b.FlushAt)
+		}
+		return a.SHA < b.SHA
+	})
+	return s
+}
+
+// debugStateHandler serves debugSnapshot as JSON on the metrics server.
+func (r *RollbackController) debugStateHandler(token string) http.Handler {
+	return bearerAuth(token, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.debugSnapshot())
+	}))
+}
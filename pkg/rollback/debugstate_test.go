@@ -0,0 +1,53 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestDebugState(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.RevertBatchWindow = time.Minute
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	r.setClock(clocktesting.NewFakeClock(start))
+	web := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+	r.handleResource(web.Kind, web.Name, web.Namespace, "main@sha1:aaa", false, func(string) {})
+	r.debounce.Complete("main@sha1:bbb")
+	r.revertCommit(context.Background(), gitlabProject{ProjectID: "42"}, web, nil, "main@sha1:bbb")
+	r.notifyOnly[web.String()] = "staging"
+	h := r.debugStateHandler("secret")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/state", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/debug/state", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var s debugState
+	if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Pending) != 1 || s.Pending[0].SHA != "main@sha1:aaa" || !s.Pending[0].RevertAt.Equal(start.Add(5*time.Minute)) {
+		t.Errorf("pending = %+v", s.Pending)
+	}
+	if len(s.Completed) != 1 || s.Completed[0] != "main@sha1:bbb" {
+		t.Errorf("completed = %v", s.Completed)
+	}
+	if len(s.QueuedReverts) != 1 || s.QueuedReverts[0].SHA != "bbb" || s.QueuedReverts[0].Project != "42" || !s.QueuedReverts[0].FlushAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("queued reverts = %+v", s.QueuedReverts)
+	}
+	if len(s.Held) != 1 || s.Held[0].Reason != "environment staging" {
+		t.Errorf("held = %+v", s.Held)
+	}
+}
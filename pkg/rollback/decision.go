@@ -0,0 +1,66 @@
+package rollback
+
+import (
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// DecisionAction is what the controller did about one observation of a
+// resource.
+type DecisionAction string
+
+const (
+	// DecisionNone: healthy, or nothing left to do, e.g. already reverted.
+	DecisionNone DecisionAction = "None"
+	// DecisionDetected: the failure was detected; its debounce window or
+	// escalation started.
+	DecisionDetected DecisionAction = "Detected"
+	// DecisionWaiting: still failing within the debounce window, or before
+	// the next escalation step.
+	DecisionWaiting DecisionAction = "Waiting"
+	// DecisionEscalated: escalation steps ran.
+	DecisionEscalated DecisionAction = "Escalated"
+	// DecisionReverted: the revert was issued.
+	DecisionReverted DecisionAction = "Reverted"
+	// DecisionSkipped: the failure was stable, but the commit opted out.
+	DecisionSkipped DecisionAction = "Skipped"
+	// DecisionNotified: the failure was stable, but the resource's
+	// environment is only notified about.
+	DecisionNotified DecisionAction = "Notified"
+	// DecisionRecovered: a pending failure became healthy.
+	DecisionRecovered DecisionAction = "Recovered"
+	// DecisionHeld: the revert is due but held by reconcileBeforeRevert, a
+	// metricsGate or a Flagger Canary, or the resource's RollbackPolicy is
+	// invalid.
+	DecisionHeld DecisionAction = "Held"
+	// DecisionSuppressed: the failure is not acted upon on its own; it is
+	// left to a failing dependency or within a stabilization window.
+	DecisionSuppressed DecisionAction = "Suppressed"
+)
+
+// Decision is the outcome of evaluating one observation of a resource.
+type Decision struct {
+	Action DecisionAction
+	// RequeueAfter is how long to wait before re-checking; 0 = no requeue.
+	RequeueAfter time.Duration
+	// Reason explains the action for logs and tests, e.g. "already reverted".
+	Reason string
+	// Err is set if the resource could not be evaluated; the reconcile is
+	// retried with backoff.
+	Err error
+}
+
+// result returns d as a controller-runtime reconcile result.
+func (d Decision) result() (ctrl.Result, error) {
+	return ctrl.Result{RequeueAfter: d.RequeueAfter}, d.Err
+}
+
+// reconciled logs the decision taken for res and returns it as the reconcile
+// result.
+func (r *RollbackController) reconciled(res resourceRef, d Decision) (ctrl.Result, error) {
+	r.log.V(1).Info("Reconciled", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name,
+		"action", d.Action, "reason", d.Reason, "requeueAfter", d.RequeueAfter)
+	observeRequeue(res, d)
+	return d.result()
+}
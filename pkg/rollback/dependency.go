@@ -0,0 +1,97 @@
+package rollback
+
+import (
+	"context"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// failingDependency returns the dependency res failed because of: the
+// deepest dependency in its dependsOn chain that is not Ready on the same
+// commit as sha. Kustomizations depend on Kustomizations and HelmReleases on
+// HelmReleases, as in Flux.
+func (r *RollbackController) failingDependency(ctx context.Context, res resourceRef, deps []meta.NamespacedObjectReference, sha string) (resourceRef, bool) {
+	return r.walkDependencies(ctx, res, deps, gitCommitSHA(sha), map[string]bool{res.String(): true})
+}
+
+func (r *RollbackController) walkDependencies(ctx context.Context, res resourceRef, deps []meta.NamespacedObjectReference, commit string, seen map[string]bool) (resourceRef, bool) {
+	for _, dep := range deps {
+		ref := resourceRef{Kind: res.Kind, Namespace: dep.Namespace, Name: dep.Name}
+		if ref.Namespace == "" {
+			ref.Namespace = res.Namespace
+		}
+		if seen[ref.String()] {
+			continue
+		}
+		seen[ref.String()] = true
+		ready, rev, next, ok := r.dependencyState(ctx, ref)
+		if !ok || ready || gitCommitSHA(rev) != commit {
+			continue
+		}
+		if root, ok := r.walkDependencies(ctx, ref, next, commit, seen); ok {
+			return root, true
+		}
+		return ref, true
+	}
+	return resourceRef{}, false
+}
+
+// dependencyState reads a dependency's readiness, the revision it last
+// attempted and its own dependencies. ok is false if it can't be read.
+func (r *RollbackController) dependencyState(ctx context.Context, ref resourceRef) (ready bool, rev string, deps []meta.NamespacedObjectReference, ok bool) {
+	key := client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}
+	switch ref.Kind {
+	case "Kustomization":
+		var ks kustomizev1.Kustomization
+		if err := r.getConverted(ctx, ref.Kind, key, &ks); err != nil {
+			return false, "", nil, false
+		}
+		ready = r.isReadyKind(ref.Kind, ks.Status.Conditions)
+		return ready, r.kustomizationRevision(ctx, &ks, ready), ks.GetDependsOn(), true
+	case "HelmRelease":
+		var hr helmv2.HelmRelease
+		if err := r.getConverted(ctx, ref.Kind, key, &hr); err != nil {
+			return false, "", nil, false
+		}
+		rev, ok := r.helmSourceRevision(ctx, &hr)
+		return r.isReadyKind(ref.Kind, hr.Status.Conditions), rev, hr.GetDependsOn(), ok
+	}
+	return false, "", nil, false
+}
+
+// suppressDownstream reports whether the failure of res on sha is a
+// downstream effect of a dependency failing on the same commit. Such
+// failures are not debounced or reverted on their own: the incident belongs
+// to the dependency, which is evaluated by its own reconcile. It returns how
+// long to wait before re-checking res.
+func (r *RollbackController) suppressDownstream(ctx context.Context, res resourceRef, deps []meta.NamespacedObjectReference, sha string, ready bool) (time.Duration, bool) {
+	if !r.Features.Enabled(DependencySuppression) {
+		return 0, false
+	}
+	var culprit resourceRef
+	failing := false
+	if !ready && sha != "" && len(deps) > 0 {
+		culprit, failing = r.failingDependency(ctx, res, deps, sha)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := res.String()
+	if !failing {
+		delete(r.suppressed, key)
+		return 0, false
+	}
+	r.setStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+	if r.suppressed[key] != sha {
+		r.suppressed[key] = sha
+		delete(r.escalations, key)
+		r.log.Info("Failure caused by a failing dependency, not reverting on its own", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "dependency", culprit.String())
+		r.recordAudit(auditSuppressed, res.Kind, res.Namespace, res.Name, sha, "dependency "+culprit.String()+" fails on the same revision")
+	}
+	// The dependency's recovery or revert changes this resource's status too;
+	// re-check after a window in case it doesn't.
+	return r.debounce.Window(), true
+}
@@ -0,0 +1,62 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func failingKustomization(name, revision string, deps ...string) *kustomizev1.Kustomization {
+	ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: name}}
+	for _, dep := range deps {
+		ks.Spec.DependsOn = append(ks.Spec.DependsOn, kustomizev1.DependencyReference{Name: dep})
+	}
+	ks.Status.LastAttemptedRevision = revision
+	ks.Status.Conditions = []metav1.Condition{{Type: "Ready", Status: metav1.ConditionFalse, Reason: "ReconciliationFailed"}}
+	return ks
+}
+
+func TestSuppressDownstream(t *testing.T) {
+	infra := failingKustomization("infra", "main@sha1:bad")
+	apps := failingKustomization("apps", "main@sha1:bad", "infra")
+	web := failingKustomization("web", "main@sha1:bad", "apps")
+	other := failingKustomization("other", "main@sha1:old")
+	api := failingKustomization("api", "main@sha1:bad", "other")
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(infra, apps, web, other, api).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "web"}
+	requeue, ok := r.suppressDownstream(ctx, res, web.GetDependsOn(), "main@sha1:bad", false)
+	if !ok || requeue != 300*time.Second {
+		t.Fatalf("suppressDownstream = %v, %v; want suppressed", requeue, ok)
+	}
+	last := r.auditLog[len(r.auditLog)-1]
+	if last.Event != auditSuppressed || last.Message != "dependency Kustomization/flux-system/infra fails on the same revision" {
+		t.Errorf("unexpected audit entry %+v", last)
+	}
+	if _, ok := r.suppressDownstream(ctx, res, web.GetDependsOn(), "main@sha1:bad", false); !ok || len(r.auditLog) != 1 {
+		t.Errorf("repeated suppression recorded %d audit entries, want 1", len(r.auditLog))
+	}
+	if len(r.debounce.Pending()) != 0 {
+		t.Error("suppressed failure started a debounce window")
+	}
+
+	// A dependency failing on another revision is not the culprit.
+	res.Name = "api"
+	if _, ok := r.suppressDownstream(ctx, res, api.GetDependsOn(), "main@sha1:bad", false); ok {
+		t.Error("suppressed for a dependency failing on another revision")
+	}
+	r.Features = featureGates{DependencySuppression: false}
+	res.Name = "web"
+	if _, ok := r.suppressDownstream(ctx, res, web.GetDependsOn(), "main@sha1:bad", false); ok {
+		t.Error("suppressed with the gate off")
+	}
+}
@@ -0,0 +1,88 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryOptions bound the paged GitLab listings of discovery calls:
+// revert branches and MRs on startup, searches for an existing revert MR,
+// commit diffs and blob searches. In monorepos with tens of thousands of
+// branches and MRs, a listing must neither stall startup nor a revert.
+type discoveryOptions struct {
+	PageSize    int           // items per page (per_page), at most 100
+	MaxPages    int           // pages read per listing
+	Timeout     time.Duration // total time of one listing, all pages; 0 = none
+	Concurrency int           // pages requested in parallel
+}
+
+var defaultDiscoveryOptions = discoveryOptions{PageSize: 100, MaxPages: 20, Timeout: time.Minute, Concurrency: 1}
+
+// discoveryOptionsFromEnv reads GITLAB_PAGE_SIZE, GITLAB_MAX_PAGES,
+// GITLAB_DISCOVERY_TIMEOUT_SECONDS and GITLAB_DISCOVERY_CONCURRENCY, keeping
+// the defaults for unset or invalid values.
+func discoveryOptionsFromEnv(getenv func(string) string) discoveryOptions {
+	opts := defaultDiscoveryOptions
+	if n, err := strconv.Atoi(getenv("GITLAB_PAGE_SIZE")); err == nil && n > 0 {
+		opts.PageSize = min(n, 100)
+	}
+	if n, err := strconv.Atoi(getenv("GITLAB_MAX_PAGES")); err == nil && n > 0 {
+		opts.MaxPages = n
+	}
+	if n, err := strconv.Atoi(getenv("GITLAB_DISCOVERY_TIMEOUT_SECONDS")); err == nil && n >= 0 {
+		opts.Timeout = time.Duration(n) * time.Second
+	}
+	if n, err := strconv.Atoi(getenv("GITLAB_DISCOVERY_CONCURRENCY")); err == nil && n > 0 {
+		opts.Concurrency = n
+	}
+	return opts
+}
+
+// listPages GETs the GitLab listing at path page by page, decoding each page
+// into []T, until a page is short or opts.MaxPages pages are read. Up to
+// opts.Concurrency pages are requested at a time; a listing still running
+// after opts.Timeout fails.
+func listPages[T any](g gitlabProject, path string, opts discoveryOptions) ([]T, error) {
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	var all []T
+	for first := 1; first <= opts.MaxPages; first += opts.Concurrency {
+		pages := make([][]T, min(opts.Concurrency, opts.MaxPages-first+1))
+		errs := make([]error, len(pages))
+		var wg sync.WaitGroup
+		for i := range pages {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				page := fmt.Sprintf("%s%sper_page=%d&page=%d", path, sep, opts.PageSize, first+i)
+				errs[i] = g.requestContext(ctx, "GET", page, nil, &pages[i])
+			}()
+		}
+		wg.Wait()
+		for i, items := range pages {
+			if errs[i] != nil {
+				if ctx.Err() != nil {
+					return nil, fmt.Errorf("listing %s: timed out after %s and %d pages: %w", path, opts.Timeout, first+i-1, errs[i])
+				}
+				return nil, errs[i]
+			}
+			all = append(all, items...)
+			if len(items) < opts.PageSize {
+				return all, nil
+			}
+		}
+	}
+	return all, nil
+}
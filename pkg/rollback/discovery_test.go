@@ -0,0 +1,88 @@
+package rollback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryOptionsFromEnv(t *testing.T) {
+	if got := discoveryOptionsFromEnv(func(string) string { return "" }); got != defaultDiscoveryOptions {
+		t.Errorf("unset: %+v", got)
+	}
+	env := map[string]string{
+		"GITLAB_PAGE_SIZE":                 "500",
+		"GITLAB_MAX_PAGES":                 "200",
+		"GITLAB_DISCOVERY_TIMEOUT_SECONDS": "0",
+		"GITLAB_DISCOVERY_CONCURRENCY":     "4",
+	}
+	want := discoveryOptions{PageSize: 100, MaxPages: 200, Concurrency: 4}
+	if got := discoveryOptionsFromEnv(func(k string) string { return env[k] }); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	const total = 23
+	var mu sync.Mutex
+	var pages []string
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if q.Get("search") == "slow" {
+			<-block
+		}
+		mu.Lock()
+		pages = append(pages, q.Get("page"))
+		mu.Unlock()
+		size, _ := strconv.Atoi(q.Get("per_page"))
+		page, _ := strconv.Atoi(q.Get("page"))
+		var items []string
+		for i := (page - 1) * size; i < min(page*size, total); i++ {
+			items = append(items, `{"name":"b`+strconv.Itoa(i)+`"}`)
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(items, ",") + "]"))
+	}))
+	defer srv.Close()
+	defer close(block)
+	gl := gitlabProject{BaseURL: srv.URL, ProjectID: "42"}
+	type branch struct {
+		Name string `json:"name"`
+	}
+
+	for _, opts := range []discoveryOptions{
+		{PageSize: 5, MaxPages: 20, Concurrency: 1},
+		{PageSize: 5, MaxPages: 20, Concurrency: 3},
+		{PageSize: 10, MaxPages: 20, Concurrency: 10},
+	} {
+		pages = nil
+		got, err := listPages[branch](gl, "/repository/branches?search=b", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != total || got[0].Name != "b0" || got[total-1].Name != "b22" {
+			t.Errorf("%+v: listed %v", opts, got)
+		}
+		if want := (total/opts.PageSize + opts.Concurrency) / opts.Concurrency * opts.Concurrency; len(pages) != want {
+			t.Errorf("%+v: requested pages %v, want %d", opts, pages, want)
+		}
+	}
+
+	got, err := listPages[branch](gl, "/repository/branches", discoveryOptions{PageSize: 5, MaxPages: 2, Concurrency: 1})
+	if err != nil || len(got) != 10 {
+		t.Errorf("MaxPages 2: %d items, %v", len(got), err)
+	}
+
+	start := time.Now()
+	_, err = listPages[branch](gl, "/repository/branches?search=slow", discoveryOptions{PageSize: 5, MaxPages: 20, Concurrency: 1, Timeout: 50 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms and 0 pages") {
+		t.Errorf("slow listing: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the timeout did not cancel the request")
+	}
+}
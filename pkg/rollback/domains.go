@@ -0,0 +1,197 @@
+package rollback
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// defaultFailureDomain is the failure domain of resources whose policy sets
+// none.
+const defaultFailureDomain = "default"
+
+// domainBudgetRemaining is served on the controller-runtime metrics endpoint.
+var domainBudgetRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "rollback_failure_domain_budget_remaining",
+	Help: "Reverts a failure domain may still issue within its FAILURE_DOMAIN_BUDGETS window.",
+}, []string{"domain"})
+
+func init() {
+	metrics.Registry.MustRegister(domainBudgetRemaining)
+}
+
+// domainBudget allows Reverts reverts per Window.
+type domainBudget struct {
+	Reverts int
+	Window  time.Duration
+}
+
+// domainBudgets bounds the reverts of each failure domain, so an incident in
+// one domain can't use up the reverts the others need. It has its own lock
+// since reverts run both with and without r.mu held.
+type domainBudgets struct {
+	mu     sync.Mutex
+	limits map[string]domainBudget // by domain; "*" applies to unlisted ones
+	spent  map[string][]time.Time  // revert times by domain, oldest first
+}
+
+// parseDomainBudgets parses FAILURE_DOMAIN_BUDGETS: comma-separated
+// <domain>=<reverts>/<window> entries such as "infra=3/1h,*=10/1h". "*"
+// applies to every unlisted domain, each with a budget of its own; without
+// it, unlisted domains are not limited. Empty returns nil, no budgets.
+func parseDomainBudgets(spec string) (*domainBudgets, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	b := &domainBudgets{limits: make(map[string]domainBudget), spent: make(map[string][]time.Time)}
+	for _, entry := range strings.Split(spec, ",") {
+		domain, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		reverts, window, ok2 := strings.Cut(limit, "/")
+		if !ok || !ok2 || domain == "" {
+			return nil, fmt.Errorf("FAILURE_DOMAIN_BUDGETS entry %q: want <domain>=<reverts>/<window>", entry)
+		}
+		n, err := strconv.Atoi(reverts)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("FAILURE_DOMAIN_BUDGETS entry %q: invalid number of reverts", entry)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("FAILURE_DOMAIN_BUDGETS entry %q: invalid window", entry)
+		}
+		b.limits[domain] = domainBudget{Reverts: n, Window: d}
+	}
+	return b, nil
+}
+
+// limit returns the budget of domain, and false if it has none.
+func (b *domainBudgets) limit(domain string) (domainBudget, bool) {
+	if l, ok := b.limits[domain]; ok {
+		return l, true
+	}
+	l, ok := b.limits["*"]
+	return l, ok
+}
+
+// available reports whether domain may revert at now and, if not, how long
+// until its oldest revert leaves the window. Safe to call on nil.
+func (b *domainBudgets) available(domain string, now time.Time) (time.Duration, bool) {
+	if b == nil {
+		return 0, true
+	}
+	l, ok := b.limit(domain)
+	if !ok {
+		return 0, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spent := b.prune(domain, l, now)
+	if len(spent) < l.Reverts {
+		return 0, true
+	}
+	if len(spent) == 0 {
+		// A budget of 0 never allows a revert.
+		return l.Window, false
+	}
+	return spent[0].Add(l.Window).Sub(now), false
+}
+
+// spend records a revert of domain at now. Safe to call on nil.
+func (b *domainBudgets) spend(domain string, now time.Time) {
+	if b == nil {
+		return
+	}
+	l, ok := b.limit(domain)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent[domain] = append(b.prune(domain, l, now), now)
+	domainBudgetRemaining.WithLabelValues(domain).Set(float64(max(l.Reverts-len(b.spent[domain]), 0)))
+}
+
+// prune drops the reverts of domain that left the window and returns the
+// rest. Callers must hold b.mu.
+func (b *domainBudgets) prune(domain string, l domainBudget, now time.Time) []time.Time {
+	spent := b.spent[domain]
+	i := 0
+	for i < len(spent) && !spent[i].Add(l.Window).After(now) {
+		i++
+	}
+	spent = spent[i:]
+	b.spent[domain] = spent
+	domainBudgetRemaining.WithLabelValues(domain).Set(float64(max(l.Reverts-len(spent), 0)))
+	return spent
+}
+
+// checkDomainBudget holds a due revert of res while the failure domain of
+// its policy has spent its revert budget, until the domain's oldest revert
+// leaves the window. It reports whether remediate must wait, and for how
+// long.
+func (r *RollbackController) checkDomainBudget(res resourceRef, sha string, ready bool, policy *RollbackPolicy) (time.Duration, bool) {
+	key := res.String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ready || sha == "" || r.Budgets == nil {
+		delete(r.budgetHeld, key)
+		return 0, false
+	}
+	if !r.revertDue(res, sha, policy) {
+		return 0, false
+	}
+	domain := policy.failureDomain()
+	wait, ok := r.Budgets.available(domain, r.clock.Now())
+	if ok {
+		delete(r.budgetHeld, key)
+		return 0, false
+	}
+	if r.budgetHeld[key] != sha {
+		r.budgetHeld[key] = sha
+		l, _ := r.Budgets.limit(domain)
+		r.log.Info("Revert due, but the failure domain spent its revert budget, holding it", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name,
+			"sha", sha, "domain", domain, "reverts", l.Reverts, "window", l.Window)
+		r.recordAudit(auditBudgetExhausted, res.Kind, res.Namespace, res.Name, sha,
+			fmt.Sprintf("failure domain %s spent its budget of %d reverts per %s", domain, l.Reverts, l.Window))
+		r.emitEvent(auditBudgetExhausted, res.Kind, res.Namespace, res.Name, sha)
+	}
+	return r.capRequeue(wait), true
+}
+
+// spendDomainBudget returns revert, charging every revert to the failure
+// domain of policy.
+func (r *RollbackController) spendDomainBudget(policy *RollbackPolicy, revert func(sha string)) func(sha string) {
+	if r.Budgets == nil {
+		return revert
+	}
+	domain := policy.failureDomain()
+	return func(sha string) {
+		r.Budgets.spend(domain, r.clock.Now())
+		revert(sha)
+	}
+}
+
+// parseDomainNotifiers parses FAILURE_DOMAIN_NOTIFIERS: comma-separated
+// <domain>=<notifier URL> entries, with the URLs of INCIDENT_NOTIFIER.
+func parseDomainNotifiers(spec string) (map[string]incidentNotifier, error) {
+	out := make(map[string]incidentNotifier)
+	if strings.TrimSpace(spec) == "" {
+		return out, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		domain, u, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || domain == "" || u == "" {
+			return nil, fmt.Errorf("FAILURE_DOMAIN_NOTIFIERS entry %q: want <domain>=<notifier URL>", entry)
+		}
+		n, err := newIncidentNotifier(u)
+		if err != nil {
+			return nil, fmt.Errorf("FAILURE_DOMAIN_NOTIFIERS entry for %s: %w", domain, err)
+		}
+		out[domain] = n
+	}
+	return out, nil
+}
@@ -0,0 +1,105 @@
+package rollback
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseDomainBudgets(t *testing.T) {
+	b, err := parseDomainBudgets("infra=3/1h, *=10/30m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, ok := b.limit("infra"); !ok || l != (domainBudget{3, time.Hour}) {
+		t.Errorf("infra budget %+v, %v", l, ok)
+	}
+	if l, ok := b.limit("payments"); !ok || l != (domainBudget{10, 30 * time.Minute}) {
+		t.Errorf("unlisted domains get %+v, %v; want the * budget", l, ok)
+	}
+	if b, _ := parseDomainBudgets(""); b != nil {
+		t.Error("empty spec should set no budgets")
+	}
+	for _, spec := range []string{"infra=3", "infra=x/1h", "infra=3/0s", "=3/1h"} {
+		if _, err := parseDomainBudgets(spec); err == nil {
+			t.Errorf("parseDomainBudgets(%q) accepted", spec)
+		}
+	}
+}
+
+func TestDomainBudget(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 60)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	r.Budgets, _ = parseDomainBudgets("infra=1/1h")
+	ctx := context.Background()
+	infra := &RollbackPolicy{Spec: RollbackPolicySpec{FailureDomain: "infra"}}
+	reverted := make(map[string]int)
+	remediate := func(name, sha string, policy *RollbackPolicy) Decision {
+		res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: name}
+		return r.remediate(ctx, res, sha, false, policy, func(string) { reverted[name]++ })
+	}
+
+	remediate("network", "main@sha1:aaa", infra)
+	remediate("dns", "main@sha1:bbb", infra)
+	remediate("shop", "main@sha1:ccc", nil)
+	clk.SetTime(start.Add(time.Minute))
+	remediate("network", "main@sha1:aaa", infra)
+	for i := 0; i < 2; i++ {
+		if d := remediate("dns", "main@sha1:bbb", infra); d.Action != DecisionHeld || d.RequeueAfter != time.Hour {
+			t.Fatalf("second infra revert: %+v, want it held for the rest of the window", d)
+		}
+	}
+	// The infra incident does not touch the budget of other domains.
+	remediate("shop", "main@sha1:ccc", nil)
+	if reverted["network"] != 1 || reverted["dns"] != 0 || reverted["shop"] != 1 {
+		t.Errorf("reverts %v, want network and shop", reverted)
+	}
+	held := 0
+	for _, e := range r.auditLog {
+		if e.Event == auditBudgetExhausted {
+			held++
+		}
+	}
+	if held != 1 {
+		t.Errorf("%d budgetExhausted audit entries, want 1", held)
+	}
+
+	clk.SetTime(start.Add(time.Hour + time.Minute))
+	if remediate("dns", "main@sha1:bbb", infra); reverted["dns"] != 1 {
+		t.Errorf("window passed: %d dns reverts, want 1", reverted["dns"])
+	}
+}
+
+func TestDomainNotifiers(t *testing.T) {
+	policy := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{
+		"targets":       []interface{}{map[string]interface{}{"kind": "Kustomization", "name": "network"}},
+		"failureDomain": "infra",
+	}}}
+	policy.SetGroupVersionKind(gaFluxAPIs.Policy)
+	policy.SetNamespace("apps")
+	policy.SetName("infra")
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(policy).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 0)
+	r.DomainNotifiers = map[string]incidentNotifier{"infra": &recordingNotifier{}}
+
+	inc := incident{Events: []incidentEvent{
+		{Event: auditDetected, Resource: "Kustomization/apps/network"},
+		{Event: auditDetected, Resource: "Kustomization/apps/web"},
+	}}
+	var keys []string
+	for key := range r.incidentRecipients(context.Background(), inc, &recordingNotifier{}) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "" || keys[1] != "domain:infra" {
+		t.Errorf("recipients %q, want INCIDENT_NOTIFIER and the infra notifier", keys)
+	}
+}
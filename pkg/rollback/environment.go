@@ -0,0 +1,71 @@
+package rollback
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultEnvironmentLabel is the label naming a resource's environment
+// (ENVIRONMENT_LABEL).
+const defaultEnvironmentLabel = "environment"
+
+// environmentOf returns the environment of res: the EnvironmentLabel of the
+// resource itself, else of its namespace, else "".
+func (r *RollbackController) environmentOf(ctx context.Context, res resourceRef) (string, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.APIs.gvkFor(res.Kind))
+	if err := r.Get(ctx, client.ObjectKey{Namespace: res.Namespace, Name: res.Name}, obj); err != nil {
+		return "", err
+	}
+	if env, ok := obj.GetLabels()[r.EnvironmentLabel]; ok {
+		return env, nil
+	}
+	var ns corev1.Namespace
+	if err := r.Get(ctx, client.ObjectKey{Name: res.Namespace}, &ns); err != nil {
+		return "", err
+	}
+	return ns.Labels[r.EnvironmentLabel], nil
+}
+
+// checkEnvironment decides whether the policy's revertEnvironments allow
+// reverting res. If not, runRevert only notifies for it. A resource whose
+// environment can't be read is reverted as usual.
+func (r *RollbackController) checkEnvironment(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy) {
+	key := res.String()
+	envs := policy.revertEnvironments()
+	if ready || sha == "" || len(envs) == 0 {
+		r.mu.Lock()
+		delete(r.notifyOnly, key)
+		r.mu.Unlock()
+		return
+	}
+	env, err := r.environmentOf(ctx, res)
+	if err != nil {
+		r.log.Error(err, "failed to read the environment, reverting as usual", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+		return
+	}
+	allowed := false
+	for _, pattern := range envs {
+		if env != "" && globMatch(pattern, env) {
+			allowed = true
+			break
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if allowed {
+		delete(r.notifyOnly, key)
+		return
+	}
+	r.notifyOnly[key] = env
+}
+
+// notifyInstead reports whether res only gets notified about instead of
+// reverted, and its environment. Callers must hold r.mu.
+func (r *RollbackController) notifyInstead(res resourceRef) (string, bool) {
+	env, ok := r.notifyOnly[res.String()]
+	return env, ok
+}
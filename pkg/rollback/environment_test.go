@@ -0,0 +1,60 @@
+package rollback
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEnvironmentOf(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"environment": "production"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sandbox"}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "preview", Labels: map[string]string{"environment": "staging"}}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "sandbox", Name: "demo"}},
+	).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 0)
+	for name, want := range map[string]string{"shop/web": "production", "shop/preview": "staging", "sandbox/demo": ""} {
+		ns, n, _ := strings.Cut(name, "/")
+		got, err := r.environmentOf(context.Background(), resourceRef{Kind: "Kustomization", Namespace: ns, Name: n})
+		if err != nil || got != want {
+			t.Errorf("environmentOf(%s) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestRevertEnvironments(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web", Labels: map[string]string{"environment": "prod-eu"}}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "preview", Labels: map[string]string{"environment": "staging"}}},
+	).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 0)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{RevertEnvironments: []string{"prod-*"}}}
+
+	var reverted []string
+	for _, tc := range []struct{ name, sha string }{{"web", "main@sha1:aaa"}, {"preview", "main@sha1:bbb"}} {
+		res := resourceRef{Kind: "Kustomization", Namespace: "shop", Name: tc.name}
+		for i := 0; i < 2; i++ {
+			r.remediate(ctx, res, tc.sha, false, policy, func(sha string) { reverted = append(reverted, sha) })
+		}
+	}
+	if len(reverted) != 1 || reverted[0] != "main@sha1:aaa" {
+		t.Errorf("reverted %v, want only the production resource", reverted)
+	}
+	last := r.auditLog[len(r.auditLog)-1]
+	if last.Event != auditNotified || last.Name != "preview" || last.Message != `environment "staging" is not in revertEnvironments; not reverted` {
+		t.Errorf("unexpected audit entry %+v", last)
+	}
+}
@@ -0,0 +1,218 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// suspendedAnnotation is set on resources suspended by an escalation, so it
+// is clear who suspended them and for which revision.
+const suspendedAnnotation = "rollback.eumel8.io/suspended-for"
+
+// escalation tracks a failing resource through its policy's escalation steps.
+type escalation struct {
+	SHA       string
+	FirstSeen time.Time
+	Done      int // steps already run, in policy order
+	Attempts  int // failed attempts of the next step (retryableError)
+}
+
+// remediate runs the policy's escalation for the resource if it has one, and
+// the plain debounced revert otherwise, and returns the decision. A resource
+// targeted by an invalid policy is held.
+func (r *RollbackController) remediate(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy, revert func(sha string)) Decision {
+	if r.invalidPolicy(policy) != nil {
+		r.recordStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+		return Decision{Action: DecisionHeld, Reason: "invalid policy"}
+	}
+	revert = r.spendDomainBudget(policy, r.reconcileAfterRevert(context.WithoutCancel(ctx), res, policy, revert))
+	r.checkFingerprint(res, sha, ready)
+	if !ready {
+		defaults := r.namespaceDefaultsFor(ctx, res.Namespace)
+		r.setNamespaceDebounce(res.Namespace, defaults.Debounce)
+		r.warnInvalidNamespaceDebounce(res, sha, defaults.DebounceErr)
+	}
+	if !ready && sha != "" && !(res.Kind == "HelmRelease" && policy.helmRemediation() == HelmRemediationPinChartVersion) {
+		// Chart pins track chart versions, not commits.
+		r.checkSkipMarker(ctx, res, sha)
+	}
+	r.checkEnvironment(ctx, res, sha, ready, policy)
+	if wait, hold := r.checkFlapping(res, sha, ready); hold {
+		return Decision{Action: DecisionHeld, RequeueAfter: wait, Reason: "flapping"}
+	}
+	if wait, hold := r.nudgeBeforeRevert(ctx, res, sha, ready, policy); hold {
+		r.recordStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+		return Decision{Action: DecisionHeld, RequeueAfter: wait, Reason: "reconcileBeforeRevert"}
+	}
+	if wait, hold := r.checkMetricsGate(ctx, res, sha, ready, policy); hold {
+		r.recordStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+		return Decision{Action: DecisionHeld, RequeueAfter: wait, Reason: "metricsGate"}
+	}
+	if wait, hold := r.checkCanaries(ctx, res, sha, ready, policy); hold {
+		r.recordStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+		return Decision{Action: DecisionHeld, RequeueAfter: wait, Reason: "canary"}
+	}
+	if wait, hold := r.checkDomainBudget(res, sha, ready, policy); hold {
+		r.recordStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+		return Decision{Action: DecisionHeld, RequeueAfter: wait, Reason: "failureDomainBudget"}
+	}
+	var decision Decision
+	if len(policy.escalation()) > 0 {
+		decision = r.handleEscalation(ctx, res, sha, ready, policy, revert)
+	} else {
+		decision = r.handleResource(res.Kind, res.Name, res.Namespace, sha, ready, revert)
+	}
+	if wait := r.checkStaleMR(ctx, res, sha, ready, policy); wait > 0 && (decision.RequeueAfter == 0 || wait < decision.RequeueAfter) {
+		decision.RequeueAfter = wait
+	}
+	r.checkAffectedResources(ctx, res, sha, ready)
+	if wait := r.checkMergeTrain(ctx, res); wait > 0 && (decision.RequeueAfter == 0 || wait < decision.RequeueAfter) {
+		decision.RequeueAfter = wait
+	}
+	if wait := r.checkVerification(ctx, res, sha, ready, policy); wait > 0 && (decision.RequeueAfter == 0 || wait < decision.RequeueAfter) {
+		decision.RequeueAfter = wait
+	}
+	return decision
+}
+
+// handleEscalation runs every step whose After has elapsed since the resource
+// started failing on sha, in order and each at most once. A new revision
+// starts over; recovery ends the escalation. The Revert step shares the
+// completed SHAs with handleResource, so a commit is reverted only once even
+// if several resources escalate on it.
+func (r *RollbackController) handleEscalation(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy, revert func(sha string)) Decision {
+	steps := policy.escalation()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+	if sha == "" {
+		r.log.Info("WARNING: Cannot escalate without sha", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+		return Decision{Action: DecisionNone, Reason: "no revision"}
+	}
+	key := res.String()
+	e := r.escalations[key]
+	decision := Decision{Action: DecisionNone}
+	if e != nil && (ready || e.SHA != sha) {
+		delete(r.escalations, key)
+		delete(r.skipMarked, e.SHA)
+		if ready {
+			r.recordAudit(auditRecovered, res.Kind, res.Namespace, res.Name, e.SHA, "")
+			r.emitEvent(auditRecovered, res.Kind, res.Namespace, res.Name, e.SHA)
+			decision.Action = DecisionRecovered
+		}
+		e = nil
+	}
+	if ready {
+		r.rearmCompleted(res.Kind, res.Namespace, res.Name, sha)
+		return decision
+	}
+	now := r.clock.Now()
+	if e == nil {
+		e = &escalation{SHA: sha, FirstSeen: now}
+		r.escalations[key] = e
+		r.log.Info("Failure detected, escalating", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "steps", len(steps))
+		r.recordAudit(auditDetected, res.Kind, res.Namespace, res.Name, sha, "")
+		r.emitEvent(auditDetected, res.Kind, res.Namespace, res.Name, sha)
+		decision.Action = DecisionDetected
+	} else if e.Done < len(steps) {
+		decision.Action = DecisionWaiting
+	}
+	for e.Done < len(steps) {
+		step := steps[e.Done]
+		if wait := step.After.Duration - now.Sub(e.FirstSeen); wait > 0 {
+			decision.RequeueAfter = r.capRequeue(wait)
+			return decision
+		}
+		e.Done++
+		if r.runEscalationStep(ctx, e, actionRun{res: res, sha: sha, step: step, policy: policy, revert: revert}) {
+			e.Done--
+			decision.RequeueAfter = r.capRequeue(actionRetryInterval)
+			decision.Action, decision.Reason = DecisionWaiting, step.Action
+			return decision
+		}
+		decision.Action, decision.Reason = DecisionEscalated, step.Action
+	}
+	return decision
+}
+
+// runEscalationStep runs one step of e with its remediationAction and
+// reports whether to run it again: after a retryableError, up to
+// maxActionAttempts times. Callers must hold r.mu.
+func (r *RollbackController) runEscalationStep(ctx context.Context, e *escalation, a actionRun) bool {
+	a.log = r.log.WithValues("kind", a.res.Kind, "namespace", a.res.Namespace, "name", a.res.Name, "sha", a.sha, "after", a.step.After.Duration)
+	action, ok := remediationActions[a.step.Action]
+	if !ok {
+		a.log.Error(nil, "unknown escalation action, skipping", "action", a.step.Action)
+		return false
+	}
+	err := action.run(ctx, r, a)
+	var retry *retryableError
+	if errors.As(err, &retry) && e.Attempts+1 < maxActionAttempts {
+		e.Attempts++
+		a.log.Error(err, "escalation action failed, retrying", "action", a.step.Action, "attempt", e.Attempts, "in", actionRetryInterval)
+		return true
+	}
+	e.Attempts = 0
+	if err != nil {
+		a.log.Error(err, "escalation action failed", "action", a.step.Action)
+		r.kubeEventf(a.res.Kind, a.res.Namespace, a.res.Name, a.sha, corev1.EventTypeWarning, "RollbackActionFailed", "Escalation action %s failed for revision %s: %v", a.step.Action, a.sha, err)
+	}
+	return false
+}
+
+// suspendResource sets spec.suspend on the resource. Flux keeps a suspended
+// resource as it is until someone resumes it, e.g. with "flux resume".
+func (r *RollbackController) suspendResource(ctx context.Context, res resourceRef, sha string) error {
+	if dryRun() {
+		r.log.Info("ECHO: would suspend", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+		r.recordAction(r.project(ctx, res.Kind, res.Namespace, res.Name), recordedAction{Action: "suspend", SHA: sha, Namespace: res.Namespace, Name: res.Name})
+		return nil
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.APIs.gvkFor(res.Kind))
+	obj.SetNamespace(res.Namespace)
+	obj.SetName(res.Name)
+	return r.suspendObject(ctx, obj, sha)
+}
+
+// suspendObject sets spec.suspend and suspendedAnnotation on obj.
+func (r *RollbackController) suspendObject(ctx context.Context, obj client.Object, sha string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]string{suspendedAnnotation: sha}},
+		"spec":     map[string]interface{}{"suspend": true},
+	})
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch))
+}
+
+// autoMergeRevert sets the revert MR iid to merge when its pipeline succeeds,
+// adds it to the merge train if the policy sets mergeRequest.mergeTrain, or
+// submits the Gerrit revert change iid.
+func (r *RollbackController) autoMergeRevert(ctx context.Context, res resourceRef, sha string, iid int, policy *RollbackPolicy) error {
+	gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+	if dryRun() {
+		r.log.Info("ECHO: would merge revert MR", "mr", iid, "project", gl.ProjectID)
+		r.recordAction(gl, recordedAction{Action: "autoMerge", SHA: sha, Namespace: res.Namespace, Name: res.Name})
+		return nil
+	}
+	switch gl.Provider {
+	case providerGerrit:
+		return gl.gerrit().submit(iid)
+	case providerCodeCommit, providerSSH:
+		return fmt.Errorf("AutoMerge is not supported with provider %s", gl.Provider)
+	}
+	if policy.mergeTrain() {
+		return r.joinMergeTrain(gl, res, sha, iid)
+	}
+	return gl.mergeWhenPipelineSucceeds(iid)
+}
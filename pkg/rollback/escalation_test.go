@@ -0,0 +1,124 @@
+package rollback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEscalationPolicyDecoding(t *testing.T) {
+	raw := map[string]interface{}{"spec": map[string]interface{}{"escalation": []interface{}{
+		map[string]interface{}{"action": "Notify", "after": "0s"},
+		map[string]interface{}{"action": "Revert", "after": "15m"},
+	}}}
+	var p RollbackPolicy
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &p); err != nil {
+		t.Fatal(err)
+	}
+	want := []EscalationStep{{Action: EscalationNotify}, {Action: EscalationRevert, After: metav1.Duration{Duration: 15 * time.Minute}}}
+	if !reflect.DeepEqual(p.escalation(), want) {
+		t.Errorf("escalation = %+v, want %+v", p.escalation(), want)
+	}
+	if (*RollbackPolicy)(nil).escalation() != nil {
+		t.Error("nil policy must not escalate")
+	}
+}
+
+func TestHandleEscalation(t *testing.T) {
+	var merged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		merged = append(merged, req.Method+" "+req.URL.Path)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "apps"}}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(ks).Build()
+	r := NewRollbackController(c, logr.Discard(), "token", "42", srv.URL, "revert", 300)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+	steps := []EscalationStep{
+		{Action: EscalationNotify},
+		{Action: EscalationSuspend, After: metav1.Duration{Duration: 5 * time.Minute}},
+		{Action: EscalationRevert, After: metav1.Duration{Duration: 10 * time.Minute}},
+		{Action: EscalationAutoMerge, After: metav1.Duration{Duration: 30 * time.Minute}},
+	}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{Escalation: steps}}
+	var reverted []string
+	revert := func(sha string) {
+		reverted = append(reverted, sha)
+		r.setRevertMR(sha, &gitlabMergeRequest{IID: 9, WebURL: "https://gitlab/mr/9"})
+	}
+	step := func(at time.Duration, wantRequeue time.Duration) {
+		t.Helper()
+		clk.SetTime(start.Add(at))
+		if got := r.handleEscalation(ctx, res, "main@sha1:bad", false, policy, revert).RequeueAfter; got != wantRequeue {
+			t.Errorf("at %s: requeue = %s, want %s", at, got, wantRequeue)
+		}
+	}
+
+	step(0, 5*time.Minute) // notify right away
+	step(7*time.Minute, 3*time.Minute)
+	var got kustomizev1.Kustomization
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ks), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Spec.Suspend || got.Annotations[suspendedAnnotation] != "main@sha1:bad" {
+		t.Errorf("resource not suspended: suspend=%v annotations=%v", got.Spec.Suspend, got.Annotations)
+	}
+	if len(reverted) != 0 {
+		t.Fatal("reverted before the Revert step")
+	}
+	step(10*time.Minute, 20*time.Minute)
+	step(11*time.Minute, 19*time.Minute)
+	if !reflect.DeepEqual(reverted, []string{"main@sha1:bad"}) || !r.debounce.IsCompleted("main@sha1:bad") {
+		t.Fatalf("reverted %v, want exactly one revert marked completed", reverted)
+	}
+	step(40*time.Minute, 0)
+	if !reflect.DeepEqual(merged, []string{"PUT /api/v4/projects/42/merge_requests/9/merge"}) {
+		t.Errorf("merge requests %v", merged)
+	}
+	var events []string
+	for _, e := range r.auditLog {
+		events = append(events, e.Event)
+	}
+	want := []string{auditDetected, auditNotified, auditSuspended, auditDebounced, auditReverted, auditAutoMerged}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("audit events %v, want %v", events, want)
+	}
+
+	// Recovery ends the escalation; the next failure starts over.
+	r.handleEscalation(ctx, res, "main@sha1:bad", true, policy, revert)
+	if _, ok := r.escalations[res.String()]; ok {
+		t.Error("escalation kept after recovery")
+	}
+	if last := r.auditLog[len(r.auditLog)-1]; last.Event != auditRecovered {
+		t.Errorf("last audit event %q, want %q", last.Event, auditRecovered)
+	}
+}
+
+func TestRemediateWithoutEscalation(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.setClock(clocktesting.NewFakeClock(time.Now()))
+	res := resourceRef{Kind: "Kustomization", Namespace: "ns", Name: "app"}
+	var reverted int
+	for i := 0; i < 2; i++ {
+		r.remediate(context.Background(), res, "abc", false, nil, func(string) { reverted++ })
+	}
+	if reverted != 1 || len(r.escalations) != 0 {
+		t.Errorf("plain debounce expected: reverted %d, escalations %d", reverted, len(r.escalations))
+	}
+}
@@ -0,0 +1,356 @@
+package rollback
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// cloudEventTypePrefix is prepended to the lifecycle event name to form the
+// CloudEvents type, e.g. "io.github.eumel8.rollback.reverted".
+const cloudEventTypePrefix = "io.github.eumel8.rollback."
+
+// eventQueueSize bounds the events buffered for the sink; further events are
+// dropped so a slow sink never blocks reconciles.
+const eventQueueSize = 100
+
+// cloudEvent is a CloudEvents 1.0 event in structured JSON mode.
+type cloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            cloudEventData `json:"data"`
+	// IncidentID is an extension attribute grouping all events of one bad
+	// revision (see incident).
+	IncidentID string `json:"incidentid,omitempty"`
+	// Instance is an extension attribute naming the controller instance
+	// (CONTROLLER_INSTANCE).
+	Instance string `json:"instance,omitempty"`
+}
+
+type cloudEventData struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	SHA       string `json:"sha"`
+}
+
+var eventSeq atomic.Uint64
+
+func newCloudEvent(now time.Time, event, kind, namespace, name, sha string) cloudEvent {
+	return cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              fmt.Sprintf("%d-%d", now.UnixNano(), eventSeq.Add(1)),
+		Source:          "rollback-controller",
+		Type:            cloudEventTypePrefix + event,
+		Subject:         kind + "/" + namespace + "/" + name,
+		Time:            now,
+		DataContentType: "application/json",
+		Data:            cloudEventData{Kind: kind, Namespace: namespace, Name: name, SHA: sha},
+	}
+}
+
+// emitEvent records a lifecycle event as a Kubernetes Event (see
+// recordKubeEvent), adds it to its incident (see trackIncident) and queues
+// it as a CloudEvent if a sink is configured. It never blocks, so it is safe
+// to call with r.mu held.
+func (r *RollbackController) emitEvent(event, kind, namespace, name, sha string) {
+	r.recordKubeEvent(event, kind, namespace, name, sha)
+	incidentID := r.trackIncident(event, kind, namespace, name, sha)
+	if r.events == nil {
+		return
+	}
+	ce := newCloudEvent(r.clock.Now(), event, kind, namespace, name, sha)
+	ce.IncidentID = incidentID
+	ce.Instance = r.Instance
+	select {
+	case r.events <- ce:
+	default:
+		r.log.Info("CloudEvents queue full, dropping event", "event", event, "kind", kind, "namespace", namespace, "name", name)
+	}
+}
+
+// eventPublisher delivers a batch of events to a sink.
+type eventPublisher func(ctx context.Context, events []cloudEvent) error
+
+// eventSinks maps sink URL schemes to publisher constructors.
+var eventSinks = map[string]func(u *url.URL) (eventPublisher, error){
+	"http":      newHTTPPublisher,
+	"https":     newHTTPPublisher,
+	"nats":      newNATSPublisher,
+	"jetstream": newNATSPublisher,
+	"kafka":     newKafkaPublisher,
+}
+
+// newEventPublisher returns the publisher for a sink URL (see eventSinks).
+func newEventPublisher(sink string) (eventPublisher, error) {
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, err
+	}
+	newPublisher, ok := eventSinks[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported CloudEvents sink scheme %q (want http, https, nats, jetstream or kafka)", u.Scheme)
+	}
+	return newPublisher(u)
+}
+
+// sinkSubject returns the subject/topic from the URL path, defaulting to
+// "rollback-controller".
+func sinkSubject(u *url.URL) string {
+	if s := strings.TrimPrefix(u.Path, "/"); s != "" {
+		return s
+	}
+	return "rollback-controller"
+}
+
+// newHTTPPublisher posts events in structured content mode, or as a JSON
+// array in batched content mode when more than one event is sent.
+func newHTTPPublisher(u *url.URL) (eventPublisher, error) {
+	sink := u.String()
+	return func(ctx context.Context, events []cloudEvent) error {
+		var body interface{} = events
+		contentType := "application/cloudevents-batch+json"
+		if len(events) == 1 {
+			body, contentType = events[0], "application/cloudevents+json"
+		}
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "POST", sink, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("CloudEvents sink %s: %s", sink, resp.Status)
+		}
+		return nil
+	}, nil
+}
+
+// newNATSPublisher publishes to nats://host:port/subject. With the jetstream
+// scheme every message is published with a reply inbox and waits for the
+// stream's ack, so it is only considered delivered once persisted.
+func newNATSPublisher(u *url.URL) (eventPublisher, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	subject := sinkSubject(u)
+	jetStream := u.Scheme == "jetstream"
+	return func(ctx context.Context, events []cloudEvent) error {
+		return publishNATS(ctx, addr, subject, jetStream, events)
+	}, nil
+}
+
+// publishNATS publishes events using the plain NATS text protocol. Events are
+// rare, so each batch uses its own connection; the trailing PING/PONG confirms
+// the server processed all PUBs.
+func publishNATS(ctx context.Context, addr, subject string, jetStream bool, events []cloudEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The context deadline bounds the whole exchange, not only the dial.
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	rd := bufio.NewReader(conn)
+	if line, err := rd.ReadString('\n'); err != nil {
+		return err
+	} else if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+	var buf bytes.Buffer
+	buf.WriteString("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"rollback-controller\"}\r\n")
+	inbox := ""
+	if jetStream {
+		inbox = fmt.Sprintf("_INBOX.rollback-controller.%d", eventSeq.Add(1))
+		fmt.Fprintf(&buf, "SUB %s 1\r\n", inbox)
+	}
+	for _, ev := range events {
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if jetStream {
+			fmt.Fprintf(&buf, "PUB %s %s %d\r\n%s\r\n", subject, inbox, len(payload), payload)
+		} else {
+			fmt.Fprintf(&buf, "PUB %s %d\r\n%s\r\n", subject, len(payload), payload)
+		}
+	}
+	buf.WriteString("PING\r\n")
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	acks, pong := 0, false
+	for !pong || acks < len(events) && jetStream {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "PONG"):
+			pong = true
+		case strings.HasPrefix(line, "PING"):
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS: %s", strings.TrimSpace(line))
+		case strings.HasPrefix(line, "MSG "):
+			if err := readJetStreamAck(rd, line); err != nil {
+				return err
+			}
+			acks++
+		}
+	}
+	return nil
+}
+
+// readJetStreamAck reads the payload of a MSG line carrying a JetStream
+// publish ack and returns its error, if any.
+func readJetStreamAck(rd *bufio.Reader, msgLine string) error {
+	fields := strings.Fields(msgLine)
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return fmt.Errorf("malformed NATS MSG %q", strings.TrimSpace(msgLine))
+	}
+	payload := make([]byte, size+2) // payload + CRLF
+	if _, err := io.ReadFull(rd, payload); err != nil {
+		return err
+	}
+	var ack struct {
+		Stream string `json:"stream"`
+		Error  *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload[:size], &ack); err != nil {
+		return fmt.Errorf("malformed JetStream ack: %w", err)
+	}
+	if ack.Error != nil {
+		return fmt.Errorf("JetStream: %s", ack.Error.Description)
+	}
+	if ack.Stream == "" {
+		return fmt.Errorf("no JetStream stream for subject (ack %q)", payload[:size])
+	}
+	return nil
+}
+
+// newKafkaPublisher writes to kafka://broker1:9092,broker2:9092/topic. Events
+// are keyed by subject so a resource's lifecycle stays ordered within a
+// partition.
+func newKafkaPublisher(u *url.URL) (eventPublisher, error) {
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+		Topic:        sinkSubject(u),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		MaxAttempts:  1, // retries are handled by runEventSink
+	}
+	return func(ctx context.Context, events []cloudEvent) error {
+		msgs := make([]kafka.Message, 0, len(events))
+		for _, ev := range events {
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, kafka.Message{
+				Key:     []byte(ev.Subject),
+				Value:   payload,
+				Headers: []kafka.Header{{Key: "content-type", Value: []byte("application/cloudevents+json")}},
+			})
+		}
+		return w.WriteMessages(ctx, msgs...)
+	}, nil
+}
+
+// eventSinkOptions control batching and retries of runEventSink.
+type eventSinkOptions struct {
+	BatchSize     int           // max events per publish call
+	FlushInterval time.Duration // how long to wait for a batch to fill
+	Retries       int           // extra attempts after a failed publish
+	Backoff       time.Duration // initial delay between attempts, doubled each time
+}
+
+// nextBatch blocks for the first event, then collects more until the batch
+// is full or the flush interval has passed. ok is false once ctx is done.
+func (r *RollbackController) nextBatch(ctx context.Context, opts eventSinkOptions) (batch []cloudEvent, ok bool) {
+	select {
+	case <-ctx.Done():
+		return nil, false
+	case ev := <-r.events:
+		batch = append(batch, ev)
+	}
+	flush := r.clock.NewTimer(opts.FlushInterval)
+	defer flush.Stop()
+	for len(batch) < opts.BatchSize {
+		select {
+		case ev := <-r.events:
+			batch = append(batch, ev)
+		case <-flush.C():
+			return batch, true
+		case <-ctx.Done():
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// runEventSink delivers queued events in batches until ctx is done, retrying
+// failed publishes with exponential backoff. Batches that still fail are
+// logged and dropped.
+func (r *RollbackController) runEventSink(ctx context.Context, publish eventPublisher, opts eventSinkOptions) error {
+	for {
+		batch, ok := r.nextBatch(ctx, opts)
+		if !ok {
+			return nil
+		}
+		backoff := opts.Backoff
+		for attempt := 0; ; attempt++ {
+			err := publish(ctx, batch)
+			if err == nil {
+				break
+			}
+			if attempt >= opts.Retries || ctx.Err() != nil {
+				r.log.Error(err, "failed to publish CloudEvents, dropping batch", "events", len(batch), "attempts", attempt+1)
+				break
+			}
+			r.log.V(1).Info("publishing CloudEvents failed, retrying", "error", err.Error(), "retryIn", backoff)
+			select {
+			case <-r.clock.After(backoff):
+			case <-ctx.Done():
+			}
+			backoff *= 2
+		}
+	}
+}
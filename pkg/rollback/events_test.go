@@ -0,0 +1,188 @@
+package rollback
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestHandleResourceEmitsLifecycleEvents(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.events = make(chan cloudEvent, eventQueueSize)
+	r.handleResource("Kustomization", "app", "flux-system", "sha", false, func(string) {})
+	r.handleResource("Kustomization", "app", "flux-system", "sha", false, func(string) {})
+
+	var types []string
+	for len(r.events) > 0 {
+		types = append(types, (<-r.events).Type)
+	}
+	want := []string{cloudEventTypePrefix + "detected", cloudEventTypePrefix + "debounced", cloudEventTypePrefix + "reverted"}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("got events %v, want %v", types, want)
+	}
+}
+
+func TestHTTPEventPublisher(t *testing.T) {
+	var contentTypes []string
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		contentTypes = append(contentTypes, req.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, body)
+	}))
+	defer srv.Close()
+
+	publish, err := newEventPublisher(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := newCloudEvent(time.Now(), auditRecovered, "HelmRelease", "apps", "web", "abc")
+	if err := publish(context.Background(), []cloudEvent{ev}); err != nil {
+		t.Fatal(err)
+	}
+	if err := publish(context.Background(), []cloudEvent{ev, ev}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got cloudEvent
+	if err := json.Unmarshal(bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if contentTypes[0] != "application/cloudevents+json" || got.SpecVersion != "1.0" || got.Type != cloudEventTypePrefix+"recovered" ||
+		got.Subject != "HelmRelease/apps/web" || got.Data.SHA != "abc" {
+		t.Errorf("unexpected event %s %+v", contentTypes[0], got)
+	}
+	var batch []cloudEvent
+	if err := json.Unmarshal(bodies[1], &batch); err != nil || len(batch) != 2 || contentTypes[1] != "application/cloudevents-batch+json" {
+		t.Errorf("unexpected batch %s %s (%v)", contentTypes[1], bodies[1], err)
+	}
+}
+
+// fakeNATS accepts one connection, answers PING with PONG and, if ack is set,
+// replies to PUBs with a reply subject. It returns the PUB lines received.
+func fakeNATS(t *testing.T, ack string) (addr string, pubs <-chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "INFO {}\r\n")
+		rd := bufio.NewReader(conn)
+		var lines []string
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				ch <- lines
+				_, _ = io.WriteString(conn, "PONG\r\n")
+			case "PUB":
+				lines = append(lines, strings.TrimSpace(line))
+				_, _ = rd.ReadString('\n') // payload
+				if len(fields) == 4 {
+					_, _ = io.WriteString(conn, fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", fields[2], len(ack), ack))
+				}
+			}
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+func TestNATSEventPublisher(t *testing.T) {
+	addr, pubs := fakeNATS(t, "")
+	publish, err := newEventPublisher("nats://" + addr + "/rollbacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := newCloudEvent(time.Now(), auditDetected, "Kustomization", "flux-system", "app", "abc")
+	if err := publish(context.Background(), []cloudEvent{ev, ev}); err != nil {
+		t.Fatal(err)
+	}
+	got := <-pubs
+	if len(got) != 2 || !strings.HasPrefix(got[0], "PUB rollbacks ") {
+		t.Errorf("unexpected PUBs %q", got)
+	}
+}
+
+func TestJetStreamEventPublisher(t *testing.T) {
+	addr, pubs := fakeNATS(t, `{"stream":"ROLLBACKS","seq":1}`)
+	publish, err := newEventPublisher("jetstream://" + addr + "/rollbacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := newCloudEvent(time.Now(), auditDetected, "Kustomization", "flux-system", "app", "abc")
+	if err := publish(context.Background(), []cloudEvent{ev, ev}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-pubs; len(got) != 2 || len(strings.Fields(got[0])) != 4 {
+		t.Errorf("JetStream PUBs need a reply subject: %q", got)
+	}
+
+	addr, _ = fakeNATS(t, `{"error":{"code":503,"description":"no responders"}}`)
+	publish, _ = newEventPublisher("jetstream://" + addr + "/rollbacks")
+	if err := publish(context.Background(), []cloudEvent{ev}); err == nil || !strings.Contains(err.Error(), "no responders") {
+		t.Errorf("expected JetStream ack error, got %v", err)
+	}
+}
+
+func TestRunEventSinkBatchesAndRetries(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.events = make(chan cloudEvent, eventQueueSize)
+	for i := 0; i < 3; i++ {
+		r.emitEvent(auditDetected, "Kustomization", "flux-system", "app", "abc")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	var delivered []int
+	publish := func(_ context.Context, events []cloudEvent) error {
+		calls++
+		if calls == 1 {
+			return fmt.Errorf("sink unavailable")
+		}
+		delivered = append(delivered, len(events))
+		if len(delivered) == 2 {
+			cancel()
+		}
+		return nil
+	}
+	opts := eventSinkOptions{BatchSize: 2, FlushInterval: 10 * time.Millisecond, Retries: 2, Backoff: time.Millisecond}
+	if err := r.runEventSink(ctx, publish, opts); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(delivered) != 2 || delivered[0] != 2 || delivered[1] != 1 {
+		t.Errorf("calls = %d, delivered batches = %v", calls, delivered)
+	}
+}
+
+func TestNewEventPublisher(t *testing.T) {
+	for _, sink := range []string{"https://events.example.com", "nats://nats", "jetstream://nats:4222/s", "kafka://b1:9092,b2:9092/topic"} {
+		if _, err := newEventPublisher(sink); err != nil {
+			t.Errorf("%s: %v", sink, err)
+		}
+	}
+	if _, err := newEventPublisher("amqp://broker/queue"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
@@ -0,0 +1,126 @@
+package rollback
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature gates. Experimental capabilities ship behind a gate so they can be
+// enabled per installation without changing the default behavior.
+const (
+	// DependencySuppression leaves the failure of a resource to its
+	// dependsOn dependency when that fails on the same revision.
+	DependencySuppression = "DependencySuppression"
+	// FlaggerCanaries holds due reverts while a Flagger Canary of the
+	// failing resource's workloads has not failed.
+	FlaggerCanaries = "FlaggerCanaries"
+	// FluxEvents records lifecycle events as Kubernetes Events on Flux
+	// resources and requests a Flux reconcile after direct reverts.
+	FluxEvents = "FluxEvents"
+	// ReconcileOnMerge requests a Flux reconcile of the reverted resources
+	// and their GitRepository once a revert MR merges.
+	ReconcileOnMerge = "ReconcileOnMerge"
+	// LegacyFluxAPIs watches v1beta2/v2beta2/v2beta1 Flux APIs on clusters
+	// that don't serve the GA versions.
+	LegacyFluxAPIs = "LegacyFluxAPIs"
+	// FailureFingerprints treats a new failure on a reverted revision the
+	// resource had recovered on as a new incident.
+	FailureFingerprints = "FailureFingerprints"
+	// ResourceAnnotations annotates failing resources with their revert MR.
+	ResourceAnnotations = "ResourceAnnotations"
+	// SourceAggregation reverts per GitRepository revision based on the
+	// aggregate health of all resources built from it.
+	SourceAggregation = "SourceAggregation"
+)
+
+// featureStage describes the maturity of a gate.
+type featureStage string
+
+const (
+	featureAlpha featureStage = "Alpha" // off by default, may change or go away
+	featureBeta  featureStage = "Beta"  // on by default, can be switched off
+)
+
+type featureSpec struct {
+	Default bool
+	Stage   featureStage
+}
+
+// knownFeatures lists all feature gates with their defaults.
+var knownFeatures = map[string]featureSpec{
+	DependencySuppression: {Default: true, Stage: featureBeta},
+	FailureFingerprints:   {Default: false, Stage: featureAlpha},
+	FlaggerCanaries:       {Default: false, Stage: featureAlpha},
+	FluxEvents:            {Default: true, Stage: featureBeta},
+	LegacyFluxAPIs:        {Default: true, Stage: featureBeta},
+	ReconcileOnMerge:      {Default: false, Stage: featureAlpha},
+	ResourceAnnotations:   {Default: true, Stage: featureBeta},
+	SourceAggregation:     {Default: false, Stage: featureAlpha},
+}
+
+// featureGates holds the enabled state of every known gate.
+type featureGates map[string]bool
+
+// defaultFeatureGates returns the gates with their default values.
+func defaultFeatureGates() featureGates {
+	gates := featureGates{}
+	for name, spec := range knownFeatures {
+		gates[name] = spec.Default
+	}
+	return gates
+}
+
+// parseFeatureGates parses "Name=true,Other=false" on top of the defaults.
+// Unknown gates and invalid values are errors.
+func parseFeatureGates(spec string) (featureGates, error) {
+	gates := defaultFeatureGates()
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("feature gate %q: want Name=true|false", item)
+		}
+		name = strings.TrimSpace(name)
+		if _, known := knownFeatures[name]; !known {
+			return nil, fmt.Errorf("unknown feature gate %q (known: %s)", name, strings.Join(knownFeatureNames(), ", "))
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("feature gate %q: %w", name, err)
+		}
+		gates[name] = enabled
+	}
+	return gates, nil
+}
+
+func knownFeatureNames() []string {
+	names := make([]string, 0, len(knownFeatures))
+	for name := range knownFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled reports whether the gate is on. Gates not set explicitly use their
+// default, so a nil featureGates is valid.
+func (g featureGates) Enabled(name string) bool {
+	if enabled, ok := g[name]; ok {
+		return enabled
+	}
+	return knownFeatures[name].Default
+}
+
+// String renders the gates in --feature-gates syntax, sorted by name.
+func (g featureGates) String() string {
+	var parts []string
+	for _, name := range knownFeatureNames() {
+		parts = append(parts, fmt.Sprintf("%s=%t", name, g.Enabled(name)))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,33 @@
+package rollback
+
+import "testing"
+
+func TestParseFeatureGates(t *testing.T) {
+	gates, err := parseFeatureGates("ResourceAnnotations=false, LegacyFluxAPIs=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gates.Enabled(ResourceAnnotations) || !gates.Enabled(LegacyFluxAPIs) {
+		t.Errorf("unexpected gates %s", gates)
+	}
+	if got := gates.String(); got != "DependencySuppression=true,FailureFingerprints=false,FlaggerCanaries=false,FluxEvents=true,LegacyFluxAPIs=true,ReconcileOnMerge=false,ResourceAnnotations=false,SourceAggregation=false" {
+		t.Errorf("String() = %q", got)
+	}
+
+	defaults, err := parseFeatureGates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unset featureGates
+	for name, spec := range knownFeatures {
+		if defaults.Enabled(name) != spec.Default || unset.Enabled(name) != spec.Default {
+			t.Errorf("%s: default not applied", name)
+		}
+	}
+
+	for _, spec := range []string{"Bogus=true", "ResourceAnnotations", "ResourceAnnotations=maybe"} {
+		if _, err := parseFeatureGates(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
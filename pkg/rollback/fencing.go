@@ -0,0 +1,81 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// stateFence is the fencing token of the state store. Leader election alone
+// does not keep a stale leader from writing: a replica that was paused or
+// partitioned past its lease keeps running until it notices, with pending
+// timers that a new leader has already restored and acted on. Each leader
+// therefore claims the next epoch when it restores the state (restoreState),
+// stores never accept state of an older epoch (errStateFenced), and reverts
+// check the stored epoch first (checkFence). Guarded by r.mu.
+type stateFence struct {
+	store  stateStore
+	epoch  int64 // claimed epoch; 0 until the state was restored
+	fenced bool  // a newer leader claimed the store
+}
+
+// stateFenced reports whether a newer leader claimed the state store.
+func (r *RollbackController) stateFenced() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fence != nil && r.fence.fenced
+}
+
+// fenceOff stops this replica from reverting or saving state once a newer
+// leader claimed the store.
+func (r *RollbackController) fenceOff(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fenceOffLocked(err)
+}
+
+// fenceOffLocked is fenceOff for callers holding r.mu.
+func (r *RollbackController) fenceOffLocked(err error) {
+	if r.fence == nil || r.fence.fenced {
+		return
+	}
+	r.fence.fenced = true
+	r.log.Error(err, "A newer leader claimed the state store; no longer reverting or saving state", "epoch", r.fence.epoch)
+}
+
+// checkFence reports whether this replica must not revert sha: a newer
+// leader claimed the state store, or another leader already reverted sha.
+// It loads the store without r.mu; callers must hold r.mu and expect it to be
+// released in between, as around the revert itself. Store errors fail open
+// like the other gates, leaving the store's own check on the next save.
+func (r *RollbackController) checkFence(sha string) (string, bool) {
+	f := r.fence
+	if f == nil {
+		return "", false
+	}
+	if f.fenced {
+		return "a newer leader claimed the state store; this replica is stale", true
+	}
+	epoch := f.epoch
+	r.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	s, err := f.store.Load(ctx)
+	cancel()
+	r.mu.Lock()
+	if err != nil {
+		r.log.Error(err, "failed to check the state store epoch, reverting anyway", "sha", sha)
+		return "", false
+	}
+	if epoch > 0 && s.Epoch > epoch {
+		r.fenceOffLocked(fmt.Errorf("%w: stored epoch %d, claimed epoch %d", errStateFenced, s.Epoch, epoch))
+		return fmt.Sprintf("a newer leader (epoch %d) claimed the state store; this replica (epoch %d) is stale", s.Epoch, epoch), true
+	}
+	if s.Epoch != epoch {
+		for _, done := range s.Completed {
+			if done == sha {
+				return fmt.Sprintf("already reverted by the leader of epoch %d", s.Epoch), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,57 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestStaleLeaderIsFenced(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	store := &memoryStateStore{state: persistedState{Pending: map[string]time.Time{"abc": start.Add(-time.Hour)}}}
+	leader := func() *RollbackController {
+		r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+		r.setClock(clocktesting.NewFakeClock(start))
+		r.fence = &stateFence{store: store}
+		if err := r.restoreState(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// The old leader stalls past its lease; the new one restores the same
+	// pending failure and reverts it.
+	old, current := leader(), leader()
+	if old.fence.epoch != 1 || current.fence.epoch != 2 {
+		t.Fatalf("claimed epochs %d and %d, want 1 and 2", old.fence.epoch, current.fence.epoch)
+	}
+	var reverted []string
+	current.handleResource("Kustomization", "app", "ns", "abc", false, func(sha string) { reverted = append(reverted, "current:"+sha) })
+	if err := store.Save(ctx, current.currentState()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old leader wakes up with the same stale timer.
+	old.handleResource("Kustomization", "app", "ns", "abc", false, func(sha string) { reverted = append(reverted, "old:"+sha) })
+	if !reflect.DeepEqual(reverted, []string{"current:abc"}) {
+		t.Errorf("reverted %v, want only the current leader's revert", reverted)
+	}
+	if n := len(old.auditLog); n == 0 || old.auditLog[n-1].Event != auditFenced {
+		t.Errorf("old leader audit log = %+v", old.auditLog)
+	}
+	if !old.stateFenced() {
+		t.Error("old leader should be fenced off")
+	}
+	if err := store.Save(ctx, old.currentState()); !errors.Is(err, errStateFenced) {
+		t.Errorf("saving the old leader's state: got %v, want errStateFenced", err)
+	}
+	if store.state.Epoch != 2 {
+		t.Errorf("stored epoch %d, want 2", store.state.Epoch)
+	}
+}
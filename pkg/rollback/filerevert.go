@@ -0,0 +1,130 @@
+package rollback
+
+import (
+	"fmt"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// diffsUnder returns the diffs touching a path below one of prefixes.
+func diffsUnder(diffs []gitlabDiff, prefixes []string) []gitlabDiff {
+	var out []gitlabDiff
+	for _, d := range diffs {
+		for _, p := range prefixes {
+			if strings.HasPrefix(d.NewPath, p) || strings.HasPrefix(d.OldPath, p) {
+				out = append(out, d)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// revertActions turns the diffs of a bad commit into commit actions restoring
+// each file to its state in the parent commit. parentContent returns a file's
+// content at the parent.
+func revertActions(diffs []gitlabDiff, parentContent func(path string) (string, error)) ([]gitlabCommitAction, error) {
+	var actions []gitlabCommitAction
+	for _, d := range diffs {
+		switch {
+		case d.NewFile:
+			actions = append(actions, gitlabCommitAction{Action: "delete", FilePath: d.NewPath})
+		case d.DeletedFile:
+			content, err := parentContent(d.OldPath)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, gitlabCommitAction{Action: "create", FilePath: d.OldPath, Content: content})
+		case d.RenamedFile:
+			content, err := parentContent(d.OldPath)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions,
+				gitlabCommitAction{Action: "delete", FilePath: d.NewPath},
+				gitlabCommitAction{Action: "create", FilePath: d.OldPath, Content: content})
+		default:
+			content, err := parentContent(d.OldPath)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, gitlabCommitAction{Action: "update", FilePath: d.NewPath, Content: content})
+		}
+	}
+	return actions, nil
+}
+
+// revertHelmFiles restores only the files below the policy's helmRevertPaths
+// that the bad commit changed, by default via an MR. If the commit touched
+// none of them, it falls back to reverting the whole commit. It returns the
+// MR, if one was opened.
+func (r *RollbackController) revertHelmFiles(gl gitlabProject, hr *helmv2.HelmRelease, policy *RollbackPolicy, revision string) *gitlabMergeRequest {
+	if !gl.isGitLab() {
+		r.log.Error(nil, "RevertFiles is only supported with GitLab", "provider", gl.Provider, "namespace", hr.Namespace, "name", hr.Name)
+		return nil
+	}
+	sha := gitCommitSHA(revision)
+	diffs, err := gl.commitDiff(sha)
+	if err != nil {
+		r.log.Error(err, "failed to get commit diff", "sha", sha)
+		return nil
+	}
+	diffs = diffsUnder(diffs, policy.Spec.HelmRevertPaths)
+	if len(diffs) == 0 {
+		r.log.Info("Bad commit changed no files below helmRevertPaths, reverting whole commit", "namespace", hr.Namespace, "name", hr.Name, "sha", sha)
+		return r.createGitlabRevertMR(gl, resourceRef{Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name}, policy, revision)
+	}
+	strategy := policy.revertStrategy(RevertStrategyMergeRequest)
+	branch := r.revertBranchName(branchNameData{Action: branchActionFiles, Strategy: strategy, Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name, SHA: sha},
+		fmt.Sprintf("%s-%s-%s-%s", r.RevertBranchPrefix, hr.Namespace, hr.Name, sha))
+	if dryRun() {
+		var files []string
+		for _, d := range diffs {
+			r.log.Info("ECHO: would revert file", "path", d.NewPath, "sha", sha, "branch", branch, "strategy", strategy)
+			files = append(files, d.NewPath)
+		}
+		r.recordAction(gl, recordedAction{Action: "revertFiles", Strategy: strategy, Branch: branch, SHA: sha, Namespace: hr.Namespace, Name: hr.Name, Files: files})
+		return nil
+	}
+
+	parent, err := gl.commitParent(sha)
+	if err != nil {
+		r.log.Error(err, "failed to get parent commit", "sha", sha)
+		return nil
+	}
+	actions, err := revertActions(diffs, func(path string) (string, error) {
+		content, err := gl.fileRaw(path, parent)
+		return string(content), err
+	})
+	if err != nil {
+		r.log.Error(err, "failed to read files at parent commit", "parent", parent)
+		return nil
+	}
+	target, err := targetBranch(gl, policy)
+	if err != nil {
+		r.log.Error(err, "failed to get default branch")
+		return nil
+	}
+	title := fmt.Sprintf("Revert files of %s for HelmRelease %s/%s", sha, hr.Namespace, hr.Name)
+	var paths []string
+	for _, a := range actions {
+		paths = append(paths, "- `"+a.FilePath+"`")
+	}
+	mr := gitlabMergeRequestOptions{
+		Title: title,
+		Description: fmt.Sprintf("HelmRelease %s/%s failed after %s. Restoring only these files to %s:\n\n%s",
+			hr.Namespace, hr.Name, sha, parent, strings.Join(paths, "\n")),
+	}
+	if strategy == RevertStrategyMergeRequest {
+		r.prepareMergeRequest(gl, resourceRef{Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name}, policy, sha, &mr)
+	}
+	created, err := deliverChange(gl, strategy, branch, target, mr, commitFiles(gl, withRevertTrailer(title), actions))
+	if err != nil {
+		r.log.Error(err, "failed to deliver file revert", "branch", branch, "strategy", strategy)
+		r.recordRevertFailure(resourceRef{Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name}, revision, err)
+		return nil
+	}
+	r.log.Info("File revert created successfully", "namespace", hr.Namespace, "name", hr.Name, "sha", sha, "files", len(actions), "strategy", strategy, "mr", created.webURL())
+	return created
+}
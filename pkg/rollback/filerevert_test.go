@@ -0,0 +1,55 @@
+package rollback
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffsUnder(t *testing.T) {
+	diffs := []gitlabDiff{
+		{OldPath: "apps/my-app/values.yaml", NewPath: "apps/my-app/values.yaml"},
+		{OldPath: "apps/other/values.yaml", NewPath: "apps/other/values.yaml"},
+		{OldPath: "old/my-app.yaml", NewPath: "apps/my-app/moved.yaml", RenamedFile: true},
+	}
+	got := diffsUnder(diffs, []string{"apps/my-app/"})
+	if len(got) != 2 || got[0].NewPath != "apps/my-app/values.yaml" || got[1].NewPath != "apps/my-app/moved.yaml" {
+		t.Errorf("unexpected diffs: %+v", got)
+	}
+}
+
+func TestRevertActions(t *testing.T) {
+	diffs := []gitlabDiff{
+		{OldPath: "a.yaml", NewPath: "a.yaml"},
+		{OldPath: "b.yaml", NewPath: "b.yaml", NewFile: true},
+		{OldPath: "c.yaml", NewPath: "c.yaml", DeletedFile: true},
+		{OldPath: "d.yaml", NewPath: "e.yaml", RenamedFile: true},
+	}
+	got, err := revertActions(diffs, func(path string) (string, error) { return "old " + path, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gitlabCommitAction{
+		{Action: "update", FilePath: "a.yaml", Content: "old a.yaml"},
+		{Action: "delete", FilePath: "b.yaml"},
+		{Action: "create", FilePath: "c.yaml", Content: "old c.yaml"},
+		{Action: "delete", FilePath: "e.yaml"},
+		{Action: "create", FilePath: "d.yaml", Content: "old d.yaml"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestGitCommitSHA(t *testing.T) {
+	for in, want := range map[string]string{
+		"main@sha1:838d357b": "838d357b",
+		"sha1:838d357b":      "838d357b",
+		"838d357b":           "838d357b",
+		"main/838d357b33a16d7efe4ad42a813fa2134d5da581": "838d357b33a16d7efe4ad42a813fa2134d5da581",
+		"feature/x": "feature/x",
+	} {
+		if got := gitCommitSHA(in); got != want {
+			t.Errorf("gitCommitSHA(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package rollback
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// volatileParts match the parts of condition messages that change between
+// reports of the same failure: revisions, timestamps, durations, counts.
+var volatileParts = regexp.MustCompile(`\b[0-9a-f]{7,64}\b|\d+(\.\d+)?`)
+
+// failureFingerprint identifies a failure by the reason of the failing
+// condition and a hash of its message with the volatile parts masked, e.g.
+// "HealthCheckFailed/3f2a1b9c0d4e", so the same failure reported twice gets
+// the same fingerprint.
+func failureFingerprint(reason, message string) string {
+	normalized := strings.Join(strings.Fields(volatileParts.ReplaceAllString(message, "#")), " ")
+	sum := sha256.Sum256([]byte(reason + "\n" + normalized))
+	return reason + "/" + hex.EncodeToString(sum[:6])
+}
+
+// conditionFingerprint returns the fingerprint of the condition deciding the
+// health of a resource of kind: its READY_CONDITIONS entry, or Healthy with
+// the policy's failureSignal Healthy. It returns "" if the condition is
+// missing.
+func (r *RollbackController) conditionFingerprint(kind string, conditions []metav1.Condition, policy *RollbackPolicy) string {
+	typ := r.readyCondition(kind).Type
+	if kind == "Kustomization" && policy.failureSignal() == FailureSignalHealthy {
+		typ = "Healthy"
+	}
+	c := meta.FindStatusCondition(conditions, typ)
+	if c == nil {
+		return ""
+	}
+	return failureFingerprint(c.Reason, c.Message)
+}
+
+// failureHistory is the failure fingerprint history of a resource.
+type failureHistory struct {
+	Current   string // fingerprint of the current failure; "" while Ready
+	SHA       string // revision Incident was detected on
+	Incident  string // fingerprint the failure on SHA was detected with
+	Recovered bool   // Ready on SHA since then
+}
+
+// observeFingerprint records the fingerprint of the current failure of res.
+func (r *RollbackController) observeFingerprint(res resourceRef, ready bool, fingerprint string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.fingerprints[res.String()]
+	if st == nil {
+		st = &failureHistory{}
+		r.fingerprints[res.String()] = st
+	}
+	if ready {
+		fingerprint = ""
+	}
+	st.Current = fingerprint
+}
+
+// checkFingerprint treats a failure of res on a reverted revision it had
+// recovered on as a new incident if its fingerprint differs from the failure
+// the revision was detected with (FailureFingerprints gate): the revision
+// is no longer completed, so the failure is debounced, escalated and
+// reverted afresh, and a refailed audit entry names both fingerprints. The
+// same failure coming back, or a failure whose message changes before any
+// recovery, still matches the completed revert.
+func (r *RollbackController) checkFingerprint(res resourceRef, sha string, ready bool) {
+	if !r.Features.Enabled(FailureFingerprints) || sha == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.fingerprints[res.String()]
+	switch {
+	case st == nil:
+	case ready:
+		if st.SHA == sha {
+			st.Recovered = true
+		}
+	case st.Current == "":
+	case st.SHA != sha:
+		st.SHA, st.Incident, st.Recovered = sha, st.Current, false
+	case !st.Recovered:
+	case st.Current == st.Incident:
+		st.Recovered = false
+	default:
+		previous := st.Incident
+		st.Incident, st.Recovered = st.Current, false
+		cleared := r.debounce.ClearCompleted(sha)
+		if plain := gitCommitSHA(sha); plain != sha && r.debounce.ClearCompleted(plain) {
+			cleared = true
+		}
+		if cleared {
+			r.log.Info("Reverted revision fails again for a new reason, treating it as a new incident",
+				"kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "fingerprint", st.Current, "previous", previous)
+			r.recordAudit(auditRefailed, res.Kind, res.Namespace, res.Name, sha, fmt.Sprintf("new failure %s, reverted for %s", st.Current, previous))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package rollback
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestFailureFingerprint(t *testing.T) {
+	timeout := failureFingerprint("HealthCheckFailed", "health check failed after 5m0.01s: timeout waiting for: [Deployment/apps/web status: 'InProgress'] at main@sha1:0123456789abcdef")
+	if !strings.HasPrefix(timeout, "HealthCheckFailed/") {
+		t.Errorf("fingerprint %q does not start with the reason", timeout)
+	}
+	if again := failureFingerprint("HealthCheckFailed", "health check failed after 4m59.9s:  timeout waiting for: [Deployment/apps/web status: 'InProgress'] at main@sha1:fedcba9876543210"); again != timeout {
+		t.Errorf("same failure with other durations and revision: %q, want %q", again, timeout)
+	}
+	for _, other := range []string{
+		failureFingerprint("HealthCheckFailed", "health check failed after 5m0s: timeout waiting for: [Deployment/apps/api status: 'InProgress']"),
+		failureFingerprint("ReconciliationFailed", "health check failed after 5m0.01s: timeout waiting for: [Deployment/apps/web status: 'InProgress']"),
+	} {
+		if other == timeout {
+			t.Errorf("different failure got the same fingerprint %q", other)
+		}
+	}
+}
+
+func TestCheckFingerprint(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.Features, _ = parseFeatureGates("FailureFingerprints=true")
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+	const sha = "main@sha1:0123456789abcdef0123456789abcdef01234567"
+	observe := func(ready bool, reason, message string) {
+		t.Helper()
+		r.observeFingerprint(res, ready, failureFingerprint(reason, message))
+		r.checkFingerprint(res, sha, ready)
+	}
+
+	observe(false, "HealthCheckFailed", "timeout after 5m0s waiting for Deployment/apps/web")
+	r.debounce.Complete(gitCommitSHA(sha)) // reverted
+	observe(false, "ReconciliationFailed", "Deployment/apps/web is invalid")
+	if !r.debounce.IsCompleted(gitCommitSHA(sha)) {
+		t.Fatal("a changed failure before any recovery is a new incident")
+	}
+	observe(true, "ReconciliationSucceeded", "Applied revision")
+	observe(false, "HealthCheckFailed", "timeout after 5m2s waiting for Deployment/apps/web")
+	if !r.debounce.IsCompleted(gitCommitSHA(sha)) {
+		t.Fatal("the same failure coming back is a new incident")
+	}
+
+	observe(true, "ReconciliationSucceeded", "Applied revision")
+	observe(false, "ReconciliationFailed", "Deployment/apps/web is invalid")
+	if r.debounce.IsCompleted(gitCommitSHA(sha)) {
+		t.Fatal("a new failure after recovery still matches the completed revert")
+	}
+	e := r.auditLog[len(r.auditLog)-1]
+	if e.Event != auditRefailed || !strings.HasPrefix(e.Message, "new failure ReconciliationFailed/") || !strings.Contains(e.Message, "reverted for HealthCheckFailed/") {
+		t.Errorf("audit entry %+v", e)
+	}
+	if d := r.handleResource(res.Kind, res.Name, res.Namespace, sha, false, func(string) {}); d.Action != DecisionDetected {
+		t.Errorf("decision %s, want a new detection", d.Action)
+	}
+
+	r.Features, _ = parseFeatureGates("")
+	r.debounce.Complete(gitCommitSHA(sha))
+	observe(true, "ReconciliationSucceeded", "Applied revision")
+	observe(false, "DependencyNotReady", "dependency apps/infra is not ready")
+	if !r.debounce.IsCompleted(gitCommitSHA(sha)) {
+		t.Error("FailureFingerprints disabled, but the completed revert was cleared")
+	}
+}
@@ -0,0 +1,138 @@
+package rollback
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// canaryGVK is the Flagger Canary API.
+var canaryGVK = schema.GroupVersionKind{Group: "flagger.app", Version: "v1beta1", Kind: "Canary"}
+
+// canaryFailed is the Canary status.phase Flagger sets when it rolled the
+// canary back.
+const canaryFailed = "Failed"
+
+// canaryPollInterval is how often a revert held by a Canary re-checks its
+// phase.
+const canaryPollInterval = 30 * time.Second
+
+// fluxOwnerLabels returns the labels Flux sets on the objects res applies.
+func fluxOwnerLabels(res resourceRef) map[string]string {
+	group := "kustomize.toolkit.fluxcd.io"
+	if res.Kind == "HelmRelease" {
+		group = "helm.toolkit.fluxcd.io"
+	}
+	return map[string]string{group + "/name": res.Name, group + "/namespace": res.Namespace}
+}
+
+// reader returns the client for reads that are not worth an informer.
+func (r *RollbackController) reader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// canaryPhases returns the status.phase of every Flagger Canary whose target
+// workload was applied by res, keyed by "namespace/name". It returns nothing
+// if Flagger is not installed.
+func (r *RollbackController) canaryPhases(ctx context.Context, res resourceRef) (map[string]string, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(canaryGVK.GroupVersion().WithKind(canaryGVK.Kind + "List"))
+	if err := r.reader().List(ctx, list); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	owner := fluxOwnerLabels(res)
+	phases := make(map[string]string)
+	for _, c := range list.Items {
+		apiVersion, _, _ := unstructured.NestedString(c.Object, "spec", "targetRef", "apiVersion")
+		kind, _, _ := unstructured.NestedString(c.Object, "spec", "targetRef", "kind")
+		name, _, _ := unstructured.NestedString(c.Object, "spec", "targetRef", "name")
+		if apiVersion == "" {
+			apiVersion = "apps/v1"
+		}
+		if kind == "" || name == "" {
+			continue
+		}
+		target := &unstructured.Unstructured{}
+		target.SetAPIVersion(apiVersion)
+		target.SetKind(kind)
+		if err := r.reader().Get(ctx, client.ObjectKey{Namespace: c.GetNamespace(), Name: name}, target); err != nil {
+			if !isGone(err) {
+				r.log.V(1).Info("cannot read Canary target", "canary", c.GetNamespace()+"/"+c.GetName(), "error", err.Error())
+			}
+			continue
+		}
+		labels := target.GetLabels()
+		applied := true
+		for k, v := range owner {
+			if labels[k] != v {
+				applied = false
+			}
+		}
+		if applied {
+			phase, _, _ := unstructured.NestedString(c.Object, "status", "phase")
+			phases[c.GetNamespace()+"/"+c.GetName()] = phase
+		}
+	}
+	return phases, nil
+}
+
+// checkCanaries implements the FlaggerCanaries gate: once the revert of res
+// on sha is due, it holds the revert while a Flagger Canary for a workload
+// res applied has not failed, so Flagger's analysis decides whether the
+// rollout is bad. Resources without a Canary, and Canaries that can't be
+// read, don't hold the revert. It reports whether remediate must wait, and
+// for how long.
+func (r *RollbackController) checkCanaries(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy) (time.Duration, bool) {
+	key := res.String()
+	r.mu.Lock()
+	if ready || sha == "" || !r.Features.Enabled(FlaggerCanaries) {
+		delete(r.canaryHeld, key)
+		r.mu.Unlock()
+		return 0, false
+	}
+	due := r.revertDue(res, sha, policy)
+	r.mu.Unlock()
+	if !due {
+		return 0, false
+	}
+	phases, err := r.canaryPhases(ctx, res)
+	if err != nil {
+		r.log.Error(err, "failed to read Flagger Canaries, reverting as usual", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+		return 0, false
+	}
+	var pending []string
+	failed := false
+	for name, phase := range phases {
+		if phase == canaryFailed {
+			failed = true
+		} else {
+			pending = append(pending, name+" is "+phase)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(phases) == 0 || failed {
+		delete(r.canaryHeld, key)
+		return 0, false
+	}
+	if r.canaryHeld[key] != sha {
+		r.canaryHeld[key] = sha
+		sort.Strings(pending)
+		r.log.Info("Revert due, but Flagger has not failed the canary, holding it", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "canaries", pending)
+		r.recordAudit(auditHeld, res.Kind, res.Namespace, res.Name, sha, "canary "+strings.Join(pending, ", "))
+		r.emitEvent(auditHeld, res.Kind, res.Namespace, res.Name, sha)
+	}
+	return canaryPollInterval, true
+}
@@ -0,0 +1,97 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func canary(name, target, phase string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"targetRef": map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": target}},
+		"status": map[string]interface{}{"phase": phase},
+	}}
+	u.SetGroupVersionKind(canaryGVK)
+	u.SetNamespace("shop")
+	u.SetName(name)
+	return u
+}
+
+func deployment(name string, labels map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("apps/v1")
+	u.SetKind("Deployment")
+	u.SetNamespace("shop")
+	u.SetName(name)
+	u.SetLabels(labels)
+	return u
+}
+
+func TestCheckCanaries(t *testing.T) {
+	web := resourceRef{Kind: "HelmRelease", Namespace: "flux-system", Name: "web"}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(
+		deployment("web", fluxOwnerLabels(web)),
+		deployment("other", fluxOwnerLabels(resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "web"})),
+		canary("web", "web", "Progressing"),
+		canary("other", "other", canaryFailed),
+	).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 60)
+	r.Features = featureGates{FlaggerCanaries: true}
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	ctx := context.Background()
+	reverted := 0
+	remediate := func(res resourceRef) time.Duration {
+		return r.remediate(ctx, res, "main@sha1:bad", false, nil, func(string) { reverted++ }).RequeueAfter
+	}
+
+	remediate(web)
+	clk.SetTime(start.Add(time.Minute))
+	for i := 0; i < 2; i++ {
+		if got := remediate(web); got != canaryPollInterval || reverted != 0 {
+			t.Fatalf("canary progressing: requeue %v, %d reverts; want the revert held", got, reverted)
+		}
+	}
+	if last := r.auditLog[len(r.auditLog)-1]; last.Event != auditHeld || last.Message != "canary shop/web is Progressing" {
+		t.Errorf("unexpected audit entry %+v", last)
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(canaryGVK)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "web"}, u); err != nil {
+		t.Fatal(err)
+	}
+	_ = unstructured.SetNestedField(u.Object, canaryFailed, "status", "phase")
+	if err := c.Update(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if remediate(web); reverted != 1 {
+		t.Errorf("canary failed: %d reverts, want 1", reverted)
+	}
+}
+
+func TestCanaryPhases(t *testing.T) {
+	api := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "api"}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(
+		deployment("api", fluxOwnerLabels(api)),
+		deployment("manual", nil),
+		canary("api", "api", "Succeeded"),
+		canary("manual", "manual", "Progressing"),
+		canary("gone", "missing", "Failed"),
+	).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 60)
+	phases, err := r.canaryPhases(context.Background(), api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) != 1 || phases["shop/api"] != "Succeeded" {
+		t.Errorf("canaryPhases = %v, want only shop/api", phases)
+	}
+}
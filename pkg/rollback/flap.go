@@ -0,0 +1,124 @@
+package rollback
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// defaultFlapWindow is FLAP_WINDOW_SECONDS when unset.
+const defaultFlapWindow = 10 * time.Minute
+
+// Ready flap metrics, served on the controller-runtime metrics endpoint.
+var (
+	readyTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rollback_resource_ready_transitions_total",
+		Help: "Ready condition changes of watched Flux resources, by the new Ready status.",
+	}, []string{"kind", "namespace", "name", "ready"})
+	failureDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rollback_resource_failure_duration_seconds",
+		Help:    "How long Flux resources stayed not Ready before recovering; short failures are flaps.",
+		Buckets: []float64{10, 30, 60, 120, 300, 600, 1800, 3600, 7200},
+	}, []string{"kind"})
+	resourceFlapping = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rollback_resource_flapping",
+		Help: "1 while a Flux resource flaps between Ready and not Ready and its reverts are held (FLAP_THRESHOLD).",
+	}, []string{"kind", "namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(readyTransitions, failureDuration, resourceFlapping)
+}
+
+// flapState is the Ready history of one resource.
+type flapState struct {
+	FailingSince time.Time   // zero while Ready
+	Failures     []time.Time // failures starting within FlapWindow
+	Alerted      bool        // flapping was reported
+}
+
+// observeReady records a change of the Ready status of res from prev (nil if
+// not seen before) to ready; a resource first seen failing counts as a
+// failure too. Callers must hold r.mu.
+func (r *RollbackController) observeReady(res resourceRef, prev *resourceStatus, ready bool) {
+	key := res.String()
+	now := r.clock.Now()
+	st := r.flaps[key]
+	if st == nil {
+		st = &flapState{}
+		r.flaps[key] = st
+	}
+	if prev != nil && prev.Ready == ready || prev == nil && ready {
+		return
+	}
+	if prev != nil {
+		readyTransitions.WithLabelValues(res.Kind, res.Namespace, res.Name, fmt.Sprint(ready)).Inc()
+	}
+	if ready {
+		if !st.FailingSince.IsZero() {
+			failureDuration.WithLabelValues(res.Kind).Observe(now.Sub(st.FailingSince).Seconds())
+		}
+		st.FailingSince = time.Time{}
+		return
+	}
+	st.FailingSince = now
+	if r.FlapThreshold > 0 {
+		st.Failures = append(st.Failures, now)
+	}
+}
+
+// checkFlapping holds the revert of a resource that went from Ready to not
+// Ready FlapThreshold times within FlapWindow: reverting rarely fixes flaky
+// probes. It reports flapping once per episode and returns when the oldest
+// counted failure leaves the window. Flapping ends once fewer failures remain
+// in the window, and the revert goes ahead as usual if the resource is
+// still failing then.
+func (r *RollbackController) checkFlapping(res resourceRef, sha string, ready bool) (time.Duration, bool) {
+	if r.FlapThreshold <= 0 {
+		return 0, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+	key := res.String()
+	st := r.flaps[key]
+	now := r.clock.Now()
+	kept := st.Failures[:0]
+	for _, t := range st.Failures {
+		if now.Sub(t) < r.FlapWindow {
+			kept = append(kept, t)
+		}
+	}
+	st.Failures = kept
+	if len(st.Failures) < r.FlapThreshold {
+		if st.Alerted {
+			r.log.Info("Resource stopped flapping", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+			st.Alerted = false
+			resourceFlapping.DeleteLabelValues(res.Kind, res.Namespace, res.Name)
+		}
+		return 0, false
+	}
+	if ready {
+		return 0, false
+	}
+	if !st.Alerted {
+		st.Alerted = true
+		resourceFlapping.WithLabelValues(res.Kind, res.Namespace, res.Name).Set(1)
+		msg := fmt.Sprintf("failed %d times within %s; revert held", len(st.Failures), r.FlapWindow)
+		r.log.Info("Resource is flapping, holding its revert", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "failures", len(st.Failures), "window", r.FlapWindow)
+		r.recordAudit(auditFlapping, res.Kind, res.Namespace, res.Name, sha, msg)
+		r.emitEvent(auditFlapping, res.Kind, res.Namespace, res.Name, sha)
+	}
+	oldest := st.Failures[len(st.Failures)-r.FlapThreshold]
+	return r.capRequeue(oldest.Add(r.FlapWindow).Sub(now)), true
+}
+
+// forgetFlaps drops the Ready history of a deleted resource. Callers must
+// hold r.mu.
+func (r *RollbackController) forgetFlaps(res resourceRef) {
+	delete(r.flaps, res.String())
+	resourceFlapping.DeleteLabelValues(res.Kind, res.Namespace, res.Name)
+	readyTransitions.DeletePartialMatch(prometheus.Labels{"kind": res.Kind, "namespace": res.Namespace, "name": res.Name})
+}
@@ -0,0 +1,62 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestFlapSuppression(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	clk := clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	r.setClock(clk)
+	r.FlapThreshold = 3
+	r.FlapWindow = 10 * time.Minute
+	res := resourceRef{Kind: "Kustomization", Namespace: "flaky", Name: "probes"}
+	ctx := context.Background()
+	var reverted []string
+	remediate := func(ready bool) Decision {
+		return r.remediate(ctx, res, "main@sha1:abc", ready, nil, func(sha string) { reverted = append(reverted, sha) })
+	}
+	flapping := func() float64 {
+		return testutil.ToFloat64(resourceFlapping.WithLabelValues(res.Kind, res.Namespace, res.Name))
+	}
+
+	// Failing at 0m, 2m and 4m, Ready in between.
+	for i, ready := range []bool{false, true, false, true} {
+		if got := remediate(ready); got.Action == DecisionHeld {
+			t.Fatalf("step %d held early: %+v", i, got)
+		}
+		clk.Step(time.Minute)
+	}
+	if got := remediate(false); got != (Decision{Action: DecisionHeld, RequeueAfter: 6 * time.Minute, Reason: "flapping"}) {
+		t.Errorf("third failure = %+v, want held until the first leaves the window", got)
+	}
+	clk.Step(time.Minute)
+	remediate(false)
+	var alerts int
+	for _, e := range r.auditLog {
+		if e.Event == auditFlapping {
+			alerts++
+		}
+	}
+	if alerts != 1 || flapping() != 1 {
+		t.Errorf("flapping reported %d times, gauge %v; want once and 1", alerts, flapping())
+	}
+
+	// Once the first failure leaves the window, the failure is debounced as usual.
+	clk.Step(6 * time.Minute)
+	if got := remediate(false); got.Action != DecisionDetected || len(reverted) != 0 {
+		t.Errorf("after flapping: %+v, reverted %v", got, reverted)
+	}
+	if flapping() != 0 {
+		t.Errorf("flapping gauge not cleared")
+	}
+	if n := testutil.CollectAndCount(failureDuration); n == 0 {
+		t.Errorf("no failure durations observed")
+	}
+}
@@ -0,0 +1,61 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fluxProviderGVK is the notification-controller Provider a policy
+// notification target can reuse (notifications.targets[].fluxProvider).
+var fluxProviderGVK = schema.GroupVersionKind{Group: "notification.toolkit.fluxcd.io", Version: "v1beta3", Kind: "Provider"}
+
+// fluxProviderTypes are the Provider types incidents can be posted to. All
+// of them accept {"text": ...} on their webhook address; a Slack Provider
+// with a bot token and a channel is threaded like slackChannel instead.
+var fluxProviderTypes = map[string]bool{"slack": true, "msteams": true, "rocket": true}
+
+// fluxProviderNotifier returns a notifier posting to the Flux Provider name
+// in the controller namespace, with the address, channel and token of the
+// Provider and its secretRef, so channels configured for Flux Alerts need no
+// second configuration.
+func (r *RollbackController) fluxProviderNotifier(ctx context.Context, name string, tmpl *template.Template) (incidentNotifier, error) {
+	provider := &unstructured.Unstructured{}
+	provider.SetGroupVersionKind(fluxProviderGVK)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: name}, provider); err != nil {
+		return nil, err
+	}
+	typ, _, _ := unstructured.NestedString(provider.Object, "spec", "type")
+	if !fluxProviderTypes[typ] {
+		return nil, fmt.Errorf("provider %s/%s: unsupported type %q (want slack, msteams or rocket)", r.Namespace, name, typ)
+	}
+	if suspended, _, _ := unstructured.NestedBool(provider.Object, "spec", "suspend"); suspended {
+		return nil, fmt.Errorf("provider %s/%s is suspended", r.Namespace, name)
+	}
+	address, _, _ := unstructured.NestedString(provider.Object, "spec", "address")
+	channel, _, _ := unstructured.NestedString(provider.Object, "spec", "channel")
+	var token string
+	if secretName, _, _ := unstructured.NestedString(provider.Object, "spec", "secretRef", "name"); secretName != "" {
+		var secret corev1.Secret
+		if err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: secretName}, &secret); err != nil {
+			return nil, fmt.Errorf("provider %s/%s: %w", r.Namespace, name, err)
+		}
+		if a := strings.TrimSpace(string(secret.Data["address"])); a != "" {
+			address = a
+		}
+		token = strings.TrimSpace(string(secret.Data["token"]))
+	}
+	if typ == "slack" && token != "" && channel != "" {
+		return &slackNotifier{channel: channel, token: token, tmpl: tmpl}, nil
+	}
+	if address == "" {
+		return nil, fmt.Errorf("provider %s/%s has no address", r.Namespace, name)
+	}
+	return &webhookNotifier{url: address, tmpl: tmpl}, nil
+}
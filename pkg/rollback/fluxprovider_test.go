@@ -0,0 +1,55 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestFluxProviderNotifier(t *testing.T) {
+	provider := func(name string, spec map[string]interface{}) client.Object {
+		p := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		p.SetGroupVersionKind(fluxProviderGVK)
+		p.SetNamespace("flux-system")
+		p.SetName(name)
+		return p
+	}
+	objects := []client.Object{
+		provider("slack-bot", map[string]interface{}{"type": "slack", "channel": "team-a", "secretRef": map[string]interface{}{"name": "slack-bot"}}),
+		provider("slack-hook", map[string]interface{}{"type": "slack", "secretRef": map[string]interface{}{"name": "slack-hook"}}),
+		provider("teams", map[string]interface{}{"type": "msteams", "address": "https://teams.example.com/hook"}),
+		provider("paused", map[string]interface{}{"type": "msteams", "address": "https://teams.example.com/hook", "suspend": true}),
+		provider("github", map[string]interface{}{"type": "github", "address": "https://github.com/org/repo"}),
+		provider("empty", map[string]interface{}{"type": "rocket"}),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "slack-bot"}, Data: map[string][]byte{"token": []byte("xoxb-1\n")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "slack-hook"}, Data: map[string][]byte{"address": []byte("https://hooks.slack.com/services/x")}},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(objects...).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 0)
+	r.Namespace = "flux-system"
+	ctx := context.Background()
+
+	if n, err := r.fluxProviderNotifier(ctx, "slack-bot", nil); err != nil {
+		t.Errorf("slack-bot: %v", err)
+	} else if s, ok := n.(*slackNotifier); !ok || s.channel != "team-a" || s.token != "xoxb-1" {
+		t.Errorf("slack-bot: %#v, want a threaded Slack notifier", n)
+	}
+	for name, want := range map[string]string{"slack-hook": "https://hooks.slack.com/services/x", "teams": "https://teams.example.com/hook"} {
+		if n, err := r.fluxProviderNotifier(ctx, name, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if w, ok := n.(*webhookNotifier); !ok || w.url != want {
+			t.Errorf("%s: %#v, want a webhook to %s", name, n, want)
+		}
+	}
+	for _, name := range []string{"paused", "github", "empty", "missing"} {
+		if n, err := r.fluxProviderNotifier(ctx, name, nil); err == nil {
+			t.Errorf("%s: %#v, want an error", name, n)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileRequestAnnotation is Flux's "reconcile now" annotation, also set
+// by "flux reconcile".
+const reconcileRequestAnnotation = "reconcile.fluxcd.io/requestedAt"
+
+// kubeEvent is how a lifecycle event is shown as a Kubernetes Event.
+type kubeEvent struct {
+	Type    string
+	Reason  string
+	Message string // format with the revision as only argument
+}
+
+// kubeEvents maps lifecycle events to Kubernetes Events. Reasons follow the
+// CamelCase style of the Flux controllers' own events, so they read naturally
+// next to them in Flux UI timelines.
+var kubeEvents = map[string]kubeEvent{
+	auditDetected:           {corev1.EventTypeWarning, "RollbackPending", "Failing on revision %s; a revert is pending"},
+	auditDebounced:          {corev1.EventTypeWarning, "RollbackTriggered", "Still failing on revision %s after the debounce window; reverting"},
+	auditReverted:           {corev1.EventTypeNormal, "RollbackReverted", "Revert of revision %s issued"},
+	auditRecovered:          {corev1.EventTypeNormal, "RollbackCancelled", "Ready again on revision %s; no revert needed"},
+	auditSkipped:            {corev1.EventTypeWarning, "RollbackSkipped", "Still failing on revision %s, but the commit opted out of automated reverts"},
+	auditHeld:               {corev1.EventTypeWarning, "RollbackHeld", "Still failing on revision %s, but the revert is held until user impact is confirmed"},
+	auditNotified:           {corev1.EventTypeWarning, "RollbackEscalated", "Still failing on revision %s"},
+	auditSuspended:          {corev1.EventTypeWarning, "RollbackSuspended", "Suspended while failing on revision %s"},
+	auditAutoMerged:         {corev1.EventTypeNormal, "RollbackAutoMerged", "Revert MR for revision %s set to merge"},
+	auditMerged:             {corev1.EventTypeNormal, "RollbackMerged", "Revert MR for revision %s merged"},
+	auditHelmRolledBack:     {corev1.EventTypeNormal, "RollbackHelmRollback", "Helm rollback requested while failing on revision %s"},
+	auditWebhookCalled:      {corev1.EventTypeNormal, "RollbackWebhookCalled", "Remediation webhook called for revision %s"},
+	auditJobStarted:         {corev1.EventTypeNormal, "RollbackJobStarted", "Remediation Job started for revision %s"},
+	auditStale:              {corev1.EventTypeWarning, "RollbackMRStale", "Still failing on revision %s; the revert MR is still open"},
+	auditFlapping:           {corev1.EventTypeWarning, "RollbackFlapping", "Flapping on revision %s; the revert is held"},
+	auditFenced:             {corev1.EventTypeWarning, "RollbackFenced", "Still failing on revision %s, but another leader owns the revert"},
+	auditBudgetExhausted:    {corev1.EventTypeWarning, "RollbackBudgetExhausted", "Still failing on revision %s, but the failure domain spent its revert budget"},
+	auditAlreadyRequested:   {corev1.EventTypeNormal, "RollbackAlreadyRequested", "Revert of revision %s is already requested by another controller instance"},
+	auditDeadLettered:       {corev1.EventTypeWarning, "RollbackDeadLettered", "Revert of revision %s failed on every retry; it waits in the dead-letter queue"},
+	auditVerified:           {corev1.EventTypeNormal, "RollbackVerified", "Verification of the revert of revision %s succeeded"},
+	auditVerificationFailed: {corev1.EventTypeWarning, "RollbackVerificationFailed", "Verification of the revert of revision %s failed"},
+}
+
+// recordKubeEvent records a lifecycle event as a Kubernetes Event on the
+// Flux object, where Weave GitOps, the Headlamp Flux plugin and "flux events"
+// pick it up. Like Flux, it annotates the Event with "<group>/revision". It
+// never blocks, so it is safe to call with r.mu held.
+func (r *RollbackController) recordKubeEvent(event, kind, namespace, name, sha string) {
+	if e, ok := kubeEvents[event]; ok {
+		r.kubeEventf(kind, namespace, name, sha, e.Type, e.Reason, e.Message, sha)
+	}
+}
+
+// kubeEventf records an Event with its own reason and message on the Flux
+// object, annotated like recordKubeEvent.
+func (r *RollbackController) kubeEventf(kind, namespace, name, sha, eventtype, reason, format string, args ...interface{}) {
+	if r.kubeEvents == nil {
+		return
+	}
+	gvk := r.APIs.gvkFor(kind)
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	annotations := map[string]string{gvk.Group + "/revision": sha}
+	if r.Instance != "" {
+		annotations[instanceAnnotation] = r.Instance
+	}
+	r.kubeEvents.AnnotatedEventf(obj, annotations, eventtype, reason, format, args...)
+}
+
+// reconcileMergedRevert requests a Flux reconcile of the resources reverted
+// by a revert MR that just merged, so Flux applies the revert right away
+// instead of after its sync interval (ReconcileOnMerge gate).
+func (r *RollbackController) reconcileMergedRevert(ctx context.Context, open openRevertMR) {
+	if !r.Features.Enabled(ReconcileOnMerge) {
+		return
+	}
+	var resources []resourceRef
+	for _, rec := range open.Reverts {
+		resources = append(resources, resourceRef{Kind: rec.Kind, Namespace: rec.Namespace, Name: rec.Name})
+	}
+	r.log.Info("Revert MR merged, requesting Flux reconcile", "mr", open.Reverts[0].URL, "resources", len(resources))
+	r.requestFluxReconcile(ctx, resources...)
+}
+
+// requestFluxReconcile sets reconcileRequestAnnotation on the resources and
+// their GitRepositories, like "flux reconcile --with-source", so a revert
+// pushed to the target branch is applied without waiting for the next
+// interval. Sources shared by several resources are annotated once.
+func (r *RollbackController) requestFluxReconcile(ctx context.Context, resources ...resourceRef) {
+	if dryRun() {
+		for _, res := range resources {
+			r.log.Info("ECHO: would request reconcile", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+		}
+		return
+	}
+	var sources, targets []resourceRef
+	seen := make(map[resourceRef]bool)
+	for _, res := range resources {
+		if src, ok := r.gitSourceOf(ctx, res); ok {
+			if ref := (resourceRef{Kind: "GitRepository", Namespace: src.Namespace, Name: src.Name}); !seen[ref] {
+				seen[ref] = true
+				sources = append(sources, ref)
+			}
+		}
+		if !seen[res] {
+			seen[res] = true
+			targets = append(targets, res)
+		}
+	}
+	targets = append(sources, targets...)
+	token := r.clock.Now().Format(time.RFC3339Nano)
+	for _, t := range targets {
+		if err := r.patchReconcileRequest(ctx, t, token); err != nil {
+			r.log.Error(err, "failed to request reconcile", "kind", t.Kind, "namespace", t.Namespace, "name", t.Name)
+		}
+	}
+}
+
+// patchReconcileRequest sets reconcileRequestAnnotation on res to token,
+// which Flux reports back as status.lastHandledReconcileAt once handled.
+func (r *RollbackController) patchReconcileRequest(ctx context.Context, res resourceRef, token string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{reconcileRequestAnnotation: token},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.APIs.gvkFor(res.Kind))
+	obj.SetNamespace(res.Namespace)
+	obj.SetName(res.Name)
+	return r.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch))
+}
+
+// gitSourceOf returns the GitRepository a Kustomization or HelmRelease is
+// built from. A GitRepository is its own source and handled by the caller.
+func (r *RollbackController) gitSourceOf(ctx context.Context, res resourceRef) (types.NamespacedName, bool) {
+	key := client.ObjectKey{Namespace: res.Namespace, Name: res.Name}
+	switch res.Kind {
+	case "Kustomization":
+		var ks kustomizev1.Kustomization
+		if err := r.getConverted(ctx, res.Kind, key, &ks); err == nil {
+			return kustomizationGitSource(&ks)
+		}
+	case "HelmRelease":
+		var hr helmv2.HelmRelease
+		if err := r.getConverted(ctx, res.Kind, key, &hr); err == nil {
+			return helmReleaseGitSource(&hr)
+		}
+	}
+	return types.NamespacedName{}, false
+}
+
+// reconcileAfterRevert wraps revert to request a Flux reconcile once the
+// revert has landed on the target branch, i.e. for the Direct strategy.
+// Batched commit reverts land later and are left to Flux's interval.
+func (r *RollbackController) reconcileAfterRevert(ctx context.Context, res resourceRef, policy *RollbackPolicy, revert func(sha string)) func(sha string) {
+	batched := r.RevertBatchWindow > 0 && !r.criticalResource(res) && (res.Kind != "HelmRelease" || policy.helmRemediation() == HelmRemediationRevert)
+	if !r.Features.Enabled(FluxEvents) || policy.revertStrategy("") != RevertStrategyDirect || batched {
+		return revert
+	}
+	return func(sha string) {
+		revert(sha)
+		r.requestFluxReconcile(ctx, res)
+	}
+}
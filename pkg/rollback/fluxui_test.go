@@ -0,0 +1,133 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecordKubeEvent(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	rec := record.NewFakeRecorder(10)
+	rec.IncludeObject = true
+	r.kubeEvents = rec
+
+	r.emitEvent(auditReverted, "Kustomization", "flux-system", "apps", "main@sha1:abc")
+	r.emitEvent("unknown", "Kustomization", "flux-system", "apps", "main@sha1:abc")
+	want := "Normal RollbackReverted Revert of revision main@sha1:abc issued" +
+		" involvedObject{kind=Kustomization,apiVersion=kustomize.toolkit.fluxcd.io/v1}" +
+		" map[kustomize.toolkit.fluxcd.io/revision:main@sha1:abc]"
+	if got := <-rec.Events; got != want {
+		t.Errorf("event %q, want %q", got, want)
+	}
+	if len(rec.Events) != 0 {
+		t.Error("unknown lifecycle events must not be recorded")
+	}
+	for event := range kubeEvents {
+		if !strings.HasPrefix(kubeEvents[event].Reason, "Rollback") {
+			t.Errorf("%s: reason %q is not namespaced", event, kubeEvents[event].Reason)
+		}
+	}
+}
+
+func TestReconcileAfterDirectRevert(t *testing.T) {
+	ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"}}
+	ks.Spec.SourceRef = kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "fleet", Namespace: "flux-system"}
+	repo := &unstructured.Unstructured{}
+	repo.SetGroupVersionKind(gaFluxAPIs.Source.WithKind("GitRepository"))
+	repo.SetNamespace("flux-system")
+	repo.SetName("fleet")
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(ks, repo).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.Features = defaultFeatureGates()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	r.setClock(clocktesting.NewFakeClock(now))
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+
+	var reverted int
+	revert := func(string) { reverted++ }
+	direct := &RollbackPolicy{Spec: RollbackPolicySpec{RevertStrategy: RevertStrategyDirect}}
+	r.reconcileAfterRevert(ctx, res, &RollbackPolicy{}, revert)("abc")
+	if requestedAt(t, c, ks) != "" || requestedAt(t, c, repo) != "" {
+		t.Fatal("reconcile requested for a revert that is not on the target branch yet")
+	}
+	r.reconcileAfterRevert(ctx, res, direct, revert)("abc")
+	stamp := now.Format(time.RFC3339Nano)
+	if reverted != 2 || requestedAt(t, c, ks) != stamp || requestedAt(t, c, repo) != stamp {
+		t.Errorf("reverted %d, requestedAt ks=%q repo=%q", reverted, requestedAt(t, c, ks), requestedAt(t, c, repo))
+	}
+}
+
+func TestReconcileOnMerge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"iid":7,"state":"merged"}`)
+	}))
+	defer srv.Close()
+	web := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"}}
+	web.Spec.SourceRef = kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "fleet", Namespace: "flux-system"}
+	api := web.DeepCopy()
+	api.Name = "api"
+	repo := &unstructured.Unstructured{}
+	repo.SetGroupVersionKind(gaFluxAPIs.Source.WithKind("GitRepository"))
+	repo.SetNamespace("flux-system")
+	repo.SetName("fleet")
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(web, api, repo).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "42", srv.URL, "revert", 300)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	r.setClock(clocktesting.NewFakeClock(now))
+	r.MRWatchOnly = true
+	// A batch revert MR of both Kustomizations.
+	r.reverts = []revertRecord{
+		{Kind: "Kustomization", Namespace: "apps", Name: "web", SHA: "a", URL: srv.URL + "/mr/7", MRIID: 7},
+		{Kind: "Kustomization", Namespace: "apps", Name: "api", SHA: "b", URL: srv.URL + "/mr/7", MRIID: 7},
+	}
+	ctx := context.Background()
+
+	r.Features = featureGates{ReconcileOnMerge: false}
+	for _, mr := range r.openRevertMRs() {
+		r.maintainRevertMR(ctx, mr)
+	}
+	if requestedAt(t, c, web) != "" || requestedAt(t, c, repo) != "" {
+		t.Fatal("reconcile requested without ReconcileOnMerge")
+	}
+
+	r.Features = featureGates{ReconcileOnMerge: true}
+	r.mrSettled = map[string]bool{}
+	for _, mr := range r.openRevertMRs() {
+		r.maintainRevertMR(ctx, mr)
+	}
+	stamp := now.Format(time.RFC3339Nano)
+	if requestedAt(t, c, web) != stamp || requestedAt(t, c, api) != stamp || requestedAt(t, c, repo) != stamp {
+		t.Errorf("requestedAt web=%q api=%q repo=%q", requestedAt(t, c, web), requestedAt(t, c, api), requestedAt(t, c, repo))
+	}
+	if len(r.openRevertMRs()) != 0 {
+		t.Error("merged MR should no longer be checked")
+	}
+}
+
+func requestedAt(t *testing.T, c client.Client, obj client.Object) string {
+	t.Helper()
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	if u.GetKind() == "" {
+		u.SetGroupVersionKind(gaFluxAPIs.Kustomization)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(obj), u); err != nil {
+		t.Fatal(err)
+	}
+	return u.GetAnnotations()[reconcileRequestAnnotation]
+}
@@ -0,0 +1,221 @@
+package rollback
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Values for routeRule.Provider and gitlabProject.Provider.
+const (
+	providerGitLab = "gitlab" // default
+	providerGerrit = "gerrit"
+)
+
+// gerritXSSIPrefix precedes every JSON response of the Gerrit REST API.
+const gerritXSSIPrefix = ")]}'"
+
+// gerritChange is the subset of Gerrit's ChangeInfo the controller uses.
+type gerritChange struct {
+	ID      string `json:"id"`
+	Number  int    `json:"_number"`
+	Project string `json:"project"`
+	Branch  string `json:"branch"`
+	Subject string `json:"subject"`
+}
+
+// gerrit returns the Gerrit view of a routed project: BaseURL is the Gerrit
+// server, ProjectID the Gerrit project name and Username/Token the HTTP
+// credentials.
+func (g gitlabProject) gerrit() gerritProject {
+	return gerritProject{BaseURL: strings.TrimSuffix(g.BaseURL, "/"), Project: g.ProjectID, Username: g.Username, Password: g.Token, Proxy: g.Proxy, api: g.api}
+}
+
+// gerritProject talks to the authenticated Gerrit REST API (/a/...).
+type gerritProject struct {
+	BaseURL  string
+	Project  string
+	Username string
+	Password string
+	Proxy    string // egress proxy URL; empty uses HTTP(S)_PROXY
+
+	api *providerAPI // of the controller; nil uses defaultProviderAPI
+}
+
+// healthKey identifies the project in its health tracker.
+func (g gerritProject) healthKey() string {
+	return g.BaseURL + "/a/projects/" + url.PathEscape(g.Project)
+}
+
+// request sends a request to the Gerrit REST API. body, if non-nil, is sent
+// as JSON; the response, minus the XSSI prefix, is decoded into out.
+func (g gerritProject) request(method, path string, body, out interface{}) error {
+	credential := credentialID(g.Username + ":" + g.Password)
+	health := g.api.healthTracker()
+	if err := health.check(g.healthKey(), credential); err != nil {
+		return err
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(data)
+	}
+	u := g.BaseURL + "/a" + path
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(g.Username, g.Password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	httpClient, err := g.api.httpClient(g.Proxy)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	health.observe(g.healthKey(), credential, method, u, false, resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newProviderError("Gerrit API", method, u, resp, g.api.orDefault().opts.ErrorBodyBytes)
+	}
+	if out == nil {
+		return nil
+	}
+	rd := bufio.NewReader(resp.Body)
+	if prefix, err := rd.Peek(len(gerritXSSIPrefix)); err == nil && string(prefix) == gerritXSSIPrefix {
+		_, _ = rd.ReadString('\n')
+	}
+	return json.NewDecoder(rd).Decode(out)
+}
+
+// changeForCommit returns the merged change that introduced sha.
+func (g gerritProject) changeForCommit(sha string) (*gerritChange, error) {
+	q := url.QueryEscape("commit:" + sha + " project:" + g.Project)
+	var changes []gerritChange
+	if err := g.request("GET", "/changes/?n=1&q="+q, nil, &changes); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no Gerrit change for commit %s in project %s", sha, g.Project)
+	}
+	return &changes[0], nil
+}
+
+// revertChange creates a change reverting the change with the given number.
+func (g gerritProject) revertChange(number int, message string) (*gerritChange, error) {
+	var change gerritChange
+	err := g.request("POST", "/changes/"+strconv.Itoa(number)+"/revert", map[string]string{"message": message}, &change)
+	return &change, err
+}
+
+// addReviewer adds a user or group as reviewer of a change.
+func (g gerritProject) addReviewer(number int, reviewer string) error {
+	return g.request("POST", "/changes/"+strconv.Itoa(number)+"/reviewers", map[string]string{"reviewer": reviewer}, nil)
+}
+
+// setHashtags adds hashtags, Gerrit's equivalent of MR labels, to a change.
+func (g gerritProject) setHashtags(number int, hashtags []string) error {
+	return g.request("POST", "/changes/"+strconv.Itoa(number)+"/hashtags", map[string][]string{"add": hashtags}, nil)
+}
+
+// setWorkInProgress marks a change as work in progress.
+func (g gerritProject) setWorkInProgress(number int) error {
+	return g.request("POST", "/changes/"+strconv.Itoa(number)+"/wip", struct{}{}, nil)
+}
+
+// vote applies label votes, e.g. Code-Review +1, to the current revision.
+func (g gerritProject) vote(number int, labels map[string]int) error {
+	return g.request("POST", "/changes/"+strconv.Itoa(number)+"/revisions/current/review", map[string]interface{}{"labels": labels}, nil)
+}
+
+// submit submits a change; Gerrit rejects it until its submit requirements
+// are met.
+func (g gerritProject) submit(number int) error {
+	return g.request("POST", "/changes/"+strconv.Itoa(number)+"/submit", struct{}{}, nil)
+}
+
+// commitMessage returns the message of sha via the change that introduced it.
+func (g gerritProject) commitMessage(sha string) (string, error) {
+	change, err := g.changeForCommit(sha)
+	if err != nil {
+		return "", err
+	}
+	var commit struct {
+		Message string `json:"message"`
+	}
+	err = g.request("GET", fmt.Sprintf("/changes/%d/revisions/%s/commit", change.Number, url.PathEscape(sha)), nil, &commit)
+	return commit.Message, err
+}
+
+// changeURL returns the web link of a change.
+func (g gerritProject) changeURL(number int) string {
+	return fmt.Sprintf("%s/c/%s/+/%d", g.BaseURL, g.Project, number)
+}
+
+// createGerritRevert opens a revert change for the change that introduced
+// badSHA and decorates it with the policy's reviewers, hashtags (labels) and
+// label votes. Gerrit reviews every change, so revertStrategy does not apply.
+// The change is returned as a gitlabMergeRequest (number and link) so MR
+// tracking and AutoMerge work unchanged.
+func (r *RollbackController) createGerritRevert(gl gitlabProject, res resourceRef, policy *RollbackPolicy, badSHA string) *gitlabMergeRequest {
+	sha := gitCommitSHA(badSHA)
+	g := gl.gerrit()
+	if dryRun() {
+		r.log.Info("ECHO: would create Gerrit revert change", "url", g.BaseURL, "project", g.Project, "sha", sha)
+		r.recordAction(gl, recordedAction{Action: "revert", Strategy: "GerritChange", SHA: sha, Namespace: res.Namespace, Name: res.Name})
+		return nil
+	}
+	if s := policy.revertStrategy(""); s != "" && s != RevertStrategyMergeRequest {
+		r.log.Info("Gerrit always reviews reverts, ignoring revertStrategy", "strategy", s)
+	}
+	bad, err := g.changeForCommit(sha)
+	if err != nil {
+		r.log.Error(err, "failed to find Gerrit change", "sha", sha)
+		return nil
+	}
+	change, err := g.revertChange(bad.Number, r.revertMessage(res, sha, bad.Subject, r.resourceLinks(res, sha)))
+	if err != nil {
+		r.log.Error(err, "failed to create Gerrit revert change", "sha", sha, "change", bad.Number)
+		r.recordRevertFailure(res, badSHA, err)
+		return nil
+	}
+	r.log.Info("Gerrit revert change created", "sha", sha, "change", change.Number, "url", g.changeURL(change.Number))
+	if policy.draftMergeRequests() {
+		if err := g.setWorkInProgress(change.Number); err != nil {
+			r.log.Error(err, "failed to mark Gerrit change as work in progress", "change", change.Number)
+		}
+	}
+	if policy != nil && policy.Spec.MergeRequest != nil {
+		spec := policy.Spec.MergeRequest
+		for _, reviewer := range spec.Reviewers {
+			if err := g.addReviewer(change.Number, reviewer); err != nil {
+				r.log.Error(err, "failed to add Gerrit reviewer", "reviewer", reviewer)
+			}
+		}
+		if len(spec.Labels) > 0 {
+			if err := g.setHashtags(change.Number, spec.Labels); err != nil {
+				r.log.Error(err, "failed to set Gerrit hashtags", "hashtags", spec.Labels)
+			}
+		}
+		if len(spec.GerritLabels) > 0 {
+			if err := g.vote(change.Number, spec.GerritLabels); err != nil {
+				r.log.Error(err, "failed to vote on Gerrit change", "labels", spec.GerritLabels)
+			}
+		}
+	}
+	return &gitlabMergeRequest{IID: change.Number, WebURL: g.changeURL(change.Number)}
+}
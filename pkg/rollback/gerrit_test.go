@@ -0,0 +1,111 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeGerrit serves the change endpoints used by createGerritRevert and
+// records "METHOD path body" of every request.
+func fakeGerrit(t *testing.T) (string, *[]string) {
+	t.Helper()
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "rollback-bot" || pass != "http-pass" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		calls = append(calls, req.Method+" "+req.URL.RequestURI()+" "+string(body))
+		fmt.Fprintln(w, gerritXSSIPrefix)
+		switch req.URL.Path {
+		case "/a/changes/":
+			fmt.Fprint(w, `[{"id":"fleet~main~I1","_number":5,"project":"fleet","branch":"main"}]`)
+		case "/a/changes/5/revert":
+			fmt.Fprint(w, `{"id":"fleet~main~I2","_number":6,"project":"fleet","branch":"main"}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &calls
+}
+
+func TestCreateGerritRevert(t *testing.T) {
+	url, calls := fakeGerrit(t)
+	gl := gitlabProject{BaseURL: url, ProjectID: "fleet", Provider: providerGerrit, Username: "rollback-bot", Token: "http-pass"}
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{MergeRequest: &MergeRequestSpec{
+		Reviewers:    []string{"oncall"},
+		Labels:       []string{"auto-revert"},
+		GerritLabels: map[string]int{"Code-Review": 1},
+	}}}
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+
+	mr := r.createGerritRevert(gl, res, policy, "main@sha1:abc")
+	if mr == nil || mr.IID != 6 || mr.WebURL != url+"/c/fleet/+/6" {
+		t.Fatalf("unexpected change %+v", mr)
+	}
+	var revert struct{ Message string }
+	if err := json.Unmarshal([]byte((*calls)[1][len("POST /a/changes/5/revert "):]), &revert); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Revert abc\n\nThis reverts commit abc.\n\n"; revert.Message[:len(want)] != want {
+		t.Errorf("revert message %q", revert.Message)
+	}
+	want := []string{
+		"GET /a/changes/?n=1&q=commit%3Aabc+project%3Afleet ",
+		(*calls)[1],
+		`POST /a/changes/6/reviewers {"reviewer":"oncall"}`,
+		`POST /a/changes/6/hashtags {"add":["auto-revert"]}`,
+		`POST /a/changes/6/revisions/current/review {"labels":{"Code-Review":1}}`,
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls\n%q\nwant\n%q", *calls, want)
+	}
+}
+
+func TestGerritRouting(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "routes"},
+		Data: map[string]string{routingConfigKey: `
+rules:
+- match: {namespace: legacy}
+  provider: gerrit
+  url: https://review.corp
+  projectID: fleet
+  username: rollback-bot
+  tokenSecret: gerrit-http
+- match: {namespace: broken}
+  provider: svn
+`},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "gerrit-http"},
+		Data:       map[string][]byte{"token": []byte("http-pass")},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(cm, secret).Build()
+	r := NewRollbackController(c, logr.Discard(), "gl-token", "1", "https://gitlab", "revert", 300)
+	r.Namespace = "flux-system"
+	r.RoutingConfigMap = "routes"
+	ctx := context.Background()
+
+	want := gitlabProject{BaseURL: "https://review.corp", ProjectID: "fleet", Token: "http-pass", Provider: providerGerrit, Username: "rollback-bot"}
+	if got := r.project(ctx, "Kustomization", "legacy", "web"); got != want {
+		t.Errorf("project = %+v, want %+v", got, want)
+	}
+	if got := r.project(ctx, "Kustomization", "broken", "web"); got != r.defaultProject() {
+		t.Errorf("unknown provider must fall back to the default project, got %+v", got)
+	}
+}
@@ -0,0 +1,397 @@
+package rollback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gitlabProject identifies a GitLab project and the credentials to use for it.
+// Routing rules pick one per resource; RollbackController.defaultProject
+// returns the one from the environment.
+type gitlabProject struct {
+	BaseURL   string
+	ProjectID string
+	Token     string
+	// Provider is "" or "gitlab", "gerrit" to send reverts to the Gerrit
+	// server at BaseURL (see gerrit()), "codecommit" for an AWS CodeCommit
+	// repository (see codeCommit()) or "ssh" to push reverts to the Git
+	// remote at BaseURL (see pushRevert()).
+	Provider string
+	Username string // Gerrit HTTP user
+	Region   string // CodeCommit AWS region; empty uses AWS_REGION
+	// SSHKey and KnownHosts authenticate pushes to "ssh" remotes (BaseURL).
+	SSHKey     string
+	KnownHosts string
+	// Proxy is the egress proxy URL, with credentials, for the provider;
+	// empty uses HTTP(S)_PROXY and NO_PROXY.
+	Proxy string
+
+	// api and instance are the controller's (see RollbackController.bind).
+	api      *providerAPI // nil uses defaultProviderAPI
+	instance string       // stamped on MR descriptions (stampInstance)
+}
+
+// isGitLab reports whether the project is hosted on GitLab.
+func (g gitlabProject) isGitLab() bool {
+	return g.Provider == "" || g.Provider == providerGitLab
+}
+
+// url returns the project-scoped API URL <base>/api/v4/projects/<id><path>.
+func (g gitlabProject) url(path string) string {
+	return fmt.Sprintf("%s/api/v4/projects/%s%s", g.BaseURL, g.ProjectID, path)
+}
+
+// healthKey identifies the project in its health tracker.
+func (g gitlabProject) healthKey() string {
+	if g.Provider == providerGerrit {
+		return g.gerrit().healthKey()
+	}
+	return g.url("")
+}
+
+// credentialID identifies the project's token in its health tracker.
+func (g gitlabProject) credentialID() string {
+	if g.Provider == providerGerrit {
+		return credentialID(g.Username + ":" + g.Token)
+	}
+	return credentialID(g.Token)
+}
+
+// request sends a request to the project-scoped GitLab API. body, if non-nil,
+// is sent as JSON. The response is decoded as JSON into out, or copied
+// verbatim if out is a *[]byte. Non-2xx responses are returned as errors.
+func (g gitlabProject) request(method, path string, body, out interface{}) error {
+	return g.requestURLContext(context.Background(), method, g.url(path), body, out)
+}
+
+// requestContext is request, cancelled with ctx.
+func (g gitlabProject) requestContext(ctx context.Context, method, path string, body, out interface{}) error {
+	return g.requestURLContext(ctx, method, g.url(path), body, out)
+}
+
+// requestURL is request for an absolute API URL.
+func (g gitlabProject) requestURL(method, u string, body, out interface{}) error {
+	return g.requestURLContext(context.Background(), method, u, body, out)
+}
+
+// requestURLContext is requestURL, cancelled with ctx. Requests to a project
+// degraded in the health tracker fail without being sent.
+func (g gitlabProject) requestURLContext(ctx context.Context, method, u string, body, out interface{}) error {
+	credential := g.credentialID()
+	health := g.api.healthTracker()
+	if err := health.check(g.url(""), credential); err != nil {
+		return err
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", g.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient, err := g.api.httpClient(g.Proxy)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	health.observe(g.url(""), credential, method, u, u == g.url(""), resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newProviderError("GitLab API", method, u, resp, g.api.orDefault().opts.ErrorBodyBytes)
+	}
+	switch o := out.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*o, err = io.ReadAll(resp.Body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+// defaultBranch returns the default branch of the project.
+func (g gitlabProject) defaultBranch() (string, error) {
+	var project struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := g.request("GET", "", nil, &project); err != nil {
+		return "", err
+	}
+	return project.DefaultBranch, nil
+}
+
+// fileRaw returns the content of a file at ref.
+func (g gitlabProject) fileRaw(path, ref string) ([]byte, error) {
+	var data []byte
+	err := g.request("GET", fmt.Sprintf("/repository/files/%s/raw?ref=%s",
+		url.PathEscape(path), url.QueryEscape(ref)), nil, &data)
+	return data, err
+}
+
+// gitlabDiff is one file entry of GET /repository/commits/:sha/diff.
+type gitlabDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+	Diff        string `json:"diff"` // unified diff of the file
+}
+
+// commitDiff returns the files changed by a commit.
+func (g gitlabProject) commitDiff(sha string) ([]gitlabDiff, error) {
+	return listPages[gitlabDiff](g, fmt.Sprintf("/repository/commits/%s/diff", url.PathEscape(sha)), g.api.orDefault().discovery)
+}
+
+// commitParent returns the first parent of a commit.
+func (g gitlabProject) commitParent(sha string) (string, error) {
+	var commit struct {
+		ParentIDs []string `json:"parent_ids"`
+	}
+	if err := g.request("GET", "/repository/commits/"+url.PathEscape(sha), nil, &commit); err != nil {
+		return "", err
+	}
+	if len(commit.ParentIDs) == 0 {
+		return "", fmt.Errorf("commit %s has no parent", sha)
+	}
+	return commit.ParentIDs[0], nil
+}
+
+// gitlabCommitAction is one file change of a POST /repository/commits call.
+type gitlabCommitAction struct {
+	Action   string `json:"action"`
+	FilePath string `json:"file_path"`
+	Content  string `json:"content,omitempty"`
+}
+
+// commitActions creates a single commit applying actions on branch. If
+// startBranch is set, branch is created from it; otherwise branch must exist.
+func (g gitlabProject) commitActions(branch, startBranch, message string, actions []gitlabCommitAction) error {
+	body := map[string]interface{}{
+		"branch":         branch,
+		"commit_message": message,
+		"actions":        actions,
+	}
+	if startBranch != "" {
+		body["start_branch"] = startBranch
+	}
+	return g.request("POST", "/repository/commits", body, nil)
+}
+
+// createBranch creates branch from ref.
+func (g gitlabProject) createBranch(branch, ref string) error {
+	return g.request("POST", "/repository/branches", map[string]string{"branch": branch, "ref": ref}, nil)
+}
+
+// deleteBranch deletes branch. It reports false if the branch was already
+// gone, e.g. removed by GitLab with its merged MR. The branch is looked up
+// first: a 404 on DELETE would count against the project's health.
+func (g gitlabProject) deleteBranch(branch string) (bool, error) {
+	path := "/repository/branches/" + url.PathEscape(branch)
+	err := g.request("GET", path, nil, nil)
+	var pe *providerError
+	if errors.As(err, &pe) && pe.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = g.request("DELETE", path, nil, nil)
+	return err == nil, err
+}
+
+// revertCommit commits the revert of sha onto the existing branch.
+func (g gitlabProject) revertCommit(sha, branch string) error {
+	return g.request("POST", fmt.Sprintf("/repository/commits/%s/revert", url.PathEscape(sha)), map[string]string{"branch": branch}, nil)
+}
+
+// gitlabMergeRequest is the subset of the GitLab MR object the controller uses.
+type gitlabMergeRequest struct {
+	IID         int    `json:"iid"`
+	WebURL      string `json:"web_url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// webURL returns the MR link, or "" for a nil MR.
+func (mr *gitlabMergeRequest) webURL() string {
+	if mr == nil {
+		return ""
+	}
+	return mr.WebURL
+}
+
+// gitlabMergeRequestOptions are the MR fields set by the controller.
+type gitlabMergeRequestOptions struct {
+	Title         string
+	Description   string
+	AssigneeIDs   []int
+	ReviewerIDs   []int
+	Labels        []string
+	MilestoneID   int
+	ApprovalRules []gitlabApprovalRule // created on the MR after it is opened
+	Draft         bool                 // open as a draft MR ("Draft: " title)
+}
+
+// gitlabApprovalRule is an MR-level approval rule (GitLab Premium).
+type gitlabApprovalRule struct {
+	Name              string `json:"name"`
+	ApprovalsRequired int    `json:"approvals_required"`
+	UserIDs           []int  `json:"user_ids,omitempty"`
+}
+
+// createMergeRequest opens an MR from sourceBranch into targetBranch.
+func (g gitlabProject) createMergeRequest(sourceBranch, targetBranch string, opts gitlabMergeRequestOptions) (*gitlabMergeRequest, error) {
+	title := opts.Title
+	if opts.Draft {
+		title = "Draft: " + title
+	}
+	body := map[string]interface{}{
+		"source_branch":        sourceBranch,
+		"target_branch":        targetBranch,
+		"title":                title,
+		"description":          stampInstance(g.instance, opts.Description),
+		"remove_source_branch": true,
+	}
+	if len(opts.AssigneeIDs) > 0 {
+		body["assignee_ids"] = opts.AssigneeIDs
+	}
+	if len(opts.ReviewerIDs) > 0 {
+		body["reviewer_ids"] = opts.ReviewerIDs
+	}
+	if len(opts.Labels) > 0 {
+		body["labels"] = strings.Join(opts.Labels, ",")
+	}
+	if opts.MilestoneID != 0 {
+		body["milestone_id"] = opts.MilestoneID
+	}
+	var mr gitlabMergeRequest
+	if err := g.request("POST", "/merge_requests", body, &mr); err != nil {
+		return &mr, err
+	}
+	for _, rule := range opts.ApprovalRules {
+		if err := g.request("POST", fmt.Sprintf("/merge_requests/%d/approval_rules", mr.IID), rule, nil); err != nil {
+			return &mr, fmt.Errorf("creating approval rule %q on MR !%d: %w", rule.Name, mr.IID, err)
+		}
+	}
+	return &mr, nil
+}
+
+// mergeWhenPipelineSucceeds sets MR iid to merge once its pipeline passes,
+// or merges it at once if the project has no pipeline.
+func (g gitlabProject) mergeWhenPipelineSucceeds(iid int) error {
+	return g.request("PUT", fmt.Sprintf("/merge_requests/%d/merge", iid), map[string]bool{"merge_when_pipeline_succeeds": true}, nil)
+}
+
+// gitlabUser is the subset of the GitLab user object the controller uses.
+type gitlabUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// userByUsername looks up a GitLab user by username.
+func (g gitlabProject) userByUsername(username string) (*gitlabUser, error) {
+	var users []gitlabUser
+	u := fmt.Sprintf("%s/api/v4/users?username=%s", g.BaseURL, url.QueryEscape(username))
+	if err := g.requestURL("GET", u, nil, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("GitLab user %q not found", username)
+	}
+	return &users[0], nil
+}
+
+// milestoneID returns the ID of the active project milestone with the given
+// title.
+func (g gitlabProject) milestoneID(title string) (int, error) {
+	var milestones []struct {
+		ID int `json:"id"`
+	}
+	if err := g.request("GET", "/milestones?state=active&title="+url.QueryEscape(title), nil, &milestones); err != nil {
+		return 0, err
+	}
+	if len(milestones) == 0 {
+		return 0, fmt.Errorf("milestone %q not found", title)
+	}
+	return milestones[0].ID, nil
+}
+
+// commitMessage returns the full message of a commit.
+func (g gitlabProject) commitMessage(sha string) (string, error) {
+	var commit struct {
+		Message string `json:"message"`
+	}
+	err := g.request("GET", "/repository/commits/"+url.PathEscape(sha), nil, &commit)
+	return commit.Message, err
+}
+
+// commitAuthor returns the author name and email of a commit.
+func (g gitlabProject) commitAuthor(sha string) (name, email string, err error) {
+	var commit struct {
+		AuthorName  string `json:"author_name"`
+		AuthorEmail string `json:"author_email"`
+	}
+	err = g.request("GET", "/repository/commits/"+url.PathEscape(sha), nil, &commit)
+	return commit.AuthorName, commit.AuthorEmail, err
+}
+
+// userByEmail looks up the GitLab user with the given email. Without admin
+// rights GitLab only matches public emails, so nil is returned if no unique
+// user is found.
+func (g gitlabProject) userByEmail(email string) (*gitlabUser, error) {
+	var users []gitlabUser
+	// /users is not project-scoped.
+	u := fmt.Sprintf("%s/api/v4/users?search=%s", g.BaseURL, url.QueryEscape(email))
+	if err := g.requestURL("GET", u, nil, &users); err != nil {
+		return nil, err
+	}
+	if len(users) != 1 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
+// searchBlobs runs a project blob search on ref and returns the
+// matching paths.
+func (g gitlabProject) searchBlobs(query, ref string) ([]string, error) {
+	type blob struct {
+		Path string `json:"path"`
+	}
+	results, err := listPages[blob](g, fmt.Sprintf("/search?scope=blobs&search=%s&ref=%s",
+		url.QueryEscape(query), url.QueryEscape(ref)), g.api.orDefault().discovery)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	seen := map[string]bool{}
+	for _, res := range results {
+		if !seen[res.Path] {
+			seen[res.Path] = true
+			paths = append(paths, res.Path)
+		}
+	}
+	return paths, nil
+}
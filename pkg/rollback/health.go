@@ -0,0 +1,354 @@
+package rollback
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	providerMisconfigured = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rollback_provider_misconfigured",
+		Help: "1 while requests for a project keep failing with 401, 403 or 404, i.e. the token or project mapping is broken.",
+	}, []string{"project"})
+	providerAuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rollback_provider_auth_failures_total",
+		Help: "Provider requests that failed with 401, 403 or 404 pointing at broken credentials or project mapping.",
+	}, []string{"project", "code"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(providerMisconfigured, providerAuthFailures)
+}
+
+// defaultMisconfigRetry is the default of MISCONFIG_RETRY_SECONDS.
+const defaultMisconfigRetry = time.Hour
+
+// projectHealth tracks consecutive misconfiguration failures of one project.
+type projectHealth struct {
+	Project   string    `json:"project"`
+	Failures  int       `json:"failures"`
+	LastCode  int       `json:"lastCode"`
+	LastError string    `json:"lastError"`
+	Since     time.Time `json:"since"` // first failure of the current streak
+	// Reason and Hint tell what the last code means for this project and
+	// how to fix it, see misconfigHint.
+	Reason string `json:"reason"`
+	Hint   string `json:"hint"`
+	// RetryAt is when the next request is sent while degraded.
+	RetryAt time.Time `json:"retryAt,omitempty"`
+
+	credential string // credentialID of the failing token
+}
+
+// providerHealthTracker marks a project degraded once Threshold requests in a
+// row failed in a way that only broken configuration explains. Any success
+// for the project clears it. While degraded, requests with the same token
+// fail without being sent, except one every Retry: retrying cannot fix a
+// revoked token or a wrong project path, and a changed token is tried at
+// once.
+type providerHealthTracker struct {
+	Threshold int
+	Retry     time.Duration // 0 sends every request
+	clock     clock.PassiveClock
+
+	mu       sync.Mutex
+	projects map[string]*projectHealth
+}
+
+func newProviderHealthTracker(threshold int, clk clock.PassiveClock) *providerHealthTracker {
+	return &providerHealthTracker{Threshold: threshold, Retry: defaultMisconfigRetry, clock: clk, projects: make(map[string]*projectHealth)}
+}
+
+// credentialID identifies a token without keeping it.
+func credentialID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// misconfigHint returns the failure reason for a misconfiguration status of
+// project and what to check to fix it.
+func misconfigHint(project string, code int) (reason, hint string) {
+	switch code {
+	case http.StatusUnauthorized:
+		return "ProviderUnauthorized", "the token was rejected: it is missing, expired or revoked. " +
+			"Set a valid token in GITLAB_TOKEN or the routing rule's tokenSecret"
+	case http.StatusForbidden:
+		return "ProviderForbidden", fmt.Sprintf("the token may not change %s: it needs the api scope "+
+			"(read_api and read_repository cannot revert) and at least the Developer role, "+
+			"Maintainer to push to protected branches", project)
+	}
+	return "ProjectNotFound", fmt.Sprintf("%s was not found: check GITLAB_URL and GITLAB_PROJECT_ID or the "+
+		"routing rule's projectID (numeric ID or URL-encoded path, e.g. group%%2Fapp); "+
+		"GitLab also answers 404 to tokens without access to the project", project)
+}
+
+// misconfiguredError is returned instead of sending a request to a degraded
+// project.
+type misconfiguredError struct {
+	health projectHealth
+}
+
+func (e *misconfiguredError) Error() string {
+	h := e.health
+	return fmt.Sprintf("not sent: %s is degraded (%s, %d failures since %s), next retry at %s: %s",
+		h.Project, h.Reason, h.Failures, h.Since.UTC().Format(time.RFC3339), h.RetryAt.UTC().Format(time.RFC3339), h.Hint)
+}
+
+// providerHealth is the tracker of defaultProviderAPI.
+var providerHealth = newProviderHealthTracker(3, clock.RealClock{})
+
+// misconfigStatus reports whether a response code points at broken
+// configuration: 401 and 403 always do. 404 does for the project itself and
+// for writes (revert, branch, commit, MR), where the target must exist; reads
+// of files or branches legitimately return 404.
+func misconfigStatus(method string, projectRoot bool, code int) bool {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	case http.StatusNotFound:
+		return projectRoot || method != http.MethodGet
+	}
+	return false
+}
+
+// blocked returns the health of project if requests with credential are
+// held back until its next retry. Callers must hold t.mu.
+func (t *providerHealthTracker) blocked(project, credential string) (*projectHealth, bool) {
+	h := t.projects[project]
+	if h == nil || t.Retry <= 0 || h.Failures < t.Threshold || h.credential != credential {
+		return h, false
+	}
+	return h, t.clock.Now().Before(h.RetryAt)
+}
+
+// check returns a *misconfiguredError if a request to project with
+// credential must not be sent. A request that may be sent while degraded is
+// the retry; later ones wait for the next.
+func (t *providerHealthTracker) check(project, credential string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, blocked := t.blocked(project, credential)
+	if blocked {
+		return &misconfiguredError{health: *h}
+	}
+	if h != nil && t.Retry > 0 && h.Failures >= t.Threshold && h.credential == credential {
+		h.RetryAt = t.clock.Now().Add(t.Retry)
+	}
+	return nil
+}
+
+// held returns the health of project if requests with credential are held
+// back until its next retry. Unlike check, it never uses up the retry.
+func (t *providerHealthTracker) held(project, credential string) (projectHealth, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, blocked := t.blocked(project, credential)
+	if !blocked {
+		return projectHealth{}, false
+	}
+	return *h, true
+}
+
+// get returns the health of project, if it has a failure streak.
+func (t *providerHealthTracker) get(project string) (projectHealth, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h := t.projects[project]; h != nil {
+		return *h, true
+	}
+	return projectHealth{}, false
+}
+
+// observe records the outcome of a request for project sent with
+// credential.
+func (t *providerHealthTracker) observe(project, credential, method, url string, projectRoot bool, code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.projects[project]
+	if code >= 200 && code < 300 {
+		if h != nil {
+			delete(t.projects, project)
+			providerMisconfigured.WithLabelValues(project).Set(0)
+		}
+		return
+	}
+	if !misconfigStatus(method, projectRoot, code) {
+		return
+	}
+	providerAuthFailures.WithLabelValues(project, strconv.Itoa(code)).Inc()
+	if h == nil {
+		h = &projectHealth{Project: project, Since: t.clock.Now()}
+		t.projects[project] = h
+	}
+	h.Failures++
+	h.LastCode = code
+	h.LastError = fmt.Sprintf("%s %s: %d %s", method, url, code, http.StatusText(code))
+	h.Reason, h.Hint = misconfigHint(project, code)
+	h.credential = credential
+	if h.Failures >= t.Threshold {
+		providerMisconfigured.WithLabelValues(project).Set(1)
+		h.RetryAt = t.clock.Now().Add(t.Retry)
+	}
+}
+
+// degraded returns the projects at or above the threshold, sorted.
+func (t *providerHealthTracker) degraded() []projectHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []projectHealth
+	for _, h := range t.projects {
+		if h.Failures >= t.Threshold {
+			out = append(out, *h)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Project < out[j].Project })
+	return out
+}
+
+// degradedCondition builds the Degraded condition of the ControllerConfig.
+func degradedCondition(degraded []projectHealth) metav1.Condition {
+	if len(degraded) == 0 {
+		return metav1.Condition{Type: "Degraded", Status: metav1.ConditionFalse, Reason: "ProvidersHealthy",
+			Message: "No provider credential or project mapping failures"}
+	}
+	var lines []string
+	for _, h := range degraded {
+		lines = append(lines, fmt.Sprintf("%s: %s, %d consecutive failures since %s, last %s; %s",
+			h.Project, h.Reason, h.Failures, h.Since.UTC().Format(time.RFC3339), h.LastError, h.Hint))
+	}
+	return metav1.Condition{Type: "Degraded", Status: metav1.ConditionTrue, Reason: "ProviderMisconfigured",
+		Message: strings.Join(lines, "; ")}
+}
+
+// withProject runs revert with the project of res locked. A revert to a
+// project that the health tracker holds back is not attempted; it and a revert
+// that failed with a misconfiguration status are reported with the reason
+// and hint by recordProviderFailure, not just logged.
+func (r *RollbackController) withProject(ctx context.Context, res resourceRef, sha string, revert func(gl gitlabProject)) {
+	gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+	defer r.lockProject(gl)()
+	key := gl.healthKey()
+	health := gl.api.healthTracker()
+	if h, held := health.held(key, gl.credentialID()); held {
+		r.recordProviderFailure(res, sha, h, false)
+		return
+	}
+	before, _ := health.get(key)
+	revert(gl)
+	if h, ok := health.get(key); ok && h.Failures > before.Failures {
+		r.recordProviderFailure(res, sha, h, true)
+	}
+}
+
+// recordProviderFailure records that the revert of sha for res failed, or
+// was not attempted, because the provider rejected the token or project of
+// h, with an Event whose reason tells which.
+func (r *RollbackController) recordProviderFailure(res resourceRef, sha string, h projectHealth, attempted bool) {
+	what := "failed"
+	if !attempted {
+		what = "not attempted until " + h.RetryAt.UTC().Format(time.RFC3339)
+	}
+	r.log.Error(nil, "Revert "+what+": the provider rejects the token or project", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name,
+		"sha", sha, "project", h.Project, "reason", h.Reason, "lastError", h.LastError, "hint", h.Hint)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordAudit(auditProviderFailed, res.Kind, res.Namespace, res.Name, sha, fmt.Sprintf("%s: %s", h.Reason, h.Hint))
+	r.emitEvent(auditProviderFailed, res.Kind, res.Namespace, res.Name, sha)
+	r.kubeEventf(res.Kind, res.Namespace, res.Name, sha, corev1.EventTypeWarning, "Rollback"+h.Reason,
+		"Revert of revision %s %s for %s: %s", sha, what, h.Project, h.Hint)
+	r.revertFailed(res, sha, fmt.Sprintf("%s: %s", h.Reason, h.Hint))
+}
+
+// reportHealth sets the Degraded condition on the ControllerConfig object
+// name in the controller namespace, creating the object if needed.
+func (r *RollbackController) reportHealth(ctx context.Context, c client.Client, name string, cond metav1.Condition) error {
+	key := client.ObjectKey{Namespace: r.Namespace, Name: name}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(policyGroupVersion.WithKind("ControllerConfig"))
+		err := c.Get(ctx, key, obj)
+		if apierrors.IsNotFound(err) {
+			obj.SetNamespace(key.Namespace)
+			obj.SetName(key.Name)
+			err = c.Create(ctx, obj)
+		}
+		if err != nil {
+			return err
+		}
+		var status struct {
+			Conditions []metav1.Condition `json:"conditions,omitempty"`
+		}
+		if raw, ok, _ := unstructured.NestedMap(obj.Object, "status"); ok {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &status); err != nil {
+				return err
+			}
+		}
+		cond.ObservedGeneration = obj.GetGeneration()
+		cond.LastTransitionTime = metav1.NewTime(r.clock.Now())
+		if !meta.SetStatusCondition(&status.Conditions, cond) {
+			return nil
+		}
+		raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+		if err != nil {
+			return err
+		}
+		obj.Object["status"] = raw
+		return c.Status().Update(ctx, obj)
+	})
+}
+
+// runHealthReporter periodically mirrors the provider health into the
+// ControllerConfig's Degraded condition and logs when a project turns
+// degraded or recovers.
+func (r *RollbackController) runHealthReporter(ctx context.Context, c client.Client, name string, interval time.Duration) error {
+	wasDegraded := map[string]bool{}
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		degraded := r.api.healthTracker().degraded()
+		now := map[string]bool{}
+		for _, h := range degraded {
+			now[h.Project] = true
+			if !wasDegraded[h.Project] {
+				r.log.Error(nil, "Provider misconfigured: requests keep failing, check the token and project mapping",
+					"project", h.Project, "reason", h.Reason, "failures", h.Failures, "code", h.LastCode, "lastError", h.LastError, "hint", h.Hint)
+			}
+		}
+		for p := range wasDegraded {
+			if !now[p] {
+				r.log.Info("Provider recovered", "project", p)
+			}
+		}
+		wasDegraded = now
+		if name != "" {
+			if err := r.reportHealth(ctx, c, name, degradedCondition(degraded)); err != nil {
+				r.log.Error(err, "failed to update ControllerConfig status", "name", name)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMisconfigStatus(t *testing.T) {
+	for _, c := range []struct {
+		method      string
+		projectRoot bool
+		code        int
+		want        bool
+	}{
+		{"GET", false, 401, true},
+		{"POST", false, 403, true},
+		{"GET", true, 404, true},
+		{"POST", false, 404, true},
+		{"GET", false, 404, false}, // missing file or branch
+		{"POST", false, 400, false},
+		{"POST", false, 500, false},
+	} {
+		if got := misconfigStatus(c.method, c.projectRoot, c.code); got != c.want {
+			t.Errorf("misconfigStatus(%s, %v, %d) = %v, want %v", c.method, c.projectRoot, c.code, got, c.want)
+		}
+	}
+}
+
+func TestProviderHealthTracker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	tr := newProviderHealthTracker(2, clk)
+	p := "https://gitlab/api/v4/projects/test-health"
+
+	tr.observe(p, "tok", "POST", p+"/repository/commits/abc/revert", false, 401)
+	tr.observe(p, "tok", "GET", p+"/repository/files/x/raw", false, 404) // ignored
+	if len(tr.degraded()) != 0 {
+		t.Fatal("degraded below threshold")
+	}
+	clk.SetTime(start.Add(time.Minute))
+	tr.observe(p, "tok", "POST", p+"/repository/branches", false, 404)
+	d := tr.degraded()
+	if len(d) != 1 || d[0].Failures != 2 || d[0].LastCode != 404 || !d[0].Since.Equal(start) || d[0].Reason != "ProjectNotFound" {
+		t.Fatalf("unexpected degraded %+v", d)
+	}
+	if v := testutil.ToFloat64(providerMisconfigured.WithLabelValues(p)); v != 1 {
+		t.Errorf("rollback_provider_misconfigured = %v, want 1", v)
+	}
+	tr.observe(p, "tok", "GET", p, true, 200)
+	if len(tr.degraded()) != 0 || testutil.ToFloat64(providerMisconfigured.WithLabelValues(p)) != 0 {
+		t.Error("success did not clear the project")
+	}
+}
+
+func TestProviderHealthHoldsRequests(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakePassiveClock(start)
+	tr := newProviderHealthTracker(1, clk)
+	tr.Retry = time.Hour
+	p := "https://gitlab/api/v4/projects/test-hold"
+
+	tr.observe(p, "revoked", "POST", p+"/repository/commits/abc/revert", false, 401)
+	err := tr.check(p, "revoked")
+	var misconfigured *misconfiguredError
+	if !errors.As(err, &misconfigured) || misconfigured.health.Reason != "ProviderUnauthorized" {
+		t.Fatalf("check = %v, want a ProviderUnauthorized misconfiguredError", err)
+	}
+	if err := tr.check(p, "rotated"); err != nil {
+		t.Errorf("a new token should be tried at once: %v", err)
+	}
+	clk.SetTime(start.Add(time.Hour))
+	if err := tr.check(p, "revoked"); err != nil {
+		t.Errorf("the retry should be sent: %v", err)
+	}
+	if err := tr.check(p, "revoked"); err == nil {
+		t.Error("requests after the retry should wait for the next one")
+	}
+	tr.Retry = 0
+	if err := tr.check(p, "revoked"); err != nil {
+		t.Errorf("MISCONFIG_RETRY_SECONDS=0 should send every request: %v", err)
+	}
+}
+
+func TestWithProjectReportsMisconfiguration(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		http.Error(w, "404 Project Not Found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	saved := providerHealth
+	providerHealth = newProviderHealthTracker(1, clocktesting.NewFakePassiveClock(time.Now()))
+	defer func() { providerHealth = saved }()
+
+	r := NewRollbackController(nil, logr.Discard(), "token", "group%2Fmissing", srv.URL, "revert", 0)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	rec := record.NewFakeRecorder(10)
+	r.kubeEvents = rec
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+	revert := func(gl gitlabProject) {
+		_ = gl.revertCommit("abc", "main")
+	}
+	for i := 0; i < 2; i++ {
+		r.withProject(context.Background(), res, "main@sha1:abc", revert)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("requests sent = %d, want 1: the second revert should be held", n)
+	}
+	var failed []auditEntry
+	for _, e := range r.auditLog {
+		if e.Event == auditProviderFailed {
+			failed = append(failed, e)
+		}
+	}
+	if len(failed) != 2 || !strings.HasPrefix(failed[0].Message, "ProjectNotFound: ") || !strings.Contains(failed[0].Message, "group%2Fmissing") {
+		t.Fatalf("providerFailed audit entries = %+v", failed)
+	}
+	if e := <-rec.Events; !strings.HasPrefix(e, "Warning RollbackProjectNotFound Revert of revision main@sha1:abc failed") {
+		t.Errorf("event = %q", e)
+	}
+	if e := <-rec.Events; !strings.Contains(e, "not attempted until") {
+		t.Errorf("event = %q", e)
+	}
+}
+
+func TestRequestFeedsProviderHealth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	saved := providerHealth
+	providerHealth = newProviderHealthTracker(1, clocktesting.NewFakePassiveClock(time.Now()))
+	defer func() { providerHealth = saved }()
+
+	gl := gitlabProject{BaseURL: srv.URL, ProjectID: "7", Token: "revoked"}
+	if _, err := gl.defaultBranch(); err == nil {
+		t.Fatal("expected error")
+	}
+	d := providerHealth.degraded()
+	if len(d) != 1 || d[0].Project != gl.url("") || d[0].LastCode != 401 {
+		t.Errorf("unexpected degraded %+v", d)
+	}
+}
+
+func TestReportHealth(t *testing.T) {
+	cfg := &unstructured.Unstructured{}
+	cfg.SetGroupVersionKind(policyGroupVersion.WithKind("ControllerConfig"))
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithStatusSubresource(cfg).Build()
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.Namespace = "flux-system"
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	r.setClock(clocktesting.NewFakeClock(start))
+	ctx := context.Background()
+
+	degraded := []projectHealth{{Project: "https://gitlab/api/v4/projects/7", Failures: 3, LastCode: 401, LastError: "GET x: 401 Unauthorized", Since: start}}
+	if err := r.reportHealth(ctx, c, "rollback", degradedCondition(degraded)); err != nil {
+		t.Fatal(err)
+	}
+	cond := readDegraded(t, c)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Reason != "ProviderMisconfigured" || !cond.LastTransitionTime.Time.Equal(start) {
+		t.Fatalf("unexpected condition %+v", cond)
+	}
+	if err := r.reportHealth(ctx, c, "rollback", degradedCondition(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if cond := readDegraded(t, c); cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "ProvidersHealthy" {
+		t.Errorf("unexpected condition after recovery %+v", cond)
+	}
+}
+
+func readDegraded(t *testing.T, c client.Client) *metav1.Condition {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(policyGroupVersion.WithKind("ControllerConfig"))
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "flux-system", Name: "rollback"}, obj); err != nil {
+		t.Fatal(err)
+	}
+	var status struct {
+		Conditions []metav1.Condition `json:"conditions"`
+	}
+	raw, _, _ := unstructured.NestedMap(obj.Object, "status")
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &status); err != nil {
+		t.Fatal(err)
+	}
+	return meta.FindStatusCondition(status.Conditions, "Degraded")
+}
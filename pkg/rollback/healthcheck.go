@@ -0,0 +1,62 @@
+package rollback
+
+import (
+	"path"
+	"regexp"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// healthCheckObject matches one object in a failed Healthy condition message,
+// e.g. "timeout waiting for: [Deployment/apps/web status: 'InProgress']".
+// Cluster-scoped objects have an empty namespace.
+var healthCheckObject = regexp.MustCompile(`([A-Za-z0-9]+)/([^/\s,\[\]]*)/([^/\s,\[\]]+) status:`)
+
+// failingWorkloads returns the objects named in a Healthy condition message.
+func failingWorkloads(message string) []PolicyWorkload {
+	var out []PolicyWorkload
+	for _, m := range healthCheckObject.FindAllStringSubmatch(message, -1) {
+		out = append(out, PolicyWorkload{Kind: m[1], Namespace: m[2], Name: m[3]})
+	}
+	return out
+}
+
+// matches reports whether w selects the object o. Empty fields match
+// anything; Namespace and Name are path.Match globs.
+func (w PolicyWorkload) matches(o PolicyWorkload) bool {
+	if w.Kind != "" && w.Kind != o.Kind {
+		return false
+	}
+	if ok, _ := path.Match(w.Namespace, o.Namespace); w.Namespace != "" && !ok {
+		return false
+	}
+	ok, _ := path.Match(w.Name, o.Name)
+	return w.Name == "" || ok
+}
+
+// kustomizationReady reports whether a Kustomization counts as healthy under
+// the policy's failure signal. With Healthy, only a failed health check is a
+// failure, and with CriticalWorkloads only one involving a matching workload.
+func (r *RollbackController) kustomizationReady(ks *kustomizev1.Kustomization, policy *RollbackPolicy) bool {
+	if policy.failureSignal() != FailureSignalHealthy {
+		return r.isReadyKind("Kustomization", ks.Status.Conditions)
+	}
+	c := meta.FindStatusCondition(ks.Status.Conditions, "Healthy")
+	if c == nil || c.Status != "False" {
+		return true
+	}
+	if len(policy.Spec.CriticalWorkloads) == 0 {
+		return false
+	}
+	failing := failingWorkloads(c.Message)
+	for _, o := range failing {
+		for _, w := range policy.Spec.CriticalWorkloads {
+			if w.matches(o) {
+				return false
+			}
+		}
+	}
+	r.log.V(1).Info("Health checks failing only for non-critical workloads, ignoring", "namespace", ks.Namespace, "name", ks.Name, "failing", len(failing), "message", c.Message)
+	return true
+}
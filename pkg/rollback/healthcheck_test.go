@@ -0,0 +1,56 @@
+package rollback
+
+import (
+	"reflect"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const healthMessage = "health check failed after 5m0s: timeout waiting for: [Deployment/payments-eu/api status: 'InProgress', Deployment/tools/debug-ui status: 'Failed', ClusterRole//viewer status: 'NotFound']"
+
+func TestFailingWorkloads(t *testing.T) {
+	want := []PolicyWorkload{
+		{Kind: "Deployment", Namespace: "payments-eu", Name: "api"},
+		{Kind: "Deployment", Namespace: "tools", Name: "debug-ui"},
+		{Kind: "ClusterRole", Name: "viewer"},
+	}
+	if got := failingWorkloads(healthMessage); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestKustomizationReady(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	ks := func(conds ...metav1.Condition) *kustomizev1.Kustomization {
+		k := &kustomizev1.Kustomization{}
+		k.Status.Conditions = conds
+		return k
+	}
+	notReady := metav1.Condition{Type: "Ready", Status: metav1.ConditionFalse, Message: "kustomize build failed"}
+	unhealthy := metav1.Condition{Type: "Healthy", Status: metav1.ConditionFalse, Message: healthMessage}
+	healthy := &RollbackPolicy{Spec: RollbackPolicySpec{FailureSignal: FailureSignalHealthy}}
+	critical := func(w ...PolicyWorkload) *RollbackPolicy {
+		return &RollbackPolicy{Spec: RollbackPolicySpec{FailureSignal: FailureSignalHealthy, CriticalWorkloads: w}}
+	}
+
+	for name, c := range map[string]struct {
+		ks     *kustomizev1.Kustomization
+		policy *RollbackPolicy
+		want   bool
+	}{
+		"ready signal, build failure":     {ks(notReady), nil, false},
+		"healthy signal, build failure":   {ks(notReady), healthy, true},
+		"healthy signal, health failure":  {ks(notReady, unhealthy), healthy, false},
+		"critical workload failing":       {ks(unhealthy), critical(PolicyWorkload{Kind: "Deployment", Namespace: "payments-*"}), false},
+		"only non-critical failing":       {ks(unhealthy), critical(PolicyWorkload{Namespace: "checkout"}), true},
+		"critical name glob, wrong kind":  {ks(unhealthy), critical(PolicyWorkload{Kind: "StatefulSet", Name: "api"}), true},
+		"cluster-scoped critical failing": {ks(unhealthy), critical(PolicyWorkload{Kind: "ClusterRole", Name: "view*"}), false},
+	} {
+		if got := r.kustomizationReady(c.ks, c.policy); got != c.want {
+			t.Errorf("%s: kustomizationReady = %v, want %v", name, got, c.want)
+		}
+	}
+}
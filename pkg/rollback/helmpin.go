@@ -0,0 +1,245 @@
+package rollback
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// lastSuccessfulChartVersion returns the chart version of the most recent
+// deployed or superseded release in the HelmRelease history that differs from
+// the failing version.
+func lastSuccessfulChartVersion(hr *helmv2.HelmRelease, failing string) string {
+	history := append(helmv2.Snapshots{}, hr.Status.History...)
+	history.SortByVersion()
+	for _, s := range history {
+		if (s.Status == "deployed" || s.Status == "superseded") && s.ChartVersion != failing {
+			return s.ChartVersion
+		}
+	}
+	return ""
+}
+
+var (
+	yamlKeyLine     = regexp.MustCompile(`^(\s*)([A-Za-z0-9_.-]+):`)
+	yamlVersionLine = regexp.MustCompile(`^(\s*version:\s*)(['"]?)([^'"#\s]*)(['"]?)(\s*(#.*)?)$`)
+)
+
+// Key paths of the chart template and its version inside a HelmRelease.
+var (
+	chartSpecPath    = []string{"spec", "chart", "spec"}
+	chartVersionPath = []string{"spec", "chart", "spec", "version"}
+)
+
+// yamlKey is one mapping key on the path to the current line.
+type yamlKey struct {
+	indent int
+	name   string
+}
+
+// pathIs reports whether the key names on stack equal path.
+func pathIs(stack []yamlKey, path []string) bool {
+	if len(stack) != len(path) {
+		return false
+	}
+	for i := range path {
+		if stack[i].name != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pinChartVersion rewrites spec.chart.spec.version of the HelmRelease named
+// name in the (possibly multi-document) manifest from current to version. A
+// manifest that sets metadata.namespace must set namespace; one without it
+// matches any, as the namespace may come from a Kustomization. Editing is
+// textual so comments and formatting are preserved. If current is empty or
+// "*" (unpinned) and the manifest has no version line, one is added.
+func pinChartVersion(manifest []byte, namespace, name, current, version string) ([]byte, error) {
+	docs := bytes.Split(manifest, []byte("\n---"))
+	for i, doc := range docs {
+		var meta struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Namespace string `json:"namespace"`
+				Name      string `json:"name"`
+			} `json:"metadata"`
+		}
+		if err := yaml.Unmarshal(doc, &meta); err != nil || meta.Kind != "HelmRelease" || meta.Metadata.Name != name {
+			continue
+		}
+		if meta.Metadata.Namespace != "" && meta.Metadata.Namespace != namespace {
+			continue
+		}
+		edited, err := pinChartVersionInDoc(string(doc), current, version)
+		if err != nil {
+			return nil, fmt.Errorf("HelmRelease %s: %w", name, err)
+		}
+		docs[i] = []byte(edited)
+		return bytes.Join(docs, []byte("\n---")), nil
+	}
+	return nil, fmt.Errorf("HelmRelease %s not found in manifest", name)
+}
+
+// pinChartVersionInDoc walks the indentation of a single YAML document to find
+// the spec.chart.spec mapping and its version key.
+func pinChartVersionInDoc(doc, current, version string) (string, error) {
+	lines := strings.Split(doc, "\n")
+	var stack []yamlKey
+	specLine, childIndent := -1, -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if specLine >= 0 && childIndent < 0 && pathIs(stack, chartSpecPath) {
+			childIndent = indent
+		}
+		m := yamlKeyLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		stack = append(stack, yamlKey{indent, m[2]})
+		if pathIs(stack, chartSpecPath) {
+			specLine = i
+			continue
+		}
+		if !pathIs(stack, chartVersionPath) {
+			continue
+		}
+		v := yamlVersionLine.FindStringSubmatch(line)
+		if v == nil {
+			return "", fmt.Errorf("cannot parse chart version line %q", trimmed)
+		}
+		if current != "" && v[3] != current {
+			return "", fmt.Errorf("chart version in Git is %q, expected %q", v[3], current)
+		}
+		lines[i] = v[1] + v[2] + version + v[4] + v[5]
+		return strings.Join(lines, "\n"), nil
+	}
+	if specLine < 0 {
+		return "", fmt.Errorf("no spec.chart.spec found")
+	}
+	if current != "" && current != "*" {
+		return "", fmt.Errorf("no chart version %q found", current)
+	}
+	if childIndent < 0 {
+		return "", fmt.Errorf("empty spec.chart.spec")
+	}
+	added := fmt.Sprintf("%sversion: %q", strings.Repeat(" ", childIndent), version)
+	lines = append(lines[:specLine+1], append([]string{added}, lines[specLine+1:]...)...)
+	return strings.Join(lines, "\n"), nil
+}
+
+// findHelmReleaseFile locates the repository file containing the HelmRelease
+// manifest, either from the policy or via GitLab blob search. If the search
+// finds the release in several files, e.g. in one overlay per environment,
+// it refuses to guess and the policy must set helmReleasePath.
+func (r *RollbackController) findHelmReleaseFile(gl gitlabProject, hr *helmv2.HelmRelease, policy *RollbackPolicy, ref string) (string, []byte, error) {
+	if policy != nil && policy.Spec.HelmReleasePath != "" {
+		content, err := gl.fileRaw(policy.Spec.HelmReleasePath, ref)
+		return policy.Spec.HelmReleasePath, content, err
+	}
+	// Basic (non-Elasticsearch) blob search matches literal text, so search
+	// for the name line and let pinChartVersion filter the candidates.
+	paths, err := gl.searchBlobs("name: "+hr.Name, ref)
+	if err != nil {
+		return "", nil, err
+	}
+	var found []string
+	var content []byte
+	for _, p := range paths {
+		if !strings.HasSuffix(p, ".yaml") && !strings.HasSuffix(p, ".yml") {
+			continue
+		}
+		data, err := gl.fileRaw(p, ref)
+		if err != nil {
+			continue
+		}
+		if _, err := pinChartVersion(data, hr.Namespace, hr.Name, hr.Spec.Chart.Spec.Version, hr.Spec.Chart.Spec.Version); err == nil {
+			found = append(found, p)
+			content = data
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil, fmt.Errorf("no file with HelmRelease %s/%s found", hr.Namespace, hr.Name)
+	case 1:
+		return found[0], content, nil
+	default:
+		return "", nil, fmt.Errorf("HelmRelease %s/%s found in %s; set helmReleasePath on the RollbackPolicy", hr.Namespace, hr.Name, strings.Join(found, ", "))
+	}
+}
+
+// pinHelmChartVersion pins the HelmRelease in Git back to the last
+// successfully deployed chart version, by default via an MR. It returns the
+// MR, if one was opened.
+func (r *RollbackController) pinHelmChartVersion(gl gitlabProject, hr *helmv2.HelmRelease, policy *RollbackPolicy) *gitlabMergeRequest {
+	if !gl.isGitLab() {
+		r.log.Error(nil, "PinChartVersion is only supported with GitLab", "provider", gl.Provider, "namespace", hr.Namespace, "name", hr.Name)
+		return nil
+	}
+	if hr.Spec.Chart == nil {
+		r.log.Error(nil, "HelmRelease has no chart template, cannot pin version", "namespace", hr.Namespace, "name", hr.Name)
+		return nil
+	}
+	current := hr.Spec.Chart.Spec.Version
+	failing := hr.Status.LastAttemptedRevision
+	version := lastSuccessfulChartVersion(hr, failing)
+	if version == "" {
+		r.log.Error(nil, "No previous successful chart version in history", "namespace", hr.Namespace, "name", hr.Name, "failing", failing)
+		return nil
+	}
+	strategy := policy.revertStrategy(RevertStrategyMergeRequest)
+	branch := r.revertBranchName(branchNameData{Action: branchActionChart, Strategy: strategy, Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name, Version: version},
+		fmt.Sprintf("%s-%s-%s-%s", r.RevertBranchPrefix, hr.Namespace, hr.Name, version))
+	if dryRun() {
+		r.log.Info("ECHO: would pin chart version", "namespace", hr.Namespace, "name", hr.Name, "from", current, "to", version, "branch", branch, "strategy", strategy)
+		r.recordAction(gl, recordedAction{Action: "pinChartVersion", Strategy: strategy, Branch: branch, Namespace: hr.Namespace, Name: hr.Name, From: current, To: version})
+		return nil
+	}
+
+	target, err := targetBranch(gl, policy)
+	if err != nil {
+		r.log.Error(err, "failed to get default branch")
+		return nil
+	}
+	path, content, err := r.findHelmReleaseFile(gl, hr, policy, target)
+	if err != nil {
+		r.log.Error(err, "failed to find HelmRelease manifest", "namespace", hr.Namespace, "name", hr.Name)
+		return nil
+	}
+	pinned, err := pinChartVersion(content, hr.Namespace, hr.Name, current, version)
+	if err != nil {
+		r.log.Error(err, "failed to pin chart version", "path", path)
+		return nil
+	}
+
+	title := fmt.Sprintf("Pin HelmRelease %s/%s to chart version %s", hr.Namespace, hr.Name, version)
+	mr := gitlabMergeRequestOptions{
+		Title:       title,
+		Description: fmt.Sprintf("Chart version %s of HelmRelease %s/%s failed; rolling back to %s.", failing, hr.Namespace, hr.Name, version),
+	}
+	if strategy == RevertStrategyMergeRequest {
+		r.prepareMergeRequest(gl, resourceRef{Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name}, policy, "", &mr)
+	}
+	created, err := deliverChange(gl, strategy, branch, target, mr, commitFiles(gl, title, []gitlabCommitAction{
+		{Action: "update", FilePath: path, Content: string(pinned)},
+	}))
+	if err != nil {
+		r.log.Error(err, "failed to deliver pinned chart version", "branch", branch, "strategy", strategy)
+		r.recordRevertFailure(resourceRef{Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name}, failing, err)
+		return nil
+	}
+	r.log.Info("Chart version pinned successfully", "namespace", hr.Namespace, "name", hr.Name, "version", version, "strategy", strategy, "mr", created.webURL())
+	return created
+}
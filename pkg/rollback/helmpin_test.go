@@ -0,0 +1,217 @@
+package rollback
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const helmReleaseManifest = `---
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: my-app
+spec:
+  url: https://example.com/charts
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: my-app
+spec:
+  values:
+    image:
+      version: 1.2.1
+  chart:
+    spec:
+      chart: my-app
+      version: '1.2.1' # broken
+      sourceRef:
+        kind: HelmRepository
+        name: my-app
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: other
+spec:
+  chart:
+    spec:
+      chart: other
+      version: 1.2.10
+`
+
+func TestPinChartVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		release  string
+		current  string
+		version  string
+		want     []string
+		wantErr  bool
+		unwanted []string
+	}{
+		{
+			name:     "only the chart version of the named release changes",
+			release:  "my-app",
+			current:  "1.2.1",
+			version:  "1.2.0",
+			want:     []string{"      version: '1.2.0' # broken", "      version: 1.2.1\n", "      version: 1.2.10\n"},
+			unwanted: []string{"'1.2.1'"},
+		},
+		{
+			name:    "no prefix match on a longer version",
+			release: "other",
+			current: "1.2.1",
+			version: "1.2.0",
+			wantErr: true,
+		},
+		{
+			name:    "unquoted version",
+			release: "other",
+			current: "1.2.10",
+			version: "1.2.9",
+			want:    []string{"      version: 1.2.9\n", "      version: '1.2.1' # broken"},
+		},
+		{
+			name:    "unknown release",
+			release: "missing",
+			current: "1.0.0",
+			version: "0.9.0",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pinChartVersion([]byte(helmReleaseManifest), "apps", tt.release, tt.current, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(got), w) {
+					t.Errorf("missing %q in:\n%s", w, got)
+				}
+			}
+			for _, u := range tt.unwanted {
+				if strings.Contains(string(got), u) {
+					t.Errorf("unexpected %q in:\n%s", u, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPinChartVersionAddsMissingVersion(t *testing.T) {
+	manifest := `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: latest
+spec:
+  chart:
+    spec:
+      chart: latest
+      sourceRef:
+        kind: HelmRepository
+        name: latest
+`
+	got, err := pinChartVersion([]byte(manifest), "apps", "latest", "*", "2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "    spec:\n      version: \"2.0.0\"\n      chart: latest\n") {
+		t.Errorf("version not added under spec.chart.spec:\n%s", got)
+	}
+}
+
+func TestLastSuccessfulChartVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		history helmv2.Snapshots
+		want    string
+	}{
+		{
+			name: "skips failing version and failed releases",
+			history: helmv2.Snapshots{
+				{Version: 4, ChartVersion: "0.0.3", Status: "failed"},
+				{Version: 3, ChartVersion: "0.0.3", Status: "superseded"},
+				{Version: 2, ChartVersion: "0.0.2", Status: "failed"},
+				{Version: 1, ChartVersion: "0.0.1", Status: "superseded"},
+			},
+			want: "0.0.1",
+		},
+		{
+			name: "only failed releases",
+			history: helmv2.Snapshots{
+				{Version: 2, ChartVersion: "0.0.3", Status: "failed"},
+				{Version: 1, ChartVersion: "0.0.2", Status: "failed"},
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := &helmv2.HelmRelease{Status: helmv2.HelmReleaseStatus{History: tt.history}}
+			if got := lastSuccessfulChartVersion(hr, "0.0.3"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindHelmReleaseFile(t *testing.T) {
+	release := func(namespace string) string {
+		meta := "  name: podinfo\n"
+		if namespace != "" {
+			meta += "  namespace: " + namespace + "\n"
+		}
+		return "apiVersion: helm.toolkit.fluxcd.io/v2\nkind: HelmRelease\nmetadata:\n" + meta +
+			"spec:\n  chart:\n    spec:\n      chart: podinfo\n      version: 6.5.0\n"
+	}
+	files := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/v4/projects/42/search" {
+			var blobs []map[string]string
+			for p := range files {
+				blobs = append(blobs, map[string]string{"path": p})
+			}
+			_ = json.NewEncoder(w).Encode(blobs)
+			return
+		}
+		p := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42/repository/files/"), "/raw")
+		if content, ok := files[p]; ok {
+			fmt.Fprint(w, content)
+			return
+		}
+		http.NotFound(w, req)
+	}))
+	defer srv.Close()
+	r := NewRollbackController(nil, logr.Discard(), "token", "42", srv.URL, "revert", 0)
+	hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "podinfo"}}
+	hr.Spec.Chart = &helmv2.HelmChartTemplate{}
+	hr.Spec.Chart.Spec.Version = "6.5.0"
+
+	// The staging overlay's release of the same name is not the one to pin.
+	files["staging/podinfo.yaml"] = release("staging")
+	files["prod/podinfo.yaml"] = release("prod")
+	if path, _, err := r.findHelmReleaseFile(r.defaultProject(), hr, nil, "main"); err != nil || path != "prod/podinfo.yaml" {
+		t.Errorf("findHelmReleaseFile = %q, %v, want prod/podinfo.yaml", path, err)
+	}
+
+	// Without namespaces both files match: refuse instead of picking one.
+	files["staging/podinfo.yaml"] = release("")
+	files["prod/podinfo.yaml"] = release("")
+	if _, _, err := r.findHelmReleaseFile(r.defaultProject(), hr, nil, "main"); err == nil || !strings.Contains(err.Error(), "helmReleasePath") {
+		t.Errorf("ambiguous search err = %v, want a hint at helmReleasePath", err)
+	}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{HelmReleasePath: "prod/podinfo.yaml"}}
+	if path, _, err := r.findHelmReleaseFile(r.defaultProject(), hr, policy, "main"); err != nil || path != "prod/podinfo.yaml" {
+		t.Errorf("findHelmReleaseFile with helmReleasePath = %q, %v", path, err)
+	}
+}
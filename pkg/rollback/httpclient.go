@@ -0,0 +1,264 @@
+package rollback
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Provider API metrics, served on the controller-runtime metrics endpoint.
+var (
+	providerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rollback_provider_requests_total",
+		Help: "Provider API requests by host, method and HTTP status code.",
+	}, []string{"host", "method", "code"})
+	providerRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rollback_provider_request_errors_total",
+		Help: "Provider API requests that failed without a response (network errors, timeouts).",
+	}, []string{"host", "method"})
+	providerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rollback_provider_request_duration_seconds",
+		Help:    "Provider API request latency.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"host", "method"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(providerRequests, providerRequestErrors, providerRequestDuration)
+}
+
+// httpClientOptions tune the shared provider HTTP client.
+type httpClientOptions struct {
+	Timeout             time.Duration // per request, including reading the body
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	MaxRequestsPerHost  int // requests in flight per host; 0 = unlimited
+	// ErrorBodyBytes of non-2xx responses are read into errors; 0 = none.
+	ErrorBodyBytes int64
+	// Proxy, if set, replaces HTTP(S)_PROXY and NO_PROXY for all requests.
+	Proxy *url.URL
+}
+
+var defaultHTTPClientOptions = httpClientOptions{
+	Timeout:             10 * time.Second,
+	MaxIdleConnsPerHost: 10,
+	IdleConnTimeout:     90 * time.Second,
+	MaxRequestsPerHost:  4,
+	ErrorBodyBytes:      2048,
+}
+
+// providerAPI is how a controller talks to provider APIs: its pooled HTTP
+// clients, the error body limit and the health of the projects. It is built
+// once per controller, so several controllers in one process do not share
+// settings.
+type providerAPI struct {
+	opts   httpClientOptions
+	client *http.Client           // shared by all calls so connections are kept alive
+	health *providerHealthTracker // nil uses providerHealth
+	// discovery bounds paged GitLab listings (listPages).
+	discovery discoveryOptions
+
+	mu      sync.Mutex
+	proxies map[string]*http.Client // routes with their own egress proxy, by proxy URL
+}
+
+// defaultProviderAPI serves projects and controllers without their own
+// providerAPI, e.g. in tests.
+var defaultProviderAPI = &providerAPI{
+	opts:      defaultHTTPClientOptions,
+	client:    newHTTPClient(defaultHTTPClientOptions),
+	discovery: defaultDiscoveryOptions,
+	proxies:   make(map[string]*http.Client),
+}
+
+// newProviderAPI returns a providerAPI with clients built with opts, the
+// default discovery options and its own health tracker.
+func newProviderAPI(opts httpClientOptions) *providerAPI {
+	return &providerAPI{
+		opts:      opts,
+		client:    newHTTPClient(opts),
+		health:    newProviderHealthTracker(3, clock.RealClock{}),
+		discovery: defaultDiscoveryOptions,
+		proxies:   make(map[string]*http.Client),
+	}
+}
+
+// orDefault returns p, or defaultProviderAPI if p is nil.
+func (p *providerAPI) orDefault() *providerAPI {
+	if p == nil {
+		return defaultProviderAPI
+	}
+	return p
+}
+
+// healthTracker returns the tracker of the projects p sends requests to.
+func (p *providerAPI) healthTracker() *providerHealthTracker {
+	if p = p.orDefault(); p.health != nil {
+		return p.health
+	}
+	return providerHealth
+}
+
+// httpClient returns the client sending requests through the proxy URL, or
+// the shared client if proxy is empty.
+func (p *providerAPI) httpClient(proxy string) (*http.Client, error) {
+	p = p.orDefault()
+	if proxy == "" {
+		return p.client, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.proxies[proxy]; ok {
+		return c, nil
+	}
+	u, err := parseProxyURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	opts := p.opts
+	opts.Proxy = u
+	c := newHTTPClient(opts)
+	p.proxies[proxy] = c
+	return c, nil
+}
+
+// parseProxyURL parses an egress proxy URL. The http, https, socks5 and
+// socks5h schemes are supported; credentials may be given as user info.
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url.Error quotes the URL, credentials included.
+		return nil, errors.New("invalid proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy URL %s: scheme must be http, https, socks5 or socks5h", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %s has no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// newHTTPClient returns a pooled, HTTP/2-capable client that records
+// per-host metrics.
+func newHTTPClient(opts httpClientOptions) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	var rt http.RoundTripper = instrumentedTransport{next: transport, clock: clock.RealClock{}}
+	if opts.MaxRequestsPerHost > 0 {
+		rt = &hostLimiter{limit: opts.MaxRequestsPerHost, next: rt, slots: make(map[string]chan struct{})}
+	}
+	return &http.Client{Timeout: opts.Timeout, Transport: rt}
+}
+
+// hostLimiter bounds the requests in flight per host, so a burst of reverts
+// during a cluster-wide incident does not trip the provider's rate limits. A
+// request holds its slot until its response body is closed.
+type hostLimiter struct {
+	limit int
+	next  http.RoundTripper
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func (l *hostLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	l.mu.Lock()
+	slots, ok := l.slots[req.URL.Host]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[req.URL.Host] = slots
+	}
+	l.mu.Unlock()
+	select {
+	case slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	release := func() { <-slots }
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody releases a hostLimiter slot when the body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// instrumentedTransport records request metrics per host.
+type instrumentedTransport struct {
+	next  http.RoundTripper
+	clock clock.PassiveClock
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := t.clock.Now()
+	resp, err := t.next.RoundTrip(req)
+	host := req.URL.Host
+	providerRequestDuration.WithLabelValues(host, req.Method).Observe(t.clock.Since(start).Seconds())
+	if err != nil {
+		providerRequestErrors.WithLabelValues(host, req.Method).Inc()
+		return nil, err
+	}
+	providerRequests.WithLabelValues(host, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// httpClientOptionsFromEnv reads HTTP_TIMEOUT_SECONDS,
+// HTTP_MAX_IDLE_CONNS_PER_HOST, HTTP_IDLE_CONN_TIMEOUT_SECONDS,
+// HTTP_MAX_REQUESTS_PER_HOST and HTTP_ERROR_BODY_BYTES.
+func httpClientOptionsFromEnv(getenv func(string) string) httpClientOptions {
+	opts := defaultHTTPClientOptions
+	if n, err := strconv.Atoi(getenv("HTTP_TIMEOUT_SECONDS")); err == nil && n > 0 {
+		opts.Timeout = time.Duration(n) * time.Second
+	}
+	if n, err := strconv.Atoi(getenv("HTTP_MAX_IDLE_CONNS_PER_HOST")); err == nil && n > 0 {
+		opts.MaxIdleConnsPerHost = n
+	}
+	if n, err := strconv.Atoi(getenv("HTTP_IDLE_CONN_TIMEOUT_SECONDS")); err == nil && n > 0 {
+		opts.IdleConnTimeout = time.Duration(n) * time.Second
+	}
+	if n, err := strconv.Atoi(getenv("HTTP_MAX_REQUESTS_PER_HOST")); err == nil && n >= 0 {
+		opts.MaxRequestsPerHost = n
+	}
+	if n, err := strconv.ParseInt(getenv("HTTP_ERROR_BODY_BYTES"), 10, 64); err == nil && n >= 0 {
+		opts.ErrorBodyBytes = n
+	}
+	return opts
+}
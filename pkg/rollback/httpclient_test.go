@@ -0,0 +1,86 @@
+package rollback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentedHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	host := mustURL(t, srv.URL).Host
+
+	c := newHTTPClient(defaultHTTPClientOptions)
+	before := testutil.ToFloat64(providerRequests.WithLabelValues(host, "GET", "404"))
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := testutil.ToFloat64(providerRequests.WithLabelValues(host, "GET", "404")); got != before+1 {
+		t.Errorf("request counter = %v, want %v", got, before+1)
+	}
+
+	srv.Close()
+	errorsBefore := testutil.ToFloat64(providerRequestErrors.WithLabelValues(host, "GET"))
+	if _, err := c.Get(srv.URL); err == nil {
+		t.Fatal("expected error from closed server")
+	}
+	if got := testutil.ToFloat64(providerRequestErrors.WithLabelValues(host, "GET")); got != errorsBefore+1 {
+		t.Errorf("error counter = %v, want %v", got, errorsBefore+1)
+	}
+}
+
+func TestHTTPClientOptionsFromEnv(t *testing.T) {
+	env := map[string]string{"HTTP_TIMEOUT_SECONDS": "30", "HTTP_MAX_IDLE_CONNS_PER_HOST": "50", "HTTP_IDLE_CONN_TIMEOUT_SECONDS": "bogus", "HTTP_ERROR_BODY_BYTES": "0"}
+	opts := httpClientOptionsFromEnv(func(k string) string { return env[k] })
+	if opts.Timeout != 30*time.Second || opts.MaxIdleConnsPerHost != 50 || opts.IdleConnTimeout != defaultHTTPClientOptions.IdleConnTimeout || opts.ErrorBodyBytes != 0 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+}
+
+func mustURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestProxyHTTPClient(t *testing.T) {
+	var got *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	defer proxy.Close()
+	u := mustURL(t, proxy.URL)
+	u.User = url.UserPassword("egress", "s3cret")
+
+	gl := gitlabProject{BaseURL: "http://gitlab.corp.invalid", ProjectID: "1", Token: "t", Proxy: u.String()}
+	if err := gl.request("GET", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.URL.String() != "http://gitlab.corp.invalid/api/v4/projects/1" {
+		t.Fatalf("proxy received %v, want the project URL", got)
+	}
+	if user, pass, _ := (&http.Request{Header: http.Header{"Authorization": got.Header["Proxy-Authorization"]}}).BasicAuth(); user != "egress" || pass != "s3cret" {
+		t.Errorf("proxy credentials %q:%q", user, pass)
+	}
+	if c, _ := defaultProviderAPI.httpClient(u.String()); c == defaultProviderAPI.client {
+		t.Error("proxied requests use the shared client")
+	}
+	for _, bad := range []string{"ftp://proxy:21", "socks5://", "http://user:pw@[::1"} {
+		if _, err := defaultProviderAPI.httpClient(bad); err == nil || strings.Contains(err.Error(), "pw") {
+			t.Errorf("httpClient(%q): %v", bad, err)
+		}
+	}
+}
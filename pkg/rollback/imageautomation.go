@@ -0,0 +1,236 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// imageAutomationVersions are the served ImageUpdateAutomation versions,
+// newest first.
+var imageAutomationVersions = []string{"v1", "v1beta2"}
+
+// imageAutomationGroupKind is the Flux ImageUpdateAutomation kind.
+var imageAutomationGroupKind = schema.GroupKind{Group: "image.toolkit.fluxcd.io", Kind: "ImageUpdateAutomation"}
+
+// imagePolicyMarker matches the setter comments Flux image automation
+// updates, e.g. # {"$imagepolicy": "flux-system:app"} or
+// # {"$imagepolicy": "flux-system:app:tag"}.
+var imagePolicyMarker = regexp.MustCompile(`#\s*\{\s*"\$imagepolicy"\s*:\s*"([^"]+)"\s*\}`)
+
+// imageBump is one line changed by an image-automation commit.
+type imageBump struct {
+	Path   string
+	Policy string // marker value: <namespace>:<ImagePolicy>[:tag|:name]
+	From   string // line before the commit
+	To     string // line after the commit
+}
+
+// policyNamespace returns the namespace of the bump's ImagePolicy.
+func (b imageBump) policyNamespace() string {
+	ns, _, _ := strings.Cut(b.Policy, ":")
+	return ns
+}
+
+// imageBumps returns the setter lines a commit changed. Removed and added
+// lines are paired per marker in order; a commit without any is not an
+// image-automation bump.
+func imageBumps(diffs []gitlabDiff) []imageBump {
+	var bumps []imageBump
+	for _, d := range diffs {
+		removed := map[string][]string{}
+		for _, line := range strings.Split(d.Diff, "\n") {
+			if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") || line == "" {
+				continue
+			}
+			m := imagePolicyMarker.FindStringSubmatch(line[1:])
+			if m == nil {
+				continue
+			}
+			switch line[0] {
+			case '-':
+				removed[m[1]] = append(removed[m[1]], line[1:])
+			case '+':
+				if from := removed[m[1]]; len(from) > 0 {
+					removed[m[1]] = from[1:]
+					if from[0] != line[1:] {
+						bumps = append(bumps, imageBump{Path: d.NewPath, Policy: m[1], From: from[0], To: line[1:]})
+					}
+				}
+			}
+		}
+	}
+	return bumps
+}
+
+// pinnedImageLine returns the line before the bump with its setter marker
+// renamed to "$imagepolicy-pinned", so image automation leaves it alone
+// until the marker is restored.
+func pinnedImageLine(b imageBump) string {
+	return imagePolicyMarker.ReplaceAllLiteralString(b.From, fmt.Sprintf(`# {"$imagepolicy-pinned": %q}`, b.Policy))
+}
+
+// pinImageLine replaces the bumped line of b in content with its pinned
+// previous value. If the automation bumped the image again since, the only
+// line carrying the same marker is replaced instead.
+func pinImageLine(content []byte, b imageBump) ([]byte, error) {
+	lines := strings.Split(string(content), "\n")
+	match := -1
+	for i, line := range lines {
+		if strings.TrimRight(line, "\r") == b.To {
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		for i, line := range lines {
+			if m := imagePolicyMarker.FindStringSubmatch(line); m != nil && m[1] == b.Policy {
+				if match >= 0 {
+					return nil, fmt.Errorf("%s: several lines use ImagePolicy %s", b.Path, b.Policy)
+				}
+				match = i
+			}
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("%s: no line uses ImagePolicy %s", b.Path, b.Policy)
+	}
+	lines[match] = pinnedImageLine(b)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// pinImages handles a failing revision that is a Flux image-automation tag
+// bump: instead of reverting the commit, which the automation would push
+// again, it pins the bumped images back to their previous tags, by default
+// via an MR, and with suspendAutomation suspends the ImageUpdateAutomations
+// of the bumped ImagePolicies' namespaces. It reports false if the revision
+// is not an image bump or could not be pinned, so the commit is reverted as
+// usual.
+func (r *RollbackController) pinImages(ctx context.Context, gl gitlabProject, res resourceRef, policy *RollbackPolicy, revision string) (*gitlabMergeRequest, bool) {
+	sha := gitCommitSHA(revision)
+	diffs, err := gl.commitDiff(sha)
+	if err != nil {
+		r.log.Error(err, "failed to get commit diff, reverting the commit", "sha", sha)
+		return nil, false
+	}
+	bumps := imageBumps(diffs)
+	if len(bumps) == 0 {
+		return nil, false
+	}
+	var files []string
+	byFile := map[string][]imageBump{}
+	for _, b := range bumps {
+		if _, ok := byFile[b.Path]; !ok {
+			files = append(files, b.Path)
+		}
+		byFile[b.Path] = append(byFile[b.Path], b)
+	}
+	sort.Strings(files)
+	strategy := policy.revertStrategy(RevertStrategyMergeRequest)
+	branch := r.revertBranchName(branchNameData{Action: branchActionPin, Strategy: strategy, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: sha},
+		fmt.Sprintf("%s-pin-%s", r.RevertBranchPrefix, sha))
+	if dryRun() {
+		r.log.Info("ECHO: would pin images", "sha", sha, "files", files, "branch", branch, "strategy", strategy)
+		r.recordAction(gl, recordedAction{Action: "pinImages", Strategy: strategy, Branch: branch, SHA: sha, Namespace: res.Namespace, Name: res.Name, Files: files})
+		r.suspendImageAutomation(ctx, res, policy, bumps, revision)
+		return nil, true
+	}
+
+	target, err := targetBranch(gl, policy)
+	if err != nil {
+		r.log.Error(err, "failed to get default branch")
+		return nil, false
+	}
+	var actions []gitlabCommitAction
+	var changes []string
+	for _, path := range files {
+		content, err := gl.fileRaw(path, target)
+		if err != nil {
+			r.log.Error(err, "failed to read bumped file, reverting the commit", "sha", sha, "path", path)
+			return nil, false
+		}
+		for _, b := range byFile[path] {
+			if content, err = pinImageLine(content, b); err != nil {
+				r.log.Error(err, "failed to pin image, reverting the commit", "sha", sha)
+				return nil, false
+			}
+			changes = append(changes, fmt.Sprintf("- `%s` (ImagePolicy `%s`): `%s` back to `%s`",
+				path, b.Policy, strings.TrimSpace(b.To), strings.TrimSpace(b.From)))
+		}
+		actions = append(actions, gitlabCommitAction{Action: "update", FilePath: path, Content: string(content)})
+	}
+
+	title := fmt.Sprintf("Pin images bumped by %s", sha)
+	mr := gitlabMergeRequestOptions{
+		Title: title,
+		Description: fmt.Sprintf("Flux resources failed after the image update %s; pinning the images back:\n\n%s\n\n"+
+			"Image automation skips the pinned lines. Restore their `$imagepolicy` marker once a fixed image is available.",
+			revision, strings.Join(changes, "\n")),
+	}
+	if strategy == RevertStrategyMergeRequest {
+		r.prepareMergeRequest(gl, res, policy, sha, &mr)
+	}
+	created, err := deliverChange(gl, strategy, branch, target, mr, commitFiles(gl, title, actions))
+	if err != nil {
+		r.log.Error(err, "failed to deliver pinned images", "sha", sha, "branch", branch, "strategy", strategy)
+		r.recordRevertFailure(res, revision, err)
+		return nil, true
+	}
+	r.log.Info("Images pinned successfully", "sha", sha, "files", files, "strategy", strategy, "mr", created.webURL())
+	r.reportBadCommit(gl, res, sha, created)
+	r.suspendImageAutomation(ctx, res, policy, bumps, revision)
+	return created, true
+}
+
+// suspendImageAutomation suspends the ImageUpdateAutomations in the
+// namespaces of the bumped ImagePolicies if the policy asks for it, so no
+// newer tag is pushed until someone resumes them, e.g. with
+// "flux resume image update". They are annotated like suspended resources.
+func (r *RollbackController) suspendImageAutomation(ctx context.Context, res resourceRef, policy *RollbackPolicy, bumps []imageBump, revision string) {
+	if !policy.suspendImageAutomation() {
+		return
+	}
+	gvk := imageAutomationGroupKind.WithVersion(imageAutomationVersions[0])
+	if m, err := r.RESTMapper().RESTMapping(imageAutomationGroupKind, imageAutomationVersions...); err == nil {
+		gvk = m.GroupVersionKind
+	}
+	seen := map[string]bool{}
+	for _, b := range bumps {
+		ns := b.policyNamespace()
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.List(ctx, list, client.InNamespace(ns)); err != nil {
+			r.log.Error(err, "failed to list ImageUpdateAutomations", "namespace", ns)
+			continue
+		}
+		for i := range list.Items {
+			iua := &list.Items[i]
+			if suspended, _, _ := unstructured.NestedBool(iua.Object, "spec", "suspend"); suspended {
+				continue
+			}
+			if dryRun() {
+				r.log.Info("ECHO: would suspend ImageUpdateAutomation", "namespace", iua.GetNamespace(), "name", iua.GetName())
+				continue
+			}
+			if err := r.suspendObject(ctx, iua, revision); err != nil {
+				r.log.Error(err, "failed to suspend ImageUpdateAutomation", "namespace", iua.GetNamespace(), "name", iua.GetName())
+				continue
+			}
+			r.log.Info("ImageUpdateAutomation suspended", "namespace", iua.GetNamespace(), "name", iua.GetName(), "sha", revision)
+			r.mu.Lock()
+			r.recordAudit(auditSuspended, res.Kind, res.Namespace, res.Name, revision,
+				fmt.Sprintf("ImageUpdateAutomation %s/%s", iua.GetNamespace(), iua.GetName()))
+			r.mu.Unlock()
+		}
+	}
+}
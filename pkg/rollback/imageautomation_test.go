@@ -0,0 +1,139 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const imageBumpDiff = `@@ -10,7 +10,7 @@ spec:
+       containers:
+         - name: web
+-          image: registry.example.com/web:1.4.0 # {"$imagepolicy": "flux-system:web"}
++          image: registry.example.com/web:1.5.0 # {"$imagepolicy": "flux-system:web"}
+         - name: sidecar
+-          tag: 0.9.1 # {"$imagepolicy": "flux-system:proxy:tag"}
++          tag: 0.9.2 # {"$imagepolicy": "flux-system:proxy:tag"}
+-      replicas: 2
++      replicas: 3
+`
+
+func TestImageBumps(t *testing.T) {
+	got := imageBumps([]gitlabDiff{{NewPath: "apps/web.yaml", Diff: imageBumpDiff}, {NewPath: "README.md", Diff: "@@ -1 +1 @@\n-a\n+b\n"}})
+	want := []imageBump{
+		{Path: "apps/web.yaml", Policy: "flux-system:web",
+			From: `          image: registry.example.com/web:1.4.0 # {"$imagepolicy": "flux-system:web"}`,
+			To:   `          image: registry.example.com/web:1.5.0 # {"$imagepolicy": "flux-system:web"}`},
+		{Path: "apps/web.yaml", Policy: "flux-system:proxy:tag",
+			From: `          tag: 0.9.1 # {"$imagepolicy": "flux-system:proxy:tag"}`,
+			To:   `          tag: 0.9.2 # {"$imagepolicy": "flux-system:proxy:tag"}`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imageBumps = %+v, want %+v", got, want)
+	}
+	if got := imageBumps([]gitlabDiff{{NewPath: "x.yaml", Diff: "@@ -1 +1 @@\n-replicas: 2\n+replicas: 3\n"}}); len(got) != 0 {
+		t.Errorf("a commit without setter markers is no image bump: %+v", got)
+	}
+}
+
+func TestPinImageLine(t *testing.T) {
+	b := imageBumps([]gitlabDiff{{NewPath: "apps/web.yaml", Diff: imageBumpDiff}})[0]
+	pinned := `          image: registry.example.com/web:1.4.0 # {"$imagepolicy-pinned": "flux-system:web"}`
+	content := "spec:\n" + b.To + "\n"
+	got, err := pinImageLine([]byte(content), b)
+	if err != nil || string(got) != "spec:\n"+pinned+"\n" {
+		t.Errorf("pinImageLine = %q, %v", got, err)
+	}
+	// Bumped again since: the line with the same marker is pinned.
+	again := strings.Replace(content, "1.5.0", "1.5.1", 1)
+	if got, err := pinImageLine([]byte(again), b); err != nil || string(got) != "spec:\n"+pinned+"\n" {
+		t.Errorf("pinImageLine after another bump = %q, %v", got, err)
+	}
+	if _, err := pinImageLine([]byte(again+again), b); err == nil {
+		t.Error("ambiguous markers should fail")
+	}
+	if _, err := pinImageLine([]byte("spec: {}\n"), b); err == nil {
+		t.Error("a file without the marker should fail")
+	}
+}
+
+func TestPinImages(t *testing.T) {
+	var committed map[string]interface{}
+	var mrTitle string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method + " " + req.URL.Path {
+		case "GET /api/v4/projects/42/repository/commits/abc/diff":
+			_ = json.NewEncoder(w).Encode([]gitlabDiff{{NewPath: "apps/web.yaml", Diff: imageBumpDiff}})
+		case "GET /api/v4/projects/42":
+			fmt.Fprint(w, `{"default_branch":"main"}`)
+		case "GET /api/v4/projects/42/repository/files/apps/web.yaml/raw":
+			fmt.Fprint(w, "spec:\n"+
+				`          image: registry.example.com/web:1.5.0 # {"$imagepolicy": "flux-system:web"}`+"\n"+
+				`          tag: 0.9.2 # {"$imagepolicy": "flux-system:proxy:tag"}`+"\n")
+		case "POST /api/v4/projects/42/repository/commits":
+			_ = json.NewDecoder(req.Body).Decode(&committed)
+			fmt.Fprint(w, `{}`)
+		case "POST /api/v4/projects/42/merge_requests":
+			var body map[string]interface{}
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			mrTitle, _ = body["title"].(string)
+			fmt.Fprint(w, `{"iid":9,"web_url":"https://gitlab/mr/9"}`)
+		default:
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			http.Error(w, "unexpected", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	iua := &unstructured.Unstructured{}
+	iua.SetGroupVersionKind(imageAutomationGroupKind.WithVersion("v1"))
+	iua.SetNamespace("flux-system")
+	iua.SetName("apps")
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(iua).Build()
+	r := NewRollbackController(c, logr.Discard(), "token", "42", srv.URL, "revert", 0)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{ImageAutomation: &ImageAutomationSpec{SuspendAutomation: true}}}
+
+	mr, ok := r.pinImages(ctx, r.defaultProject(), res, policy, "main@sha1:abc")
+	if !ok || mr == nil || mr.IID != 9 {
+		t.Fatalf("pinImages = %+v, %v", mr, ok)
+	}
+	if mrTitle != "Pin images bumped by abc" || committed["branch"] != "revert-pin-abc" {
+		t.Errorf("MR %q from branch %v", mrTitle, committed["branch"])
+	}
+	actions, _ := committed["actions"].([]interface{})
+	if len(actions) != 1 {
+		t.Fatalf("commit actions = %+v", committed["actions"])
+	}
+	content, _ := actions[0].(map[string]interface{})["content"].(string)
+	if !strings.Contains(content, `web:1.4.0 # {"$imagepolicy-pinned": "flux-system:web"}`) ||
+		!strings.Contains(content, `tag: 0.9.1 # {"$imagepolicy-pinned": "flux-system:proxy:tag"}`) {
+		t.Errorf("pinned content = %q", content)
+	}
+
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(iua.GroupVersionKind())
+	if err := c.Get(ctx, client.ObjectKeyFromObject(iua), got); err != nil {
+		t.Fatal(err)
+	}
+	if suspended, _, _ := unstructured.NestedBool(got.Object, "spec", "suspend"); !suspended || got.GetAnnotations()[suspendedAnnotation] != "main@sha1:abc" {
+		t.Errorf("ImageUpdateAutomation not suspended: %+v", got.Object)
+	}
+	if n := len(r.auditLog); n != 1 || r.auditLog[0].Event != auditSuspended || r.auditLog[0].Message != "ImageUpdateAutomation flux-system/apps" {
+		t.Errorf("audit log = %+v", r.auditLog)
+	}
+}
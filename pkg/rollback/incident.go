@@ -0,0 +1,240 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Incident statuses.
+const (
+	incidentOpen     = "open"
+	incidentReverted = "reverted"
+	incidentSkipped  = "skipped"
+	incidentResolved = "resolved"
+)
+
+// maxResolvedIncidents bounds the resolved incidents kept for the dashboard.
+const maxResolvedIncidents = 50
+
+// notificationQueueSize bounds the incident updates buffered for the
+// notifier; further updates are dropped so a slow chat API never blocks
+// reconciles.
+const notificationQueueSize = 100
+
+// incident groups the lifecycle events of all resources failing on one bad
+// revision, so notifications form one thread instead of a message per event.
+type incident struct {
+	ID        string          `json:"id"`
+	Revision  string          `json:"revision"`
+	Status    string          `json:"status"`
+	Opened    time.Time       `json:"opened"`
+	Resolved  *time.Time      `json:"resolved,omitempty"`
+	Resources []string        `json:"resources"` // failing, "Kind/namespace/name"
+	Events    []incidentEvent `json:"events"`
+	// Verification is the result of the rollback verification Job:
+	// succeeded or failed.
+	Verification string `json:"verification,omitempty"`
+}
+
+// incidentEvent is one lifecycle event of an incident.
+type incidentEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Resource string    `json:"resource"`
+}
+
+// incidentUpdate is queued for the notifier after every event.
+type incidentUpdate struct {
+	Incident incident // copy at the time of the event
+	Event    incidentEvent
+}
+
+// incidentTracker groups events into incidents keyed by commit SHA. It has
+// its own lock because emitEvent runs with and without r.mu held.
+type incidentTracker struct {
+	mu       sync.Mutex
+	open     map[string]*incident // gitCommitSHA(revision) -> incident
+	resolved []incident           // oldest first
+}
+
+func newIncidentTracker() *incidentTracker {
+	return &incidentTracker{open: make(map[string]*incident)}
+}
+
+// observe adds a lifecycle event to the incident of sha, opening one if
+// needed, and returns a copy of the incident. A recovery closes the
+// incident once none of its resources is failing any more, a merged revert
+// MR (CLEANUP_MERGED_REVERTS) right away. Verification results and
+// recoveries arriving after that are added to the resolved incident.
+func (t *incidentTracker) observe(now time.Time, event string, res resourceRef, sha string) (incident, incidentEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := gitCommitSHA(sha)
+	inc, ok := t.open[key]
+	if !ok && (event == auditVerified || event == auditVerificationFailed || event == auditRecovered || event == auditCancelled) {
+		for i := len(t.resolved) - 1; i >= 0; i-- {
+			if gitCommitSHA(t.resolved[i].Revision) == key {
+				inc, ok = &t.resolved[i], true
+				break
+			}
+		}
+	}
+	if !ok {
+		inc = &incident{ID: incidentID(key, now), Revision: sha, Status: incidentOpen, Opened: now}
+		t.open[key] = inc
+	}
+	ev := incidentEvent{Time: now, Event: event, Resource: res.String()}
+	inc.Events = append(inc.Events, ev)
+	switch event {
+	case auditDetected:
+		inc.Resources = addString(inc.Resources, res.String())
+	case auditReverted, auditAutoMerged, auditHelmRolledBack:
+		inc.Status = incidentReverted
+	case auditSkipped:
+		inc.Status = incidentSkipped
+	case auditVerified:
+		inc.Verification = verificationSucceeded
+	case auditVerificationFailed:
+		inc.Verification = verificationFailed
+	case auditMerged:
+		t.resolve(key, inc, now)
+	case auditRecovered, auditCancelled:
+		inc.Resources = removeString(inc.Resources, res.String())
+		if len(inc.Resources) == 0 {
+			t.resolve(key, inc, now)
+		}
+	}
+	return inc.copy(), ev
+}
+
+// resolve closes inc if it is the open incident of key.
+func (t *incidentTracker) resolve(key string, inc *incident, now time.Time) {
+	if t.open[key] != inc {
+		return
+	}
+	inc.Status = incidentResolved
+	inc.Resolved = &now
+	delete(t.open, key)
+	t.resolved = append(t.resolved, *inc)
+	if n := len(t.resolved); n > maxResolvedIncidents {
+		t.resolved = append([]incident(nil), t.resolved[n-maxResolvedIncidents:]...)
+	}
+}
+
+// list returns all open and the recently resolved incidents, newest first.
+func (t *incidentTracker) list() []incident {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []incident
+	for _, inc := range t.open {
+		out = append(out, inc.copy())
+	}
+	for _, inc := range t.resolved {
+		out = append(out, inc.copy())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Opened.After(out[j].Opened) })
+	return out
+}
+
+func (inc *incident) copy() incident {
+	c := *inc
+	c.Resources = append([]string(nil), inc.Resources...)
+	c.Events = append([]incidentEvent(nil), inc.Events...)
+	return c
+}
+
+// incidentID returns a short, readable ID such as "INC-1a2b3c4-1700000000".
+func incidentID(sha string, opened time.Time) string {
+	if len(sha) > 7 {
+		sha = sha[:7]
+	}
+	return fmt.Sprintf("INC-%s-%d", sha, opened.Unix())
+}
+
+func addString(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// trackIncident records a lifecycle event in its incident, queues the
+// update for the notifier and returns the incident ID. It never blocks.
+func (r *RollbackController) trackIncident(event, kind, namespace, name, sha string) string {
+	inc, ev := r.incidents.observe(r.clock.Now(), event, resourceRef{Kind: kind, Namespace: namespace, Name: name}, sha)
+	if r.notifications != nil {
+		select {
+		case r.notifications <- incidentUpdate{Incident: inc, Event: ev}:
+		default:
+			r.log.Info("Notification queue full, dropping incident update", "incident", inc.ID, "event", event)
+		}
+	}
+	return inc.ID
+}
+
+// revertURL returns the MR link recorded for the revert of sha, or "".
+func (r *RollbackController) revertURL(sha string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.reverts) - 1; i >= 0; i-- {
+		if r.reverts[i].SHA == sha {
+			return r.reverts[i].URL
+		}
+	}
+	return ""
+}
+
+// runIncidentNotifier delivers incident updates in order to the incident's
+// recipients (see incidentRecipients): the first update of an incident opens
+// its thread or card, later ones reply to or update it. Delivery failures
+// are logged; an incident whose thread could not be opened is retried with
+// its next update. n is INCIDENT_NOTIFIER and may be nil.
+func (r *RollbackController) runIncidentNotifier(ctx context.Context, n incidentNotifier) error {
+	type thread struct{ incident, recipient string }
+	threads := make(map[thread]string) // -> notifier reference
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case u := <-r.notifications:
+			view := incidentView{incident: u.Incident, clusterMetadata: r.Metadata, RevertURL: r.revertURL(u.Incident.Revision)}
+			for key, notifier := range r.incidentRecipients(ctx, u.Incident, n) {
+				t := thread{u.Incident.ID, key}
+				ref, ok := threads[t]
+				if !ok {
+					var err error
+					if ref, err = notifier.open(ctx, view); err != nil {
+						r.log.Error(err, "failed to open incident notification", "incident", u.Incident.ID, "recipient", key)
+						continue
+					}
+					threads[t] = ref
+				}
+				if err := notifier.update(ctx, ref, view, u.Event); err != nil {
+					r.log.Error(err, "failed to update incident notification", "incident", u.Incident.ID, "recipient", key, "event", u.Event.Event)
+				}
+			}
+			if u.Incident.Status == incidentResolved {
+				for t := range threads {
+					if t.incident == u.Incident.ID {
+						delete(threads, t)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIncidentGroupsEventsBySHA(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.events = make(chan cloudEvent, eventQueueSize)
+	r.emitEvent(auditDetected, "Kustomization", "apps", "web", "main@sha1:abc")
+	r.emitEvent(auditDetected, "HelmRelease", "apps", "api", "abc")
+	r.emitEvent(auditReverted, "Kustomization", "apps", "web", "main@sha1:abc")
+	r.emitEvent(auditDetected, "Kustomization", "apps", "db", "def")
+	r.emitEvent(auditRecovered, "Kustomization", "apps", "web", "main@sha1:abc")
+
+	incidents := r.incidents.list()
+	if len(incidents) != 2 {
+		t.Fatalf("got %d incidents, want one per SHA: %+v", len(incidents), incidents)
+	}
+	var abc incident
+	for _, inc := range incidents {
+		if inc.Revision == "main@sha1:abc" {
+			abc = inc
+		}
+	}
+	if abc.Status != incidentReverted || len(abc.Events) != 4 || strings.Join(abc.Resources, ",") != "HelmRelease/apps/api" {
+		t.Errorf("unexpected incident %+v", abc)
+	}
+	ids := map[string]bool{}
+	for len(r.events) > 0 {
+		ids[(<-r.events).IncidentID] = true
+	}
+	if len(ids) != 2 || !ids[abc.ID] {
+		t.Errorf("CloudEvents carry incident IDs %v, want two including %s", ids, abc.ID)
+	}
+
+	r.emitEvent(auditRecovered, "HelmRelease", "apps", "api", "abc")
+	for _, inc := range r.incidents.list() {
+		if inc.ID == abc.ID && (inc.Status != incidentResolved || inc.Resolved == nil) {
+			t.Errorf("incident not resolved after the last recovery: %+v", inc)
+		}
+	}
+	r.emitEvent(auditDetected, "HelmRelease", "apps", "api", "abc")
+	if got := r.incidents.list(); len(got) != 3 {
+		t.Errorf("a new failure after resolution must open a new incident, got %+v", got)
+	}
+}
+
+func TestSlackIncidentThread(t *testing.T) {
+	var calls []string
+	received := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer xoxb-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var body map[string]string
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		method := strings.TrimPrefix(req.URL.Path, "/")
+		calls = append(calls, method+" "+body["thread_ts"]+body["ts"])
+		_, _ = w.Write([]byte(`{"ok":true,"channel":"C123","ts":"1700.01"}`))
+		received <- struct{}{}
+	}))
+	defer srv.Close()
+	defer func(u string) { slackAPIURL = u }(slackAPIURL)
+	slackAPIURL = srv.URL
+	t.Setenv("SLACK_TOKEN", "xoxb-test")
+	n, err := newIncidentNotifier("slack://deployments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRollbackController(fake.NewClientBuilder().WithScheme(newScheme()).Build(), logr.Discard(), "", "", "", "revert", 0)
+	r.notifications = make(chan incidentUpdate, notificationQueueSize)
+	r.emitEvent(auditDetected, "Kustomization", "apps", "web", "abc")
+	r.emitEvent(auditReverted, "Kustomization", "apps", "web", "abc")
+	r.emitEvent(auditRecovered, "Kustomization", "apps", "web", "abc")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = r.runIncidentNotifier(ctx, n)
+		close(done)
+	}()
+	want := []string{
+		"chat.postMessage ",
+		"chat.postMessage 1700.01", "chat.update 1700.01",
+		"chat.postMessage 1700.01", "chat.update 1700.01",
+		"chat.postMessage 1700.01", "chat.update 1700.01",
+	}
+	for range want {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for Slack calls")
+		}
+	}
+	cancel()
+	<-done
+	if strings.Join(calls, "|") != strings.Join(want, "|") {
+		t.Errorf("Slack calls %q, want one top message and threaded replies %q", calls, want)
+	}
+}
@@ -0,0 +1,115 @@
+package rollback
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// instanceAnnotation carries the controller instance on the Kubernetes
+// Events it records and on the resources it annotates with a revert MR.
+const instanceAnnotation = "rollback.eumel8.io/instance"
+
+// instanceMarker is the hidden line in MR descriptions naming the instance
+// that opened the MR.
+var instanceMarker = regexp.MustCompile(`<!-- rollback-controller-instance: (.*?) -->`)
+
+// instanceID returns the identity of the controller instance
+// (RollbackController.Instance), so organizations running it in several
+// clusters against one repository can tell which cluster requested a
+// revert. It is override if set, otherwise "<cluster>/<deployment>", the
+// deployment defaulting to "rollback-controller". Without either an
+// override or a cluster name it is empty.
+func instanceID(override, cluster, deployment string) string {
+	if override != "" || cluster == "" {
+		return override
+	}
+	if deployment == "" {
+		deployment = "rollback-controller"
+	}
+	return cluster + "/" + deployment
+}
+
+// stampInstance appends the requesting instance to an MR description,
+// unless it already names one, e.g. when an MR is re-created. An empty
+// instance, for a single cluster, stamps nothing.
+func stampInstance(instance, description string) string {
+	if instance == "" || instanceMarker.MatchString(description) {
+		return description
+	}
+	return fmt.Sprintf("%s\n\nRequested by rollback-controller instance `%s`.\n<!-- rollback-controller-instance: %s -->",
+		description, instance, instance)
+}
+
+// descriptionInstance returns the instance an MR description names, or "".
+func descriptionInstance(description string) string {
+	if m := instanceMarker.FindStringSubmatch(description); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// existingRevert looks for a revert of sha that is already requested, by
+// this or another instance, e.g. a controller in another cluster reconciling
+// the same repository: an open MR titled "Revert <sha>" or the revert
+// branch. It returns the MR, if there is one, and the instance that opened
+// it, if known.
+func (g gitlabProject) existingRevert(sha, branch string) (mr *gitlabMergeRequest, instance string, found bool, err error) {
+	path := fmt.Sprintf("/merge_requests?state=opened&in=title&search=%s", url.QueryEscape(sha))
+	mrs, err := listPages[gitlabMergeRequest](g, path, g.api.orDefault().discovery)
+	if err != nil {
+		return nil, "", false, err
+	}
+	for i := range mrs {
+		if strings.TrimPrefix(mrs[i].Title, "Draft: ") == "Revert "+sha {
+			return &mrs[i], descriptionInstance(mrs[i].Description), true, nil
+		}
+	}
+	if branch == "" {
+		return nil, "", false, nil
+	}
+	err = g.request("GET", "/repository/branches/"+url.PathEscape(branch), nil, nil)
+	var pe *providerError
+	if errors.As(err, &pe) && pe.StatusCode == http.StatusNotFound {
+		return nil, "", false, nil
+	}
+	return nil, "", err == nil, err
+}
+
+// revertRequested reports whether the revert of badSHA for res is already
+// requested in GitLab (see existingRevert) and records it as
+// alreadyRequested if so. Lookup failures are logged and let the revert go
+// ahead. Without an instance identity it checks nothing.
+func (r *RollbackController) revertRequested(gl gitlabProject, res resourceRef, badSHA, strategy, branch string) bool {
+	if r.Instance == "" {
+		return false
+	}
+	if strategy == RevertStrategyDirect {
+		branch = ""
+	}
+	mr, owner, found, err := gl.existingRevert(gitCommitSHA(badSHA), branch)
+	if err != nil {
+		r.log.Error(err, "failed to look for an existing revert", "sha", badSHA, "branch", branch)
+		return false
+	}
+	if !found {
+		return false
+	}
+	where := "branch " + branch
+	if mr != nil {
+		where = mr.WebURL
+	}
+	if owner == "" {
+		owner = "an unknown instance"
+	}
+	r.log.Info("Revert already requested, not creating another", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name,
+		"sha", badSHA, "requestedBy", owner, "at", where)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordAudit(auditAlreadyRequested, res.Kind, res.Namespace, res.Name, badSHA, fmt.Sprintf("requested by %s: %s", owner, where))
+	r.emitEvent(auditAlreadyRequested, res.Kind, res.Namespace, res.Name, badSHA)
+	return true
+}
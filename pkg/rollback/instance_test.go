@@ -0,0 +1,63 @@
+package rollback
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestInstanceID(t *testing.T) {
+	for _, tt := range []struct{ override, cluster, deployment, want string }{
+		{"", "", "flux-rollback-agent", ""},
+		{"", "prod-eu", "", "prod-eu/rollback-controller"},
+		{"", "prod-eu", "flux-rollback-agent", "prod-eu/flux-rollback-agent"},
+		{"eu-1", "prod-eu", "flux-rollback-agent", "eu-1"},
+	} {
+		if got := instanceID(tt.override, tt.cluster, tt.deployment); got != tt.want {
+			t.Errorf("instanceID(%q, %q, %q) = %q, want %q", tt.override, tt.cluster, tt.deployment, got, tt.want)
+		}
+	}
+
+	const instance = "prod-eu/flux-rollback-agent"
+	desc := stampInstance(instance, "Flux resources failed.")
+	if descriptionInstance(desc) != instance || stampInstance(instance, desc) != desc {
+		t.Errorf("stamped description %q", desc)
+	}
+}
+
+func TestRevertAlreadyRequested(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	existing := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method + " " + req.URL.Path {
+		case "GET /api/v4/projects/42/merge_requests":
+			fmt.Fprint(w, existing)
+		case "GET /api/v4/projects/42/repository/branches/revert-" + sha:
+			http.NotFound(w, req)
+		default:
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+	}))
+	defer srv.Close()
+	r := NewRollbackController(nil, logr.Discard(), "token", "42", srv.URL, "revert", 300)
+	r.Instance = "prod-us/flux-rollback-agent"
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+
+	existing = `[{"iid":3,"web_url":"https://gitlab/mr/3","title":"Revert ` + sha + `",` +
+		`"description":"Flux resources failed.\n<!-- rollback-controller-instance: prod-eu/flux-rollback-agent -->"}]`
+	if mr := r.createGitlabRevertMR(r.defaultProject(), res, &RollbackPolicy{}, "main@sha1:"+sha); mr != nil {
+		t.Errorf("created %+v over the MR of another instance", mr)
+	}
+	if n := len(r.auditLog); n != 1 || r.auditLog[0].Event != auditAlreadyRequested ||
+		r.auditLog[0].Message != "requested by prod-eu/flux-rollback-agent: https://gitlab/mr/3" {
+		t.Errorf("audit log %+v", r.auditLog)
+	}
+
+	existing = `[{"iid":4,"title":"Revert 0123456 partially"}]`
+	if _, _, found, err := r.defaultProject().existingRevert(sha, "revert-"+sha); found || err != nil {
+		t.Errorf("similar MR title and missing branch: found %v, %v", found, err)
+	}
+}
@@ -0,0 +1,274 @@
+//go:build integration
+
+package rollback
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fluxRelease is one entry of the Flux API matrix. Its CRDs are downloaded
+// to test/crds/flux-<Flux> by "make test-crds" (FLUX_CRD_MATRIX); the fields
+// describe what the controller must find in them.
+type fluxRelease struct {
+	Flux          string
+	Kustomization string // served Kustomization version picked by servedFluxAPIs
+	HelmRelease   string
+	Source        string
+	// Revision formats the Git revision as the release reports it: pre-GA
+	// "main/<sha>" or RFC-0005 "main@sha1:<sha>".
+	Revision func(sha string) string
+	// Progressing is the Ready condition while a new revision is applied;
+	// it must not count as a failure.
+	Progressing map[string]interface{}
+}
+
+func legacyRevision(sha string) string { return "main/" + sha }
+func gaRevision(sha string) string     { return "main@sha1:" + sha }
+
+var fluxMatrix = []fluxRelease{
+	{Flux: "v0.37.0", Kustomization: "v1beta2", HelmRelease: "v2beta1", Source: "v1beta2", Revision: legacyRevision,
+		Progressing: condition("Ready", "Unknown", "Progressing")},
+	{Flux: "v2.0.0", Kustomization: "v1", HelmRelease: "v2beta1", Source: "v1", Revision: gaRevision,
+		Progressing: condition("Ready", "Unknown", "Progressing")},
+	{Flux: "v2.2.0", Kustomization: "v1", HelmRelease: "v2beta2", Source: "v1", Revision: gaRevision,
+		Progressing: condition("Ready", "Unknown", "Progressing")},
+	{Flux: "v2.3.0", Kustomization: "v1", HelmRelease: "v2", Source: "v1", Revision: gaRevision,
+		Progressing: condition("Ready", "Unknown", "Progressing")},
+	{Flux: "v2.6.0", Kustomization: "v1", HelmRelease: "v2", Source: "v1", Revision: gaRevision,
+		Progressing: condition("Ready", "Unknown", "ProgressingWithRetry")},
+}
+
+// condition returns a status condition valid for every Flux CRD version,
+// all of which require these fields.
+func condition(typ, status, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": typ, "status": status, "reason": reason, "message": reason,
+		"lastTransitionTime": "2024-01-01T10:00:00Z",
+	}
+}
+
+// TestFluxAPIMatrix runs the controller's status parsing against the CRDs of
+// several Flux releases on a real API server (envtest). Run it with
+// "make test-integration", which downloads the CRDs and the envtest binaries.
+func TestFluxAPIMatrix(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set; run make test-integration")
+	}
+	for _, rel := range fluxMatrix {
+		t.Run("flux-"+rel.Flux, func(t *testing.T) {
+			dir := filepath.Join("..", "..", "test", "crds", "flux-"+rel.Flux)
+			if _, err := os.Stat(dir); err != nil {
+				t.Fatalf("CRDs of Flux %s missing (%v); run make test-crds", rel.Flux, err)
+			}
+			env := &envtest.Environment{CRDDirectoryPaths: []string{dir}, ErrorIfCRDPathMissing: true}
+			cfg, err := env.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = env.Stop() }()
+			c, err := client.New(cfg, client.Options{Scheme: newScheme()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			testFluxRelease(t, c, rel)
+		})
+	}
+}
+
+func testFluxRelease(t *testing.T, c client.Client, rel fluxRelease) {
+	const (
+		ns       = "default"
+		sha      = "0123456789abcdef0123456789abcdef01234567"
+		fixedSHA = "89abcdef0123456789abcdef0123456789abcdef"
+	)
+	ctx := context.Background()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	r.APIs = servedFluxAPIs(c.RESTMapper())
+	if got := r.APIs.Kustomization.Version; got != rel.Kustomization {
+		t.Errorf("served Kustomization version %s, want %s", got, rel.Kustomization)
+	}
+	if got := r.APIs.HelmRelease.Version; got != rel.HelmRelease {
+		t.Errorf("served HelmRelease version %s, want %s", got, rel.HelmRelease)
+	}
+	if got := r.APIs.Source.Version; got != rel.Source {
+		t.Errorf("served source version %s, want %s", got, rel.Source)
+	}
+	src := func(kind string) schema.GroupVersionKind { return r.APIs.Source.WithKind(kind) }
+
+	repo := fluxObject(src("GitRepository"), ns, "apps", map[string]interface{}{
+		"interval": "1m", "url": "https://git.example.com/apps.git",
+	})
+	createWithStatus(t, c, repo, map[string]interface{}{
+		"artifact": map[string]interface{}{
+			"path": "gitrepository/default/apps/" + sha + ".tar.gz", "url": "http://source-controller/apps.tar.gz",
+			"revision": rel.Revision(sha), "lastUpdateTime": "2024-01-01T10:00:00Z",
+		},
+	})
+	chart := fluxObject(src("HelmChart"), ns, ns+"-web", map[string]interface{}{
+		"interval": "1m", "chart": "./charts/web",
+		"sourceRef": map[string]interface{}{"kind": "GitRepository", "name": "apps"},
+	})
+	createWithStatus(t, c, chart, map[string]interface{}{"observedSourceArtifactRevision": rel.Revision(sha)})
+
+	ks := fluxObject(r.APIs.Kustomization, ns, "apps", map[string]interface{}{
+		"interval": "10m", "prune": true, "path": "./apps",
+		"sourceRef": map[string]interface{}{"kind": "GitRepository", "name": "apps"},
+	})
+	createWithStatus(t, c, ks, map[string]interface{}{
+		"lastAttemptedRevision": rel.Revision(sha),
+		"lastAppliedRevision":   rel.Revision(fixedSHA),
+		"conditions":            []interface{}{condition("Ready", "False", "ReconciliationFailed")},
+	})
+	hr := fluxObject(r.APIs.HelmRelease, ns, "web", map[string]interface{}{
+		"interval": "10m",
+		"chart": map[string]interface{}{"spec": map[string]interface{}{
+			"chart":     "./charts/web",
+			"sourceRef": map[string]interface{}{"kind": "GitRepository", "name": "apps"},
+		}},
+	})
+	createWithStatus(t, c, hr, map[string]interface{}{
+		"lastAttemptedRevision": "1.2.0",
+		"helmChart":             ns + "/" + ns + "-web",
+		"conditions":            []interface{}{condition("Ready", "False", "UpgradeFailed")},
+	})
+
+	reconcile := func(name string) {
+		t.Helper()
+		req := ctrl.Request{}
+		req.Namespace, req.Name = ns, name
+		if _, err := (&GenericReconciler{r}).Reconcile(ctx, req); err != nil {
+			t.Fatalf("reconcile %s: %v", name, err)
+		}
+	}
+	expect := func(kind, name string, ready bool) {
+		t.Helper()
+		st := r.resources[kind+"/"+ns+"/"+name]
+		if st == nil {
+			t.Fatalf("%s %s not tracked", kind, name)
+		}
+		if st.Ready != ready || gitCommitSHA(st.Revision) != sha {
+			t.Errorf("%s %s: ready %v on %q, want ready %v on %s", kind, name, st.Ready, st.Revision, ready, sha)
+		}
+	}
+	reconcile("apps")
+	expect("Kustomization", "apps", false)
+	reconcile("web")
+	expect("HelmRelease", "web", false)
+	if n := len(r.debounce.Pending()); n != 1 {
+		t.Errorf("pending failures = %+v, want the shared revision once", r.debounce.Pending())
+	}
+
+	// A new revision being applied is not a failure.
+	setStatus(t, c, ks, "conditions", []interface{}{rel.Progressing})
+	reconcile("apps")
+	expect("Kustomization", "apps", true)
+}
+
+// fluxObject returns an unstructured Flux object of gvk.
+func fluxObject(gvk schema.GroupVersionKind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	u.SetGroupVersionKind(gvk)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+// createWithStatus creates u and then sets its status subresource.
+func createWithStatus(t *testing.T, c client.Client, u *unstructured.Unstructured, status map[string]interface{}) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.Create(ctx, u); err != nil {
+		t.Fatalf("create %s %s: %v", u.GetKind(), u.GetName(), err)
+	}
+	u.Object["status"] = status
+	if err := c.Status().Update(ctx, u); err != nil {
+		t.Fatalf("update status of %s %s: %v", u.GetKind(), u.GetName(), err)
+	}
+}
+
+// setStatus sets one status field of u.
+func setStatus(t *testing.T, c client.Client, u *unstructured.Unstructured, field string, value interface{}) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.Get(ctx, client.ObjectKeyFromObject(u), u); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(u.Object, value, "status", field); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Status().Update(ctx, u); err != nil {
+		t.Fatalf("update status of %s %s: %v", u.GetKind(), u.GetName(), err)
+	}
+}
+
+// TestSetupWithManager embeds the controller in a manager that runs another
+// controller, as a platform team's manager binary would.
+func TestSetupWithManager(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set; run make test-integration")
+	}
+	rel := fluxMatrix[len(fluxMatrix)-1]
+	env := &envtest.Environment{CRDDirectoryPaths: []string{filepath.Join("..", "..", "test", "crds", "flux-"+rel.Flux)}, ErrorIfCRDPathMissing: true}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = env.Stop() }()
+
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme, Metrics: metricsserver.Options{BindAddress: "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(chan string, 10)
+	if err := ctrl.NewControllerManagedBy(mgr).Named("platform").For(&corev1.ConfigMap{}).
+		Complete(reconcile.Func(func(_ context.Context, req ctrl.Request) (ctrl.Result, error) {
+			seen <- req.Name
+			return ctrl.Result{}, nil
+		})); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetupWithManager(mgr, Options{Namespace: "default", Log: logr.Discard(), MaxConcurrentReconciles: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- mgr.Start(ctx) }()
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "platform"}}
+	if err := mgr.GetClient().Create(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-seen:
+	case err := <-done:
+		t.Fatalf("manager stopped: %v", err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("the platform controller did not run next to the rollback controller")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+package rollback
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// resourceRef identifies the Flux resource a revert is for.
+type resourceRef struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (r resourceRef) String() string {
+	return r.Kind + "/" + r.Namespace + "/" + r.Name
+}
+
+// linkTemplate renders a deep link to a resource, e.g. a Grafana dashboard
+// or a GitOps UI page.
+type linkTemplate struct {
+	Title string
+	URL   *template.Template
+}
+
+// linkData is passed to link templates.
+type linkData struct {
+	Kind      string
+	Namespace string
+	Name      string
+	SHA       string
+	clusterMetadata
+}
+
+// parseLinkTemplates parses newline-separated "Title=URL template" entries.
+// Templates use text/template syntax with the fields of linkData, e.g.
+// "Grafana=https://grafana/d/flux?var-namespace={{.Namespace}}".
+func parseLinkTemplates(spec string) ([]linkTemplate, error) {
+	var links []linkTemplate
+	for _, line := range strings.Split(spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		title, tmpl, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("link template %q: want Title=URL", line)
+		}
+		t, err := template.New(title).Parse(strings.TrimSpace(tmpl))
+		if err != nil {
+			return nil, fmt.Errorf("link template %q: %w", title, err)
+		}
+		links = append(links, linkTemplate{Title: strings.TrimSpace(title), URL: t})
+	}
+	return links, nil
+}
+
+// resourceLinks returns the Markdown section linking the MR back to the
+// failing resource: the configured link templates and a command snippet to
+// inspect it.
+func (r *RollbackController) resourceLinks(res resourceRef, sha string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\n### Failing resource\n\n`%s`", res)
+	if c := r.Metadata.String(); c != "" {
+		fmt.Fprintf(&b, " in cluster %s", c)
+	}
+	b.WriteString("\n")
+	data := linkData{Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: sha, clusterMetadata: r.Metadata}
+	for _, l := range r.LinkTemplates {
+		var u strings.Builder
+		if err := l.URL.Execute(&u, data); err != nil {
+			r.log.Error(err, "failed to render link template", "title", l.Title)
+			continue
+		}
+		fmt.Fprintf(&b, "\n- [%s](%s)", l.Title, u.String())
+	}
+	if len(r.LinkTemplates) > 0 {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\n```sh\nflux get %s %s -n %s\nkubectl -n %s describe %s %s\n```\n",
+		strings.ToLower(res.Kind), res.Name, res.Namespace, res.Namespace, strings.ToLower(res.Kind), res.Name)
+	return b.String()
+}
@@ -0,0 +1,40 @@
+package rollback
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestResourceLinks(t *testing.T) {
+	links, err := parseLinkTemplates(`
+Grafana=https://grafana/d/flux?var-namespace={{.Namespace}}&var-name={{.Name}}
+Weave GitOps = https://gitops/{{.Kind | printf "%s"}}/details?name={{.Name}}&namespace={{.Namespace}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.LinkTemplates = links
+	got := r.resourceLinks(resourceRef{Kind: "HelmRelease", Namespace: "apps", Name: "web"}, "abc")
+	for _, want := range []string{
+		"`HelmRelease/apps/web`",
+		"- [Grafana](https://grafana/d/flux?var-namespace=apps&var-name=web)",
+		"- [Weave GitOps](https://gitops/HelmRelease/details?name=web&namespace=apps)",
+		"flux get helmrelease web -n apps",
+		"kubectl -n apps describe helmrelease web",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("links missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestParseLinkTemplatesErrors(t *testing.T) {
+	for _, spec := range []string{"no-equals-sign", "Bad={{.Name"} {
+		if _, err := parseLinkTemplates(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
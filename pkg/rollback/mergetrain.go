@@ -0,0 +1,182 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// mergeTrainCheckInterval is how often the train position of a revert MR is
+// read; its resource is requeued this often while the MR is on a train.
+const mergeTrainCheckInterval = time.Minute
+
+var mergeTrainPosition = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "rollback_merge_train_position",
+	Help: "Position of the revert MR of a Flux resource on its GitLab merge train; 1 merges next.",
+}, []string{"kind", "namespace", "name"})
+
+func init() {
+	metrics.Registry.MustRegister(mergeTrainPosition)
+}
+
+// mergeTrainCar is a revert MR the controller added to a merge train.
+type mergeTrainCar struct {
+	SHA          string
+	IID          int
+	TargetBranch string
+	Position     int // 1-based; 0 if not seen on the train yet
+	Checked      time.Time
+}
+
+// gitlabTrainCar is the subset of a GitLab merge train car the controller
+// uses.
+type gitlabTrainCar struct {
+	MergeRequest struct {
+		IID int `json:"iid"`
+	} `json:"merge_request"`
+}
+
+// addToMergeTrain adds MR iid to the merge train of its target branch, once
+// its pipeline succeeds.
+func (g gitlabProject) addToMergeTrain(iid int) error {
+	return g.request("POST", fmt.Sprintf("/merge_trains/merge_requests/%d", iid), map[string]bool{"auto_merge": true}, nil)
+}
+
+// mergeTrainPosition returns the 1-based position of MR iid on the active
+// merge train of targetBranch, or 0 if it is not on it.
+func (g gitlabProject) mergeTrainPosition(iid int, targetBranch string) (int, error) {
+	cars, err := listPages[gitlabTrainCar](g, fmt.Sprintf("/merge_trains/%s?scope=active&sort=asc", url.PathEscape(targetBranch)), g.api.orDefault().discovery)
+	if err != nil {
+		return 0, err
+	}
+	for i, car := range cars {
+		if car.MergeRequest.IID == iid {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// joinMergeTrain adds the revert MR iid of res to the merge train and
+// records its position as a mergeTrain audit entry. checkMergeTrain keeps
+// track of it from then on.
+func (r *RollbackController) joinMergeTrain(gl gitlabProject, res resourceRef, sha string, iid int) error {
+	mr, err := gl.mergeRequestState(iid)
+	if err != nil {
+		return err
+	}
+	if err := gl.addToMergeTrain(iid); err != nil {
+		return err
+	}
+	pos, err := gl.mergeTrainPosition(iid, mr.TargetBranch)
+	if err != nil {
+		r.log.Error(err, "failed to read merge train position", "mr", iid, "project", gl.ProjectID)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mergeTrains[res.String()] = &mergeTrainCar{SHA: sha, IID: iid, TargetBranch: mr.TargetBranch, Position: pos, Checked: r.clock.Now()}
+	r.recordAudit(auditMergeTrain, res.Kind, res.Namespace, res.Name, sha, trainMessage(iid, mr.TargetBranch, pos, "joined"))
+	if pos > 0 {
+		mergeTrainPosition.WithLabelValues(res.Kind, res.Namespace, res.Name).Set(float64(pos))
+	}
+	return nil
+}
+
+// trainMessage describes MR iid joining the merge train of branch.
+func trainMessage(iid int, branch string, pos int, verb string) string {
+	if pos == 0 {
+		return fmt.Sprintf("!%d %s the merge train of %s, waiting for its pipeline", iid, verb, branch)
+	}
+	return fmt.Sprintf("!%d %s the merge train of %s at position %d", iid, verb, branch, pos)
+}
+
+// checkMergeTrain follows the revert MR of res on its merge train, at most
+// every mergeTrainCheckInterval: position changes are logged and exported
+// as rollback_merge_train_position, and an MR GitLab dropped from the train
+// while still open, e.g. because its merged result pipeline failed or the
+// train was reset, is added again, so the revert never waits silently off
+// the train. Tracking ends once the MR is merged or closed. It returns when
+// to check again while the MR is tracked, else 0.
+func (r *RollbackController) checkMergeTrain(ctx context.Context, res resourceRef) time.Duration {
+	r.mu.Lock()
+	car := r.mergeTrains[res.String()]
+	if car == nil {
+		r.mu.Unlock()
+		return 0
+	}
+	if since := r.clock.Since(car.Checked); since < mergeTrainCheckInterval {
+		r.mu.Unlock()
+		return mergeTrainCheckInterval - since
+	}
+	car.Checked = r.clock.Now()
+	c := *car
+	r.mu.Unlock()
+
+	log := r.log.WithValues("kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "mr", c.IID)
+	gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+	pos, err := gl.mergeTrainPosition(c.IID, c.TargetBranch)
+	if err != nil {
+		log.Error(err, "failed to read merge train position")
+		return mergeTrainCheckInterval
+	}
+	if pos > 0 {
+		if pos != c.Position {
+			log.Info("Revert MR moved on the merge train", "position", pos, "was", c.Position)
+		}
+		r.setTrainPosition(res, pos)
+		return mergeTrainCheckInterval
+	}
+	mr, err := gl.mergeRequestState(c.IID)
+	if err != nil {
+		log.Error(err, "failed to check revert MR")
+		return mergeTrainCheckInterval
+	}
+	if mr.State != "opened" {
+		r.mu.Lock()
+		r.forgetMergeTrain(res)
+		r.mu.Unlock()
+		return 0
+	}
+	if c.Position == 0 && mr.DetailedMergeStatus == "ci_still_running" {
+		// Not on the train yet: it joins once its pipeline succeeds.
+		return mergeTrainCheckInterval
+	}
+	if err := gl.addToMergeTrain(c.IID); err != nil {
+		log.Error(err, "failed to add revert MR to the merge train again")
+		return mergeTrainCheckInterval
+	}
+	pos, err = gl.mergeTrainPosition(c.IID, c.TargetBranch)
+	if err != nil {
+		log.Error(err, "failed to read merge train position")
+	}
+	r.setTrainPosition(res, pos)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordAudit(auditMergeTrain, res.Kind, res.Namespace, res.Name, c.SHA, trainMessage(c.IID, c.TargetBranch, pos, "rejoined"))
+	return mergeTrainCheckInterval
+}
+
+// forgetMergeTrain stops following the revert MR of res and drops its
+// position series. Callers must hold r.mu.
+func (r *RollbackController) forgetMergeTrain(res resourceRef) {
+	delete(r.mergeTrains, res.String())
+	mergeTrainPosition.DeleteLabelValues(res.Kind, res.Namespace, res.Name)
+}
+
+// setTrainPosition records the train position of the revert MR of res.
+func (r *RollbackController) setTrainPosition(res resourceRef, pos int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if car := r.mergeTrains[res.String()]; car != nil {
+		car.Position = pos
+	}
+	if pos > 0 {
+		mergeTrainPosition.WithLabelValues(res.Kind, res.Namespace, res.Name).Set(float64(pos))
+	} else {
+		mergeTrainPosition.DeleteLabelValues(res.Kind, res.Namespace, res.Name)
+	}
+}
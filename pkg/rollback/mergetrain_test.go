@@ -0,0 +1,119 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestMergeTrain(t *testing.T) {
+	train := []int{3, 7}
+	state := "opened"
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		call := req.Method + " " + strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42")
+		calls = append(calls, call)
+		switch call {
+		case "GET /merge_requests/7":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"iid": 7, "state": state, "target_branch": "main", "detailed_merge_status": "mergeable"})
+		case "GET /merge_trains/main":
+			var cars []map[string]interface{}
+			for _, iid := range train {
+				cars = append(cars, map[string]interface{}{"merge_request": map[string]int{"iid": iid}})
+			}
+			_ = json.NewEncoder(w).Encode(cars)
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRollbackController(nil, logr.Discard(), "", "42", srv.URL, "revert", 300)
+	clk := clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	r.setClock(clk)
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+	ctx := context.Background()
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{MergeRequest: &MergeRequestSpec{MergeTrain: true}}}
+
+	if err := r.autoMergeRevert(ctx, res, "abc", 7, policy); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GET /merge_requests/7", "POST /merge_trains/merge_requests/7", "GET /merge_trains/main"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if e := r.auditLog[len(r.auditLog)-1]; e.Event != auditMergeTrain || e.Message != "!7 joined the merge train of main at position 2" {
+		t.Errorf("audit entry %+v", e)
+	}
+
+	// Checked at most once a minute.
+	calls = nil
+	train = []int{7}
+	clk.Step(20 * time.Second)
+	if wait := r.checkMergeTrain(ctx, res); len(calls) != 0 || wait != 40*time.Second {
+		t.Errorf("checked again right away: %q, requeue in %s, want 40s", calls, wait)
+	}
+	clk.Step(40 * time.Second)
+	if wait := r.checkMergeTrain(ctx, res); wait != mergeTrainCheckInterval {
+		t.Errorf("requeue in %s while on the train, want %s", wait, mergeTrainCheckInterval)
+	}
+	if got := r.mergeTrains[res.String()].Position; got != 1 {
+		t.Errorf("position %d, want 1", got)
+	}
+
+	// Dropped from the train while still open: added again.
+	calls = nil
+	train = nil
+	clk.Step(time.Minute)
+	r.checkMergeTrain(ctx, res)
+	want = []string{"GET /merge_trains/main", "GET /merge_requests/7", "POST /merge_trains/merge_requests/7", "GET /merge_trains/main"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if e := r.auditLog[len(r.auditLog)-1]; e.Message != "!7 rejoined the merge train of main, waiting for its pipeline" {
+		t.Errorf("audit entry %+v", e)
+	}
+
+	// Merged: no longer tracked.
+	state = "merged"
+	clk.Step(time.Minute)
+	if wait := r.checkMergeTrain(ctx, res); wait != 0 {
+		t.Errorf("requeue in %s after the merge, want none", wait)
+	}
+	if _, ok := r.mergeTrains[res.String()]; ok {
+		t.Error("merged MR still tracked")
+	}
+
+	// Without mergeTrain the MR merges when its pipeline succeeds.
+	calls = nil
+	if err := r.autoMergeRevert(ctx, res, "abc", 7, &RollbackPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"PUT /merge_requests/7/merge"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestForgetResourceDropsMergeTrain(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "deleted"}
+	r.mergeTrains[res.String()] = &mergeTrainCar{SHA: "abc", IID: 7, TargetBranch: "main", Position: 2}
+	r.setTrainPosition(res, 2)
+
+	r.forgetResource(res)
+	if _, ok := r.mergeTrains[res.String()]; ok {
+		t.Error("deleted resource's merge train car still tracked")
+	}
+	if mergeTrainPosition.DeleteLabelValues(res.Kind, res.Namespace, res.Name) {
+		t.Error("deleted resource's position series still exported")
+	}
+}
@@ -0,0 +1,64 @@
+package rollback
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// clusterMetadata describes where the controller runs. It is configured once
+// and embedded in the data of every template (commit messages, branch names,
+// link templates, notifications), stamped on audit entries and exported as
+// rollback_controller_cluster_info.
+type clusterMetadata struct {
+	Cluster     string `json:"cluster,omitempty"`     // CLUSTER_NAME
+	Region      string `json:"region,omitempty"`      // CLUSTER_REGION
+	Environment string `json:"environment,omitempty"` // CLUSTER_ENVIRONMENT
+}
+
+// clusterInfo is constant 1, labelled with the cluster metadata, so the
+// controller's other metrics can be joined to the cluster they come from.
+var clusterInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "rollback_controller_cluster_info",
+	Help: "Cluster, region and environment the controller runs in; always 1.",
+}, []string{"cluster", "region", "environment"})
+
+func init() {
+	metrics.Registry.MustRegister(clusterInfo)
+}
+
+// metadataFromEnv reads the cluster metadata from CLUSTER_NAME,
+// CLUSTER_REGION and CLUSTER_ENVIRONMENT.
+func metadataFromEnv() clusterMetadata {
+	return clusterMetadata{
+		Cluster:     os.Getenv("CLUSTER_NAME"),
+		Region:      os.Getenv("CLUSTER_REGION"),
+		Environment: os.Getenv("CLUSTER_ENVIRONMENT"),
+	}
+}
+
+// String describes m for humans, e.g. "prod-eu (eu-west-1, production)", or
+// "" if no cluster name is set.
+func (m clusterMetadata) String() string {
+	if m.Cluster == "" {
+		return ""
+	}
+	var extra []string
+	for _, s := range []string{m.Region, m.Environment} {
+		if s != "" {
+			extra = append(extra, s)
+		}
+	}
+	if len(extra) == 0 {
+		return m.Cluster
+	}
+	return fmt.Sprintf("%s (%s)", m.Cluster, strings.Join(extra, ", "))
+}
+
+// reportMetadata exports m as rollback_controller_cluster_info.
+func reportMetadata(m clusterMetadata) {
+	clusterInfo.WithLabelValues(m.Cluster, m.Region, m.Environment).Set(1)
+}
@@ -0,0 +1,54 @@
+package rollback
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestClusterMetadata(t *testing.T) {
+	for m, want := range map[clusterMetadata]string{
+		{}:                    "",
+		{Region: "eu-west-1"}: "",
+		{Cluster: "prod-eu"}:  "prod-eu",
+		{Cluster: "prod-eu", Environment: "production"}:                      "prod-eu (production)",
+		{Cluster: "prod-eu", Region: "eu-west-1", Environment: "production"}: "prod-eu (eu-west-1, production)",
+	} {
+		if got := m.String(); got != want {
+			t.Errorf("%+v: %q, want %q", m, got, want)
+		}
+	}
+
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.Metadata = clusterMetadata{Cluster: "prod-eu", Region: "eu-west-1", Environment: "production"}
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+
+	r.CommitMessageTemplate, _ = parseCommitMessageTemplate("Revert {{.SHA}} in {{.Cluster}}/{{.Region}}/{{.Environment}}")
+	if got := r.revertMessage(res, "abc", "", ""); !strings.HasPrefix(got, "Revert abc in prod-eu/eu-west-1/production\n") {
+		t.Errorf("commit message %q", got)
+	}
+	r.LinkTemplates, _ = parseLinkTemplates("Grafana=https://grafana/d/flux?var-cluster={{.Cluster}}&var-env={{.Environment}}")
+	links := r.resourceLinks(res, "abc")
+	if !strings.Contains(links, "`Kustomization/apps/web` in cluster prod-eu (eu-west-1, production)\n") ||
+		!strings.Contains(links, "(https://grafana/d/flux?var-cluster=prod-eu&var-env=production)") {
+		t.Errorf("resource links %q", links)
+	}
+
+	tmpl, err := parseNotificationTemplate("{{.ID}} in {{.Cluster}} ({{.Region}})")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := incidentView{incident: incident{ID: "INC-1", Status: "open"}, clusterMetadata: r.Metadata}
+	if got := notificationText(tmpl, inc); got != "INC-1 in prod-eu (eu-west-1)" {
+		t.Errorf("notification %q", got)
+	}
+	if got := notificationText(nil, inc); !strings.HasSuffix(got, "\nCluster: prod-eu (eu-west-1, production)") {
+		t.Errorf("default notification %q", got)
+	}
+
+	r.recordAudit(auditAdmin, res.Kind, res.Namespace, res.Name, "abc", "")
+	if e := r.auditLog[len(r.auditLog)-1]; e.clusterMetadata != r.Metadata {
+		t.Errorf("audit entry %+v not stamped with the cluster metadata", e)
+	}
+}
@@ -0,0 +1,130 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// metricsGatePollInterval is how often a revert held by the metrics gate
+// re-runs its query.
+const metricsGatePollInterval = time.Minute
+
+// prometheusResponse is the subset of the Prometheus query API response the
+// controller uses.
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// prometheusImpact runs an instant query against the Prometheus at baseURL
+// and reports whether it returned anything: a non-empty vector or matrix, or
+// a non-zero scalar. Queries are written as conditions, e.g.
+// "error_ratio > 0.05", so an empty result means no impact.
+func prometheusImpact(ctx context.Context, client *http.Client, baseURL, query string) (bool, error) {
+	u := strings.TrimSuffix(baseURL, "/") + "/api/v1/query?query=" + url.QueryEscape(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var out prometheusResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return false, fmt.Errorf("decoding Prometheus response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if out.Status != "success" {
+		return false, fmt.Errorf("prometheus query failed (HTTP %d): %s", resp.StatusCode, out.Error)
+	}
+	if out.Data.ResultType == "scalar" {
+		var sample [2]interface{}
+		if err := json.Unmarshal(out.Data.Result, &sample); err != nil {
+			return false, fmt.Errorf("decoding scalar result: %w", err)
+		}
+		s, _ := sample[1].(string)
+		v, err := strconv.ParseFloat(s, 64)
+		return err == nil && v != 0, nil
+	}
+	var series []json.RawMessage
+	if err := json.Unmarshal(out.Data.Result, &series); err != nil {
+		return false, fmt.Errorf("decoding %s result: %w", out.Data.ResultType, err)
+	}
+	return len(series) > 0, nil
+}
+
+// parseMetricsQuery parses a metrics gate query, a text/template with the
+// fields of linkData.
+func parseMetricsQuery(query string) (*template.Template, error) {
+	return template.New("query").Parse(query)
+}
+
+// checkMetricsGate implements the policy's metricsGate: once the revert of
+// res on sha is due, it runs the Prometheus query and holds the revert until
+// the query confirms user impact, so flaky readiness checks alone don't
+// revert. A query that can't be run doesn't block the revert. It reports
+// whether remediate must wait, and for how long.
+func (r *RollbackController) checkMetricsGate(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy) (time.Duration, bool) {
+	key := res.String()
+	gate := policy.metricsGate()
+	r.mu.Lock()
+	if ready || sha == "" || gate == nil {
+		delete(r.metricsHeld, key)
+		r.mu.Unlock()
+		return 0, false
+	}
+	due := r.revertDue(res, sha, policy)
+	r.mu.Unlock()
+	if !due {
+		return 0, false
+	}
+	log := r.log.WithValues("kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha)
+	baseURL := gate.PrometheusURL
+	if baseURL == "" {
+		baseURL = r.PrometheusURL
+	}
+	if baseURL == "" {
+		log.Error(nil, "metricsGate needs PROMETHEUS_URL or prometheusURL, reverting as usual")
+		return 0, false
+	}
+	tmpl, err := parseMetricsQuery(gate.Query)
+	var query strings.Builder
+	if err == nil {
+		err = tmpl.Execute(&query, linkData{Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: sha, clusterMetadata: r.Metadata})
+	}
+	if err != nil {
+		log.Error(err, "failed to render metricsGate query, reverting as usual")
+		return 0, false
+	}
+	impact, err := prometheusImpact(ctx, r.api.orDefault().client, baseURL, query.String())
+	if err != nil {
+		log.Error(err, "metricsGate query failed, reverting as usual", "query", query.String())
+		return 0, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if impact {
+		delete(r.metricsHeld, key)
+		return 0, false
+	}
+	if r.metricsHeld[key] != sha {
+		r.metricsHeld[key] = sha
+		log.Info("Revert due, but the metrics show no user impact, holding it", "query", query.String())
+		r.recordAudit(auditHeld, res.Kind, res.Namespace, res.Name, sha, "metricsGate query returned no user impact")
+		r.emitEvent(auditHeld, res.Kind, res.Namespace, res.Name, sha)
+	}
+	return metricsGatePollInterval, true
+}
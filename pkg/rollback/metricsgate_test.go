@@ -0,0 +1,97 @@
+package rollback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestPrometheusImpact(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       bool
+		wantErr    bool
+	}{
+		{"empty vector", `{"status":"success","data":{"resultType":"vector","result":[]}}`, false, false},
+		{"vector", `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"0.2"]}]}}`, true, false},
+		{"zero scalar", `{"status":"success","data":{"resultType":"scalar","result":[1,"0"]}}`, false, false},
+		{"scalar", `{"status":"success","data":{"resultType":"scalar","result":[1,"1"]}}`, true, false},
+		{"error", `{"status":"error","error":"parse error"}`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/api/v1/query" || req.URL.Query().Get("query") != "up == 0" {
+					t.Errorf("unexpected request %s", req.URL)
+				}
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			got, err := prometheusImpact(context.Background(), srv.Client(), srv.URL+"/", "up == 0")
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("prometheusImpact() = %v, %v; want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetricsGate(t *testing.T) {
+	impact := false
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query = req.URL.Query().Get("query")
+		if impact {
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"0.2"]}]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer srv.Close()
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 60)
+	r.PrometheusURL = srv.URL
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "shop", Name: "web"}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{MetricsGate: &MetricsGateSpec{
+		Query: `job:errors:ratio5m{namespace="{{.Namespace}}"} > 0.05`,
+	}}}
+	reverted := 0
+	remediate := func() time.Duration {
+		return r.remediate(ctx, res, "main@sha1:bad", false, policy, func(string) { reverted++ }).RequeueAfter
+	}
+
+	remediate()
+	if query != "" {
+		t.Fatalf("queried Prometheus before the revert was due: %q", query)
+	}
+	clk.SetTime(start.Add(time.Minute))
+	for i := 0; i < 2; i++ {
+		if got := remediate(); got != metricsGatePollInterval || reverted != 0 {
+			t.Fatalf("no impact: requeue %v, %d reverts; want the revert held", got, reverted)
+		}
+	}
+	if query != `job:errors:ratio5m{namespace="shop"} > 0.05` {
+		t.Errorf("query = %q", query)
+	}
+	held := 0
+	for _, e := range r.auditLog {
+		if e.Event == auditHeld {
+			held++
+		}
+	}
+	if held != 1 {
+		t.Errorf("%d held audit entries, want 1", held)
+	}
+
+	impact = true
+	if remediate(); reverted != 1 {
+		t.Errorf("impact confirmed: %d reverts, want 1", reverted)
+	}
+}
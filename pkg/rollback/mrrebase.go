@@ -0,0 +1,259 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultMergeCheckInterval is how often open revert MRs are checked for
+// merges when ReconcileOnMerge is enabled without MR_REBASE_CHECK_SECONDS.
+const defaultMergeCheckInterval = time.Minute
+
+// gitlabMergeRequestState is the subset of GET /merge_requests/:iid the
+// rebase check uses.
+type gitlabMergeRequestState struct {
+	IID                 int    `json:"iid"`
+	State               string `json:"state"`
+	Title               string `json:"title"`
+	Description         string `json:"description"`
+	SourceBranch        string `json:"source_branch"`
+	TargetBranch        string `json:"target_branch"`
+	HasConflicts        bool   `json:"has_conflicts"`
+	DetailedMergeStatus string `json:"detailed_merge_status"`
+	WebURL              string `json:"web_url"`
+}
+
+// mergeRequestState returns the state of MR iid.
+func (g gitlabProject) mergeRequestState(iid int) (*gitlabMergeRequestState, error) {
+	var mr gitlabMergeRequestState
+	if err := g.request("GET", fmt.Sprintf("/merge_requests/%d", iid), nil, &mr); err != nil {
+		return nil, err
+	}
+	return &mr, nil
+}
+
+// rebaseMergeRequest asks GitLab to rebase MR iid onto its target branch.
+// The rebase runs asynchronously.
+func (g gitlabProject) rebaseMergeRequest(iid int) error {
+	return g.request("PUT", fmt.Sprintf("/merge_requests/%d/rebase", iid), nil, nil)
+}
+
+// closeMergeRequest closes MR iid.
+func (g gitlabProject) closeMergeRequest(iid int) error {
+	return g.request("PUT", fmt.Sprintf("/merge_requests/%d", iid), map[string]string{"state_event": "close"}, nil)
+}
+
+// addMergeRequestNote comments on MR iid.
+func (g gitlabProject) addMergeRequestNote(iid int, body string) error {
+	return g.request("POST", fmt.Sprintf("/merge_requests/%d/notes", iid), map[string]string{"body": body}, nil)
+}
+
+// openRevertMR is a revert MR the controller opened, with the reverts it
+// carries, oldest first (several for a batch revert).
+type openRevertMR struct {
+	IID     int
+	Reverts []revertRecord
+}
+
+// openRevertMRs returns the MRs of the recorded reverts that are not known
+// to be closed or merged, in the order they were opened.
+func (r *RollbackController) openRevertMRs() []openRevertMR {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []openRevertMR
+	index := make(map[string]int)
+	for _, rec := range r.reverts {
+		if rec.MRIID == 0 || rec.URL == "" {
+			continue
+		}
+		// The web URL includes the project, the IID alone does not.
+		if r.mrSettled[rec.URL] {
+			continue
+		}
+		i, ok := index[rec.URL]
+		if !ok {
+			i = len(out)
+			index[rec.URL] = i
+			out = append(out, openRevertMR{IID: rec.MRIID})
+		}
+		out[i].Reverts = append(out[i].Reverts, rec)
+	}
+	return out
+}
+
+// runMRRebaser checks the open revert MRs every interval and keeps them
+// mergeable while they wait for review (MR_REBASE_CHECK_SECONDS). Merged MRs
+// trigger a Flux reconcile with ReconcileOnMerge.
+func (r *RollbackController) runMRRebaser(ctx context.Context, interval time.Duration) error {
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			for _, mr := range r.openRevertMRs() {
+				r.maintainRevertMR(ctx, mr)
+			}
+		}
+	}
+}
+
+// maintainRevertMR rebases a revert MR that fell behind its target branch
+// and re-creates it from the current target branch if it conflicts, posting
+// a note on the MR about what happened. MRs that are no longer open are
+// not checked again.
+func (r *RollbackController) maintainRevertMR(ctx context.Context, open openRevertMR) {
+	first := open.Reverts[0]
+	res := resourceRef{Kind: first.Kind, Namespace: first.Namespace, Name: first.Name}
+	gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+	if !gl.isGitLab() {
+		r.settleMR(first.URL)
+		return
+	}
+	log := r.log.WithValues("mr", first.URL)
+	mr, err := gl.mergeRequestState(open.IID)
+	if err != nil {
+		log.Error(err, "failed to check revert MR")
+		return
+	}
+	switch {
+	case mr.State != "opened":
+		r.settleMR(first.URL)
+		if mr.State == "merged" {
+			r.mu.Lock()
+			for _, rec := range open.Reverts {
+				r.recordAudit(auditMerged, rec.Kind, rec.Namespace, rec.Name, rec.SHA, rec.URL)
+			}
+			r.mu.Unlock()
+			r.reconcileMergedRevert(ctx, open)
+			r.cleanupMergedRevert(gl, mr, open)
+		}
+	case r.MRWatchOnly:
+		// Only watching for merges (ReconcileOnMerge).
+	case mr.HasConflicts && !r.commitRevertMR(mr, open):
+		// File reverts and chart pins can't be replayed from the commit.
+		r.settleMR(first.URL)
+		if err := gl.addMergeRequestNote(mr.IID, fmt.Sprintf("This change conflicts with `%s`; please resolve the conflicts manually.", mr.TargetBranch)); err != nil {
+			log.Error(err, "failed to comment on revert MR")
+		}
+	case mr.HasConflicts:
+		r.recreateRevertMR(ctx, gl, mr, open)
+	case mr.DetailedMergeStatus == "need_rebase":
+		if err := gl.rebaseMergeRequest(mr.IID); err != nil {
+			log.Error(err, "failed to rebase revert MR")
+			return
+		}
+		log.Info("Rebased revert MR onto its target branch", "target", mr.TargetBranch)
+		if err := gl.addMergeRequestNote(mr.IID, fmt.Sprintf("`%s` moved on; rebased this revert onto it automatically.", mr.TargetBranch)); err != nil {
+			log.Error(err, "failed to comment on revert MR")
+		}
+		r.mu.Lock()
+		r.recordAudit(auditRebased, res.Kind, res.Namespace, res.Name, first.SHA, fmt.Sprintf("!%d rebased onto %s", mr.IID, mr.TargetBranch))
+		r.mu.Unlock()
+	}
+}
+
+// commitRevertMR reports whether mr reverts the commits of open, i.e. it was
+// opened for a commit revert or a batch of them, possibly re-created. Branch
+// names from REVERT_BRANCH_TEMPLATE are not recognised; the MR title is.
+func (r *RollbackController) commitRevertMR(mr *gitlabMergeRequestState, open openRevertMR) bool {
+	if len(open.Reverts) > 1 {
+		return strings.HasPrefix(mr.SourceBranch, r.RevertBranchPrefix+"-batch-") ||
+			mr.Title == fmt.Sprintf("Revert %d commits", len(open.Reverts))
+	}
+	sha := gitCommitSHA(open.Reverts[0].SHA)
+	return fullSHA.MatchString(sha) && (strings.HasPrefix(mr.SourceBranch, r.RevertBranchPrefix+"-"+sha) || mr.Title == "Revert "+sha)
+}
+
+// recreateRevertMR reverts the commits of a conflicting revert MR again on a
+// new branch from the current target branch, opens a new MR with the old
+// title and description, and closes the old one with a note pointing to it.
+// If the reverts conflict with the target branch themselves, the old MR only
+// gets a note asking for manual resolution.
+func (r *RollbackController) recreateRevertMR(ctx context.Context, gl gitlabProject, old *gitlabMergeRequestState, open openRevertMR) {
+	log := r.log.WithValues("mr", old.WebURL)
+	rec := open.Reverts[len(open.Reverts)-1]
+	newest := gitCommitSHA(rec.SHA)
+	data := branchNameData{Action: branchActionRevert, Strategy: RevertStrategyMergeRequest, Kind: rec.Kind, Namespace: rec.Namespace, Name: rec.Name, SHA: newest}
+	fallback := fmt.Sprintf("%s-%s", r.RevertBranchPrefix, newest)
+	if len(open.Reverts) > 1 {
+		data.Action = branchActionBatch
+		fallback = fmt.Sprintf("%s-batch-%s", r.RevertBranchPrefix, newest)
+	}
+	branch := fmt.Sprintf("%s-%d", r.revertBranchName(data, fallback), r.clock.Now().Unix())
+	err := gl.createBranch(branch, old.TargetBranch)
+	for i := len(open.Reverts) - 1; i >= 0 && err == nil; i-- {
+		sha := gitCommitSHA(open.Reverts[i].SHA)
+		if err = gl.revertCommit(sha, branch); err != nil {
+			err = fmt.Errorf("reverting %s: %w", sha, err)
+		}
+	}
+	if err != nil {
+		log.Error(err, "failed to re-create conflicting revert MR")
+		r.settleMR(old.WebURL)
+		note := fmt.Sprintf("This revert conflicts with `%s` and could not be re-created automatically (%v); please resolve the conflicts manually.", old.TargetBranch, err)
+		if err := gl.addMergeRequestNote(old.IID, note); err != nil {
+			log.Error(err, "failed to comment on revert MR")
+		}
+		return
+	}
+	description := fmt.Sprintf("%s\n\nRe-created from !%d, which conflicted with `%s`.", old.Description, old.IID, old.TargetBranch)
+	created, err := gl.createMergeRequest(branch, old.TargetBranch, gitlabMergeRequestOptions{Title: old.Title, Description: description})
+	if err != nil {
+		log.Error(err, "failed to open re-created revert MR", "branch", branch)
+		return
+	}
+	log.Info("Re-created conflicting revert MR", "new", created.WebURL)
+	r.settleMR(old.WebURL)
+	if err := gl.addMergeRequestNote(old.IID, fmt.Sprintf("This revert conflicts with `%s`; superseded by !%d, re-created from the current `%s`.", old.TargetBranch, created.IID, old.TargetBranch)); err != nil {
+		log.Error(err, "failed to comment on revert MR")
+	}
+	if err := gl.closeMergeRequest(old.IID); err != nil {
+		log.Error(err, "failed to close superseded revert MR")
+	}
+	for _, rec := range open.Reverts {
+		res := resourceRef{Kind: rec.Kind, Namespace: rec.Namespace, Name: rec.Name}
+		r.trackMergeRequest(ctx, res, rec.SHA, created)
+		r.mu.Lock()
+		r.recordAudit(auditRecreated, res.Kind, res.Namespace, res.Name, rec.SHA, fmt.Sprintf("!%d superseded by !%d after conflicts", old.IID, created.IID))
+		r.mu.Unlock()
+	}
+}
+
+// cleanupMergedRevert deletes the source branch of the merged revert MR mr,
+// unless GitLab already removed it with the merge, and closes the incidents
+// of its reverts (CLEANUP_MERGED_REVERTS). Failing to delete the branch is
+// logged; the incidents are closed anyway.
+func (r *RollbackController) cleanupMergedRevert(gl gitlabProject, mr *gitlabMergeRequestState, open openRevertMR) {
+	if !r.CleanupMergedReverts {
+		return
+	}
+	first := open.Reverts[0]
+	deleted := false
+	if mr.SourceBranch != "" {
+		var err error
+		if deleted, err = gl.deleteBranch(mr.SourceBranch); err != nil {
+			r.log.Error(err, "failed to delete the branch of a merged revert MR", "mr", first.URL, "branch", mr.SourceBranch)
+		} else if deleted {
+			r.log.Info("Deleted the branch of a merged revert MR", "mr", first.URL, "branch", mr.SourceBranch)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if deleted {
+		r.recordAudit(auditBranchDeleted, first.Kind, first.Namespace, first.Name, first.SHA, mr.SourceBranch)
+	}
+	for _, rec := range open.Reverts {
+		r.emitEvent(auditMerged, rec.Kind, rec.Namespace, rec.Name, rec.SHA)
+	}
+}
+
+// settleMR stops checking the revert MR at webURL.
+func (r *RollbackController) settleMR(webURL string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mrSettled[webURL] = true
+}
@@ -0,0 +1,148 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestMaintainRevertMRs(t *testing.T) {
+	shaA, shaB := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	var calls []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42")
+		call := req.Method + " " + path
+		var body map[string]interface{}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		switch call {
+		case "GET /merge_requests/1":
+			_, _ = w.Write([]byte(`{"iid":1,"state":"opened","source_branch":"revert-` + shaA + `","target_branch":"main","detailed_merge_status":"need_rebase","web_url":"` + srv.URL + `/mr/1"}`))
+		case "GET /merge_requests/2":
+			_, _ = w.Write([]byte(`{"iid":2,"state":"opened","title":"Revert ` + shaB + `","source_branch":"revert-` + shaB + `","target_branch":"main","has_conflicts":true,"web_url":"` + srv.URL + `/mr/2"}`))
+		case "GET /merge_requests/3":
+			_, _ = w.Write([]byte(`{"iid":3,"state":"merged"}`))
+		case "POST /merge_requests":
+			_, _ = w.Write([]byte(`{"iid":4,"web_url":"` + srv.URL + `/mr/4"}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+		if b, ok := body["branch"]; ok {
+			call += " branch=" + b.(string)
+		}
+		calls = append(calls, call)
+	}))
+	defer srv.Close()
+
+	r := NewRollbackController(nil, logr.Discard(), "", "42", srv.URL, "revert", 300)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	r.setClock(clocktesting.NewFakeClock(start))
+	r.Features = featureGates{ResourceAnnotations: false}
+	r.reverts = []revertRecord{
+		{Kind: "Kustomization", Namespace: "flux-system", Name: "apps", SHA: "main@sha1:" + shaA, URL: srv.URL + "/mr/1", MRIID: 1},
+		{Kind: "Kustomization", Namespace: "flux-system", Name: "web", SHA: "main@sha1:" + shaB, URL: srv.URL + "/mr/2", MRIID: 2},
+		{Kind: "HelmRelease", Namespace: "default", Name: "podinfo", SHA: "main@sha1:" + strings.Repeat("c", 40), URL: srv.URL + "/mr/3", MRIID: 3},
+	}
+	ctx := context.Background()
+	for _, mr := range r.openRevertMRs() {
+		r.maintainRevertMR(ctx, mr)
+	}
+	branch := "revert-" + shaB + "-1704103200"
+	want := []string{
+		"GET /merge_requests/1",
+		"PUT /merge_requests/1/rebase",
+		"POST /merge_requests/1/notes",
+		"GET /merge_requests/2",
+		"POST /repository/branches branch=" + branch,
+		"POST /repository/commits/" + shaB + "/revert branch=" + branch,
+		"POST /merge_requests",
+		"POST /merge_requests/2/notes",
+		"PUT /merge_requests/2",
+		"GET /merge_requests/3",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if got := r.reverts[1].URL; got != srv.URL+"/mr/4" {
+		t.Errorf("re-created MR not tracked: %q", got)
+	}
+	// Only the rebased MR and the new one are still checked.
+	var open []int
+	for _, mr := range r.openRevertMRs() {
+		open = append(open, mr.IID)
+	}
+	if !reflect.DeepEqual(open, []int{1, 4}) {
+		t.Errorf("open MRs = %v, want [1 4]", open)
+	}
+}
+
+func TestCleanupMergedReverts(t *testing.T) {
+	shaA, shaB := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		call := req.Method + " " + strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42")
+		calls = append(calls, call)
+		switch call {
+		case "GET /merge_requests/1":
+			_, _ = w.Write([]byte(`{"iid":1,"state":"merged","source_branch":"revert-` + shaA + `"}`))
+		case "GET /merge_requests/2":
+			_, _ = w.Write([]byte(`{"iid":2,"state":"merged","source_branch":"revert-` + shaB + `"}`))
+		case "GET /repository/branches/revert-" + shaA:
+			_, _ = w.Write([]byte(`{"name":"revert-` + shaA + `"}`))
+		case "DELETE /repository/branches/revert-" + shaA:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, `{"message":"404 Branch Not Found"}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRollbackController(nil, logr.Discard(), "", "42", srv.URL, "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	r.MRWatchOnly, r.CleanupMergedReverts = true, true
+	r.reverts = []revertRecord{
+		{Kind: "Kustomization", Namespace: "apps", Name: "web", SHA: "main@sha1:" + shaA, URL: srv.URL + "/mr/1", MRIID: 1},
+		{Kind: "Kustomization", Namespace: "apps", Name: "api", SHA: "main@sha1:" + shaB, URL: srv.URL + "/mr/2", MRIID: 2},
+	}
+	r.emitEvent(auditDetected, "Kustomization", "apps", "web", "main@sha1:"+shaA)
+	r.emitEvent(auditReverted, "Kustomization", "apps", "web", "main@sha1:"+shaA)
+	for _, mr := range r.openRevertMRs() {
+		r.maintainRevertMR(context.Background(), mr)
+	}
+	want := []string{
+		"GET /merge_requests/1",
+		"GET /repository/branches/revert-" + shaA,
+		"DELETE /repository/branches/revert-" + shaA,
+		"GET /merge_requests/2",
+		"GET /repository/branches/revert-" + shaB, // already removed by GitLab
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	var deleted []string
+	for _, e := range r.auditLog {
+		if e.Event == auditBranchDeleted {
+			deleted = append(deleted, e.Message)
+		}
+	}
+	if !reflect.DeepEqual(deleted, []string{"revert-" + shaA}) {
+		t.Errorf("branchDeleted entries %q, want only the branch GitLab left", deleted)
+	}
+	for _, inc := range r.incidents.list() {
+		if inc.Status != incidentResolved {
+			t.Errorf("incident %s of %s is %s after the merge, want resolved", inc.ID, inc.Revision, inc.Status)
+		}
+	}
+	r.emitEvent(auditRecovered, "Kustomization", "apps", "web", "main@sha1:"+shaA)
+	if got := r.incidents.list(); len(got) != 2 {
+		t.Errorf("a recovery after the merge must join the closed incident, got %+v", got)
+	}
+}
@@ -0,0 +1,110 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Namespace annotations setting defaults for the Flux resources in the
+// namespace. They override the global configuration; routing rules override
+// them in turn.
+const (
+	namespaceDebounceAnnotation  = "rollback.eumel8.io/default-debounce" // e.g. "10m", or seconds
+	namespaceProjectIDAnnotation = "rollback.eumel8.io/project-id"
+)
+
+// maxNamespaceDebounce caps the default-debounce annotation: longer windows
+// are typos ("600m" for "600s") rather than intent.
+const maxNamespaceDebounce = 24 * time.Hour
+
+// namespaceDefaults are the defaults a Namespace sets via annotations.
+type namespaceDefaults struct {
+	Debounce  *time.Duration // nil = DEBOUNCE_SECONDS
+	ProjectID string
+	// DebounceErr is why the default-debounce annotation was ignored.
+	DebounceErr error
+}
+
+// parseNamespaceDebounce parses a default-debounce annotation: a duration
+// such as "10m", or a number of seconds like DEBOUNCE_SECONDS, of at most
+// maxNamespaceDebounce.
+func parseNamespaceDebounce(value string) (time.Duration, error) {
+	var d time.Duration
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// Bounded before the conversion, which could overflow.
+		d = time.Duration(max(min(secs, int64(maxNamespaceDebounce/time.Second)+1), -1)) * time.Second
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("debounce %q is neither seconds nor a duration such as \"10m\"", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative debounce %q", value)
+	}
+	if d > maxNamespaceDebounce {
+		return 0, fmt.Errorf("debounce %q exceeds %v", value, maxNamespaceDebounce)
+	}
+	return d, nil
+}
+
+// namespaceDefaultsFor reads the defaults set on namespace. An invalid
+// debounce is logged, returned as DebounceErr and ignored; a namespace that can't be read sets none,
+// as do all namespaces without a client (simulate).
+func (r *RollbackController) namespaceDefaultsFor(ctx context.Context, namespace string) namespaceDefaults {
+	if r.Client == nil {
+		return namespaceDefaults{}
+	}
+	var ns corev1.Namespace
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		if !isGone(err) {
+			r.log.Error(err, "failed to read namespace defaults", "namespace", namespace)
+		}
+		return namespaceDefaults{}
+	}
+	defaults := namespaceDefaults{ProjectID: ns.Annotations[namespaceProjectIDAnnotation]}
+	if value, ok := ns.Annotations[namespaceDebounceAnnotation]; ok {
+		d, err := parseNamespaceDebounce(value)
+		if err != nil {
+			r.log.Error(err, "invalid namespace debounce, using the global one", "namespace", namespace, "annotation", namespaceDebounceAnnotation)
+			defaults.DebounceErr = err
+		} else {
+			defaults.Debounce = &d
+		}
+	}
+	return defaults
+}
+
+// warnInvalidNamespaceDebounce records a Warning Event on res if its
+// namespace's default-debounce annotation was ignored for err, so the typo
+// shows up next to the resource, e.g. in "flux events".
+func (r *RollbackController) warnInvalidNamespaceDebounce(res resourceRef, sha string, err error) {
+	if err == nil {
+		return
+	}
+	r.kubeEventf(res.Kind, res.Namespace, res.Name, sha, corev1.EventTypeWarning, "RollbackInvalidOverride",
+		"Ignoring annotation %s of namespace %s, using the global debounce: %v", namespaceDebounceAnnotation, res.Namespace, err)
+}
+
+// setNamespaceDebounce records the debounce namespace sets for its resources;
+// nil uses DEBOUNCE_SECONDS.
+func (r *RollbackController) setNamespaceDebounce(namespace string, d *time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d == nil {
+		delete(r.nsDebounce, namespace)
+		return
+	}
+	r.nsDebounce[namespace] = *d
+}
+
+// debounceWindow returns the debounce window of resources in namespace.
+// Callers must hold r.mu.
+func (r *RollbackController) debounceWindow(namespace string) time.Duration {
+	if d, ok := r.nsDebounce[namespace]; ok {
+		return d
+	}
+	return r.debounce.Window()
+}
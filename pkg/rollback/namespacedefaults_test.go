@@ -0,0 +1,79 @@
+package rollback
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseNamespaceDebounce(t *testing.T) {
+	for value, want := range map[string]time.Duration{"90": 90 * time.Second, "10m": 10 * time.Minute, "0": 0, "86400": maxNamespaceDebounce} {
+		if got, err := parseNamespaceDebounce(value); err != nil || got != want {
+			t.Errorf("parseNamespaceDebounce(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "soon", "-5", "-1m", "10 m", "1.5", "86401", "25h", "99999999999999999999", "-9999999999999999"} {
+		if _, err := parseNamespaceDebounce(value); err == nil {
+			t.Errorf("parseNamespaceDebounce(%q) should fail", value)
+		}
+	}
+}
+
+func TestNamespaceDefaults(t *testing.T) {
+	team := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{
+		namespaceDebounceAnnotation:  "1m",
+		namespaceProjectIDAnnotation: "team-a/fleet",
+	}}}
+	broken := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "broken", Annotations: map[string]string{
+		namespaceDebounceAnnotation: "soon",
+	}}}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(team, broken).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "42", "", "revert", 300)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	rec := record.NewFakeRecorder(100)
+	r.kubeEvents = rec
+	ctx := context.Background()
+
+	if got := r.project(ctx, "Kustomization", "team-a", "web").ProjectID; got != "team-a/fleet" {
+		t.Errorf("project in annotated namespace = %q", got)
+	}
+	if got := r.project(ctx, "Kustomization", "other", "web").ProjectID; got != "42" {
+		t.Errorf("project elsewhere = %q, want the global one", got)
+	}
+
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+	web := resourceRef{Kind: "Kustomization", Namespace: "team-a", Name: "web"}
+	api := resourceRef{Kind: "Kustomization", Namespace: "broken", Name: "api"}
+	if got := r.remediate(ctx, web, "main@sha1:aaa", false, nil, revert); got.RequeueAfter != time.Minute {
+		t.Errorf("namespace debounce: %+v, want 1m requeue", got)
+	}
+	if got := r.remediate(ctx, api, "main@sha1:bbb", false, nil, revert); got.RequeueAfter != 5*time.Minute {
+		t.Errorf("invalid namespace debounce: %+v, want the global 5m", got)
+	}
+	var warnings []string
+	for len(rec.Events) > 0 {
+		if e := <-rec.Events; strings.Contains(e, "RollbackInvalidOverride") {
+			warnings = append(warnings, e)
+		}
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "Warning RollbackInvalidOverride Ignoring annotation "+namespaceDebounceAnnotation+" of namespace broken") {
+		t.Errorf("warnings %q, want one about the broken namespace's debounce", warnings)
+	}
+	clk.Step(time.Minute)
+	r.remediate(ctx, web, "main@sha1:aaa", false, nil, revert)
+	r.remediate(ctx, api, "main@sha1:bbb", false, nil, revert)
+	if len(reverted) != 1 || reverted[0] != "main@sha1:aaa" {
+		t.Errorf("reverted = %v, want only the team-a revision", reverted)
+	}
+}
@@ -0,0 +1,312 @@
+package rollback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// incidentView is an incident as rendered in notifications.
+type incidentView struct {
+	incident
+	clusterMetadata
+	RevertURL string // MR link of the revert, if any
+}
+
+// incidentNotifier posts incidents to a chat tool. open creates the
+// incident's message and returns a reference to it; update adds ev, e.g. as
+// a thread reply, and refreshes the message with the incident's state.
+type incidentNotifier interface {
+	open(ctx context.Context, inc incidentView) (string, error)
+	update(ctx context.Context, ref string, inc incidentView, ev incidentEvent) error
+}
+
+// incidentNotifiers maps INCIDENT_NOTIFIER URL schemes to constructors.
+var incidentNotifiers = map[string]func(u *url.URL) (incidentNotifier, error){
+	"slack": newSlackNotifier,
+	"teams": newTeamsNotifier,
+}
+
+// newIncidentNotifier returns the notifier for a URL (see incidentNotifiers).
+func newIncidentNotifier(spec string) (incidentNotifier, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	newNotifier, ok := incidentNotifiers[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported incident notifier scheme %q (want slack or teams)", u.Scheme)
+	}
+	return newNotifier(u)
+}
+
+// summary is the one-line headline of an incident.
+func (inc incidentView) summary() string {
+	return fmt.Sprintf("Incident %s: revision %s is %s", inc.ID, inc.Revision, inc.Status)
+}
+
+// Latest is the incident's most recent event, for notification templates.
+func (inc incidentView) Latest() incidentEvent {
+	if len(inc.Events) == 0 {
+		return incidentEvent{}
+	}
+	return inc.Events[len(inc.Events)-1]
+}
+
+// details lists the failing resources, the revert link and the cluster.
+func (inc incidentView) details() string {
+	var b strings.Builder
+	if len(inc.Resources) > 0 {
+		fmt.Fprintf(&b, "Failing: %s", strings.Join(inc.Resources, ", "))
+	}
+	if inc.RevertURL != "" {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Revert: %s", inc.RevertURL)
+	}
+	if inc.Verification != "" {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Verification: %s", inc.Verification)
+	}
+	if c := inc.clusterMetadata.String(); c != "" {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Cluster: %s", c)
+	}
+	return b.String()
+}
+
+func (ev incidentEvent) String() string {
+	return fmt.Sprintf("%s %s: %s", ev.Time.UTC().Format(time.RFC3339), ev.Event, ev.Resource)
+}
+
+// postJSON sends body as JSON and decodes the response into out, if non-nil.
+func postJSON(ctx context.Context, method, u string, header http.Header, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// slackAPIURL is the Slack Web API base URL; tests point it at a fake.
+var slackAPIURL = "https://slack.com/api"
+
+// slackNotifier posts one message per incident to slack://<channel> with the
+// bot token in SLACK_TOKEN, replies to it in a thread for every event and
+// keeps the top message's status current with chat.update. tmpl, if set,
+// renders the top message (RollbackPolicy notifications.template).
+type slackNotifier struct {
+	channel string
+	token   string
+	tmpl    *template.Template
+}
+
+func newSlackNotifier(u *url.URL) (incidentNotifier, error) {
+	token := os.Getenv("SLACK_TOKEN")
+	if u.Host == "" || token == "" {
+		return nil, fmt.Errorf("incident notifier %s: want slack://<channel> and SLACK_TOKEN", u)
+	}
+	return &slackNotifier{channel: u.Host, token: token}, nil
+}
+
+// call invokes a Slack Web API method; Slack reports errors in the body.
+func (s *slackNotifier) call(ctx context.Context, method string, body map[string]string) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	header := http.Header{"Authorization": {"Bearer " + s.token}}
+	if err := postJSON(ctx, "POST", slackAPIURL+"/"+method, header, body, &out); err != nil {
+		return nil, err
+	}
+	if ok, _ := out["ok"].(bool); !ok {
+		return nil, fmt.Errorf("slack %s: %v", method, out["error"])
+	}
+	return out, nil
+}
+
+func (s *slackNotifier) text(inc incidentView) string {
+	return notificationText(s.tmpl, inc)
+}
+
+// open posts the top message; the reference is "<channel ID>/<ts>".
+func (s *slackNotifier) open(ctx context.Context, inc incidentView) (string, error) {
+	out, err := s.call(ctx, "chat.postMessage", map[string]string{"channel": s.channel, "text": s.text(inc)})
+	if err != nil {
+		return "", err
+	}
+	channel, _ := out["channel"].(string)
+	ts, _ := out["ts"].(string)
+	return channel + "/" + ts, nil
+}
+
+func (s *slackNotifier) update(ctx context.Context, ref string, inc incidentView, ev incidentEvent) error {
+	channel, ts, _ := strings.Cut(ref, "/")
+	if _, err := s.call(ctx, "chat.postMessage", map[string]string{"channel": channel, "thread_ts": ts, "text": ev.String()}); err != nil {
+		return err
+	}
+	_, err := s.call(ctx, "chat.update", map[string]string{"channel": channel, "ts": ts, "text": s.text(inc)})
+	return err
+}
+
+// teamsTokenURL is the Bot Framework token endpoint; "%s" is the tenant.
+var teamsTokenURL = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+
+// teamsNotifier posts one Adaptive Card per incident to a Teams
+// conversation through the Bot Framework connector and replaces it with the
+// current timeline on every event; incoming webhooks cannot update cards.
+// teams://<conversation ID>?serviceUrl=<bot service URL> with TEAMS_APP_ID,
+// TEAMS_APP_PASSWORD and, for single-tenant bots, TEAMS_TENANT_ID.
+type teamsNotifier struct {
+	serviceURL   string
+	conversation string
+	appID        string
+	appPassword  string
+	tenant       string
+	clock        clock.PassiveClock // token expiry
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newTeamsNotifier(u *url.URL) (incidentNotifier, error) {
+	n := &teamsNotifier{
+		serviceURL:   strings.TrimSuffix(u.Query().Get("serviceUrl"), "/"),
+		conversation: u.Host + u.Path,
+		appID:        os.Getenv("TEAMS_APP_ID"),
+		appPassword:  os.Getenv("TEAMS_APP_PASSWORD"),
+		tenant:       os.Getenv("TEAMS_TENANT_ID"),
+		clock:        clock.RealClock{},
+	}
+	if n.tenant == "" {
+		n.tenant = "botframework.com"
+	}
+	if n.serviceURL == "" || n.conversation == "" || n.appID == "" || n.appPassword == "" {
+		return nil, fmt.Errorf("incident notifier %s: want teams://<conversation>?serviceUrl=..., TEAMS_APP_ID and TEAMS_APP_PASSWORD", u.Redacted())
+	}
+	return n, nil
+}
+
+// accessToken returns a cached client-credentials token for the connector.
+func (t *teamsNotifier) accessToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && t.clock.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {t.appID},
+		"client_secret": {t.appPassword},
+		"scope":         {"https://api.botframework.com/.default"},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf(teamsTokenURL, t.tenant), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Teams token: %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	t.token = tok.AccessToken
+	// Renew a minute early so a token never expires mid-request.
+	t.expires = t.clock.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return t.token, nil
+}
+
+// activity returns a message activity carrying the incident's Adaptive Card.
+func (t *teamsNotifier) activity(inc incidentView) map[string]interface{} {
+	body := []interface{}{
+		map[string]interface{}{"type": "TextBlock", "text": inc.summary(), "weight": "Bolder", "wrap": true},
+	}
+	if d := inc.details(); d != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": d, "wrap": true})
+	}
+	var lines []string
+	for _, ev := range inc.Events {
+		lines = append(lines, "- "+ev.String())
+	}
+	body = append(body, map[string]interface{}{"type": "TextBlock", "text": strings.Join(lines, "\n"), "wrap": true, "isSubtle": true})
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+func (t *teamsNotifier) send(ctx context.Context, method, path string, inc incidentView, out interface{}) error {
+	token, err := t.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	u := t.serviceURL + "/v3/conversations/" + url.PathEscape(t.conversation) + "/activities" + path
+	return postJSON(ctx, method, u, http.Header{"Authorization": {"Bearer " + token}}, t.activity(inc), out)
+}
+
+// open posts the card; the reference is the activity ID.
+func (t *teamsNotifier) open(ctx context.Context, inc incidentView) (string, error) {
+	var out struct {
+		ID string `json:"id"`
+	}
+	err := t.send(ctx, "POST", "", inc, &out)
+	return out.ID, err
+}
+
+func (t *teamsNotifier) update(ctx context.Context, ref string, inc incidentView, _ incidentEvent) error {
+	return t.send(ctx, "PUT", "/"+url.PathEscape(ref), inc, nil)
+}
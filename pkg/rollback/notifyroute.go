@@ -0,0 +1,154 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// parseNotificationTemplate parses a RollbackPolicy notifications.template;
+// an empty spec returns nil, the built-in message. The template is also run
+// on an empty incident so unknown fields fail validation, not delivery.
+func parseNotificationTemplate(spec string) (*template.Template, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("notification").Option("missingkey=error").Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, incidentView{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// notificationText renders inc with tmpl, or as bold summary and details
+// if tmpl is nil or fails.
+func notificationText(tmpl *template.Template, inc incidentView) string {
+	if tmpl != nil {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, inc); err == nil && b.Len() > 0 {
+			return b.String()
+		}
+	}
+	text := "*" + inc.summary() + "*"
+	if d := inc.details(); d != "" {
+		text += "\n" + d
+	}
+	return text
+}
+
+// webhookNotifier posts every incident event to an incoming webhook as
+// {"text": ...}. Incoming webhooks can neither thread nor edit messages, so
+// the message carries the incident's current state.
+type webhookNotifier struct {
+	url  string
+	tmpl *template.Template
+}
+
+// open posts nothing; the first event is posted by update.
+func (w *webhookNotifier) open(context.Context, incidentView) (string, error) {
+	return "", nil
+}
+
+func (w *webhookNotifier) update(ctx context.Context, _ string, inc incidentView, ev incidentEvent) error {
+	text := notificationText(w.tmpl, inc)
+	if w.tmpl == nil {
+		text = ev.String() + "\n" + text
+	}
+	return postJSON(ctx, "POST", w.url, nil, map[string]string{"text": text}, nil)
+}
+
+// secretWebhook reads the "url" and optional "token" keys of a Secret in
+// the controller namespace.
+func (r *RollbackController) secretWebhook(ctx context.Context, name string) (u, token string, err error) {
+	var secret corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: name}, &secret); err != nil {
+		return "", "", err
+	}
+	raw, ok := secret.Data["url"]
+	if !ok {
+		return "", "", fmt.Errorf("secret %s/%s has no url key", r.Namespace, name)
+	}
+	return strings.TrimSpace(string(raw)), string(secret.Data["token"]), nil
+}
+
+// policyNotifiers returns the notifiers of a policy's notification targets,
+// keyed by policy and target so each keeps its own thread. Targets that
+// can't be set up are logged and skipped.
+func (r *RollbackController) policyNotifiers(ctx context.Context, p *RollbackPolicy) map[string]incidentNotifier {
+	spec := p.notifications()
+	// validate rejects invalid templates, so policyFor never returns one.
+	tmpl, _ := parseNotificationTemplate(spec.Template)
+	out := make(map[string]incidentNotifier)
+	for _, t := range spec.Targets {
+		key := p.Namespace + "/" + p.Name + "/"
+		switch {
+		case t.SlackChannel != "":
+			token := os.Getenv("SLACK_TOKEN")
+			if token == "" {
+				r.log.Error(nil, "SLACK_TOKEN is not set, skipping notification target", "policy", p.Namespace+"/"+p.Name, "slackChannel", t.SlackChannel)
+				continue
+			}
+			out[key+"slack:"+t.SlackChannel] = &slackNotifier{channel: t.SlackChannel, token: token, tmpl: tmpl}
+		case t.WebhookSecret != "":
+			u, _, err := r.secretWebhook(ctx, t.WebhookSecret)
+			if err != nil {
+				r.log.Error(err, "failed to read webhook URL, skipping notification target", "policy", p.Namespace+"/"+p.Name, "secret", t.WebhookSecret)
+				continue
+			}
+			out[key+"webhook:"+t.WebhookSecret] = &webhookNotifier{url: u, tmpl: tmpl}
+		case t.FluxProvider != "":
+			n, err := r.fluxProviderNotifier(ctx, t.FluxProvider, tmpl)
+			if err != nil {
+				r.log.Error(err, "failed to read Flux Provider, skipping notification target", "policy", p.Namespace+"/"+p.Name, "provider", t.FluxProvider)
+				continue
+			}
+			out[key+"provider:"+t.FluxProvider] = n
+		}
+	}
+	return out
+}
+
+// incidentRecipients returns the notifiers an incident goes to: the targets
+// of the RollbackPolicies of its resources, and for resources whose policy
+// sets no notifications the notifier of their failure domain, or global
+// (INCIDENT_NOTIFIER, may be nil). The global notifier's key is "".
+func (r *RollbackController) incidentRecipients(ctx context.Context, inc incident, global incidentNotifier) map[string]incidentNotifier {
+	out := make(map[string]incidentNotifier)
+	seen := make(map[string]bool)
+	for _, ev := range inc.Events {
+		if seen[ev.Resource] {
+			continue
+		}
+		seen[ev.Resource] = true
+		var policy *RollbackPolicy
+		if parts := strings.SplitN(ev.Resource, "/", 3); len(parts) == 3 {
+			policy = r.policyFor(ctx, parts[0], parts[1], parts[2])
+		}
+		if r.invalidPolicy(policy) != nil {
+			policy = nil
+		}
+		if policy.notifications() == nil {
+			if n := r.DomainNotifiers[policy.failureDomain()]; n != nil {
+				out["domain:"+policy.failureDomain()] = n
+				continue
+			}
+			if global != nil {
+				out[""] = global
+			}
+			continue
+		}
+		for key, n := range r.policyNotifiers(ctx, policy) {
+			out[key] = n
+		}
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// recordingNotifier records the events it is updated with.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	opened int
+	events []string
+}
+
+func (n *recordingNotifier) open(context.Context, incidentView) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.opened++
+	return "ref", nil
+}
+
+func (n *recordingNotifier) update(_ context.Context, _ string, _ incidentView, ev incidentEvent) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, ev.Resource)
+	return nil
+}
+
+func TestPolicyNotificationTargets(t *testing.T) {
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		received <- body["text"]
+	}))
+	defer srv.Close()
+
+	policy := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{
+		"targets": []interface{}{map[string]interface{}{"kind": "Kustomization", "name": "web"}},
+		"notifications": map[string]interface{}{
+			"targets":  []interface{}{map[string]interface{}{"webhookSecret": "team-a-webhook"}},
+			"template": "team-a: {{.Latest.Event}} {{.Latest.Resource}} ({{.Status}})",
+		},
+	}}}
+	policy.SetGroupVersionKind(gaFluxAPIs.Policy)
+	policy.SetNamespace("apps")
+	policy.SetName("team-a")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "team-a-webhook"},
+		Data:       map[string][]byte{"url": []byte(srv.URL + "\n")},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(policy, secret).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 0)
+	r.Namespace = "flux-system"
+	r.notifications = make(chan incidentUpdate, notificationQueueSize)
+	global := &recordingNotifier{}
+
+	// web is team-a's; api has no policy and goes to INCIDENT_NOTIFIER.
+	r.emitEvent(auditDetected, "Kustomization", "apps", "web", "abc")
+	r.emitEvent(auditDetected, "HelmRelease", "apps", "api", "abc")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = r.runIncidentNotifier(ctx, global)
+		close(done)
+	}()
+	want := []string{
+		"team-a: detected Kustomization/apps/web (open)",
+		"team-a: detected HelmRelease/apps/api (open)",
+	}
+	for _, w := range want {
+		select {
+		case got := <-received:
+			if got != w {
+				t.Errorf("webhook text %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the webhook")
+		}
+	}
+	cancel()
+	<-done
+	if global.opened != 1 || strings.Join(global.events, ",") != "HelmRelease/apps/api" {
+		t.Errorf("INCIDENT_NOTIFIER opened %d threads with %v, want one once api joined the incident", global.opened, global.events)
+	}
+}
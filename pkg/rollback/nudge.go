@@ -0,0 +1,119 @@
+package rollback
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// nudgeTimeout bounds how long a revert waits for Flux to handle the
+// reconcile request; after it the revert goes ahead on the status at hand.
+const nudgeTimeout = 2 * time.Minute
+
+// nudgePollInterval is how often a nudged resource is re-checked. Flux's
+// status update also triggers a reconcile, so this is only a fallback.
+const nudgePollInterval = 10 * time.Second
+
+// nudge is a reconcile request made before reverting a resource.
+type nudge struct {
+	SHA         string
+	Token       string // requestedAt value Flux echoes as lastHandledReconcileAt
+	RequestedAt time.Time
+	Handled     bool // Flux handled it (or timed out); decide on the next reconcile
+}
+
+// nudgeBeforeRevert implements reconcileBeforeRevert: once the revert of res
+// on sha is due, it requests one fresh Flux reconcile and holds the revert
+// until Flux has handled the request, so the revert is decided on current
+// status rather than a stale failure, e.g. after a source-controller hiccup.
+// It reports whether remediate must wait, and for how long.
+func (r *RollbackController) nudgeBeforeRevert(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy) (time.Duration, bool) {
+	key := res.String()
+	r.mu.Lock()
+	n := r.nudges[key]
+	if n != nil && (ready || n.SHA != sha) {
+		delete(r.nudges, key)
+		n = nil
+	}
+	if ready || sha == "" || !policy.reconcileBeforeRevert() {
+		r.mu.Unlock()
+		return 0, false
+	}
+	if n == nil && !r.revertDue(res, sha, policy) {
+		r.mu.Unlock()
+		return 0, false
+	}
+	r.mu.Unlock()
+
+	if n == nil {
+		if dryRun() {
+			r.log.Info("ECHO: would request reconcile before reverting", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha)
+			return 0, false
+		}
+		now := r.clock.Now()
+		n = &nudge{SHA: sha, Token: now.Format(time.RFC3339Nano), RequestedAt: now}
+		if err := r.patchReconcileRequest(ctx, res, n.Token); err != nil {
+			r.log.Error(err, "failed to request reconcile before reverting, reverting on current status", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name)
+			return 0, false
+		}
+		r.log.Info("Revert due, requesting a fresh reconcile first", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha)
+		r.mu.Lock()
+		r.nudges[key] = n
+		r.mu.Unlock()
+		return nudgePollInterval, true
+	}
+	if n.Handled {
+		return 0, false
+	}
+	handled := r.lastHandledReconcile(ctx, res) == n.Token
+	if !handled && r.clock.Since(n.RequestedAt) < nudgeTimeout {
+		return nudgePollInterval, true
+	}
+	if !handled {
+		r.log.Info("Reconcile request not handled in time, deciding on current status", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "timeout", nudgeTimeout)
+	}
+	// The status this reconcile read may predate the handled request; decide
+	// on the next one.
+	r.mu.Lock()
+	n.Handled = true
+	r.mu.Unlock()
+	return time.Second, true
+}
+
+// revertDue reports whether the next failing observation of res on sha
+// reverts it: its debounce window has expired or, with an escalation, a
+// Revert step is due. Callers must hold r.mu.
+func (r *RollbackController) revertDue(res resourceRef, sha string, policy *RollbackPolicy) bool {
+	steps := policy.escalation()
+	if len(steps) == 0 {
+		return r.debounce.Due(sha) && !r.debounce.IsCompleted(gitCommitSHA(sha))
+	}
+	e := r.escalations[res.String()]
+	if e == nil || e.SHA != sha || r.debounce.IsCompleted(sha) || r.debounce.IsCompleted(gitCommitSHA(sha)) {
+		return false
+	}
+	elapsed := r.clock.Since(e.FirstSeen)
+	for _, step := range steps[e.Done:] {
+		if step.After.Duration > elapsed {
+			return false
+		}
+		if step.reverts() {
+			return true
+		}
+	}
+	return false
+}
+
+// lastHandledReconcile returns the reconcile request Flux last handled for
+// res, or "" if it can't be read.
+func (r *RollbackController) lastHandledReconcile(ctx context.Context, res resourceRef) string {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.APIs.gvkFor(res.Kind))
+	if err := r.Get(ctx, client.ObjectKey{Namespace: res.Namespace, Name: res.Name}, obj); err != nil {
+		return ""
+	}
+	handled, _, _ := unstructured.NestedString(obj.Object, "status", "lastHandledReconcileAt")
+	return handled
+}
@@ -0,0 +1,81 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNudgeBeforeRevert(t *testing.T) {
+	ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "apps"}}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(ks).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 60)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{ReconcileBeforeRevert: true}}
+	reverted := 0
+	remediate := func() time.Duration {
+		return r.remediate(ctx, res, "main@sha1:bad", false, policy, func(string) { reverted++ }).RequeueAfter
+	}
+
+	remediate()
+	clk.SetTime(start.Add(time.Minute))
+	if got := remediate(); got != nudgePollInterval || reverted != 0 {
+		t.Fatalf("due revert: requeue %v, %d reverts; want a nudge first", got, reverted)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ks), ks); err != nil {
+		t.Fatal(err)
+	}
+	token := ks.Annotations[reconcileRequestAnnotation]
+	if token != start.Add(time.Minute).Format(time.RFC3339Nano) {
+		t.Fatalf("reconcile request annotation = %q", token)
+	}
+	if remediate(); reverted != 0 {
+		t.Fatal("reverted before Flux handled the reconcile request")
+	}
+
+	ks.Status.LastHandledReconcileAt = token
+	if err := c.Update(ctx, ks); err != nil {
+		t.Fatal(err)
+	}
+	if remediate(); reverted != 0 {
+		t.Fatal("reverted on the status read together with the handled request")
+	}
+	if remediate(); reverted != 1 {
+		t.Errorf("still failing after the reconcile: %d reverts, want 1", reverted)
+	}
+}
+
+func TestNudgeRecovered(t *testing.T) {
+	ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: "apps"}}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(ks).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 60)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{ReconcileBeforeRevert: true}}
+	revert := func(string) { t.Error("reverted a resource that recovered after the reconcile") }
+
+	r.remediate(ctx, res, "main@sha1:bad", false, policy, revert)
+	clk.SetTime(start.Add(time.Minute))
+	r.remediate(ctx, res, "main@sha1:bad", false, policy, revert)
+	// Without an answer from Flux, the revert is decided after nudgeTimeout.
+	clk.SetTime(start.Add(time.Minute + nudgeTimeout))
+	r.remediate(ctx, res, "main@sha1:bad", false, policy, revert)
+	r.remediate(ctx, res, "main@sha1:bad", true, policy, revert)
+	if len(r.nudges) != 0 || len(r.debounce.Pending()) != 0 {
+		t.Errorf("state left after recovery: nudges %v, pending %+v", r.nudges, r.debounce.Pending())
+	}
+}
@@ -0,0 +1,664 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// policyGroupVersion is the API of the v1alpha1 CRDs in crds/ (RollbackState,
+// ControllerConfig and the original RollbackPolicy).
+var policyGroupVersion = schema.GroupVersion{Group: "toolkit.fluxcd.io", Version: "v1alpha1"}
+
+// policyHubVersion is the RollbackPolicy version the Go types represent and
+// the CRD's storage version; other versions are converted by convertPolicy.
+var policyHubVersion = schema.GroupVersion{Group: "toolkit.fluxcd.io", Version: "v1beta1"}
+
+// Values for RollbackPolicySpec.HelmRevisionSource.
+const (
+	// HelmRevisionSourceGit reverts the Git revision the HelmRelease chart was
+	// built from (HelmRelease -> HelmChart -> GitRepository).
+	HelmRevisionSourceGit = "Source"
+	// HelmRevisionSourceChartVersion uses Status.LastAttemptedRevision, which
+	// for HelmReleases is the chart version rather than a Git SHA.
+	HelmRevisionSourceChartVersion = "ChartVersion"
+)
+
+// Values for RollbackPolicySpec.HelmRemediation.
+const (
+	// HelmRemediationRevert reverts the failing Git revision (default).
+	HelmRemediationRevert = "Revert"
+	// HelmRemediationPinChartVersion opens an MR that sets
+	// spec.chart.spec.version of the HelmRelease manifest in Git back to the
+	// last successfully deployed chart version.
+	HelmRemediationPinChartVersion = "PinChartVersion"
+	// HelmRemediationRevertFiles reverts only the files below HelmRevertPaths
+	// changed by the bad commit (e.g. a values file) instead of the whole
+	// commit, which may touch unrelated apps.
+	HelmRemediationRevertFiles = "RevertFiles"
+)
+
+// Values for RollbackPolicySpec.RevertStrategy.
+const (
+	// RevertStrategyBranch commits the change to a new revert branch only.
+	RevertStrategyBranch = "Branch"
+	// RevertStrategyMergeRequest commits to a new revert branch and opens an
+	// MR into the target branch.
+	RevertStrategyMergeRequest = "MergeRequest"
+	// RevertStrategyDirect commits the change straight to the target branch,
+	// trusting CI.
+	RevertStrategyDirect = "Direct"
+)
+
+// Values for RollbackPolicySpec.NotifyAuthor.
+const (
+	NotifyAuthorNone    = "None"
+	NotifyAuthorAssign  = "Assign"  // assign the revert MR to the bad commit's author
+	NotifyAuthorMention = "Mention" // @-mention the author in the MR description
+)
+
+// Values for RollbackPolicySpec.FailureSignal.
+const (
+	// FailureSignalReady treats Ready=False as failure (default).
+	FailureSignalReady = "Ready"
+	// FailureSignalHealthy treats only Healthy=False, i.e. failed
+	// Kustomization health checks, as failure.
+	FailureSignalHealthy = "Healthy"
+)
+
+// Values for MergeRequestSpec.StaleActions.
+const (
+	// StaleMRPing comments on the MR, mentioning its reviewers.
+	StaleMRPing = "Ping"
+	// StaleMRNotify records a "stale" event, notifying again through Kube
+	// Events, CloudEvents and the incident thread.
+	StaleMRNotify = "Notify"
+	// StaleMRAutoMerge sets the MR to merge once its pipeline succeeds.
+	StaleMRAutoMerge = "AutoMerge"
+)
+
+// Values for EscalationStep.Action; remediationActions implements them.
+const (
+	// EscalationNotify records the failure and emits a "notified" event.
+	EscalationNotify = "Notify"
+	// EscalationSuspend sets spec.suspend on the failing resource so Flux
+	// stops retrying it.
+	EscalationSuspend = "Suspend"
+	// EscalationRevert runs the policy's remediation (revert, file revert or
+	// chart pin).
+	EscalationRevert = "Revert"
+	// EscalationGitRevert is another name for EscalationRevert.
+	EscalationGitRevert = "GitRevert"
+	// EscalationAutoMerge merges the revert MR once its pipeline succeeds.
+	EscalationAutoMerge = "AutoMerge"
+	// EscalationHelmRollback has helm-controller roll the failing
+	// HelmRelease back to its last successful release, without Git.
+	EscalationHelmRollback = "HelmRollback"
+	// EscalationWebhookCall posts the failure to the URL in WebhookSecret.
+	EscalationWebhookCall = "WebhookCall"
+	// EscalationJobRun creates a Job from JobTemplate, e.g. a runbook.
+	EscalationJobRun = "JobRun"
+)
+
+// RollbackPolicy is the Go representation of the v1beta1 RollbackPolicy CRD.
+// Policies are read as unstructured objects and converted, so no generated
+// deepcopy code is needed.
+type RollbackPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              RollbackPolicySpec `json:"spec,omitempty"`
+}
+
+type RollbackPolicySpec struct {
+	// Only the fields the controller honours or validates are decoded;
+	// project and revertBranchPrefix in the CRD are not applied per policy
+	// yet.
+	Targets []PolicyTarget `json:"targets,omitempty"`
+	// Debounce is validated but not applied per policy yet.
+	Debounce           *metav1.Duration `json:"debounce,omitempty"`
+	HelmRevisionSource string           `json:"helmRevisionSource,omitempty"`
+	HelmRemediation    string           `json:"helmRemediation,omitempty"`
+	// HelmReleasePath is the repository path of the file holding the
+	// HelmRelease manifest. If empty, the file is located via blob search,
+	// which must find exactly one file with the release.
+	HelmReleasePath string `json:"helmReleasePath,omitempty"`
+	// HelmRevertPaths are the repository path prefixes (values files, chart
+	// directory) belonging to the HelmRelease, used by RevertFiles.
+	HelmRevertPaths []string `json:"helmRevertPaths,omitempty"`
+	// TargetBranch is the branch MRs target; defaults to the project's
+	// default branch.
+	TargetBranch string `json:"targetBranch,omitempty"`
+	// RevertStrategy selects how a change lands in Git: Branch, MergeRequest
+	// or Direct. Defaults to Branch for commit reverts and MergeRequest for
+	// file reverts and chart pins.
+	RevertStrategy string `json:"revertStrategy,omitempty"`
+	// NotifyAuthor loops the author of the bad commit into the revert MR:
+	// None (default), Assign or Mention.
+	NotifyAuthor string `json:"notifyAuthor,omitempty"`
+	// MergeRequest configures the MRs opened by the MergeRequest strategy.
+	MergeRequest *MergeRequestSpec `json:"mergeRequest,omitempty"`
+	// FailureSignal selects which Kustomization condition counts as failure:
+	// Ready (default) or Healthy.
+	FailureSignal string `json:"failureSignal,omitempty"`
+	// CriticalWorkloads limits the Healthy signal to health-check failures of
+	// matching workloads; failures of other workloads are ignored.
+	CriticalWorkloads []PolicyWorkload `json:"criticalWorkloads,omitempty"`
+	// Escalation replaces the single debounced revert with ordered steps,
+	// each run once the failure has lasted its After duration.
+	Escalation []EscalationStep `json:"escalation,omitempty"`
+	// ReconcileBeforeRevert requests one fresh Flux reconcile once the revert
+	// is due and reverts only if the resource still fails afterwards.
+	ReconcileBeforeRevert bool `json:"reconcileBeforeRevert,omitempty"`
+	// RevertEnvironments limits reverts to resources whose environment
+	// (ENVIRONMENT_LABEL on the resource or its namespace) matches one of
+	// these globs; the others are only notified about. Empty reverts all.
+	RevertEnvironments []string `json:"revertEnvironments,omitempty"`
+	// FailureDomain tags the targets with a failure domain (payments,
+	// infra, ...) with its own revert budget and notification route.
+	FailureDomain string `json:"failureDomain,omitempty"`
+	// MetricsGate holds due reverts until a Prometheus query confirms user
+	// impact.
+	MetricsGate *MetricsGateSpec `json:"metricsGate,omitempty"`
+	// ImageAutomation pins images back instead of reverting failing Flux
+	// image-automation commits, which the automation would push again.
+	ImageAutomation *ImageAutomationSpec `json:"imageAutomation,omitempty"`
+	// Verification launches a Job once a revert landed, e.g. smoke tests,
+	// and records whether the rollback restored service.
+	Verification *VerificationSpec `json:"verification,omitempty"`
+	// Notifications sends the incidents of the policy's resources to the
+	// policy's own targets instead of INCIDENT_NOTIFIER.
+	Notifications *NotificationsSpec `json:"notifications,omitempty"`
+}
+
+// NotificationsSpec routes incident notifications of a policy.
+type NotificationsSpec struct {
+	Targets []NotificationTarget `json:"targets"`
+	// Template renders the incident message. It is a Go template with the
+	// incident's .ID, .Revision, .Status, .Resources, .Events, .Latest,
+	// .RevertURL and .Verification and the cluster's .Cluster, .Region and
+	// .Environment; defaults to the built-in message.
+	Template string `json:"template,omitempty"`
+}
+
+// NotificationTarget is a Slack channel, an incoming webhook or a Flux
+// Provider; exactly one field is set.
+type NotificationTarget struct {
+	// SlackChannel is posted to with SLACK_TOKEN, threaded like slack://.
+	SlackChannel string `json:"slackChannel,omitempty"`
+	// WebhookSecret names a Secret in the controller namespace whose "url"
+	// key is an incoming webhook (Slack, Mattermost, Rocket.Chat) that is
+	// sent {"text": ...} for every event.
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+	// FluxProvider names a notification-controller Provider in the
+	// controller namespace (type slack, msteams or rocket) whose address,
+	// channel and secretRef are reused.
+	FluxProvider string `json:"fluxProvider,omitempty"`
+}
+
+// VerificationSpec is the Job verifying a rollback.
+type VerificationSpec struct {
+	// JobTemplate is the Job created in the policy's namespace once the
+	// revert is merged and the resource is Ready on the new revision.
+	JobTemplate batchv1.JobTemplateSpec `json:"jobTemplate"`
+	// Timeout bounds the wait for the resource to become Ready and the run
+	// of the Job (its activeDeadlineSeconds, unless set); defaults to 15m.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// timeout returns the configured Timeout or its default.
+func (v *VerificationSpec) timeout() time.Duration {
+	if v.Timeout == nil || v.Timeout.Duration <= 0 {
+		return defaultVerificationTimeout
+	}
+	return v.Timeout.Duration
+}
+
+// ImageAutomationSpec configures rollbacks of image-automation tag bumps.
+type ImageAutomationSpec struct {
+	// SuspendAutomation also suspends the ImageUpdateAutomations in the
+	// namespaces of the bumped ImagePolicies.
+	SuspendAutomation bool `json:"suspendAutomation,omitempty"`
+}
+
+// MetricsGateSpec is a Prometheus query confirming that a failure affects
+// users.
+type MetricsGateSpec struct {
+	// Query is a PromQL condition, e.g. an error ratio above the SLO; any
+	// result confirms impact. It is a Go template with .Kind, .Namespace,
+	// .Name and .SHA of the failing resource.
+	Query string `json:"query"`
+	// PrometheusURL overrides PROMETHEUS_URL.
+	PrometheusURL string `json:"prometheusURL,omitempty"`
+}
+
+// EscalationStep is one step of a progressive escalation, or of an ordered
+// action list when all steps run right away.
+type EscalationStep struct {
+	Action string `json:"action"`
+	// After is the time since the failure was detected, e.g. "15m".
+	After metav1.Duration `json:"after"`
+	// WebhookSecret names a Secret in the controller namespace whose "url"
+	// key receives the WebhookCall.
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+	// JobTemplate is the Job created by JobRun in the policy's namespace.
+	JobTemplate *batchv1.JobTemplateSpec `json:"jobTemplate,omitempty"`
+}
+
+// reverts reports whether the step reverts the failing revision.
+func (s EscalationStep) reverts() bool {
+	return s.Action == EscalationRevert || s.Action == EscalationGitRevert
+}
+
+// PolicyWorkload selects health-checked objects. Empty fields match any
+// value; Namespace and Name may be globs such as "payments-*".
+type PolicyWorkload struct {
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// MergeRequestSpec sets labels, milestone, reviewers and approval rules on
+// revert MRs so they fit existing MR workflows.
+type MergeRequestSpec struct {
+	Labels []string `json:"labels,omitempty"`
+	// Milestone is the title of an active project milestone.
+	Milestone string `json:"milestone,omitempty"`
+	// Reviewers are GitLab usernames.
+	Reviewers     []string           `json:"reviewers,omitempty"`
+	ApprovalRules []ApprovalRuleSpec `json:"approvalRules,omitempty"`
+	// GerritLabels are votes applied to Gerrit revert changes, e.g.
+	// {"Code-Review": 1}. Labels are set as Gerrit hashtags.
+	GerritLabels map[string]int `json:"gerritLabels,omitempty"`
+	// Draft opens revert MRs as drafts (Gerrit: work in progress), so CI
+	// runs and reviewers are notified but nothing merges them until a human
+	// marks them ready.
+	Draft bool `json:"draft,omitempty"`
+	// StaleAfter escalates a revert MR that is still open this long after
+	// the revert while the resource keeps failing.
+	StaleAfter *metav1.Duration `json:"staleAfter,omitempty"`
+	// StaleActions are run on a stale revert MR: Ping, Notify and
+	// AutoMerge. Defaults to Ping and Notify.
+	StaleActions []string `json:"staleActions,omitempty"`
+	// MergeTrain makes AutoMerge add revert MRs to the GitLab merge train
+	// of their target branch instead of merging them on their own.
+	MergeTrain bool `json:"mergeTrain,omitempty"`
+}
+
+// ApprovalRuleSpec is an MR approval rule; requires GitLab Premium.
+type ApprovalRuleSpec struct {
+	Name              string   `json:"name"`
+	ApprovalsRequired int      `json:"approvalsRequired"`
+	Usernames         []string `json:"usernames,omitempty"`
+}
+
+type PolicyTarget struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// matches reports whether the policy targets the given resource. A target
+// without a namespace refers to the policy's own namespace.
+func (p *RollbackPolicy) matches(kind, namespace, name string) bool {
+	for _, t := range p.Spec.Targets {
+		ns := t.Namespace
+		if ns == "" {
+			ns = p.Namespace
+		}
+		if t.Kind == kind && t.Name == name && ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// helmRevisionSource returns the configured HelmRevisionSource, defaulting to
+// the Git source revision. Safe to call on a nil policy.
+func (p *RollbackPolicy) helmRevisionSource() string {
+	if p == nil || p.Spec.HelmRevisionSource == "" {
+		return HelmRevisionSourceGit
+	}
+	return p.Spec.HelmRevisionSource
+}
+
+// helmRemediation returns the configured HelmRemediation, defaulting to a
+// Git revert. Safe to call on a nil policy.
+func (p *RollbackPolicy) helmRemediation() string {
+	if p == nil || p.Spec.HelmRemediation == "" {
+		return HelmRemediationRevert
+	}
+	return p.Spec.HelmRemediation
+}
+
+// revertStrategy returns the configured RevertStrategy, or fallback if none
+// is set. Safe to call on a nil policy.
+func (p *RollbackPolicy) revertStrategy(fallback string) string {
+	if p == nil || p.Spec.RevertStrategy == "" {
+		return fallback
+	}
+	return p.Spec.RevertStrategy
+}
+
+// notifyAuthor returns the configured NotifyAuthor, defaulting to None. Safe
+// to call on a nil policy.
+func (p *RollbackPolicy) notifyAuthor() string {
+	if p == nil || p.Spec.NotifyAuthor == "" {
+		return NotifyAuthorNone
+	}
+	return p.Spec.NotifyAuthor
+}
+
+// failureSignal returns the configured FailureSignal, defaulting to Ready.
+// Safe to call on a nil policy.
+func (p *RollbackPolicy) failureSignal() string {
+	if p == nil || p.Spec.FailureSignal == "" {
+		return FailureSignalReady
+	}
+	return p.Spec.FailureSignal
+}
+
+// escalation returns the configured escalation steps, or nil for the plain
+// debounced revert. Safe to call on a nil policy.
+func (p *RollbackPolicy) escalation() []EscalationStep {
+	if p == nil {
+		return nil
+	}
+	return p.Spec.Escalation
+}
+
+// reconcileBeforeRevert reports whether a fresh reconcile is requested
+// before reverting. Safe to call on a nil policy.
+func (p *RollbackPolicy) reconcileBeforeRevert() bool {
+	return p != nil && p.Spec.ReconcileBeforeRevert
+}
+
+// revertEnvironments returns the environments reverted automatically, or nil
+// for all. Safe to call on a nil policy.
+func (p *RollbackPolicy) revertEnvironments() []string {
+	if p == nil {
+		return nil
+	}
+	return p.Spec.RevertEnvironments
+}
+
+// failureDomain returns the failure domain of the policy's targets,
+// defaultFailureDomain if it sets none. Safe to call on a nil policy.
+func (p *RollbackPolicy) failureDomain() string {
+	if p == nil || p.Spec.FailureDomain == "" {
+		return defaultFailureDomain
+	}
+	return p.Spec.FailureDomain
+}
+
+// draftMergeRequests reports whether revert MRs are opened as drafts. Safe
+// to call on a nil policy.
+func (p *RollbackPolicy) draftMergeRequests() bool {
+	return p != nil && p.Spec.MergeRequest != nil && p.Spec.MergeRequest.Draft
+}
+
+// mergeTrain reports whether AutoMerge adds revert MRs to merge trains.
+// Safe to call on a nil policy.
+func (p *RollbackPolicy) mergeTrain() bool {
+	return p != nil && p.Spec.MergeRequest != nil && p.Spec.MergeRequest.MergeTrain
+}
+
+// staleMergeRequests returns how long a revert MR may stay open while the
+// resource keeps failing and what to do then, or 0 if stale MRs are not
+// escalated. Safe to call on a nil policy.
+func (p *RollbackPolicy) staleMergeRequests() (time.Duration, []string) {
+	if p == nil || p.Spec.MergeRequest == nil || p.Spec.MergeRequest.StaleAfter == nil {
+		return 0, nil
+	}
+	actions := p.Spec.MergeRequest.StaleActions
+	if len(actions) == 0 {
+		actions = []string{StaleMRPing, StaleMRNotify}
+	}
+	return p.Spec.MergeRequest.StaleAfter.Duration, actions
+}
+
+// pinImages reports whether image-automation bumps are pinned back instead of
+// reverted. Safe to call on a nil policy.
+func (p *RollbackPolicy) pinImages() bool {
+	return p != nil && p.Spec.ImageAutomation != nil
+}
+
+// suspendImageAutomation reports whether pinning images also suspends the
+// image automation. Safe to call on a nil policy.
+func (p *RollbackPolicy) suspendImageAutomation() bool {
+	return p.pinImages() && p.Spec.ImageAutomation.SuspendAutomation
+}
+
+// metricsGate returns the configured MetricsGate, or nil. Safe to call on a
+// nil policy.
+func (p *RollbackPolicy) metricsGate() *MetricsGateSpec {
+	if p == nil {
+		return nil
+	}
+	return p.Spec.MetricsGate
+}
+
+// verification returns the configured Verification, or nil. Safe to call on
+// a nil policy.
+func (p *RollbackPolicy) verification() *VerificationSpec {
+	if p == nil {
+		return nil
+	}
+	return p.Spec.Verification
+}
+
+// notifications returns the configured Notifications, or nil. Safe to call
+// on a nil policy.
+func (p *RollbackPolicy) notifications() *NotificationsSpec {
+	if p == nil {
+		return nil
+	}
+	return p.Spec.Notifications
+}
+
+// targetBranch returns the configured TargetBranch. Safe to call on a nil
+// policy.
+func (p *RollbackPolicy) targetBranch() string {
+	if p == nil {
+		return ""
+	}
+	return p.Spec.TargetBranch
+}
+
+// validate checks the rules the CRD enforces with CEL, for API servers that
+// predate CEL validation or policies created before the rules were added.
+// It returns all violations at once.
+func (p *RollbackPolicy) validate() error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i, t := range p.Spec.Targets {
+		if t.Kind != "Kustomization" && t.Kind != "HelmRelease" {
+			errs = append(errs, fmt.Errorf("targets[%d].kind must be Kustomization or HelmRelease, not %q", i, t.Kind))
+		}
+		if t.Name == "" {
+			errs = append(errs, fmt.Errorf("targets[%d].name is required", i))
+		}
+		ns := t.Namespace
+		if ns == "" {
+			ns = p.Namespace
+		}
+		key := t.Kind + "/" + ns + "/" + t.Name
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("targets[%d] duplicates an earlier target", i))
+		}
+		seen[key] = true
+	}
+	if p.Spec.Debounce != nil && p.Spec.Debounce.Duration < 0 {
+		errs = append(errs, errors.New("debounce must not be negative"))
+	}
+	if p.Spec.HelmRevisionSource == HelmRevisionSourceChartVersion && p.Spec.HelmRemediation == HelmRemediationRevertFiles {
+		errs = append(errs, errors.New("helmRemediation RevertFiles needs a Git revision, not helmRevisionSource ChartVersion"))
+	}
+	if p.Spec.HelmRemediation == HelmRemediationRevertFiles && len(p.Spec.HelmRevertPaths) == 0 {
+		errs = append(errs, errors.New("helmRemediation RevertFiles requires helmRevertPaths"))
+	}
+	if len(p.Spec.CriticalWorkloads) > 0 && p.failureSignal() != FailureSignalHealthy {
+		errs = append(errs, errors.New("criticalWorkloads requires failureSignal Healthy"))
+	}
+	for i, step := range p.Spec.Escalation {
+		if step.After.Duration < 0 {
+			errs = append(errs, fmt.Errorf("escalation[%d].after must not be negative", i))
+		}
+		if step.Action == EscalationAutoMerge && p.Spec.RevertStrategy != RevertStrategyMergeRequest {
+			errs = append(errs, fmt.Errorf("escalation[%d]: AutoMerge requires revertStrategy MergeRequest", i))
+		}
+		if step.Action == EscalationAutoMerge && p.draftMergeRequests() {
+			errs = append(errs, fmt.Errorf("escalation[%d]: AutoMerge cannot merge draft MRs", i))
+		}
+		if _, ok := remediationActions[step.Action]; !ok {
+			errs = append(errs, fmt.Errorf("escalation[%d]: unknown action %q", i, step.Action))
+		}
+		if step.Action == EscalationWebhookCall && step.WebhookSecret == "" {
+			errs = append(errs, fmt.Errorf("escalation[%d]: WebhookCall requires webhookSecret", i))
+		}
+		if step.Action == EscalationJobRun && (step.JobTemplate == nil || len(step.JobTemplate.Spec.Template.Spec.Containers) == 0) {
+			errs = append(errs, fmt.Errorf("escalation[%d]: JobRun requires jobTemplate with containers", i))
+		}
+	}
+	if mr := p.Spec.MergeRequest; mr != nil {
+		if mr.StaleAfter != nil && mr.StaleAfter.Duration <= 0 {
+			errs = append(errs, errors.New("mergeRequest.staleAfter must be positive"))
+		}
+		for i, action := range mr.StaleActions {
+			switch action {
+			case StaleMRPing, StaleMRNotify:
+			case StaleMRAutoMerge:
+				if p.Spec.RevertStrategy != RevertStrategyMergeRequest || mr.Draft {
+					errs = append(errs, fmt.Errorf("mergeRequest.staleActions[%d]: AutoMerge requires revertStrategy MergeRequest and no draft MRs", i))
+				}
+			default:
+				errs = append(errs, fmt.Errorf("mergeRequest.staleActions[%d]: unknown action %q", i, action))
+			}
+		}
+	}
+	if g := p.Spec.MetricsGate; g != nil {
+		if g.Query == "" {
+			errs = append(errs, errors.New("metricsGate.query is required"))
+		} else if _, err := parseMetricsQuery(g.Query); err != nil {
+			errs = append(errs, fmt.Errorf("metricsGate.query: %w", err))
+		}
+	}
+	if v := p.Spec.Verification; v != nil {
+		if len(v.JobTemplate.Spec.Template.Spec.Containers) == 0 {
+			errs = append(errs, errors.New("verification.jobTemplate.spec.template.spec.containers is required"))
+		}
+		if v.Timeout != nil && v.Timeout.Duration < 0 {
+			errs = append(errs, errors.New("verification.timeout must not be negative"))
+		}
+	}
+	if n := p.Spec.Notifications; n != nil {
+		if len(n.Targets) == 0 {
+			errs = append(errs, errors.New("notifications.targets is required"))
+		}
+		for i, t := range n.Targets {
+			set := 0
+			for _, f := range []string{t.SlackChannel, t.WebhookSecret, t.FluxProvider} {
+				if f != "" {
+					set++
+				}
+			}
+			if set != 1 {
+				errs = append(errs, fmt.Errorf("notifications.targets[%d] must set exactly one of slackChannel, webhookSecret and fluxProvider", i))
+			}
+		}
+		if _, err := parseNotificationTemplate(n.Template); err != nil {
+			errs = append(errs, fmt.Errorf("notifications.template: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// policyFor returns the first valid RollbackPolicy targeting the given
+// resource, or nil if none does (or the CRD is not installed); further
+// policies targeting the resource are logged as conflicts. If an invalid
+// policy targets the resource, it is returned instead (see invalidPolicy), so
+// a typo in a policy meant to restrict reverts doesn't fall back to reverting.
+func (r *RollbackController) policyFor(ctx context.Context, kind, namespace, name string) *RollbackPolicy {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(r.APIs.Policy.GroupVersion().WithKind("RollbackPolicyList"))
+	if err := r.List(ctx, list); err != nil {
+		r.log.V(1).Info("cannot list RollbackPolicies", "error", err.Error())
+		return nil
+	}
+	var found, invalid *RollbackPolicy
+	for _, item := range list.Items {
+		var p RollbackPolicy
+		err := convertPolicy(&item, policyHubVersion.Version)
+		if err == nil {
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &p)
+		}
+		if err != nil {
+			r.log.Error(err, "invalid RollbackPolicy", "namespace", item.GetNamespace(), "name", item.GetName())
+			continue
+		}
+		if !p.matches(kind, namespace, name) {
+			continue
+		}
+		err = p.validate()
+		r.reportPolicyValidity(&item, err)
+		if err != nil {
+			if invalid == nil {
+				invalid = &p
+			}
+			continue
+		}
+		if found != nil {
+			r.log.Error(nil, "RollbackPolicies conflict, using the first", "kind", kind, "namespace", namespace, "name", name,
+				"used", found.Namespace+"/"+found.Name, "ignored", p.Namespace+"/"+p.Name)
+			continue
+		}
+		found = &p
+	}
+	if invalid != nil {
+		return invalid
+	}
+	return found
+}
+
+// invalidPolicy returns why policy was invalid when policyFor last read it,
+// or nil for a valid or no policy. The resources it targets are held.
+func (r *RollbackController) invalidPolicy(policy *RollbackPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if msg, ok := r.invalidPolicies[policy.Namespace+"/"+policy.Name]; ok {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// reportPolicyValidity logs an invalid policy and records a Warning Event on
+// it once per validation error, so it isn't reported on every reconcile of
+// the resources it targets.
+func (r *RollbackController) reportPolicyValidity(policy *unstructured.Unstructured, err error) {
+	key := policy.GetNamespace() + "/" + policy.GetName()
+	r.mu.Lock()
+	reported, ok := r.invalidPolicies[key]
+	if err == nil {
+		delete(r.invalidPolicies, key)
+	} else {
+		r.invalidPolicies[key] = err.Error()
+	}
+	r.mu.Unlock()
+	if err == nil || (ok && reported == err.Error()) {
+		return
+	}
+	r.log.Error(err, "invalid RollbackPolicy, holding the resources it targets", "namespace", policy.GetNamespace(), "name", policy.GetName())
+	if r.kubeEvents != nil {
+		r.kubeEvents.Eventf(policy, corev1.EventTypeWarning, "RollbackInvalidPolicy", "Holding the resources this policy targets until it is fixed: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package rollback
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	web := PolicyTarget{Kind: "Kustomization", Name: "web"}
+	tests := []struct {
+		name string
+		spec RollbackPolicySpec
+		want string // substring of the error, "" for valid
+	}{
+		{"valid", RollbackPolicySpec{Targets: []PolicyTarget{web}, Debounce: &metav1.Duration{Duration: 5 * time.Minute}}, ""},
+		{"unknown kind", RollbackPolicySpec{Targets: []PolicyTarget{{Kind: "GitRepository", Name: "x"}}}, "targets[0].kind must be Kustomization or HelmRelease"},
+		{"missing name", RollbackPolicySpec{Targets: []PolicyTarget{{Kind: "HelmRelease"}}}, "targets[0].name is required"},
+		{"duplicate target", RollbackPolicySpec{Targets: []PolicyTarget{web, {Kind: "Kustomization", Name: "web", Namespace: "apps"}}}, "targets[1] duplicates"},
+		{"negative debounce", RollbackPolicySpec{Debounce: &metav1.Duration{Duration: -time.Second}}, "debounce must not be negative"},
+		{"RevertFiles without paths", RollbackPolicySpec{HelmRemediation: HelmRemediationRevertFiles}, "requires helmRevertPaths"},
+		{"RevertFiles on chart versions", RollbackPolicySpec{HelmRemediation: HelmRemediationRevertFiles, HelmRevertPaths: []string{"charts/"}, HelmRevisionSource: HelmRevisionSourceChartVersion}, "needs a Git revision"},
+		{"critical workloads on Ready", RollbackPolicySpec{CriticalWorkloads: []PolicyWorkload{{Kind: "Deployment"}}}, "criticalWorkloads requires failureSignal Healthy"},
+		{"AutoMerge without MR", RollbackPolicySpec{Escalation: []EscalationStep{{Action: EscalationRevert}, {Action: EscalationAutoMerge, After: metav1.Duration{Duration: time.Hour}}}}, "escalation[1]: AutoMerge requires revertStrategy MergeRequest"},
+		{"AutoMerge of drafts", RollbackPolicySpec{RevertStrategy: RevertStrategyMergeRequest, MergeRequest: &MergeRequestSpec{Draft: true}, Escalation: []EscalationStep{{Action: EscalationAutoMerge}}}, "escalation[0]: AutoMerge cannot merge draft MRs"},
+		{"unknown escalation action", RollbackPolicySpec{Escalation: []EscalationStep{{Action: "Page"}}}, `escalation[0]: unknown action "Page"`},
+		{"WebhookCall without secret", RollbackPolicySpec{Escalation: []EscalationStep{{Action: EscalationWebhookCall}}}, "WebhookCall requires webhookSecret"},
+		{"JobRun without template", RollbackPolicySpec{Escalation: []EscalationStep{{Action: EscalationJobRun}}}, "JobRun requires jobTemplate"},
+		{"metrics gate without query", RollbackPolicySpec{MetricsGate: &MetricsGateSpec{}}, "metricsGate.query is required"},
+		{"metrics gate with bad template", RollbackPolicySpec{MetricsGate: &MetricsGateSpec{Query: "up{namespace=\"{{.Namespace\"}"}}, "metricsGate.query:"},
+		{"notifications without targets", RollbackPolicySpec{Notifications: &NotificationsSpec{}}, "notifications.targets is required"},
+		{"notification target with two kinds", RollbackPolicySpec{Notifications: &NotificationsSpec{Targets: []NotificationTarget{{SlackChannel: "team-a", WebhookSecret: "hook"}}}}, "notifications.targets[0] must set exactly one"},
+		{"notification target without a kind", RollbackPolicySpec{Notifications: &NotificationsSpec{Targets: []NotificationTarget{{}}}}, "notifications.targets[0] must set exactly one"},
+		{"Flux Provider and Slack channel", RollbackPolicySpec{Notifications: &NotificationsSpec{Targets: []NotificationTarget{{SlackChannel: "team-a", FluxProvider: "slack"}}}}, "notifications.targets[0] must set exactly one"},
+		{"notification template with unknown field", RollbackPolicySpec{Notifications: &NotificationsSpec{Targets: []NotificationTarget{{SlackChannel: "team-a"}}, Template: "{{.Team}}"}}, "notifications.template:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &RollbackPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "apps"}, Spec: tt.spec}
+			err := p.validate()
+			if tt.want == "" && err != nil {
+				t.Errorf("validate() = %v, want nil", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("validate() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyForHoldsInvalidAndSkipsConflicting(t *testing.T) {
+	policy := func(name string, spec map[string]interface{}) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		u.SetGroupVersionKind(gaFluxAPIs.Policy)
+		u.SetNamespace("apps")
+		u.SetName(name)
+		return u
+	}
+	target := []interface{}{
+		map[string]interface{}{"kind": "Kustomization", "name": "web"},
+		map[string]interface{}{"kind": "Kustomization", "name": "api"},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(
+		policy("a-invalid", map[string]interface{}{"targets": target[:1], "debounce": "-5s", "revertEnvironments": []interface{}{"staging"}}),
+		policy("b-valid", map[string]interface{}{"targets": target, "revertStrategy": "Direct"}),
+		policy("c-conflict", map[string]interface{}{"targets": target, "revertStrategy": "MergeRequest"}),
+	).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	rec := record.NewFakeRecorder(10)
+	r.kubeEvents = rec
+	ctx := context.Background()
+
+	// The invalid policy wins over valid ones: falling back to them or to
+	// the defaults could revert what it was meant to restrict.
+	p := r.policyFor(ctx, "Kustomization", "apps", "web")
+	if p == nil || p.Name != "a-invalid" || r.invalidPolicy(p) == nil {
+		t.Fatalf("policyFor = %+v, want the invalid policy a-invalid", p)
+	}
+	if p := r.policyFor(ctx, "Kustomization", "apps", "api"); p == nil || p.Name != "b-valid" || r.invalidPolicy(p) != nil {
+		t.Fatalf("policyFor = %+v, want the first valid policy b-valid", p)
+	}
+	if p := r.policyFor(ctx, "Kustomization", "apps", "other"); p != nil {
+		t.Errorf("policyFor of an untargeted resource = %s", p.Name)
+	}
+	if got := len(rec.Events); got != 1 {
+		t.Fatalf("%d events for the invalid policy over three reads, want 1", got)
+	}
+	if e := <-rec.Events; !strings.Contains(e, "RollbackInvalidPolicy") || !strings.Contains(e, "debounce") {
+		t.Errorf("event = %q", e)
+	}
+
+	// A failing resource it targets is held, never reverted.
+	r.DebounceSeconds = 0
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	res := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+	for i := 0; i < 3; i++ {
+		d := r.remediate(ctx, res, "main@sha1:bad", false, p, func(string) { t.Error("reverted under an invalid policy") })
+		if d.Action != DecisionHeld || d.Reason != "invalid policy" {
+			t.Fatalf("decision = %+v, want Held for the invalid policy", d)
+		}
+	}
+	if st := r.resources["Kustomization/apps/web"]; st == nil || st.Ready {
+		t.Errorf("held resource not shown as failing: %+v", st)
+	}
+}
@@ -0,0 +1,239 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Annotations keeping v1beta1 values that v1alpha1 cannot represent, so a
+// round trip through the old version is lossless.
+const (
+	policyProjectIDAnnotation = "rollback.eumel8.io/project-id" // non-numeric project.id
+	policyDebounceAnnotation  = "rollback.eumel8.io/debounce"   // debounce with a sub-second part
+)
+
+// Defaults the mutating webhook fills in. defaultPolicyDebounce matches the
+// DEBOUNCE_SECONDS default.
+const (
+	defaultPolicyDebounce     = "5m0s"
+	defaultPolicyBranchPrefix = "revert"
+)
+
+// convertPolicy converts a RollbackPolicy in place to version. v1beta1
+// replaces debounceSeconds with the debounce duration and gitlabProjectID
+// and gitlabTokenSecret with the provider-neutral project; all other fields
+// are the same in both versions.
+func convertPolicy(u *unstructured.Unstructured, version string) error {
+	from := u.GroupVersionKind().Version
+	if from == version {
+		return nil
+	}
+	spec, _, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	annotations := u.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	switch {
+	case from == "v1alpha1" && version == "v1beta1":
+		policyToV1beta1(spec, annotations)
+	case from == "v1beta1" && version == "v1alpha1":
+		policyToV1alpha1(spec, annotations)
+	default:
+		return fmt.Errorf("cannot convert RollbackPolicy from %s to %s", from, version)
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	u.SetAnnotations(annotations)
+	u.SetAPIVersion(policyHubVersion.Group + "/" + version)
+	return unstructured.SetNestedMap(u.Object, spec, "spec")
+}
+
+func policyToV1beta1(spec map[string]interface{}, annotations map[string]string) {
+	if d, ok := annotations[policyDebounceAnnotation]; ok {
+		spec["debounce"] = d
+		delete(annotations, policyDebounceAnnotation)
+	} else if n, ok := int64Value(spec["debounceSeconds"]); ok {
+		spec["debounce"] = (time.Duration(n) * time.Second).String()
+	}
+	delete(spec, "debounceSeconds")
+
+	project := map[string]interface{}{}
+	if id, ok := annotations[policyProjectIDAnnotation]; ok {
+		project["id"] = id
+		delete(annotations, policyProjectIDAnnotation)
+	} else if n, ok := int64Value(spec["gitlabProjectID"]); ok {
+		project["id"] = strconv.FormatInt(n, 10)
+	}
+	if s, ok := spec["gitlabTokenSecret"].(string); ok && s != "" {
+		project["tokenSecret"] = s
+	}
+	delete(spec, "gitlabProjectID")
+	delete(spec, "gitlabTokenSecret")
+	if len(project) > 0 {
+		spec["project"] = project
+	}
+}
+
+func policyToV1alpha1(spec map[string]interface{}, annotations map[string]string) {
+	if d, ok := spec["debounce"].(string); ok {
+		if dur, err := time.ParseDuration(d); err == nil && dur%time.Second == 0 {
+			spec["debounceSeconds"] = int64(dur / time.Second)
+		} else {
+			annotations[policyDebounceAnnotation] = d
+		}
+	}
+	delete(spec, "debounce")
+
+	if project, ok := spec["project"].(map[string]interface{}); ok {
+		if id, ok := project["id"].(string); ok && id != "" {
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+				spec["gitlabProjectID"] = n
+			} else {
+				annotations[policyProjectIDAnnotation] = id
+			}
+		}
+		if s, ok := project["tokenSecret"].(string); ok && s != "" {
+			spec["gitlabTokenSecret"] = s
+		}
+	}
+	delete(spec, "project")
+}
+
+// int64Value returns a JSON number decoded into an unstructured object.
+func int64Value(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// defaultPolicy fills the defaults of a v1beta1 policy that the CRD schema
+// cannot express, and the static ones for API servers that skip schema
+// defaulting. Targets without a namespace get the policy's namespace.
+func defaultPolicy(u *unstructured.Unstructured) {
+	spec, _, _ := unstructured.NestedMap(u.Object, "spec")
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	setDefault := func(field, value string) {
+		if s, _ := spec[field].(string); s == "" {
+			spec[field] = value
+		}
+	}
+	setDefault("debounce", defaultPolicyDebounce)
+	setDefault("revertBranchPrefix", defaultPolicyBranchPrefix)
+	setDefault("helmRevisionSource", HelmRevisionSourceGit)
+	setDefault("helmRemediation", HelmRemediationRevert)
+	setDefault("notifyAuthor", NotifyAuthorNone)
+	setDefault("failureSignal", FailureSignalReady)
+
+	targets, _ := spec["targets"].([]interface{})
+	helmOnly := len(targets) > 0
+	for _, t := range targets {
+		target, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if ns, _ := target["namespace"].(string); ns == "" {
+			target["namespace"] = u.GetNamespace()
+		}
+		if target["kind"] != "HelmRelease" {
+			helmOnly = false
+		}
+	}
+	// revertStrategy defaults per remediation: Branch for commit reverts,
+	// MergeRequest for file reverts and chart pins, which only apply to
+	// HelmReleases. Policies mixing those with Kustomizations keep the
+	// per-resource default.
+	switch {
+	case spec["helmRemediation"] == HelmRemediationRevert:
+		setDefault("revertStrategy", RevertStrategyBranch)
+	case helmOnly:
+		setDefault("revertStrategy", RevertStrategyMergeRequest)
+	}
+	_ = unstructured.SetNestedMap(u.Object, spec, "spec")
+}
+
+// policyDefaulter is the mutating admission webhook applying defaultPolicy.
+// It is registered for v1beta1 only; the API server converts requests for
+// v1alpha1 objects first.
+var policyDefaulter = admission.HandlerFunc(func(_ context.Context, req admission.Request) admission.Response {
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(req.Object.Raw); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if u.GroupVersionKind().Version != policyHubVersion.Version {
+		return admission.Allowed("only " + policyHubVersion.Version + " is defaulted")
+	}
+	if u.GetNamespace() == "" {
+		u.SetNamespace(req.Namespace)
+	}
+	defaultPolicy(u)
+	defaulted, err := u.MarshalJSON()
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, defaulted)
+})
+
+// servePolicyConversion answers the CRD conversion webhook's
+// ConversionReviews with convertPolicy.
+func servePolicyConversion(w http.ResponseWriter, req *http.Request) {
+	var review apiextensionsv1.ConversionReview
+	if err := json.NewDecoder(req.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid ConversionReview", http.StatusBadRequest)
+		return
+	}
+	resp := &apiextensionsv1.ConversionResponse{UID: review.Request.UID, Result: metav1.Status{Status: metav1.StatusSuccess}}
+	if err := convertPolicies(review.Request, resp); err != nil {
+		resp.ConvertedObjects = nil
+		resp.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+	}
+	review.Request = nil
+	review.Response = resp
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(review)
+}
+
+func convertPolicies(req *apiextensionsv1.ConversionRequest, resp *apiextensionsv1.ConversionResponse) error {
+	gv, err := schema.ParseGroupVersion(req.DesiredAPIVersion)
+	if err != nil {
+		return err
+	}
+	for _, obj := range req.Objects {
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(obj.Raw); err != nil {
+			return err
+		}
+		if err := convertPolicy(u, gv.Version); err != nil {
+			return err
+		}
+		raw, err := u.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Raw: raw})
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package rollback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func v1alpha1Policy(spec map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	u.SetGroupVersionKind(policyGroupVersion.WithKind("RollbackPolicy"))
+	u.SetNamespace("apps")
+	u.SetName("web")
+	return u
+}
+
+func TestConvertPolicy(t *testing.T) {
+	u := v1alpha1Policy(map[string]interface{}{
+		"debounceSeconds":   int64(90),
+		"gitlabProjectID":   int64(123),
+		"gitlabTokenSecret": "gitlab-token",
+		"revertStrategy":    "Direct",
+	})
+	orig := u.DeepCopy()
+	if err := convertPolicy(u, "v1beta1"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"debounce":       "1m30s",
+		"project":        map[string]interface{}{"id": "123", "tokenSecret": "gitlab-token"},
+		"revertStrategy": "Direct",
+	}
+	if u.GetAPIVersion() != "toolkit.fluxcd.io/v1beta1" || !reflect.DeepEqual(u.Object["spec"], want) {
+		t.Errorf("v1beta1 = %s %v, want %v", u.GetAPIVersion(), u.Object["spec"], want)
+	}
+	if err := convertPolicy(u, "v1alpha1"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u, orig) {
+		t.Errorf("round trip = %v, want %v", u.Object, orig.Object)
+	}
+
+	// Values v1alpha1 cannot hold survive a round trip in annotations.
+	beta := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"debounce": "1.5s", "project": map[string]interface{}{"id": "fleet/apps"}},
+	}}
+	beta.SetGroupVersionKind(policyHubVersion.WithKind("RollbackPolicy"))
+	beta.SetName("web")
+	betaOrig := beta.DeepCopy()
+	if err := convertPolicy(beta, "v1alpha1"); err != nil {
+		t.Fatal(err)
+	}
+	if a := beta.GetAnnotations(); a[policyDebounceAnnotation] != "1.5s" || a[policyProjectIDAnnotation] != "fleet/apps" {
+		t.Errorf("v1alpha1 annotations = %v", a)
+	}
+	if err := convertPolicy(beta, "v1beta1"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(beta, betaOrig) {
+		t.Errorf("round trip = %v, want %v", beta.Object, betaOrig.Object)
+	}
+}
+
+func TestServePolicyConversion(t *testing.T) {
+	raw, _ := v1alpha1Policy(map[string]interface{}{"debounceSeconds": int64(300)}).MarshalJSON()
+	review := apiextensionsv1.ConversionReview{Request: &apiextensionsv1.ConversionRequest{
+		UID:               "42",
+		DesiredAPIVersion: "toolkit.fluxcd.io/v1beta1",
+		Objects:           []runtime.RawExtension{{Raw: raw}},
+	}}
+	body, _ := json.Marshal(review)
+	rec := httptest.NewRecorder()
+	servePolicyConversion(rec, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body)))
+
+	var got apiextensionsv1.ConversionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Response == nil || got.Response.UID != "42" || got.Response.Result.Status != "Success" || len(got.Response.ConvertedObjects) != 1 {
+		t.Fatalf("unexpected response %+v", got.Response)
+	}
+	u := &unstructured.Unstructured{}
+	_ = u.UnmarshalJSON(got.Response.ConvertedObjects[0].Raw)
+	if d, _, _ := unstructured.NestedString(u.Object, "spec", "debounce"); u.GetAPIVersion() != "toolkit.fluxcd.io/v1beta1" || d != "5m0s" {
+		t.Errorf("converted %v", u.Object)
+	}
+
+	review.Request.DesiredAPIVersion = "toolkit.fluxcd.io/v2"
+	body, _ = json.Marshal(review)
+	rec = httptest.NewRecorder()
+	servePolicyConversion(rec, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body)))
+	_ = json.Unmarshal(rec.Body.Bytes(), &got)
+	if got.Response.Result.Status != "Failure" || got.Response.ConvertedObjects != nil {
+		t.Errorf("conversion to an unknown version: %+v", got.Response)
+	}
+}
+
+func TestPolicyDefaulter(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   map[string]interface{}
+		want   string // patched revertStrategy, "" for none
+		wantNS string // patched namespace of the first target, "" for none
+	}{
+		{"commit revert", map[string]interface{}{"targets": []interface{}{map[string]interface{}{"kind": "Kustomization", "name": "web"}}}, RevertStrategyBranch, "apps"},
+		{"chart pin", map[string]interface{}{"helmRemediation": "PinChartVersion", "targets": []interface{}{map[string]interface{}{"kind": "HelmRelease", "name": "web", "namespace": "web"}}}, RevertStrategyMergeRequest, ""},
+		{"mixed", map[string]interface{}{"helmRemediation": "RevertFiles", "targets": []interface{}{map[string]interface{}{"kind": "Kustomization", "name": "web"}, map[string]interface{}{"kind": "HelmRelease", "name": "web"}}}, "", "apps"},
+		{"explicit", map[string]interface{}{"revertStrategy": "Direct", "targets": []interface{}{map[string]interface{}{"kind": "Kustomization", "name": "web"}}}, "", "apps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": tt.spec}}
+			u.SetGroupVersionKind(policyHubVersion.WithKind("RollbackPolicy"))
+			u.SetName("web")
+			raw, _ := u.MarshalJSON()
+			resp := policyDefaulter.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace: "apps",
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if !resp.Allowed {
+				t.Fatalf("denied: %v", resp.Result)
+			}
+			patched := map[string]string{}
+			for _, p := range resp.Patches {
+				patched[p.Path], _ = p.Value.(string)
+			}
+			if patched["/spec/debounce"] != defaultPolicyDebounce || patched["/spec/revertBranchPrefix"] != defaultPolicyBranchPrefix {
+				t.Errorf("static defaults missing: %v", patched)
+			}
+			if got := patched["/spec/revertStrategy"]; got != tt.want {
+				t.Errorf("revertStrategy patch = %q, want %q", got, tt.want)
+			}
+			if got := patched["/spec/targets/0/namespace"]; got != tt.wantNS {
+				t.Errorf("target namespace patch = %q, want %q", got, tt.wantNS)
+			}
+		})
+	}
+}
+
+func TestPolicyForReadsV1alpha1(t *testing.T) {
+	old := v1alpha1Policy(map[string]interface{}{
+		"targets":         []interface{}{map[string]interface{}{"kind": "Kustomization", "name": "web"}},
+		"debounceSeconds": int64(60),
+		"revertStrategy":  "Direct",
+	})
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(old).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.APIs.Policy = policyGroupVersion.WithKind("RollbackPolicy")
+
+	p := r.policyFor(context.Background(), "Kustomization", "apps", "web")
+	if p == nil || p.Spec.RevertStrategy != RevertStrategyDirect || p.Spec.Debounce == nil || p.Spec.Debounce.Duration.Seconds() != 60 {
+		t.Errorf("policyFor on v1alpha1 = %+v", p)
+	}
+}
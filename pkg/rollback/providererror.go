@@ -0,0 +1,133 @@
+package rollback
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// providerError is a non-2xx response of a provider API. Message is the
+// reason the provider gave, e.g. GitLab's "Branch already exists", parsed
+// from the captured body.
+type providerError struct {
+	API        string // "GitLab API", "Gerrit API"
+	Method     string
+	URL        string
+	Status     string
+	StatusCode int
+	Message    string
+}
+
+func (e *providerError) Error() string {
+	msg := fmt.Sprintf("%s %s %s: %s", e.API, e.Method, e.URL, e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// newProviderError reads up to maxBody bytes of resp's body (0 reads none)
+// and returns it as providerError. The message is taken from a JSON body's
+// "message" or "error" and "error_description" fields as GitLab sends them,
+// otherwise it is the body itself on one line.
+func newProviderError(api, method, u string, resp *http.Response, maxBody int64) error {
+	e := &providerError{API: api, Method: method, URL: u, Status: resp.Status, StatusCode: resp.StatusCode}
+	if maxBody <= 0 {
+		return e
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	e.Message = providerErrorMessage(body)
+	return e
+}
+
+// providerErrorMessage extracts the reason from an error response body.
+// GitLab sends {"message": "..."}, {"message": ["...", ...]},
+// {"message": {"field": ["...", ...]}} or {"error": "...",
+// "error_description": "..."}.
+func providerErrorMessage(body []byte) string {
+	var v struct {
+		Message          interface{} `json:"message"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return strings.Join(strings.Fields(string(body)), " ")
+	}
+	if msg := flattenErrorMessage(v.Message); msg != "" {
+		return msg
+	}
+	if v.Error != "" && v.ErrorDescription != "" {
+		return v.Error + ": " + v.ErrorDescription
+	}
+	return v.Error
+}
+
+// flattenErrorMessage renders a GitLab "message" value as one line.
+func flattenErrorMessage(m interface{}) string {
+	switch m := m.(type) {
+	case string:
+		return m
+	case []interface{}:
+		var parts []string
+		for _, p := range m {
+			if s := flattenErrorMessage(p); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, "; ")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var parts []string
+		for _, k := range keys {
+			if s := flattenErrorMessage(m[k]); s != "" {
+				parts = append(parts, k+" "+s)
+			}
+		}
+		return strings.Join(parts, "; ")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(m)
+	}
+}
+
+// revertFailureReason returns the reason of a failed revert for the audit
+// log: the provider's status and message, or the error itself.
+func revertFailureReason(err error) string {
+	var pe *providerError
+	if errors.As(err, &pe) {
+		if pe.Message != "" {
+			return pe.Status + ": " + pe.Message
+		}
+		return pe.Status
+	}
+	return err.Error()
+}
+
+// recordRevertFailure records that the revert of sha for res failed with
+// err as revertFailed audit entry, CloudEvent and Warning Event carrying the
+// provider's reason, and retries or dead-letters it. Rejected tokens and projects are recorded as
+// providerFailed by withProject instead.
+func (r *RollbackController) recordRevertFailure(res resourceRef, sha string, err error) {
+	var pe *providerError
+	if errors.As(err, &pe) && misconfigStatus(pe.Method, false, pe.StatusCode) {
+		return
+	}
+	reason := revertFailureReason(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordAudit(auditRevertFailed, res.Kind, res.Namespace, res.Name, sha, reason)
+	r.emitEvent(auditRevertFailed, res.Kind, res.Namespace, res.Name, sha)
+	r.kubeEventf(res.Kind, res.Namespace, res.Name, sha, corev1.EventTypeWarning, "RollbackFailed", "Revert of revision %s failed: %s", sha, reason)
+	r.revertFailed(res, sha, reason)
+}
@@ -0,0 +1,62 @@
+package rollback
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestProviderErrorMessage(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"message":"Branch already exists"}`:                                                          "Branch already exists",
+		`{"message":["Sorry, we cannot revert this commit automatically."]}`:                           "Sorry, we cannot revert this commit automatically.",
+		`{"message":{"source_branch":["is invalid"],"base":["Another MR exists"]}}`:                    "base Another MR exists; source_branch is invalid",
+		`{"error":"insufficient_scope","error_description":"The request requires higher privileges."}`: "insufficient_scope: The request requires higher privileges.",
+		"<html>\n  <body>502 Bad Gateway</body>\n</html>":                                              "<html> <body>502 Bad Gateway</body> </html>",
+		`{}`: "",
+	} {
+		if got := providerErrorMessage([]byte(body)); got != want {
+			t.Errorf("providerErrorMessage(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestRevertFailureIsRecorded(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method + " " + req.URL.Path {
+		case "GET /api/v4/projects/42":
+			fmt.Fprint(w, `{"default_branch":"main"}`)
+		case "POST /api/v4/projects/42/repository/branches":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"message":"Branch already exists"}`)
+		default:
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+	}))
+	defer srv.Close()
+	r := NewRollbackController(nil, logr.Discard(), "token", "42", srv.URL, "revert", 300)
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+
+	if mr := r.createGitlabRevertMR(r.defaultProject(), res, &RollbackPolicy{}, "main@sha1:"+sha); mr != nil {
+		t.Fatalf("revert succeeded: %+v", mr)
+	}
+	if n := len(r.auditLog); n != 1 || r.auditLog[0].Event != auditRevertFailed || r.auditLog[0].Message != "400 Bad Request: Branch already exists" {
+		t.Errorf("audit log = %+v", r.auditLog)
+	}
+
+	// Without body capture only the status line is known.
+	opts := defaultHTTPClientOptions
+	opts.ErrorBodyBytes = 0
+	r.api = newProviderAPI(opts)
+	err := r.defaultProject().createBranch("revert-"+sha, "main")
+	var pe *providerError
+	if !errors.As(err, &pe) || pe.StatusCode != http.StatusBadRequest || pe.Message != "" || !strings.HasSuffix(err.Error(), ": 400 Bad Request") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package rollback
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Defaults for PROVIDER_WORKERS and the queue in front of them.
+const (
+	defaultProviderWorkers = 4
+	providerQueueSize      = 256
+)
+
+var (
+	providerTasksQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rollback_provider_tasks_queued",
+		Help: "Reverts waiting for a provider worker.",
+	})
+	providerTaskWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rollback_provider_task_wait_seconds",
+		Help:    "How long reverts waited for a provider worker after the decision to revert.",
+		Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 15, 60, 300},
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(providerTasksQueued, providerTaskWait)
+}
+
+// providerPool runs reverts, i.e. the provider calls creating revert commits,
+// MRs and their decoration, on a bounded number of workers instead of inside
+// the reconcile that decided them. During a cluster-wide incident reverts of
+// different projects then proceed concurrently while reconciles keep
+// detecting failures. Calls to the same host are further bounded by
+// HTTP_MAX_REQUESTS_PER_HOST; writes to the same project are serialized by
+// lockProject.
+type providerPool struct {
+	workers int
+	clock   clock.PassiveClock
+	tasks   chan providerTask
+}
+
+// providerTask is a queued revert.
+type providerTask struct {
+	queued time.Time
+	run    func()
+}
+
+func newProviderPool(workers int, c clock.PassiveClock) *providerPool {
+	return &providerPool{workers: workers, clock: c, tasks: make(chan providerTask, providerQueueSize)}
+}
+
+// submit queues run and reports whether it was queued; when the queue is
+// full the caller runs it itself, so a revert is never dropped.
+func (p *providerPool) submit(run func()) bool {
+	select {
+	case p.tasks <- providerTask{queued: p.clock.Now(), run: run}:
+		providerTasksQueued.Inc()
+		return true
+	default:
+		return false
+	}
+}
+
+// run starts the workers and blocks until ctx is done. Queued reverts are
+// still run on shutdown: their SHAs are already marked as reverted.
+func (p *providerPool) run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case t := <-p.tasks:
+					p.exec(t)
+				case <-ctx.Done():
+					for {
+						select {
+						case t := <-p.tasks:
+							p.exec(t)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (p *providerPool) exec(t providerTask) {
+	providerTasksQueued.Dec()
+	providerTaskWait.Observe(p.clock.Since(t.queued).Seconds())
+	t.run()
+}
+
+// lockProject serializes changes to the Git project of gl, so concurrent
+// reverts don't race for the same branch. It returns the unlock function.
+func (r *RollbackController) lockProject(gl gitlabProject) func() {
+	key := gl.BaseURL + "|" + gl.ProjectID
+	r.projectLocksMu.Lock()
+	l, ok := r.projectLocks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		r.projectLocks[key] = l
+	}
+	r.projectLocksMu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
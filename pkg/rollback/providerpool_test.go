@@ -0,0 +1,122 @@
+package rollback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// concurrency tracks the maximum number of calls in flight.
+type concurrency struct {
+	mu       sync.Mutex
+	now, max int
+}
+
+func (c *concurrency) enter() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now++
+	if c.now > c.max {
+		c.max = c.now
+	}
+}
+
+func (c *concurrency) leave() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now--
+}
+
+func TestProviderPool(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	r.providers = newProviderPool(2, r.clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.providers.run(ctx) }()
+
+	var c concurrency
+	var reverted sync.WaitGroup
+	release := make(chan struct{})
+	revert := func(string) {
+		defer reverted.Done()
+		c.enter()
+		defer c.leave()
+		<-release
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		reverted.Add(1)
+		sha := "main@sha1:" + name
+		r.handleResource("Kustomization", name, "ns", sha, false, revert)
+		if got := r.handleResource("Kustomization", name, "ns", sha, false, revert); got != (Decision{Action: DecisionReverted, Reason: "queued"}) {
+			t.Fatalf("revert of %s = %+v, want queued", name, got)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	reverted.Wait()
+	if c.max != 2 {
+		t.Errorf("max concurrent reverts = %d, want 2", c.max)
+	}
+	var audited int
+	r.mu.Lock()
+	for _, e := range r.auditLog {
+		if e.Event == auditReverted {
+			audited++
+		}
+	}
+	r.mu.Unlock()
+	if audited != 4 {
+		t.Errorf("reverted audit entries = %d, want 4", audited)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("run: %v", err)
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	var c concurrency
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		c.enter()
+		defer c.leave()
+		<-release
+	}))
+	defer srv.Close()
+	opts := defaultHTTPClientOptions
+	opts.MaxRequestsPerHost = 2
+	client := newHTTPClient(opts)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := requests.Load(); n != 2 {
+		t.Errorf("requests in flight = %d, want 2", n)
+	}
+	close(release)
+	wg.Wait()
+	if c.max != 2 || requests.Load() != 5 {
+		t.Errorf("max concurrent requests = %d of %d, want 2 of 5", c.max, requests.Load())
+	}
+}
@@ -0,0 +1,174 @@
+package rollback
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RBAC profiles of the controller's ClusterRole. The read-only profile is
+// enough for REVERT_MODE=echo|record and the soak period, which only watch
+// Flux and revert nothing in the cluster; the action profile adds the
+// patches, suspends, Jobs and Events of remediation.
+const (
+	rbacProfileReadOnly = "readonly"
+	rbacProfileAction   = "action"
+	// rbacProfileIncomplete: the ServiceAccount lacks read-only permissions.
+	rbacProfileIncomplete = "incomplete"
+)
+
+// rbacRule is one rule of the controller's ClusterRole.
+type rbacRule struct {
+	Comment     string // YAML comment above the rule; lines separated by \n
+	APIGroup    string
+	Resources   []string
+	Verbs       []string // in both profiles
+	ActionVerbs []string // in the action profile only
+}
+
+// clusterRBACRules is the controller's ClusterRole, rendered by `rbac` and
+// kept in manifests/deployment.yaml (action) and manifests/rbac-readonly.yaml.
+var clusterRBACRules = []rbacRule{
+	{APIGroup: "helm.toolkit.fluxcd.io", Resources: []string{"helmreleases"},
+		Verbs: []string{"get", "list", "watch"}, ActionVerbs: []string{"patch"}},
+	{APIGroup: "kustomize.toolkit.fluxcd.io", Resources: []string{"kustomizations"},
+		Verbs: []string{"get", "list", "watch"}, ActionVerbs: []string{"patch"}},
+	{APIGroup: "source.toolkit.fluxcd.io", Resources: []string{"gitrepositories", "ocirepositories", "buckets", "helmcharts"},
+		Verbs: []string{"get", "list", "watch"}},
+	{Comment: "environment labels and default annotations of namespaces",
+		Resources: []string{"namespaces"}, Verbs: []string{"get", "list", "watch"}},
+	{Comment: "Flagger Canaries and their targets (FlaggerCanaries gate)",
+		APIGroup: "flagger.app", Resources: []string{"canaries"}, Verbs: []string{"get", "list"}},
+	{APIGroup: "apps", Resources: []string{"deployments", "daemonsets"}, Verbs: []string{"get"}},
+	{Comment: "reconcile requests and escalation suspends of GitRepositories",
+		APIGroup: "source.toolkit.fluxcd.io", Resources: []string{"gitrepositories"}, ActionVerbs: []string{"patch"}},
+	{APIGroup: "toolkit.fluxcd.io", Resources: []string{"rollbackpolicies"}, Verbs: []string{"get", "list", "watch"}},
+	{Comment: "suspending image automation after pinning images (imageAutomation.suspendAutomation)",
+		APIGroup: "image.toolkit.fluxcd.io", Resources: []string{"imageupdateautomations"}, ActionVerbs: []string{"list", "patch"}},
+	{Comment: "rollback verification Jobs (RollbackPolicy verification) and JobRun\nescalation actions",
+		APIGroup: "batch", Resources: []string{"jobs"}, ActionVerbs: []string{"get", "create"}},
+	{Comment: "Kubernetes Events on Flux resources (FluxEvents gate) and leader election",
+		Resources: []string{"events"}, ActionVerbs: []string{"create", "patch"}},
+}
+
+// verbs returns the verbs of rule in profile.
+func (rule rbacRule) verbs(profile string) []string {
+	if profile == rbacProfileAction {
+		return append(append([]string(nil), rule.Verbs...), rule.ActionVerbs...)
+	}
+	return rule.Verbs
+}
+
+// rbacClusterRole renders the ClusterRole of profile as YAML.
+func rbacClusterRole(profile string) string {
+	quote := func(items []string) string { return `["` + strings.Join(items, `","`) + `"]` }
+	var b strings.Builder
+	b.WriteString("apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: flux-rollback-agent\nrules:\n")
+	for _, rule := range clusterRBACRules {
+		verbs := rule.verbs(profile)
+		if len(verbs) == 0 {
+			continue
+		}
+		if rule.Comment != "" {
+			for _, line := range strings.Split(rule.Comment, "\n") {
+				b.WriteString("  # " + line + "\n")
+			}
+		}
+		fmt.Fprintf(&b, "  - apiGroups: %s\n    resources: %s\n    verbs: %s\n",
+			quote([]string{rule.APIGroup}), quote(rule.Resources), quote(verbs))
+	}
+	return b.String()
+}
+
+// runRBAC implements the `rbac` subcommand: it prints the ClusterRole of a
+// profile.
+func runRBAC(args []string) int {
+	fs := flag.NewFlagSet("rbac", flag.ExitOnError)
+	profile := fs.String("profile", rbacProfileAction, "RBAC profile: action or readonly")
+	_ = fs.Parse(args)
+	if *profile != rbacProfileAction && *profile != rbacProfileReadOnly {
+		fmt.Fprintln(os.Stderr, "usage: rollback-controller rbac [-profile action|readonly]")
+		return 2
+	}
+	fmt.Print(rbacClusterRole(*profile))
+	return 0
+}
+
+// rbacPermission is one verb on one resource of clusterRBACRules.
+type rbacPermission struct {
+	Group, Resource, Verb string
+	Action                bool // only in the action profile
+}
+
+func (p rbacPermission) String() string {
+	if p.Group == "" {
+		return p.Verb + " " + p.Resource
+	}
+	return p.Verb + " " + p.Resource + "." + p.Group
+}
+
+// missingRBACPermissions returns the permissions of the action profile the
+// ServiceAccount of c lacks, as answered by SelfSubjectAccessReviews.
+func missingRBACPermissions(ctx context.Context, c client.Client) ([]rbacPermission, error) {
+	var missing []rbacPermission
+	for _, rule := range clusterRBACRules {
+		for _, res := range rule.Resources {
+			for _, verb := range rule.verbs(rbacProfileAction) {
+				p := rbacPermission{Group: rule.APIGroup, Resource: res, Verb: verb}
+				for _, v := range rule.ActionVerbs {
+					p.Action = p.Action || v == verb
+				}
+				review := &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{Group: p.Group, Resource: p.Resource, Verb: p.Verb},
+				}}
+				if err := c.Create(ctx, review); err != nil {
+					return nil, err
+				}
+				if !review.Status.Allowed {
+					missing = append(missing, p)
+				}
+			}
+		}
+	}
+	return missing, nil
+}
+
+// reportRBAC logs the RBAC profile the controller's ServiceAccount has and
+// returns it. Missing action permissions are an error unless in dry-run
+// mode, which needs none.
+func (r *RollbackController) reportRBAC(ctx context.Context, c client.Client) string {
+	missing, err := missingRBACPermissions(ctx, c)
+	if err != nil {
+		r.log.Error(err, "Failed to check the ServiceAccount's RBAC permissions")
+		return ""
+	}
+	var read, action []string
+	for _, p := range missing {
+		if p.Action {
+			action = append(action, p.String())
+		} else {
+			read = append(read, p.String())
+		}
+	}
+	profile := rbacProfileAction
+	switch {
+	case len(read) > 0:
+		profile = rbacProfileIncomplete
+	case len(action) > 0:
+		profile = rbacProfileReadOnly
+	}
+	r.log.Info("RBAC capabilities", "profile", profile, "dryRun", dryRun())
+	if len(read) > 0 {
+		r.log.Error(nil, "ServiceAccount can't read all Flux resources, failures may go undetected", "missing", read)
+	}
+	if len(action) > 0 && !dryRun() {
+		r.log.Error(nil, "ServiceAccount lacks the action RBAC profile, reconcile requests, suspends, Helm rollbacks, Jobs and Events will fail",
+			"missing", action)
+	}
+	return profile
+}
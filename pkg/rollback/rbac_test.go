@@ -0,0 +1,69 @@
+package rollback
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestRBACManifestsUpToDate(t *testing.T) {
+	deployment, err := os.ReadFile("../../manifests/deployment.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(deployment), rbacClusterRole(rbacProfileAction)) {
+		t.Errorf("ClusterRole in manifests/deployment.yaml differs from `rbac -profile action`:\n%s", rbacClusterRole(rbacProfileAction))
+	}
+	readOnly, err := os.ReadFile("../../manifests/rbac-readonly.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(readOnly) != rbacClusterRole(rbacProfileReadOnly) {
+		t.Errorf("manifests/rbac-readonly.yaml differs from `rbac -profile readonly`:\n%s", rbacClusterRole(rbacProfileReadOnly))
+	}
+	if strings.Contains(string(readOnly), "patch") || strings.Contains(string(readOnly), "create") {
+		t.Error("read-only profile grants writes")
+	}
+}
+
+func TestReportRBAC(t *testing.T) {
+	// allowed grants the verbs of a profile, as the API server would; none
+	// for an empty profile.
+	allowed := func(profile string) interceptor.Funcs {
+		return interceptor.Funcs{Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+			review := obj.(*authorizationv1.SelfSubjectAccessReview)
+			attr := review.Spec.ResourceAttributes
+			if profile == "" {
+				return nil
+			}
+			for _, rule := range clusterRBACRules {
+				for _, res := range rule.Resources {
+					for _, verb := range rule.verbs(profile) {
+						if rule.APIGroup == attr.Group && res == attr.Resource && verb == attr.Verb {
+							review.Status.Allowed = true
+						}
+					}
+				}
+			}
+			return nil
+		}}
+	}
+	for _, tc := range []struct{ granted, want string }{
+		{rbacProfileAction, rbacProfileAction},
+		{rbacProfileReadOnly, rbacProfileReadOnly},
+		{"", rbacProfileIncomplete},
+	} {
+		c := fake.NewClientBuilder().WithScheme(newScheme()).WithInterceptorFuncs(allowed(tc.granted)).Build()
+		r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+		if got := r.reportRBAC(context.Background(), c); got != tc.want {
+			t.Errorf("with %q permissions: profile %q, want %q", tc.granted, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package rollback
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// readyCondition is the status condition telling whether a resource of a
+// kind is healthy.
+type readyCondition struct {
+	Type string
+	// Healthy is the status of a healthy resource, the condition's polarity:
+	// True for positive conditions such as Ready or Available, False for
+	// negative ones such as Degraded or Stalled. The opposite status is a
+	// failure; Unknown and a missing condition are not.
+	Healthy metav1.ConditionStatus
+}
+
+// defaultReadyCondition is Flux's Ready condition.
+var defaultReadyCondition = readyCondition{Type: "Ready", Healthy: metav1.ConditionTrue}
+
+// failure returns the condition if it reports a failure, or nil.
+func (c readyCondition) failure(conditions []metav1.Condition) *metav1.Condition {
+	cond := meta.FindStatusCondition(conditions, c.Type)
+	if cond == nil || cond.Status == metav1.ConditionUnknown || cond.Status == c.Healthy {
+		return nil
+	}
+	return cond
+}
+
+// ready reports whether conditions don't report a failure.
+func (c readyCondition) ready(conditions []metav1.Condition) bool {
+	return c.failure(conditions) == nil
+}
+
+// failingSince returns the time the condition turned to the failing status,
+// or ok=false if the resource is not failing.
+func (c readyCondition) failingSince(conditions []metav1.Condition) (since time.Time, message string, ok bool) {
+	cond := c.failure(conditions)
+	if cond == nil {
+		return time.Time{}, "", false
+	}
+	return cond.LastTransitionTime.Time, cond.Message, true
+}
+
+// String returns the condition as in READY_CONDITIONS.
+func (c readyCondition) String() string {
+	if c.Healthy == metav1.ConditionTrue {
+		return c.Type
+	}
+	return c.Type + ":" + string(c.Healthy)
+}
+
+// parseReadyConditions parses READY_CONDITIONS: comma-separated
+// <Kind>=<Type>[:<healthy status>] entries, e.g. "HelmRelease=Released" or
+// "Kustomization=Degraded:False". The healthy status defaults to True.
+func parseReadyConditions(spec string) (map[string]readyCondition, error) {
+	out := make(map[string]readyCondition)
+	if strings.TrimSpace(spec) == "" {
+		return out, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		kind, cond, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		typ, status, _ := strings.Cut(cond, ":")
+		if !ok || typ == "" {
+			return nil, fmt.Errorf("READY_CONDITIONS entry %q: want <Kind>=<Type>[:<healthy status>]", entry)
+		}
+		if kind != "Kustomization" && kind != "HelmRelease" {
+			return nil, fmt.Errorf("READY_CONDITIONS entry %q: kind must be Kustomization or HelmRelease", entry)
+		}
+		c := readyCondition{Type: typ, Healthy: metav1.ConditionTrue}
+		switch metav1.ConditionStatus(status) {
+		case "", metav1.ConditionTrue:
+		case metav1.ConditionFalse:
+			c.Healthy = metav1.ConditionFalse
+		default:
+			return nil, fmt.Errorf("READY_CONDITIONS entry %q: healthy status must be True or False", entry)
+		}
+		out[kind] = c
+	}
+	return out, nil
+}
+
+// readyCondition returns the condition telling whether a resource of kind
+// is healthy.
+func (r *RollbackController) readyCondition(kind string) readyCondition {
+	if c, ok := r.ReadyConditions[kind]; ok {
+		return c
+	}
+	return defaultReadyCondition
+}
+
+// isReadyKind reports whether a resource of kind with conditions is
+// healthy under its READY_CONDITIONS entry.
+func (r *RollbackController) isReadyKind(kind string, conditions []metav1.Condition) bool {
+	return r.readyCondition(kind).ready(conditions)
+}
@@ -0,0 +1,45 @@
+package rollback
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadyConditions(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	var err error
+	if r.ReadyConditions, err = parseReadyConditions("HelmRelease=Degraded:False, Kustomization=Available"); err != nil {
+		t.Fatal(err)
+	}
+	cond := func(typ string, status metav1.ConditionStatus) []metav1.Condition {
+		return []metav1.Condition{{Type: typ, Status: status}}
+	}
+	for _, tt := range []struct {
+		kind       string
+		conditions []metav1.Condition
+		want       bool
+	}{
+		{"HelmRelease", cond("Degraded", metav1.ConditionTrue), false},
+		{"HelmRelease", cond("Degraded", metav1.ConditionFalse), true},
+		// Ready is no longer looked at.
+		{"HelmRelease", cond("Ready", metav1.ConditionFalse), true},
+		{"Kustomization", cond("Available", metav1.ConditionFalse), false},
+		{"Kustomization", cond("Available", metav1.ConditionUnknown), true},
+		{"Kustomization", nil, true},
+	} {
+		if got := r.isReadyKind(tt.kind, tt.conditions); got != tt.want {
+			t.Errorf("isReadyKind(%s, %v) = %v, want %v", tt.kind, tt.conditions, got, tt.want)
+		}
+	}
+	if got := r.readyCondition("HelmRelease").String(); got != "Degraded:False" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, spec := range []string{"HelmRelease", "GitRepository=Ready", "HelmRelease=Ready:Maybe", "Kustomization=:False"} {
+		if _, err := parseReadyConditions(spec); err == nil {
+			t.Errorf("parseReadyConditions(%q) accepted", spec)
+		}
+	}
+}
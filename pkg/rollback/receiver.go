@@ -0,0 +1,70 @@
+package rollback
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// maxReceiverBody bounds the JSON body of a receiver request.
+const maxReceiverBody = 64 << 10
+
+// receiverRequest is the body of a receiver hook: the resources to evaluate
+// and whether Flux should reconcile them first, like "flux reconcile".
+type receiverRequest struct {
+	Resources []struct {
+		Kind      string `json:"kind,omitempty"` // needed for reconcile
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"resources"`
+	Reconcile bool `json:"reconcile,omitempty"`
+}
+
+// receiverPath returns the secret hook path for token, like the URL of a Flux
+// Receiver: /hook/ followed by the SHA-256 of the token.
+func receiverPath(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "/hook/" + hex.EncodeToString(sum[:])
+}
+
+// receiverHandler serves POST /hook/<sha256 of token>, which enqueues an
+// evaluation of the named Kustomizations and HelmReleases right away instead
+// of waiting for their next watch event, e.g. called by CI after a deploy.
+func (r *RollbackController) receiverHandler(ctx context.Context, token string) http.Handler {
+	path := receiverPath(token)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hook/{digest}", func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.URL.Path), []byte(path)) != 1 {
+			http.NotFound(w, req)
+			return
+		}
+		var body receiverRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxReceiverBody)).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body: " + err.Error()})
+			return
+		}
+		if len(body.Resources) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "resources required"})
+			return
+		}
+		for _, res := range body.Resources {
+			if res.Namespace == "" || res.Name == "" || (res.Kind != "" && res.Kind != "Kustomization" && res.Kind != "HelmRelease") {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "each resource needs a namespace and a name; kind must be Kustomization or HelmRelease"})
+				return
+			}
+		}
+		for _, item := range body.Resources {
+			res := resourceRef{Kind: item.Kind, Namespace: item.Namespace, Name: item.Name}
+			r.log.Info("Receiver requested evaluation", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "reconcile", body.Reconcile)
+			if body.Reconcile && res.Kind != "" {
+				r.requestFluxReconcile(ctx, res)
+			}
+			r.requestReconcile(res.Namespace, res.Name)
+		}
+		writeJSON(w, http.StatusAccepted, map[string]int{"queued": len(body.Resources)})
+	})
+	return mux
+}
@@ -0,0 +1,43 @@
+package rollback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestReceiver(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	h := r.receiverHandler(context.Background(), "s3cret")
+	post := func(path, body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rec.Code
+	}
+	valid := `{"resources":[{"kind":"Kustomization","namespace":"apps","name":"web"},{"namespace":"apps","name":"api"}]}`
+
+	if code := post(receiverPath("wrong"), valid); code != http.StatusNotFound {
+		t.Errorf("wrong token: status %d", code)
+	}
+	for _, body := range []string{`{}`, `{"resources":[{"name":"web"}]}`, `{"resources":[{"kind":"Deployment","namespace":"apps","name":"web"}]}`, `not json`} {
+		if code := post(receiverPath("s3cret"), body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", body, code)
+		}
+	}
+	if len(r.enqueue) != 0 {
+		t.Fatalf("rejected requests enqueued %d reconciles", len(r.enqueue))
+	}
+	if code := post(receiverPath("s3cret"), valid); code != http.StatusAccepted {
+		t.Fatalf("valid request: status %d", code)
+	}
+	if len(r.enqueue) != 2 {
+		t.Fatalf("enqueued %d reconciles, want 2", len(r.enqueue))
+	}
+	if ev := <-r.enqueue; ev.Object.GetNamespace() != "apps" || ev.Object.GetName() != "web" {
+		t.Errorf("enqueued %s/%s", ev.Object.GetNamespace(), ev.Object.GetName())
+	}
+}
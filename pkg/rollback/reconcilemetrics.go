@@ -0,0 +1,49 @@
+package rollback
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// reconcilerName names the controller in the controller-runtime workqueue
+// and reconcile metrics (controller="rollback").
+const reconcilerName = "rollback"
+
+// Reconcile metrics by Flux kind, served on the controller-runtime metrics
+// endpoint next to its workqueue metrics.
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rollback_reconcile_duration_seconds",
+		Help:    "Reconcile latency by kind; Unknown if the resource could not be read.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind"})
+	reconcileRequeues = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rollback_reconcile_requeues_total",
+		Help: "Reconciles that requeued their resource, by kind and decision action (Error if retried with backoff).",
+	}, []string{"kind", "action"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileDuration, reconcileRequeues)
+}
+
+// observeReconcile records the latency of a reconcile of kind.
+func observeReconcile(kind string, d time.Duration) {
+	if kind == "" {
+		kind = "Unknown"
+	}
+	reconcileDuration.WithLabelValues(kind).Observe(d.Seconds())
+}
+
+// observeRequeue counts the decision d for res if it requeues res.
+func observeRequeue(res resourceRef, d Decision) {
+	action := string(d.Action)
+	if d.Err != nil {
+		action = "Error"
+	} else if d.RequeueAfter <= 0 {
+		return
+	}
+	reconcileRequeues.WithLabelValues(res.Kind, action).Inc()
+}
@@ -0,0 +1,47 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileMetrics(t *testing.T) {
+	res := resourceRef{Kind: "HelmRelease", Namespace: "apps", Name: "web"}
+	requeues := func(action string) float64 {
+		return testutil.ToFloat64(reconcileRequeues.WithLabelValues(res.Kind, action))
+	}
+	waiting, errored := requeues("Waiting"), requeues("Error")
+	observeRequeue(res, Decision{Action: DecisionWaiting, RequeueAfter: time.Minute})
+	observeRequeue(res, Decision{Action: DecisionNone})
+	observeRequeue(res, Decision{Err: errors.New("conversion failed")})
+	if got := requeues("Waiting") - waiting; got != 1 {
+		t.Errorf("Waiting requeues +%v, want +1", got)
+	}
+	if got := requeues("Error") - errored; got != 1 {
+		t.Errorf("Error requeues +%v, want +1", got)
+	}
+	if n := testutil.CollectAndCount(reconcileRequeues); n == 0 {
+		t.Error("no requeue series collected")
+	}
+
+	// A resource that can't be found is reconciled as Unknown.
+	c := fake.NewClientBuilder().WithScheme(newScheme()).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	req := ctrl.Request{}
+	req.Namespace, req.Name = "apps", "gone"
+	if _, err := (&GenericReconciler{r}).Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(reconcileDuration, "rollback_reconcile_duration_seconds"); n == 0 {
+		t.Error("reconcile duration not observed")
+	}
+}
@@ -0,0 +1,222 @@
+package rollback
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Values for REVERT_MODE. In both modes no changes are made in GitLab; the
+// recording mode additionally persists the would-be actions.
+const (
+	revertModeEcho   = "echo"
+	revertModeRecord = "record"
+)
+
+// recordingConfigKey is the ConfigMap data key holding recorded actions.
+const recordingConfigKey = "actions.json"
+
+// maxRecordedActions bounds the actions kept in the recording ConfigMap,
+// which is limited to 1MiB.
+const maxRecordedActions = 500
+
+// dryRun reports whether REVERT_MODE or the soak period (SOAK_DAYS)
+// disables changes in GitLab.
+func dryRun() bool {
+	mode := os.Getenv("REVERT_MODE")
+	return mode == revertModeEcho || mode == revertModeRecord || soak.active()
+}
+
+// recordedAction is a revert action the controller would have performed.
+type recordedAction struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"` // revert, revertFiles, pinChartVersion, pinImages, suspend or autoMerge
+	Strategy  string    `json:"strategy"`
+	Project   string    `json:"project"`
+	Branch    string    `json:"branch"`
+	SHA       string    `json:"sha,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Files     []string  `json:"files,omitempty"`
+	From      string    `json:"from,omitempty"` // chart version being replaced
+	To        string    `json:"to,omitempty"`   // chart version pinned
+	Soak      bool      `json:"soak,omitempty"` // recorded during the soak period
+}
+
+// recordAction persists a would-be action to RecordFile or RecordConfigMap
+// when running with REVERT_MODE=record or during the soak period.
+func (r *RollbackController) recordAction(gl gitlabProject, a recordedAction) {
+	switch {
+	case os.Getenv("REVERT_MODE") == revertModeRecord:
+	case soak.active():
+		a.Soak = true
+	default:
+		return
+	}
+	a.Time = r.clock.Now()
+	a.Project = gl.url("")
+	var err error
+	switch {
+	case r.RecordFile != "":
+		err = appendRecordFile(r.RecordFile, a)
+	case r.RecordConfigMap != "":
+		err = r.appendRecordConfigMap(a)
+	default:
+		err = fmt.Errorf("neither RECORD_FILE nor RECORD_CONFIGMAP is set")
+	}
+	if err != nil {
+		r.log.Error(err, "failed to record action", "action", a.Action, "branch", a.Branch)
+	}
+}
+
+// appendRecordFile appends the action as one JSON line.
+func appendRecordFile(path string, a recordedAction) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(a)
+}
+
+// readRecordFile reads the actions written by appendRecordFile.
+func readRecordFile(path string) ([]recordedAction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var actions []recordedAction
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var a recordedAction
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, sc.Err()
+}
+
+// appendRecordConfigMap appends the action to the recording ConfigMap in the
+// controller namespace, creating it if needed and keeping the newest
+// maxRecordedActions entries.
+func (r *RollbackController) appendRecordConfigMap(a recordedAction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var cm corev1.ConfigMap
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.RecordConfigMap}, &cm)
+		create := apierrors.IsNotFound(err)
+		if err != nil && !create {
+			return err
+		}
+		actions, err := configMapActions(&cm)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, a)
+		if n := len(actions); n > maxRecordedActions {
+			actions = actions[n-maxRecordedActions:]
+		}
+		data, err := json.Marshal(actions)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[recordingConfigKey] = string(data)
+		if create {
+			cm.ObjectMeta = metav1.ObjectMeta{Namespace: r.Namespace, Name: r.RecordConfigMap}
+			return r.Create(ctx, &cm)
+		}
+		return r.Update(ctx, &cm)
+	})
+}
+
+func configMapActions(cm *corev1.ConfigMap) ([]recordedAction, error) {
+	var actions []recordedAction
+	if data := cm.Data[recordingConfigKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &actions); err != nil {
+			return nil, fmt.Errorf("configmap %s/%s: %w", cm.Namespace, cm.Name, err)
+		}
+	}
+	return actions, nil
+}
+
+// writeRecordings renders recorded actions as "table", "json" or "yaml".
+func writeRecordings(w io.Writer, actions []recordedAction, format string) error {
+	switch format {
+	case "json", "yaml":
+		return writeStructured(w, actions, format)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tACTION\tRESOURCE\tBRANCH\tDETAILS")
+		for _, a := range actions {
+			resource := "-"
+			if a.Name != "" {
+				resource = a.Namespace + "/" + a.Name
+			}
+			details := a.SHA
+			if a.To != "" {
+				details = a.From + " -> " + a.To
+			}
+			if len(a.Files) > 0 {
+				details = fmt.Sprintf("%s (%d files)", a.SHA, len(a.Files))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.Time.Format(time.RFC3339), a.Action, resource, a.Branch, details)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q (want table, json or yaml)", format)
+}
+
+// runRecordings implements the `recordings` subcommand, which prints the
+// actions recorded with REVERT_MODE=record.
+func runRecordings(args []string) int {
+	fs := flag.NewFlagSet("recordings", flag.ExitOnError)
+	output := fs.String("o", "table", "output format: table, json or yaml")
+	_ = fs.Parse(args)
+
+	var actions []recordedAction
+	var err error
+	if path := os.Getenv("RECORD_FILE"); path != "" {
+		actions, err = readRecordFile(path)
+	} else if name := os.Getenv("RECORD_CONFIGMAP"); name != "" {
+		var c client.Client
+		if c, err = client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: newScheme()}); err == nil {
+			var cm corev1.ConfigMap
+			if err = c.Get(context.Background(), client.ObjectKey{Namespace: controllerNamespace(), Name: name}, &cm); err == nil {
+				actions, err = configMapActions(&cm)
+			}
+		}
+	} else {
+		err = fmt.Errorf("set RECORD_FILE or RECORD_CONFIGMAP")
+	}
+	if err == nil {
+		err = writeRecordings(os.Stdout, actions, *output)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package rollback
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecordActionToFile(t *testing.T) {
+	t.Setenv("REVERT_MODE", revertModeRecord)
+	r := NewRollbackController(nil, logr.Discard(), "", "42", "https://gitlab.example.com", "revert", 300)
+	r.RecordFile = filepath.Join(t.TempDir(), "actions.jsonl")
+
+	r.createGitlabRevertMR(r.defaultProject(), resourceRef{}, nil, "abc")
+	r.recordAction(r.defaultProject(), recordedAction{Action: "pinChartVersion", Branch: "revert-apps-web-1.0.0", Namespace: "apps", Name: "web", From: "1.1.0", To: "1.0.0"})
+
+	actions, err := readRecordFile(r.RecordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 || actions[0].Action != "revert" || actions[0].SHA != "abc" || actions[0].Branch != "revert-abc" ||
+		actions[0].Project != "https://gitlab.example.com/api/v4/projects/42" || actions[1].To != "1.0.0" {
+		t.Errorf("unexpected actions %+v", actions)
+	}
+
+	var buf bytes.Buffer
+	if err := writeRecordings(&buf, actions, "table"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "apps/web") || !strings.Contains(buf.String(), "1.1.0 -> 1.0.0") {
+		t.Errorf("unexpected table:\n%s", buf.String())
+	}
+}
+
+func TestRecordActionToConfigMap(t *testing.T) {
+	t.Setenv("REVERT_MODE", revertModeRecord)
+	c := fake.NewClientBuilder().WithScheme(newScheme()).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "42", "https://gitlab", "revert", 300)
+	r.Namespace = "flux-system"
+	r.RecordConfigMap = "rollback-recordings"
+
+	for i := 0; i < maxRecordedActions+1; i++ {
+		r.createGitlabRevertMR(r.defaultProject(), resourceRef{}, nil, "abc")
+	}
+	var cm corev1.ConfigMap
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "flux-system", Name: "rollback-recordings"}, &cm); err != nil {
+		t.Fatal(err)
+	}
+	actions, err := configMapActions(&cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != maxRecordedActions {
+		t.Errorf("got %d recorded actions, want %d", len(actions), maxRecordedActions)
+	}
+}
+
+func TestRecordActionOnlyInRecordMode(t *testing.T) {
+	t.Setenv("REVERT_MODE", revertModeEcho)
+	r := NewRollbackController(nil, logr.Discard(), "", "42", "https://gitlab", "revert", 300)
+	r.RecordFile = filepath.Join(t.TempDir(), "actions.jsonl")
+	r.createGitlabRevertMR(r.defaultProject(), resourceRef{}, nil, "abc")
+	if _, err := readRecordFile(r.RecordFile); err == nil {
+		t.Error("echo mode must not record actions")
+	}
+}
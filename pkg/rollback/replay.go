@@ -0,0 +1,93 @@
+package rollback
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var fullSHA = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// completedKeysFromBranches derives tracking keys from revert branch names.
+// Commit reverts use "<prefix>-<revision>", so the remainder is the key
+// itself; file reverts end in "-<sha>", so a trailing full SHA is added too.
+// Chart pin branches name the target version, not the failing one, and
+// cannot be mapped back. Templated names (REVERT_BRANCH_TEMPLATE) starting
+// with "<prefix>/" yield every full SHA among their "/"- or "-"-separated
+// parts.
+func completedKeysFromBranches(prefix string, branches []string) []string {
+	var keys []string
+	for _, b := range branches {
+		if rest, ok := strings.CutPrefix(b, prefix+"/"); ok {
+			for _, part := range strings.FieldsFunc(rest, func(c rune) bool { return c == '/' || c == '-' }) {
+				if fullSHA.MatchString(part) {
+					keys = append(keys, part)
+				}
+			}
+			continue
+		}
+		rest, ok := strings.CutPrefix(b, prefix+"-")
+		if !ok || rest == "" {
+			continue
+		}
+		keys = append(keys, rest)
+		if i := strings.LastIndex(rest, "-"); i >= 0 && fullSHA.MatchString(rest[i+1:]) {
+			keys = append(keys, rest[i+1:])
+		}
+	}
+	return keys
+}
+
+// revertBranches returns the names of existing branches and MR source
+// branches (any state, since merged MRs delete their branch) starting with
+// the revert branch prefix.
+func (g gitlabProject) revertBranches(branchPrefix string) ([]string, error) {
+	ours := func(name string) bool {
+		return strings.HasPrefix(name, branchPrefix+"-") || strings.HasPrefix(name, branchPrefix+"/")
+	}
+	type branch struct {
+		Name string `json:"name"`
+	}
+	branches, err := listPages[branch](g, "/repository/branches?search="+url.QueryEscape("^"+branchPrefix), g.api.orDefault().discovery)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, b := range branches {
+		if ours(b.Name) {
+			names = append(names, b.Name)
+		}
+	}
+	type mergeRequest struct {
+		SourceBranch string `json:"source_branch"`
+	}
+	mrs, err := listPages[mergeRequest](g, "/merge_requests?state=all", g.api.orDefault().discovery)
+	if err != nil {
+		return nil, err
+	}
+	for _, mr := range mrs {
+		if ours(mr.SourceBranch) {
+			names = append(names, mr.SourceBranch)
+		}
+	}
+	return names, nil
+}
+
+// restoreCompletedSHAs marks as completed the SHAs of revert branches and MRs
+// that already exist in GitLab, so a reinstalled controller never re-creates
+// a revert.
+func (r *RollbackController) restoreCompletedSHAs() {
+	if dryRun() || r.GitlabToken == "" {
+		return
+	}
+	branches, err := r.defaultProject().revertBranches(r.RevertBranchPrefix)
+	if err != nil {
+		r.log.Error(err, "failed to restore completed SHAs from GitLab")
+		return
+	}
+	keys := completedKeysFromBranches(r.RevertBranchPrefix, branches)
+	for _, k := range keys {
+		r.debounce.Complete(k)
+	}
+	r.log.Info("Restored completed SHAs from existing revert branches", "branches", len(branches), "keys", len(keys))
+}
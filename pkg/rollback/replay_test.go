@@ -0,0 +1,26 @@
+package rollback
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompletedKeysFromBranches(t *testing.T) {
+	sha := "838d357b33a16d7efe4ad42a813fa2134d5da581"
+	got := completedKeysFromBranches("revert", []string{
+		"revert-main@sha1:" + sha,
+		"revert-my-app-my-app-" + sha,
+		"revert-",
+		"feature-x",
+		"revert/apps/my-app/files/" + sha + "/20240101",
+		"revert/apps/my-app/abc1234",
+	})
+	want := []string{
+		"main@sha1:" + sha,
+		"my-app-my-app-" + sha, sha,
+		sha,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
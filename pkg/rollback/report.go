@@ -0,0 +1,177 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// reportEntry is one failing Flux resource in the output of `report`.
+type reportEntry struct {
+	Kind         string    `json:"kind"`
+	Namespace    string    `json:"namespace"`
+	Name         string    `json:"name"`
+	Revision     string    `json:"revision"`
+	RevertExists bool      `json:"revertExists"`
+	FailingSince time.Time `json:"failingSince"`
+	FailingFor   string    `json:"failingFor"`
+	Message      string    `json:"message,omitempty"`
+}
+
+// revertExists reports whether any of the revert keys derived from existing
+// branches covers the revision.
+func revertExists(keys map[string]bool, revision string) bool {
+	return revision != "" && (keys[revision] || keys[gitCommitSHA(revision)])
+}
+
+// failingResources lists all Kustomizations and HelmReleases whose ready
+// condition (READY_CONDITIONS, default Ready) reports a failure, with the
+// revision the controller would revert.
+func (r *RollbackController) failingResources(ctx context.Context, now time.Time) ([]reportEntry, error) {
+	var entries []reportEntry
+	add := func(kind, ns, name, rev string, since time.Time, msg string) {
+		entries = append(entries, reportEntry{
+			Kind: kind, Namespace: ns, Name: name, Revision: rev, FailingSince: since,
+			FailingFor: now.Sub(since).Truncate(time.Second).String(), Message: msg,
+		})
+	}
+
+	kss, err := r.listConverted(ctx, "Kustomization", func() client.Object { return &kustomizev1.Kustomization{} })
+	if err != nil {
+		return nil, fmt.Errorf("listing Kustomizations: %w", err)
+	}
+	for _, obj := range kss {
+		ks := obj.(*kustomizev1.Kustomization)
+		if since, msg, ok := r.readyCondition("Kustomization").failingSince(ks.Status.Conditions); ok {
+			add("Kustomization", ks.Namespace, ks.Name, r.kustomizationRevision(ctx, ks, false), since, msg)
+		}
+	}
+
+	hrs, err := r.listConverted(ctx, "HelmRelease", func() client.Object { return &helmv2.HelmRelease{} })
+	if err != nil {
+		return nil, fmt.Errorf("listing HelmReleases: %w", err)
+	}
+	for _, obj := range hrs {
+		hr := obj.(*helmv2.HelmRelease)
+		since, msg, ok := r.readyCondition("HelmRelease").failingSince(hr.Status.Conditions)
+		if !ok {
+			continue
+		}
+		policy := r.policyFor(ctx, "HelmRelease", hr.Namespace, hr.Name)
+		rev := hr.Status.LastAttemptedRevision
+		if policy.helmRemediation() != HelmRemediationPinChartVersion {
+			if sha, ok := r.helmRevision(ctx, hr, policy); ok {
+				rev = sha
+			}
+		}
+		add("HelmRelease", hr.Namespace, hr.Name, rev, since, msg)
+	}
+	return entries, nil
+}
+
+// markExistingReverts sets RevertExists from the revert branches in each
+// resource's GitLab project. Projects are queried once each.
+func (r *RollbackController) markExistingReverts(ctx context.Context, entries []reportEntry) {
+	keysByProject := map[gitlabProject]map[string]bool{}
+	for i := range entries {
+		e := &entries[i]
+		gl := r.project(ctx, e.Kind, e.Namespace, e.Name)
+		keys, ok := keysByProject[gl]
+		if !ok {
+			keys = map[string]bool{}
+			branches, err := gl.revertBranches(r.RevertBranchPrefix)
+			if err != nil {
+				r.log.Error(err, "cannot list revert branches", "project", gl.ProjectID)
+			}
+			for _, k := range completedKeysFromBranches(r.RevertBranchPrefix, branches) {
+				keys[k] = true
+			}
+			keysByProject[gl] = keys
+		}
+		e.RevertExists = revertExists(keys, e.Revision)
+	}
+}
+
+// writeReport renders entries as "table", "json" or "yaml".
+func writeReport(w io.Writer, entries []reportEntry, format string) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FailingSince.Before(entries[j].FailingSince)
+	})
+	switch format {
+	case "json", "yaml":
+		return writeStructured(w, entries, format)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tNAMESPACE\tNAME\tREVISION\tREVERT\tFAILING FOR")
+		for _, e := range entries {
+			revert := "no"
+			if e.RevertExists {
+				revert = "yes"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Kind, e.Namespace, e.Name, e.Revision, revert, e.FailingFor)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q (want table, json or yaml)", format)
+}
+
+// writeStructured renders v as indented JSON or as YAML.
+func writeStructured(w io.Writer, v interface{}, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// runReport implements the `report` subcommand: a one-shot scan of failing
+// Flux resources for incident retrospectives or cron jobs.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	output := fs.String("o", "table", "output format: table, json or yaml")
+	_ = fs.Parse(args)
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: newScheme()})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	rollback, err := controllerFromEnv(c, ctrl.Log.WithName("report"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	rollback.APIs = servedFluxAPIs(c.RESTMapper())
+	ctx := context.Background()
+	entries, err := rollback.failingResources(ctx, rollback.clock.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !dryRun() {
+		rollback.markExistingReverts(ctx, entries)
+	}
+	if err := writeReport(os.Stdout, entries, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package rollback
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFailingSince(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	conds := []metav1.Condition{{Type: "Ready", Status: metav1.ConditionFalse, LastTransitionTime: metav1.NewTime(ts), Message: "boom"}}
+	since, msg, ok := defaultReadyCondition.failingSince(conds)
+	if !ok || !since.Equal(ts) || msg != "boom" {
+		t.Errorf("failingSince = %v, %q, %v", since, msg, ok)
+	}
+	if _, _, ok := defaultReadyCondition.failingSince([]metav1.Condition{{Type: "Ready", Status: metav1.ConditionTrue}}); ok {
+		t.Error("ready resource reported as failing")
+	}
+	if _, _, ok := defaultReadyCondition.failingSince(nil); ok {
+		t.Error("resource without conditions reported as failing")
+	}
+}
+
+func TestRevertExists(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	keys := map[string]bool{sha: true}
+	if !revertExists(keys, "main@sha1:"+sha) {
+		t.Error("revert for Flux revision not found")
+	}
+	if revertExists(keys, "main@sha1:"+strings.Repeat("b", 40)) || revertExists(keys, "") {
+		t.Error("unexpected revert match")
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []reportEntry{
+		{Kind: "HelmRelease", Namespace: "apps", Name: "b", Revision: "1.2.3", FailingSince: now, FailingFor: "1m0s"},
+		{Kind: "Kustomization", Namespace: "flux-system", Name: "a", Revision: "main@sha1:abc", RevertExists: true, FailingSince: now.Add(-time.Hour), FailingFor: "1h1m0s"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, entries, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "KIND") {
+		t.Fatalf("unexpected table:\n%s", buf.String())
+	}
+	if !strings.Contains(lines[1], "Kustomization") || !strings.Contains(lines[1], "yes") {
+		t.Errorf("oldest failure should be listed first with a revert: %q", lines[1])
+	}
+
+	buf.Reset()
+	if err := writeReport(&buf, entries, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []reportEntry
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != 2 {
+		t.Errorf("invalid JSON report: %v\n%s", err, buf.String())
+	}
+
+	buf.Reset()
+	if err := writeReport(&buf, entries, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "revertExists: true") {
+		t.Errorf("unexpected YAML report:\n%s", buf.String())
+	}
+
+	if err := writeReport(&buf, entries, "xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,28 @@
+package rollback
+
+import (
+	"fmt"
+	"time"
+)
+
+// checkRevisionChange reports whether the failure of res started more than
+// RevisionChangeWindow after its revision last changed. A resource that
+// breaks long after its last deploy is broken by drift or infrastructure,
+// e.g. an expired certificate or a full node, and reverting the last commit
+// would not fix it; such failures are only notified about. Callers must hold
+// r.mu.
+func (r *RollbackController) checkRevisionChange(res resourceRef) (string, bool) {
+	if r.RevisionChangeWindow <= 0 {
+		return "", false
+	}
+	st, fl := r.resources[res.String()], r.flaps[res.String()]
+	if st == nil || fl == nil || fl.FailingSince.IsZero() {
+		return "", false
+	}
+	unchanged := fl.FailingSince.Sub(st.RevisionSince)
+	if unchanged <= r.RevisionChangeWindow {
+		return "", false
+	}
+	return fmt.Sprintf("revision unchanged for %s before the failure (window %s); likely drift or infrastructure, not reverted",
+		unchanged.Round(time.Second), r.RevisionChangeWindow), true
+}
@@ -0,0 +1,44 @@
+package rollback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestRevisionChangeWindow(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(t0)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 0)
+	r.setClock(clk)
+	r.RevisionChangeWindow = time.Hour
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+
+	r.handleResource("Kustomization", "drift", "apps", "main@sha1:aaa", true, revert)
+	r.handleResource("Kustomization", "deploy", "apps", "main@sha1:aaa", true, revert)
+
+	// Two hours later: "deploy" gets a new revision and breaks, "drift"
+	// breaks on the revision it has been running all along.
+	clk.Step(2 * time.Hour)
+	r.handleResource("Kustomization", "deploy", "apps", "main@sha1:bbb", true, revert)
+	clk.Step(time.Minute)
+	r.handleResource("Kustomization", "drift", "apps", "main@sha1:aaa", false, revert)
+	got := r.handleResource("Kustomization", "drift", "apps", "main@sha1:aaa", false, revert)
+	if got.Action != DecisionNotified || len(reverted) != 0 {
+		t.Fatalf("drift: %+v, reverted %v; want notified only", got, reverted)
+	}
+	if e := r.auditLog[len(r.auditLog)-1]; e.Event != auditNotified || e.Name != "drift" {
+		t.Errorf("last audit entry = %+v", e)
+	}
+
+	r.handleResource("Kustomization", "deploy", "apps", "main@sha1:bbb", false, revert)
+	if got := r.handleResource("Kustomization", "deploy", "apps", "main@sha1:bbb", false, revert); got.Action != DecisionReverted {
+		t.Errorf("deploy: %+v, want reverted", got)
+	}
+	if len(reverted) != 1 || reverted[0] != "main@sha1:bbb" {
+		t.Errorf("reverted %v, want the new revision", reverted)
+	}
+}
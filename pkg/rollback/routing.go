@@ -0,0 +1,358 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// routingConfigKey is the ConfigMap data key holding the routing rules.
+const routingConfigKey = "rules.yaml"
+
+// routeMatch selects resources by shell-style glob patterns (see path.Match).
+// An empty field matches anything.
+type routeMatch struct {
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// routeRule sends matching resources to a GitLab project. Empty URL falls
+// back to GITLAB_URL; empty TokenSecret to the token of the instance with
+// that URL, or GITLAB_TOKEN.
+type routeRule struct {
+	Match     routeMatch `json:"match"`
+	URL       string     `json:"url,omitempty"`
+	ProjectID string     `json:"projectID"`
+	// Provider is "gitlab" (default), "gerrit" or "codecommit". For Gerrit,
+	// URL is the server, ProjectID the project name and Username the HTTP
+	// user whose HTTP password is in TokenSecret. For CodeCommit, ProjectID
+	// is the repository name in Region, URL optionally overrides the API
+	// endpoint and credentials come from the pod's IAM role (IRSA). For
+	// "ssh", URL is the Git remote and SSHKeySecret holds the deploy key.
+	Provider string `json:"provider,omitempty"`
+	Username string `json:"username,omitempty"`
+	Region   string `json:"region,omitempty"`
+	// SSHKeySecret names a Secret in the controller namespace with the
+	// "identity" and "known_hosts" keys, as used by Flux GitRepositories.
+	SSHKeySecret string `json:"sshKeySecret,omitempty"`
+	// TokenSecret names a Secret in the controller namespace whose "token" key
+	// holds the API token for this project.
+	TokenSecret string `json:"tokenSecret,omitempty"`
+	// Proxy is the egress proxy URL (http, https, socks5 or socks5h) for this
+	// project, replacing HTTP(S)_PROXY; empty uses the proxy of the instance
+	// with URL, if any. ProxySecret names a Secret in the controller namespace
+	// whose "username" and "password" keys authenticate to the proxy.
+	Proxy       string `json:"proxy,omitempty"`
+	ProxySecret string `json:"proxySecret,omitempty"`
+}
+
+// gitlabInstance is a GitLab server with its own token. Resources no rule
+// matches are routed to the instance hosting their GitRepository, in the
+// project named by the repository's URL path.
+type gitlabInstance struct {
+	// URL is the instance's base URL, e.g. https://gitlab.com.
+	URL string `json:"url"`
+	// Hosts are further host names GitRepository URLs use for the instance,
+	// e.g. an SSH endpoint; the host of URL always matches.
+	Hosts []string `json:"hosts,omitempty"`
+	// TokenSecret names a Secret in the controller namespace whose "token"
+	// key holds the API token for the instance.
+	TokenSecret string `json:"tokenSecret"`
+	// Proxy and ProxySecret are the instance's egress proxy, as for rules.
+	Proxy       string `json:"proxy,omitempty"`
+	ProxySecret string `json:"proxySecret,omitempty"`
+}
+
+// routingConfig is the content of the routing ConfigMap. Rules are evaluated
+// in order and the first match wins; otherwise a resource goes to the
+// instance hosting its GitRepository, and Default applies if there is none.
+type routingConfig struct {
+	Default   *routeRule       `json:"default,omitempty"`
+	Rules     []routeRule      `json:"rules,omitempty"`
+	Instances []gitlabInstance `json:"instances,omitempty"`
+}
+
+// instance returns the instance serving host, or nil.
+func (c *routingConfig) instance(host string) *gitlabInstance {
+	if host == "" {
+		return nil
+	}
+	for i := range c.Instances {
+		inst := &c.Instances[i]
+		if u, err := url.Parse(inst.URL); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return inst
+		}
+		for _, h := range inst.Hosts {
+			if strings.EqualFold(h, host) {
+				return inst
+			}
+		}
+	}
+	return nil
+}
+
+// proxy returns the egress proxy of rule and the Secret with its
+// credentials: the rule's own, or that of the instance serving its URL.
+func (c *routingConfig) proxy(rule *routeRule) (proxy, secret string) {
+	if rule.Proxy != "" || rule.URL == "" {
+		return rule.Proxy, rule.ProxySecret
+	}
+	if inst := c.instance(hostname(rule.URL)); inst != nil {
+		return inst.Proxy, inst.ProxySecret
+	}
+	return "", ""
+}
+
+// gitRepoProject splits a GitRepository URL into its host and project path,
+// e.g. "https://gitlab.com/group/app.git" and "ssh://git@gitlab.com/group/app"
+// into "gitlab.com" and "group/app". scp-like URLs ("git@host:group/app")
+// are accepted too.
+func gitRepoProject(raw string) (host, project string, ok bool) {
+	if !strings.Contains(raw, "://") {
+		if at := strings.Index(raw, "@"); at >= 0 {
+			if h, p, found := strings.Cut(raw[at+1:], ":"); found {
+				raw = "ssh://" + raw[:at+1] + h + "/" + p
+			}
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return "", "", false
+	}
+	project = strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	return u.Hostname(), project, project != ""
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+func (m routeMatch) matches(kind, namespace, name string) bool {
+	return globMatch(m.Kind, kind) && globMatch(m.Namespace, namespace) && globMatch(m.Name, name)
+}
+
+// route returns the rule for the resource, or nil if neither a rule nor a
+// default applies.
+func (c *routingConfig) route(kind, namespace, name string) *routeRule {
+	for i := range c.Rules {
+		if c.Rules[i].Match.matches(kind, namespace, name) {
+			return &c.Rules[i]
+		}
+	}
+	return c.Default
+}
+
+// defaultProject returns the GitLab project configured via the environment.
+func (r *RollbackController) defaultProject() gitlabProject {
+	return r.bind(gitlabProject{BaseURL: r.GitlabBaseURL, ProjectID: r.GitlabProjectID, Token: r.GitlabToken})
+}
+
+// bind returns gl sending its requests with the controller's provider API
+// and stamping its instance on the MRs it opens.
+func (r *RollbackController) bind(gl gitlabProject) gitlabProject {
+	gl.api, gl.instance = r.api, r.Instance
+	return gl
+}
+
+// secretToken reads the "token" key of a Secret in the controller namespace.
+func (r *RollbackController) secretToken(ctx context.Context, name string) (string, error) {
+	var secret corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: name}, &secret); err != nil {
+		return "", err
+	}
+	token, ok := secret.Data["token"]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no token key", r.Namespace, name)
+	}
+	return string(token), nil
+}
+
+// secretSSHKey reads the "identity" and "known_hosts" keys of a Secret in
+// the controller namespace.
+func (r *RollbackController) secretSSHKey(ctx context.Context, name string) (identity, knownHosts string, err error) {
+	var secret corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: name}, &secret); err != nil {
+		return "", "", err
+	}
+	key, ok := secret.Data["identity"]
+	if !ok {
+		return "", "", fmt.Errorf("secret %s/%s has no identity key", r.Namespace, name)
+	}
+	return string(key), string(secret.Data["known_hosts"]), nil
+}
+
+// proxyURL returns the proxy URL with the "username" and "password" keys of
+// the Secret named secret, if any, as credentials.
+func (r *RollbackController) proxyURL(ctx context.Context, proxy, secret string) (string, error) {
+	u, err := parseProxyURL(proxy)
+	if err != nil || secret == "" {
+		return proxy, err
+	}
+	var s corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: secret}, &s); err != nil {
+		return "", err
+	}
+	username, ok := s.Data["username"]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no username key", r.Namespace, secret)
+	}
+	u.User = url.UserPassword(string(username), string(s.Data["password"]))
+	return u.String(), nil
+}
+
+// project returns the GitLab project a revert for the resource goes to. The
+// routing ConfigMap is read at revert time, so rule changes apply without a
+// restart; any error falls back to the default project. The project-id
+// annotation of the resource's namespace replaces the project of the
+// GITLAB_* settings and of the routing default, but not of matching rules.
+func (r *RollbackController) project(ctx context.Context, kind, namespace, name string) gitlabProject {
+	gl := r.defaultProject()
+	nsProjectID := r.namespaceDefaultsFor(ctx, namespace).ProjectID
+	if nsProjectID != "" {
+		gl.ProjectID = nsProjectID
+	}
+	fallback := gl
+	if r.RoutingConfigMap == "" {
+		return gl
+	}
+	var cm corev1.ConfigMap
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.RoutingConfigMap}, &cm); err != nil {
+		r.log.Error(err, "failed to read routing ConfigMap, using default project", "configmap", r.RoutingConfigMap)
+		return gl
+	}
+	var cfg routingConfig
+	if err := yaml.Unmarshal([]byte(cm.Data[routingConfigKey]), &cfg); err != nil {
+		r.log.Error(err, "invalid routing rules, using default project", "configmap", r.RoutingConfigMap)
+		return gl
+	}
+	rule := cfg.route(kind, namespace, name)
+	if (rule == nil || rule == cfg.Default) && len(cfg.Instances) > 0 {
+		if routed, ok := r.projectBySource(ctx, &cfg, resourceRef{Kind: kind, Namespace: namespace, Name: name}); ok {
+			return routed
+		}
+	}
+	if rule == nil {
+		return gl
+	}
+	if rule == cfg.Default && nsProjectID != "" {
+		def := *rule
+		def.ProjectID = nsProjectID
+		rule = &def
+	}
+	if rule.URL != "" {
+		gl.BaseURL = rule.URL
+	}
+	if rule.ProjectID != "" {
+		gl.ProjectID = rule.ProjectID
+	}
+	if proxy, secret := cfg.proxy(rule); proxy != "" {
+		var err error
+		if gl.Proxy, err = r.proxyURL(ctx, proxy, secret); err != nil {
+			r.log.Error(err, "failed to configure proxy for route, using default project", "secret", secret)
+			return fallback
+		}
+	}
+	switch rule.Provider {
+	case "", providerGitLab, providerGerrit:
+		gl.Provider = rule.Provider
+		gl.Username = rule.Username
+	case providerCodeCommit:
+		gl.Provider = rule.Provider
+		gl.BaseURL = rule.URL
+		gl.Region = rule.Region
+		gl.Token = ""
+		r.log.V(1).Info("Routed revert", "kind", kind, "namespace", namespace, "name", name, "provider", gl.Provider, "repository", gl.ProjectID)
+		return gl
+	case providerSSH:
+		gl = r.bind(gitlabProject{Provider: rule.Provider, BaseURL: rule.URL, ProjectID: rule.ProjectID, Proxy: gl.Proxy})
+		if rule.SSHKeySecret != "" {
+			key, knownHosts, err := r.secretSSHKey(ctx, rule.SSHKeySecret)
+			if err != nil {
+				r.log.Error(err, "failed to read deploy key for route, using default project", "secret", rule.SSHKeySecret)
+				return fallback
+			}
+			gl.SSHKey, gl.KnownHosts = key, knownHosts
+		}
+		r.log.V(1).Info("Routed revert", "kind", kind, "namespace", namespace, "name", name, "provider", gl.Provider, "remote", gl.BaseURL)
+		return gl
+	default:
+		r.log.Error(nil, "unknown provider in routing rule, using default project", "provider", rule.Provider)
+		return fallback
+	}
+	tokenSecret := rule.TokenSecret
+	if tokenSecret == "" && rule.URL != "" && gl.isGitLab() {
+		if inst := cfg.instance(hostname(rule.URL)); inst != nil {
+			tokenSecret = inst.TokenSecret
+		}
+	}
+	if tokenSecret != "" {
+		token, err := r.secretToken(ctx, tokenSecret)
+		if err != nil {
+			r.log.Error(err, "failed to read token for route, using default project", "secret", tokenSecret)
+			return fallback
+		}
+		gl.Token = token
+	}
+	r.log.V(1).Info("Routed revert", "kind", kind, "namespace", namespace, "name", name, "url", gl.BaseURL, "project", gl.ProjectID)
+	return gl
+}
+
+// projectBySource routes res to the instance hosting its GitRepository, in
+// the project named by the repository URL. It reports false if the source
+// cannot be read or no instance serves its host.
+func (r *RollbackController) projectBySource(ctx context.Context, cfg *routingConfig, res resourceRef) (gitlabProject, bool) {
+	src := types.NamespacedName{Namespace: res.Namespace, Name: res.Name}
+	if res.Kind != "GitRepository" {
+		var ok bool
+		if src, ok = r.gitSourceOf(ctx, res); !ok {
+			return gitlabProject{}, false
+		}
+	}
+	repo, err := r.getSource(ctx, "GitRepository", src.Namespace, src.Name)
+	if err != nil {
+		r.log.V(1).Info("cannot get GitRepository for routing", "namespace", src.Namespace, "name", src.Name, "error", err.Error())
+		return gitlabProject{}, false
+	}
+	repoURL, _, _ := unstructured.NestedString(repo.Object, "spec", "url")
+	host, project, ok := gitRepoProject(repoURL)
+	inst := cfg.instance(host)
+	if !ok || inst == nil {
+		return gitlabProject{}, false
+	}
+	token, err := r.secretToken(ctx, inst.TokenSecret)
+	if err != nil {
+		r.log.Error(err, "failed to read token of GitLab instance", "url", inst.URL, "secret", inst.TokenSecret)
+		return gitlabProject{}, false
+	}
+	gl := r.bind(gitlabProject{BaseURL: strings.TrimSuffix(inst.URL, "/"), ProjectID: url.PathEscape(project), Token: token})
+	if inst.Proxy != "" {
+		if gl.Proxy, err = r.proxyURL(ctx, inst.Proxy, inst.ProxySecret); err != nil {
+			r.log.Error(err, "failed to configure proxy of GitLab instance", "url", inst.URL, "secret", inst.ProxySecret)
+			return gitlabProject{}, false
+		}
+	}
+	r.log.V(1).Info("Routed revert by source URL", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "source", repoURL, "url", gl.BaseURL, "project", project)
+	return gl, true
+}
+
+// hostname returns the host name of a URL, or "".
+func hostname(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,76 @@
+package rollback
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+)
+
+// serverTLS serves an endpoint over TLS from a mounted directory, laid out
+// like a cert-manager Certificate Secret: tls.crt and tls.key are the
+// serving certificate, reloaded when they change; with ca.crt, callers must
+// present a client certificate signed by it (mutual TLS).
+type serverTLS struct {
+	config  *tls.Config
+	watcher *certwatcher.CertWatcher
+	mutual  bool
+}
+
+// loadServerTLS loads the TLS setup from dir (ADMIN_TLS_DIR,
+// RECEIVER_TLS_DIR). Empty returns nil, plain HTTP.
+func loadServerTLS(dir string) (*serverTLS, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	watcher, err := certwatcher.New(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if err != nil {
+		return nil, fmt.Errorf("loading serving certificate from %s: %w", dir, err)
+	}
+	s := &serverTLS{
+		config:  &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: watcher.GetCertificate},
+		watcher: watcher,
+	}
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%s: no PEM certificates", filepath.Join(dir, "ca.crt"))
+	}
+	s.config.ClientCAs = pool
+	s.config.ClientAuth = tls.RequireAndVerifyClientCert
+	s.mutual = true
+	return s, nil
+}
+
+// loadClientTLS returns the client side of a TLS directory for the
+// subcommands calling the admin API: ca.crt verifies the server, tls.crt and
+// tls.key, if present, are the client certificate.
+func loadClientTLS(dir string) (*tls.Config, error) {
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: x509.NewCertPool()}
+	if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%s: no PEM certificates", filepath.Join(dir, "ca.crt"))
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+	return cfg, nil
+}
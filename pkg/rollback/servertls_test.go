@@ -0,0 +1,99 @@
+package rollback
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// testCert issues a certificate for name, signed by parent (self-signed if
+// nil), and writes it to dir as <file>.crt and <file>.key.
+func testCert(t *testing.T, dir, file, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(filepath.Join(dir, file+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(filepath.Join(dir, file+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	serverDir, clientDir, anonDir := t.TempDir(), t.TempDir(), t.TempDir()
+	ca, caKey := testCert(t, serverDir, "ca", "rollback-ca", nil, nil)
+	testCert(t, serverDir, "tls", "rollback-controller", ca, caKey)
+	testCert(t, clientDir, "tls", "operator", ca, caKey)
+	caPEM, _ := os.ReadFile(filepath.Join(serverDir, "ca.crt"))
+	_ = os.WriteFile(filepath.Join(clientDir, "ca.crt"), caPEM, 0o600)
+	_ = os.WriteFile(filepath.Join(anonDir, "ca.crt"), caPEM, 0o600)
+	_ = os.Remove(filepath.Join(serverDir, "ca.key"))
+
+	if s, err := loadServerTLS(""); s != nil || err != nil {
+		t.Errorf("no TLS dir: %v, %v; want plain HTTP", s, err)
+	}
+	s, err := loadServerTLS(serverDir)
+	if err != nil || !s.mutual {
+		t.Fatalf("loadServerTLS: %v, mutual %v", err, s != nil && s.mutual)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	go func() { _ = r.serveHTTP(ctx, "admin API", addr, r.adminHandler("admin"), s) }()
+
+	list := func(dir string) int {
+		return runDeadLetters([]string{"-admin", "https://" + addr, "-token", "admin", "-tls-dir", dir, "list"})
+	}
+	code := 1
+	for i := 0; i < 50 && code != 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		code = list(clientDir)
+	}
+	if code != 0 {
+		t.Fatalf("client with a certificate of the CA: exit %d", code)
+	}
+	if code := list(anonDir); code != 1 {
+		t.Errorf("client without a certificate: exit %d, want 1", code)
+	}
+}
@@ -0,0 +1,346 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlbuilder "sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Options configures SetupWithManager. Everything else is read from the
+// environment variables of the standalone controller.
+type Options struct {
+	// Namespace holds the routing, state and status objects and the
+	// Secrets the controller reads; "" uses POD_NAMESPACE or flux-system.
+	Namespace string
+	// Log is the controller log; the zero Logger uses
+	// ctrl.Log.WithName("rollback-controller").
+	Log logr.Logger
+	// FeatureGates are comma-separated Name=true|false gates
+	// (FEATURE_GATES); "" keeps the defaults.
+	FeatureGates string
+	// Webhooks registers RollbackPolicy defaulting and conversion on the
+	// manager's webhook server.
+	Webhooks bool
+	// MaxConcurrentReconciles of the rollback reconciler; 0 uses
+	// MAX_CONCURRENT_RECONCILES, or 1.
+	MaxConcurrentReconciles int
+}
+
+// AddToScheme adds the typed objects the controller reads and writes to s:
+// Flux Kustomizations and HelmReleases, core, batch and authorization. The
+// manager passed to SetupWithManager needs them in its scheme.
+func AddToScheme(s *runtime.Scheme) error {
+	for _, add := range []func(*runtime.Scheme) error{
+		kustomizev1.AddToScheme, helmv2.AddToScheme, corev1.AddToScheme, batchv1.AddToScheme, authorizationv1.AddToScheme,
+	} {
+		if err := add(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupWithManager adds the rollback reconciler and its runnables (state
+// sync, notifiers, MR checks, HTTP servers, ...) to mgr, so they run in an
+// existing manager binary next to other controllers. It is what the
+// standalone controller runs; configuration outside opts comes from the
+// environment, and invalid settings are returned as errors. The controller
+// keeps its HTTP clients, provider health and instance identity to itself
+// instead of in package variables.
+// Leader election is the manager's: the reconcilers and runnables only run
+// on its leader.
+func SetupWithManager(mgr ctrl.Manager, opts Options) error {
+	features, err := parseFeatureGates(opts.FeatureGates)
+	if err != nil {
+		return err
+	}
+	log := opts.Log
+	if log.GetSink() == nil {
+		log = ctrl.Log.WithName("rollback-controller")
+	}
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = controllerNamespace()
+	}
+	rollback, err := controllerFromEnv(mgr.GetClient(), log)
+	if err != nil {
+		return err
+	}
+	rollback.Namespace = namespace
+	rollback.Features = features
+	if rollback.Instance != "" {
+		log.Info("Controller instance", "instance", rollback.Instance)
+	}
+	rollback.APIReader = mgr.GetAPIReader()
+	log.Info("Feature gates", "gates", features.String())
+	p := currentPlatform()
+	reportPlatform(p)
+	reportMetadata(rollback.Metadata)
+	log.Info("Platform", "os", p.OS, "arch", p.Arch, "go", p.GoVersion, "revision", p.Revision)
+	if p.CGO {
+		log.Info("WARNING: binary built with cgo; release images expect a static CGO_ENABLED=0 build")
+	}
+	if features.Enabled(LegacyFluxAPIs) {
+		rollback.APIs = servedFluxAPIs(mgr.GetRESTMapper())
+	}
+	rollback.APIs.Policy = servedPolicyAPI(mgr.GetRESTMapper())
+	log.Info("Using Flux APIs", "kustomization", rollback.APIs.Kustomization.GroupVersion().String(),
+		"helmRelease", rollback.APIs.HelmRelease.GroupVersion().String(), "source", rollback.APIs.Source.String(),
+		"rollbackPolicy", rollback.APIs.Policy.GroupVersion().String())
+	log.Info("Ready conditions", "kustomization", rollback.readyCondition("Kustomization").String(),
+		"helmRelease", rollback.readyCondition("HelmRelease").String())
+	if os.Getenv("REVERT_MODE") == revertModeRecord && rollback.RecordFile == "" && rollback.RecordConfigMap == "" {
+		return errors.New("RECORD_FILE or RECORD_CONFIGMAP must be set when REVERT_MODE=record")
+	}
+	rollback.restoreCompletedSHAs()
+
+	if addr := os.Getenv("DASHBOARD_ADDR"); addr != "" {
+		dashToken := os.Getenv("DASHBOARD_TOKEN")
+		if dashToken == "" {
+			return errors.New("DASHBOARD_TOKEN must be set when DASHBOARD_ADDR is set")
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return rollback.serveHTTP(ctx, "dashboard", addr, rollback.dashboardHandler(dashToken), nil)
+		})); err != nil {
+			return err
+		}
+	}
+	if opts.Webhooks {
+		hooks := mgr.GetWebhookServer()
+		hooks.Register("/mutate-rollbackpolicy", &webhook.Admission{Handler: policyDefaulter})
+		hooks.Register("/convert", http.HandlerFunc(servePolicyConversion))
+	}
+	if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+		adminToken := os.Getenv("ADMIN_TOKEN")
+		if adminToken == "" {
+			return errors.New("ADMIN_TOKEN must be set when ADMIN_ADDR is set")
+		}
+		adminTLS, err := loadServerTLS(os.Getenv("ADMIN_TLS_DIR"))
+		if err != nil {
+			return err
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return rollback.serveHTTP(ctx, "admin API", addr, rollback.adminHandler(adminToken), adminTLS)
+		})); err != nil {
+			return err
+		}
+	}
+	if addr := os.Getenv("RECEIVER_ADDR"); addr != "" {
+		receiverToken := os.Getenv("RECEIVER_TOKEN")
+		if receiverToken == "" {
+			return errors.New("RECEIVER_TOKEN must be set when RECEIVER_ADDR is set")
+		}
+		receiverTLS, err := loadServerTLS(os.Getenv("RECEIVER_TLS_DIR"))
+		if err != nil {
+			return err
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return rollback.serveHTTP(ctx, "receiver", addr, rollback.receiverHandler(ctx, receiverToken), receiverTLS)
+		})); err != nil {
+			return err
+		}
+	}
+
+	if token := os.Getenv("DEBUG_STATE_TOKEN"); token != "" {
+		// Raw tracking state next to /metrics, for debugging without the dashboard.
+		if err := mgr.AddMetricsServerExtraHandler("/debug/state", rollback.debugStateHandler(token)); err != nil {
+			return err
+		}
+	}
+
+	// Objects the controller owns (state, ControllerConfig, status) are read and
+	// written directly: they change rarely and need no informer or list RBAC.
+	direct, err := client.New(mgr.GetConfig(), client.Options{Scheme: newScheme()})
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(os.Getenv("SOAK_DAYS")); err == nil && n > 0 {
+		if err := rollback.startSoak(context.Background(), direct, n); err != nil {
+			return err
+		}
+	}
+	// After startSoak: the read-only profile is enough during the soak period.
+	rollback.reportRBAC(context.Background(), direct)
+	if spec := os.Getenv("STATE_STORE"); spec != "" {
+		store, err := newStateStore(spec, stateStoreEnv{Client: direct, Namespace: namespace, Clock: rollback.clock, HTTPClient: rollback.api.client})
+		if err != nil {
+			return err
+		}
+		interval := 10 * time.Second
+		if n, err := strconv.Atoi(os.Getenv("STATE_SYNC_SECONDS")); err == nil && n > 0 {
+			interval = time.Duration(n) * time.Second
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return rollback.runStateSync(ctx, store, interval)
+		})); err != nil {
+			return err
+		}
+	}
+
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return rollback.runHealthReporter(ctx, direct, os.Getenv("CONTROLLER_CONFIG"), 30*time.Second)
+	})); err != nil {
+		return err
+	}
+
+	if name := os.Getenv("CONTROLLER_STATUS"); name != "" {
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return rollback.runStatusReporter(ctx, direct, name, 30*time.Second)
+		})); err != nil {
+			return err
+		}
+	}
+
+	if features.Enabled(FluxEvents) {
+		// The core/v1 recorder supports annotations, which Flux events carry.
+		rollback.kubeEvents = mgr.GetEventRecorderFor("rollback-controller") //nolint:staticcheck
+	}
+
+	if rollback.PendingTTL > 0 {
+		if err := mgr.Add(manager.RunnableFunc(rollback.runPendingExpiry)); err != nil {
+			return err
+		}
+	}
+
+	if rollback.RevertBatchWindow > 0 {
+		if err := mgr.Add(manager.RunnableFunc(rollback.runRevertBatcher)); err != nil {
+			return err
+		}
+	}
+
+	workers := defaultProviderWorkers
+	if n, err := strconv.Atoi(os.Getenv("PROVIDER_WORKERS")); err == nil && n >= 0 {
+		workers = n
+	}
+	if workers > 0 {
+		rollback.providers = newProviderPool(workers, rollback.clock)
+		if err := mgr.Add(manager.RunnableFunc(rollback.providers.run)); err != nil {
+			return err
+		}
+	}
+
+	// Merged revert MRs are only noticed by polling, so ReconcileOnMerge and
+	// CLEANUP_MERGED_REVERTS watch the MRs even without rebasing them.
+	rebaseSeconds, _ := strconv.Atoi(os.Getenv("MR_REBASE_CHECK_SECONDS"))
+	rollback.MRWatchOnly = rebaseSeconds <= 0
+	if (!rollback.MRWatchOnly || features.Enabled(ReconcileOnMerge) || rollback.CleanupMergedReverts) && !dryRun() {
+		interval := defaultMergeCheckInterval
+		if !rollback.MRWatchOnly {
+			interval = time.Duration(rebaseSeconds) * time.Second
+		}
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return rollback.runMRRebaser(ctx, interval)
+		})); err != nil {
+			return err
+		}
+	}
+
+	if sink := os.Getenv("CLOUDEVENTS_SINK"); sink != "" {
+		publish, err := newEventPublisher(sink)
+		if err != nil {
+			return err
+		}
+		opts := eventSinkOptions{BatchSize: 1, FlushInterval: time.Second, Retries: 3, Backoff: time.Second}
+		if n, err := strconv.Atoi(os.Getenv("CLOUDEVENTS_BATCH_SIZE")); err == nil && n > 0 {
+			opts.BatchSize = n
+		}
+		if n, err := strconv.Atoi(os.Getenv("CLOUDEVENTS_RETRIES")); err == nil && n >= 0 {
+			opts.Retries = n
+		}
+		rollback.events = make(chan cloudEvent, eventQueueSize)
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return rollback.runEventSink(ctx, publish, opts)
+		})); err != nil {
+			return err
+		}
+	}
+
+	// Incidents are also routed to RollbackPolicy notification targets, so
+	// the notifier runs without INCIDENT_NOTIFIER too.
+	var notifier incidentNotifier
+	if spec := os.Getenv("INCIDENT_NOTIFIER"); spec != "" {
+		if notifier, err = newIncidentNotifier(spec); err != nil {
+			return err
+		}
+	}
+	if rollback.DomainNotifiers, err = parseDomainNotifiers(os.Getenv("FAILURE_DOMAIN_NOTIFIERS")); err != nil {
+		return err
+	}
+	rollback.notifications = make(chan incidentUpdate, notificationQueueSize)
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return rollback.runIncidentNotifier(ctx, notifier)
+	})); err != nil {
+		return err
+	}
+
+	// Reconciles of different resources may run in parallel; the tracking
+	// state is guarded by RollbackController.mu.
+	maxConcurrent := opts.MaxConcurrentReconciles
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+		if n, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_RECONCILES")); err == nil && n > 0 {
+			maxConcurrent = n
+		}
+	}
+	builder := ctrl.NewControllerManagedBy(mgr).
+		Named(reconcilerName).
+		WithOptions(controller.Options{MaxConcurrentReconciles: maxConcurrent}).
+		For(rollback.APIs.watchObject("Kustomization")).
+		Watches(rollback.APIs.watchObject("Kustomization"), rollback.deletionHandler("Kustomization")).
+		WatchesRawSource(source.Channel(rollback.enqueue, &handler.EnqueueRequestForObject{})).
+		// Changed defaults and policies re-evaluate pending failures right away.
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(rollback.namespaceConfigRequests),
+			ctrlbuilder.WithPredicates(predicate.AnnotationChangedPredicate{}))
+	if _, err := mgr.GetRESTMapper().RESTMapping(rollback.APIs.Policy.GroupKind(), rollback.APIs.Policy.Version); err == nil {
+		policy := &unstructured.Unstructured{}
+		policy.SetGroupVersionKind(rollback.APIs.Policy)
+		builder = builder.Watches(policy, handler.EnqueueRequestsFromMapFunc(rollback.policyConfigRequests),
+			ctrlbuilder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	}
+	if features.Enabled(SourceAggregation) {
+		// New GitRepository revisions re-evaluate all consumers of the source.
+		gitRepository := &unstructured.Unstructured{}
+		gitRepository.SetGroupVersionKind(rollback.APIs.Source.WithKind("GitRepository"))
+		builder = builder.Watches(gitRepository, handler.EnqueueRequestsFromMapFunc(rollback.sourceConsumerRequests))
+	}
+	rollbackController, err := builder.Build(&GenericReconciler{rollback})
+	if err != nil {
+		return err
+	}
+	// HelmRelease is watched once served, so the helm-controller may be
+	// installed after the rollback controller.
+	rollback.watches = newDynamicWatches(rollbackController, mgr.GetRESTMapper(), func(kind string) []source.Source {
+		return []source.Source{
+			source.Kind(mgr.GetCache(), rollback.APIs.watchObject(kind), &handler.EnqueueRequestForObject{}),
+			source.Kind(mgr.GetCache(), rollback.APIs.watchObject(kind), rollback.deletionHandler(kind)),
+		}
+	}, "Kustomization")
+	rollback.ensureWatch("HelmRelease")
+	if err := mgr.Add(manager.RunnableFunc(rollback.runDynamicWatches)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAddToScheme(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	for _, gvk := range []schema.GroupVersionKind{
+		kustomizev1.GroupVersion.WithKind("Kustomization"),
+		helmv2.GroupVersion.WithKind("HelmRelease"),
+		corev1.SchemeGroupVersion.WithKind("Secret"),
+		batchv1.SchemeGroupVersion.WithKind("Job"),
+		authorizationv1.SchemeGroupVersion.WithKind("SelfSubjectAccessReview"),
+	} {
+		if !s.Recognizes(gvk) {
+			t.Errorf("scheme does not recognize %s", gvk)
+		}
+	}
+}
+
+func TestControllerFromEnv(t *testing.T) {
+	t.Setenv("CONTROLLER_INSTANCE", "eu-1")
+	t.Setenv("HTTP_ERROR_BODY_BYTES", "0")
+	t.Setenv("MISCONFIG_THRESHOLD", "5")
+	a, err := controllerFromEnv(nil, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONTROLLER_INSTANCE", "us-1")
+	b, err := controllerFromEnv(nil, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each controller keeps its own settings; the defaults stay untouched.
+	if a.Instance != "eu-1" || b.Instance != "us-1" || a.api == b.api || a.api.health == b.api.health {
+		t.Errorf("controllers share state: %q %p, %q %p", a.Instance, a.api, b.Instance, b.api)
+	}
+	if a.api.opts.ErrorBodyBytes != 0 || a.api.health.Threshold != 5 || defaultProviderAPI.opts.ErrorBodyBytes == 0 || providerHealth.Threshold != 3 {
+		t.Errorf("provider settings %+v, threshold %d", a.api.opts, a.api.health.Threshold)
+	}
+	if gl := a.defaultProject(); gl.api != a.api || gl.instance != "eu-1" {
+		t.Error("default project not bound to the controller")
+	}
+
+	t.Setenv("CRITICAL_REVERT_STRATEGY", "force-push")
+	if _, err := controllerFromEnv(nil, logr.Discard()); err == nil {
+		t.Error("invalid CRITICAL_REVERT_STRATEGY accepted")
+	}
+}
+
+func TestConcurrentReconciles(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	failed := metav1.Condition{Type: "Ready", Status: metav1.ConditionFalse, Reason: "ReconciliationFailed", LastTransitionTime: metav1.NewTime(start)}
+	ready := metav1.Condition{Type: "Ready", Status: metav1.ConditionTrue, Reason: "ReconciliationSucceeded", LastTransitionTime: metav1.NewTime(start)}
+	var objs []client.Object
+	var reqs []ctrl.Request
+	for i := 0; i < 4; i++ {
+		cond := failed
+		if i%2 == 1 {
+			cond = ready
+		}
+		ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "flux-system", Name: fmt.Sprintf("ks-%d", i)}}
+		ks.Spec.SourceRef = kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "apps"}
+		ks.Status.LastAttemptedRevision = "main@sha1:bad"
+		ks.Status.Conditions = []metav1.Condition{cond}
+		// Charts from an OCIRepository have no Git revision and are reported
+		// as not Git-sourced.
+		hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: fmt.Sprintf("hr-%d", i)}}
+		hr.Spec.ChartRef = &helmv2.CrossNamespaceSourceReference{Kind: "OCIRepository", Name: "charts"}
+		hr.Status.Conditions = []metav1.Condition{cond}
+		objs = append(objs, ks, hr)
+		reqs = append(reqs, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ks)}, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(hr)})
+	}
+	reqs = append(reqs, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "apps", Name: "gone"}})
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(objs...).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(start))
+
+	// Reconcile every resource from several workers at once, as with
+	// MaxConcurrentReconciles > 1; run with -race.
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range reqs {
+				req := reqs[(i+w*len(reqs)/4)%len(reqs)]
+				if _, err := (&GenericReconciler{r}).Reconcile(context.Background(), req); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(r.notGitSourced); got != 4 {
+		t.Errorf("%d HelmReleases reported as not Git-sourced, want 4", got)
+	}
+}
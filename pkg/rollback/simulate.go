@@ -0,0 +1,174 @@
+package rollback
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// simEvent is one recorded status change of a Flux resource.
+type simEvent struct {
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Revision  string    `json:"revision"`
+	Ready     bool      `json:"ready"`
+}
+
+// readSimEvents parses a JSON array or JSON lines of status changes.
+func readSimEvents(r io.Reader) ([]simEvent, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []simEvent
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e simEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// simulate replays events in time order through handleResource on a fake
+// clock, re-evaluating each resource when the controller would have been
+// requeued, and returns the reverts it would have created. r must not be
+// running; its clock and debounce state are replaced.
+func simulate(r *RollbackController, events []simEvent) []revertRecord {
+	if len(events) == 0 {
+		return nil
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	fake := clocktesting.NewFakeClock(events[0].Time)
+	r.setClock(fake)
+	r.reverts = nil
+
+	last := map[string]simEvent{}
+	requeues := map[string]time.Time{}
+	handle := func(key string) {
+		e := last[key]
+		delete(requeues, key)
+		after := r.handleResource(e.Kind, e.Name, e.Namespace, e.Revision, e.Ready, func(string) {}).RequeueAfter
+		if after > 0 {
+			requeues[key] = fake.Now().Add(after)
+		}
+	}
+	// nextRequeue returns the earliest due requeue, ordered by key on ties so
+	// runs are reproducible.
+	nextRequeue := func() (string, time.Time, bool) {
+		var key string
+		var due time.Time
+		for k, t := range requeues {
+			if key == "" || t.Before(due) || (t.Equal(due) && k < key) {
+				key, due = k, t
+			}
+		}
+		return key, due, key != ""
+	}
+
+	for _, e := range events {
+		for {
+			key, due, ok := nextRequeue()
+			if !ok || due.After(e.Time) {
+				break
+			}
+			fake.SetTime(due)
+			handle(key)
+		}
+		fake.SetTime(e.Time)
+		key := e.Kind + "/" + e.Namespace + "/" + e.Name
+		last[key] = e
+		handle(key)
+	}
+	for {
+		key, due, ok := nextRequeue()
+		if !ok {
+			break
+		}
+		fake.SetTime(due)
+		handle(key)
+	}
+	return r.reverts
+}
+
+// writeSimulation renders reverts as "table", "json" or "yaml".
+func writeSimulation(w io.Writer, reverts []revertRecord, format string) error {
+	switch format {
+	case "json", "yaml":
+		if reverts == nil {
+			reverts = []revertRecord{}
+		}
+		return writeStructured(w, reverts, format)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tKIND\tNAMESPACE\tNAME\tSHA")
+		for _, rv := range reverts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", rv.Time.Format(time.RFC3339), rv.Kind, rv.Namespace, rv.Name, rv.SHA)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q (want table, json or yaml)", format)
+}
+
+// runSimulate implements the `simulate` subcommand: an offline replay of
+// recorded status changes for tuning DEBOUNCE_SECONDS.
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	file := fs.String("f", "-", "recorded status changes (JSON array or JSON lines); - reads stdin")
+	debounce := fs.Int("debounce", -1, "debounce seconds (default DEBOUNCE_SECONDS)")
+	output := fs.String("o", "table", "output format: table, json or yaml")
+	_ = fs.Parse(args)
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	events, err := readSimEvents(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	rollback, err := controllerFromEnv(nil, logr.Discard())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *debounce >= 0 {
+		rollback.DebounceSeconds = *debounce
+	}
+	if err := writeSimulation(os.Stdout, simulate(rollback, events), *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package rollback
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestReadSimEvents(t *testing.T) {
+	lines := `{"time":"2024-01-01T10:00:00Z","kind":"Kustomization","namespace":"flux","name":"app","revision":"main@sha1:aaa","ready":false}
+
+{"time":"2024-01-01T10:01:00Z","kind":"Kustomization","namespace":"flux","name":"app","revision":"main@sha1:aaa","ready":true}
+`
+	events, err := readSimEvents(strings.NewReader(lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Ready || !events[1].Ready || events[1].Name != "app" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+	array, err := readSimEvents(strings.NewReader(" [" + strings.ReplaceAll(strings.TrimSpace(lines), "}\n\n{", "},{") + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(array) != 2 {
+		t.Errorf("unexpected events from array: %+v", array)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	events := []simEvent{
+		// Flaps and recovers within the window: no revert.
+		{Time: at(0), Kind: "Kustomization", Namespace: "flux", Name: "flappy", Revision: "main@sha1:aaa"},
+		{Time: at(30), Kind: "Kustomization", Namespace: "flux", Name: "flappy", Revision: "main@sha1:aaa", Ready: true},
+		// Fails for good: reverted once the window expires via requeue, even
+		// without a later event.
+		{Time: at(100), Kind: "HelmRelease", Namespace: "apps", Name: "broken", Revision: "bbb"},
+		{Time: at(120), Kind: "HelmRelease", Namespace: "apps", Name: "broken", Revision: "bbb"},
+	}
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 60)
+	reverts := simulate(r, events)
+	if len(reverts) != 1 {
+		t.Fatalf("expected one revert, got %+v", reverts)
+	}
+	if rv := reverts[0]; rv.Name != "broken" || rv.SHA != "bbb" || !rv.Time.Equal(at(160)) {
+		t.Errorf("unexpected revert %+v", rv)
+	}
+
+	r = NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 20)
+	if reverts := simulate(r, events); len(reverts) != 2 || reverts[0].Name != "flappy" || !reverts[0].Time.Equal(at(20)) {
+		t.Errorf("shorter debounce should revert the flap too, got %+v", reverts)
+	}
+}
@@ -0,0 +1,69 @@
+package rollback
+
+import (
+	"context"
+	"strings"
+)
+
+// defaultSkipMarker in a commit message opts the commit out of automated
+// reverts, e.g. for a known-risky migration expected to fail for a while.
+const defaultSkipMarker = "[no-auto-rollback]"
+
+// checkSkipMarker looks up the commit message of sha once and remembers
+// whether it carries r.SkipMarker or revertTrailer, i.e. is a revert the
+// controller made itself, which must not be reverted in turn; runRevert then
+// only notifies. Lookup errors are logged and not remembered, so the next
+// reconcile retries, and the revert goes ahead if the provider stays
+// unreachable.
+func (r *RollbackController) checkSkipMarker(ctx context.Context, res resourceRef, sha string) {
+	r.mu.Lock()
+	_, checked := r.skipMarked[sha]
+	r.mu.Unlock()
+	if checked {
+		return
+	}
+	gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+	if gl.Token == "" && gl.isGitLab() {
+		return // e.g. REVERT_MODE=echo without credentials
+	}
+	commitMessage := gl.commitMessage
+	switch gl.Provider {
+	case providerGerrit:
+		commitMessage = gl.gerrit().commitMessage
+	case providerCodeCommit:
+		commitMessage = func(sha string) (string, error) {
+			repo, err := gl.codeCommit(ctx)
+			if err != nil {
+				return "", err
+			}
+			return repo.commitMessage(ctx, sha)
+		}
+	case providerSSH:
+		commitMessage = func(sha string) (string, error) {
+			return gitCommitMessage(ctx, gl, sha)
+		}
+	}
+	msg, err := commitMessage(gitCommitSHA(sha))
+	if err != nil {
+		r.log.Error(err, "failed to check commit for the skip marker", "sha", sha, "marker", r.SkipMarker)
+		return
+	}
+	marked := true
+	switch {
+	case isControllerRevert(msg):
+		r.log.Info("Commit is a revert made by the controller, it will not be reverted", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha)
+	case r.SkipMarker != "" && strings.Contains(msg, r.SkipMarker):
+		r.log.Info("Commit carries the skip marker, it will not be reverted", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "marker", r.SkipMarker)
+	default:
+		marked = false
+	}
+	r.mu.Lock()
+	r.skipMarked[sha] = marked
+	r.mu.Unlock()
+}
+
+// skipRevert reports whether sha must not be reverted because of the skip
+// marker. Callers must hold r.mu.
+func (r *RollbackController) skipRevert(sha string) bool {
+	return r.skipMarked[sha]
+}
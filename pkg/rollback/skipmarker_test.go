@@ -0,0 +1,67 @@
+package rollback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestSkipMarker(t *testing.T) {
+	lookups := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lookups++
+		switch req.URL.Path {
+		case "/api/v4/projects/42/repository/commits/risky":
+			_, _ = w.Write([]byte(`{"message":"Migrate orders table\n\n[no-auto-rollback]"}`))
+		case "/api/v4/projects/42/repository/commits/ours":
+			_, _ = w.Write([]byte(`{"message":"Revert \"Bump\"\n\nRollback-Controller: true"}`))
+		case "/api/v4/projects/42/repository/commits/plain":
+			_, _ = w.Write([]byte(`{"message":"Bump replicas"}`))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	r := NewRollbackController(nil, logr.Discard(), "token", "42", srv.URL, "revert", 0)
+	r.SkipMarker = defaultSkipMarker
+	r.setClock(clocktesting.NewFakeClock(time.Now()))
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "ns", Name: "app"}
+
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+	for i := 0; i < 3; i++ {
+		r.remediate(ctx, res, "main@sha1:risky", false, nil, revert)
+	}
+	if len(reverted) != 0 {
+		t.Fatalf("marked commit reverted: %v", reverted)
+	}
+	if lookups != 1 {
+		t.Errorf("commit looked up %d times, want once", lookups)
+	}
+	if last := r.auditLog[len(r.auditLog)-1]; last.Event != auditSkipped {
+		t.Errorf("last audit event %q, want %q", last.Event, auditSkipped)
+	}
+
+	// A failing revert of the controller's own is not reverted again, even
+	// with the skip marker disabled.
+	r.SkipMarker = ""
+	for i := 0; i < 2; i++ {
+		r.remediate(ctx, res, "main@sha1:ours", false, nil, revert)
+	}
+	if len(reverted) != 0 {
+		t.Fatalf("controller revert reverted: %v", reverted)
+	}
+
+	for i := 0; i < 2; i++ {
+		r.remediate(ctx, res, "main@sha1:plain", false, nil, revert)
+	}
+	if len(reverted) != 1 || reverted[0] != "main@sha1:plain" {
+		t.Errorf("reverted %v, want the unmarked commit", reverted)
+	}
+}
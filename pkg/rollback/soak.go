@@ -0,0 +1,108 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotations on the recording ConfigMap tracking the soak period.
+const (
+	soakStartedAnnotation = "rollback.eumel8.io/soak-started"
+	soakUntilAnnotation   = "rollback.eumel8.io/soak-until"
+)
+
+// soakPeriod is the first-run soak mode (SOAK_DAYS): until it ends the
+// controller behaves as with REVERT_MODE=record, so teams can review what it
+// would have done before it acts. It ends on its own; dryRun covers it.
+type soakPeriod struct {
+	mu    sync.Mutex
+	clock clock.PassiveClock
+	log   logr.Logger
+	until time.Time // zero when soak mode is off
+	ended bool
+}
+
+var soak = &soakPeriod{clock: clock.RealClock{}, log: logr.Discard()}
+
+// active reports whether the soak period is running, logging once when it
+// ends.
+func (s *soakPeriod) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.until.IsZero() || s.ended {
+		return false
+	}
+	if s.clock.Now().Before(s.until) {
+		return true
+	}
+	s.ended = true
+	s.log.Info("Soak period ended; reverts are now carried out", "since", s.until)
+	return false
+}
+
+// startSoak starts or resumes the soak period of days. Its start is kept as
+// an annotation on the recording ConfigMap, so restarts do not extend it;
+// deleting the ConfigMap starts a new period.
+func (r *RollbackController) startSoak(ctx context.Context, c client.Client, days int) error {
+	if r.RecordConfigMap == "" {
+		return fmt.Errorf("RECORD_CONFIGMAP must be set when SOAK_DAYS is set")
+	}
+	now := r.clock.Now()
+	var started time.Time
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var cm corev1.ConfigMap
+		err := c.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.RecordConfigMap}, &cm)
+		create := apierrors.IsNotFound(err)
+		if err != nil && !create {
+			return err
+		}
+		if t, err := time.Parse(time.RFC3339, cm.Annotations[soakStartedAnnotation]); err == nil {
+			started = t
+			return nil
+		}
+		started = now
+		if create {
+			cm.ObjectMeta = metav1.ObjectMeta{Namespace: r.Namespace, Name: r.RecordConfigMap}
+		}
+		if cm.Annotations == nil {
+			cm.Annotations = map[string]string{}
+		}
+		cm.Annotations[soakStartedAnnotation] = started.UTC().Format(time.RFC3339)
+		cm.Annotations[soakUntilAnnotation] = started.Add(time.Duration(days) * 24 * time.Hour).UTC().Format(time.RFC3339)
+		if create {
+			err := c.Create(ctx, &cm)
+			if apierrors.IsAlreadyExists(err) {
+				// Another replica started it; read its start.
+				return apierrors.NewConflict(corev1.Resource("configmaps"), cm.Name, err)
+			}
+			return err
+		}
+		return c.Update(ctx, &cm)
+	})
+	if err != nil {
+		return err
+	}
+	until := started.Add(time.Duration(days) * 24 * time.Hour)
+	soak.mu.Lock()
+	soak.clock = r.clock
+	soak.log = r.log
+	soak.until = until
+	soak.ended = !now.Before(until)
+	soak.mu.Unlock()
+	if now.Before(until) {
+		r.log.Info("Soak mode: recording would-be actions instead of carrying them out", "configMap", r.RecordConfigMap, "started", started, "until", until)
+	} else {
+		r.log.Info("Soak period is over", "started", started, "ended", until)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSoakPeriod(t *testing.T) {
+	t.Setenv("REVERT_MODE", "")
+	defer func() { soak = &soakPeriod{clock: clock.RealClock{}, log: logr.Discard()} }()
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(newScheme()).Build()
+	clk := clocktesting.NewFakeClock(start)
+	newController := func() *RollbackController {
+		r := NewRollbackController(c, logr.Discard(), "", "42", "https://gitlab.example.com", "revert", 300)
+		r.setClock(clk)
+		r.Namespace = "flux-system"
+		r.RecordConfigMap = "rollback-recordings"
+		return r
+	}
+
+	r := newController()
+	if err := r.startSoak(ctx, c, 7); err != nil {
+		t.Fatal(err)
+	}
+	if !dryRun() {
+		t.Fatal("soak period should disable changes")
+	}
+	r.createGitlabRevertMR(r.defaultProject(), resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}, nil, "abc")
+	var cm corev1.ConfigMap
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "flux-system", Name: "rollback-recordings"}, &cm); err != nil {
+		t.Fatal(err)
+	}
+	if cm.Annotations[soakStartedAnnotation] != "2024-01-01T10:00:00Z" || cm.Annotations[soakUntilAnnotation] != "2024-01-08T10:00:00Z" {
+		t.Errorf("annotations = %v", cm.Annotations)
+	}
+	actions, err := configMapActions(&cm)
+	if err != nil || len(actions) != 1 || actions[0].SHA != "abc" || !actions[0].Soak {
+		t.Errorf("recorded actions = %+v, %v", actions, err)
+	}
+
+	// A restart resumes the period instead of starting a new one.
+	clk.Step(6 * 24 * time.Hour)
+	if err := newController().startSoak(ctx, c, 7); err != nil {
+		t.Fatal(err)
+	}
+	if !dryRun() {
+		t.Error("restart extended or ended the soak period")
+	}
+	clk.Step(24 * time.Hour)
+	if dryRun() {
+		t.Error("soak period should be over after 7 days")
+	}
+}
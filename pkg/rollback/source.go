@@ -0,0 +1,157 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getSource fetches a Flux source object (GitRepository, OCIRepository,
+// Bucket, HelmChart, ...) as unstructured, in the served source API version.
+// Sources are read as unstructured objects so the controller doesn't need to
+// depend on the source-controller Go module.
+func (r *RollbackController) getSource(ctx context.Context, kind, namespace, name string) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.APIs.Source.WithKind(kind))
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// sourceArtifactRevision returns status.artifact.revision of the referenced
+// source, or "" if the source or its artifact cannot be found.
+func (r *RollbackController) sourceArtifactRevision(ctx context.Context, kind, namespace, name string) string {
+	obj, err := r.getSource(ctx, kind, namespace, name)
+	if err != nil {
+		r.log.V(1).Info("cannot get source", "kind", kind, "namespace", namespace, "name", name, "error", err.Error())
+		return ""
+	}
+	rev, _, _ := unstructured.NestedString(obj.Object, "status", "artifact", "revision")
+	return rev
+}
+
+// helmChartRef returns the namespace and name of the HelmChart backing the
+// HelmRelease, or ok=false if the chart comes from a non-HelmChart ChartRef
+// (e.g. an OCIRepository).
+func helmChartRef(hr *helmv2.HelmRelease) (namespace, name string, ok bool) {
+	if hr.HasChartRef() {
+		if hr.Spec.ChartRef.Kind != "HelmChart" {
+			return "", "", false
+		}
+		ns := hr.Spec.ChartRef.Namespace
+		if ns == "" {
+			ns = hr.Namespace
+		}
+		return ns, hr.Spec.ChartRef.Name, true
+	}
+	if ns, n := hr.Status.GetHelmChart(); n != "" {
+		return ns, n, true
+	}
+	if hr.Spec.Chart == nil {
+		return "", "", false
+	}
+	ns := hr.Spec.Chart.Spec.SourceRef.Namespace
+	if ns == "" {
+		ns = hr.Namespace
+	}
+	return ns, hr.GetHelmChartName(), true
+}
+
+// helmSourceRevision resolves the Git revision the HelmRelease chart was
+// built from by following HelmRelease -> HelmChart -> GitRepository. ok is
+// false when the chart is not sourced from a GitRepository (e.g. a
+// HelmRepository or OCIRepository), so there is no Git revision to revert.
+func (r *RollbackController) helmSourceRevision(ctx context.Context, hr *helmv2.HelmRelease) (rev string, ok bool) {
+	ns, name, ok := helmChartRef(hr)
+	if !ok {
+		return "", false
+	}
+	chart, err := r.getSource(ctx, "HelmChart", ns, name)
+	if err != nil {
+		r.log.V(1).Info("cannot get HelmChart", "namespace", ns, "name", name, "error", err.Error())
+		return "", true
+	}
+	kind, _, _ := unstructured.NestedString(chart.Object, "spec", "sourceRef", "kind")
+	if kind != "GitRepository" {
+		return "", false
+	}
+	if rev, _, _ := unstructured.NestedString(chart.Object, "status", "observedSourceArtifactRevision"); rev != "" {
+		return rev, true
+	}
+	src, _, _ := unstructured.NestedString(chart.Object, "spec", "sourceRef", "name")
+	return r.sourceArtifactRevision(ctx, kind, ns, src), true
+}
+
+// helmRevision returns the revision to revert for a HelmRelease according to
+// the policy's HelmRevisionSource. ok is false if the policy asks for the
+// Git source revision but the chart isn't Git-sourced.
+func (r *RollbackController) helmRevision(ctx context.Context, hr *helmv2.HelmRelease, policy *RollbackPolicy) (rev string, ok bool) {
+	if policy.helmRevisionSource() == HelmRevisionSourceChartVersion {
+		return hr.Status.LastAttemptedRevision, true
+	}
+	return r.helmSourceRevision(ctx, hr)
+}
+
+// logNotGitSourced explains, once per HelmRelease, why a release whose chart
+// doesn't come from a GitRepository is not handled.
+func (r *RollbackController) logNotGitSourced(hr *helmv2.HelmRelease) {
+	key := hr.Namespace + "/" + hr.Name
+	r.mu.Lock()
+	reported := r.notGitSourced[key]
+	r.notGitSourced[key] = true
+	r.mu.Unlock()
+	if reported {
+		return
+	}
+	r.log.Info("HelmRelease chart is not sourced from a GitRepository, no Git revision to revert; set helmRemediation: PinChartVersion on a RollbackPolicy to pin chart versions instead",
+		"namespace", hr.Namespace, "name", hr.Name)
+}
+
+// kustomizationRevision returns the revision a Kustomization failed on.
+func (r *RollbackController) kustomizationRevision(ctx context.Context, ks *kustomizev1.Kustomization, ready bool) string {
+	// LastAttemptedRevision is populated when the source resolves (even on apply
+	// failure); fall back to LastAppliedRevision only if the former is empty.
+	sha := ks.Status.LastAttemptedRevision
+	if sha == "" {
+		sha = ks.Status.LastAppliedRevision
+	}
+	// Very early failures happen before either revision is recorded; use the
+	// revision of the artifact the source currently serves instead.
+	if sha == "" && !ready {
+		ns := ks.Spec.SourceRef.Namespace
+		if ns == "" {
+			ns = ks.Namespace
+		}
+		sha = r.sourceArtifactRevision(ctx, ks.Spec.SourceRef.Kind, ns, ks.Spec.SourceRef.Name)
+	}
+	return sha
+}
+
+// chartPinKey is the tracking key for helmRemediation PinChartVersion: the
+// failing chart version, scoped to the release.
+func chartPinKey(hr *helmv2.HelmRelease) string {
+	if v := hr.Status.LastAttemptedRevision; v != "" {
+		return fmt.Sprintf("%s/%s@%s", hr.Namespace, hr.Name, v)
+	}
+	return ""
+}
+
+// gitCommitSHA extracts the commit SHA from a Flux revision such as
+// "main@sha1:<sha>", the pre-GA "main/<sha>" or "<sha>". It returns the input
+// unchanged if it has no recognizable SHA.
+func gitCommitSHA(revision string) string {
+	if i := strings.LastIndex(revision, "sha1:"); i >= 0 {
+		return revision[i+len("sha1:"):]
+	}
+	if i := strings.LastIndex(revision, "/"); i >= 0 && fullSHA.MatchString(revision[i+1:]) {
+		return revision[i+1:]
+	}
+	return revision
+}
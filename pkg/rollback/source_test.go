@@ -0,0 +1,154 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// sourceObject returns a Flux source object of kind in the GA source API.
+func sourceObject(kind, namespace, name string, spec, status map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec, "status": status}}
+	u.SetGroupVersionKind(gaFluxAPIs.Source.WithKind(kind))
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+// gitRepository returns a GitRepository serving an artifact of revision.
+func gitRepository(namespace, name, revision string) *unstructured.Unstructured {
+	return sourceObject("GitRepository", namespace, name, map[string]interface{}{"url": "https://git.example.com/apps.git"},
+		map[string]interface{}{"artifact": map[string]interface{}{"revision": revision}})
+}
+
+func TestHelmSourceRevision(t *testing.T) {
+	release := func(sourceKind string) *helmv2.HelmRelease {
+		hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"}}
+		hr.Spec.Chart = &helmv2.HelmChartTemplate{}
+		hr.Spec.Chart.Spec.Chart = "./charts/web"
+		hr.Spec.Chart.Spec.SourceRef = helmv2.CrossNamespaceObjectReference{Kind: sourceKind, Name: "apps"}
+		hr.Status.LastAttemptedRevision = "1.2.0"
+		return hr
+	}
+	chart := func(sourceKind string, status map[string]interface{}) *unstructured.Unstructured {
+		return sourceObject("HelmChart", "apps", "apps-web", map[string]interface{}{
+			"chart": "./charts/web", "sourceRef": map[string]interface{}{"kind": sourceKind, "name": "apps"},
+		}, status)
+	}
+	tests := []struct {
+		name    string
+		hr      *helmv2.HelmRelease
+		objs    []client.Object
+		wantRev string
+		wantOK  bool
+	}{
+		{
+			name:    "chart from a GitRepository",
+			hr:      release("GitRepository"),
+			objs:    []client.Object{chart("GitRepository", map[string]interface{}{"observedSourceArtifactRevision": "main@sha1:abc"}), gitRepository("apps", "apps", "main@sha1:def")},
+			wantRev: "main@sha1:abc",
+			wantOK:  true,
+		},
+		{
+			name:    "chart not built yet, revision of the GitRepository",
+			hr:      release("GitRepository"),
+			objs:    []client.Object{chart("GitRepository", nil), gitRepository("apps", "apps", "main@sha1:def")},
+			wantRev: "main@sha1:def",
+			wantOK:  true,
+		},
+		{
+			name: "chart from a HelmRepository",
+			hr:   release("HelmRepository"),
+			objs: []client.Object{chart("HelmRepository", map[string]interface{}{"observedSourceArtifactRevision": "sha256:123"})},
+		},
+		{
+			// Ends up as "Cannot create revert without sha" in handleResource.
+			name:   "missing HelmChart",
+			hr:     release("GitRepository"),
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(tt.objs...).Build()
+			r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+			rev, ok := r.helmRevision(context.Background(), tt.hr, nil)
+			if rev != tt.wantRev || ok != tt.wantOK {
+				t.Errorf("helmRevision = %q, %v, want %q, %v", rev, ok, tt.wantRev, tt.wantOK)
+			}
+			if tt.wantOK && rev == "" {
+				if d := r.handleResource("HelmRelease", tt.hr.Name, tt.hr.Namespace, rev, false, func(string) { t.Error("reverted without a revision") }); d.Reason != "no revision" {
+					t.Errorf("decision without a revision = %+v", d)
+				}
+			}
+		})
+	}
+
+	// The chart version source doesn't read the chart.
+	r := NewRollbackController(fake.NewClientBuilder().WithScheme(newScheme()).Build(), logr.Discard(), "", "", "", "revert", 300)
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{HelmRevisionSource: HelmRevisionSourceChartVersion}}
+	if rev, ok := r.helmRevision(context.Background(), release("HelmRepository"), policy); rev != "1.2.0" || !ok {
+		t.Errorf("helmRevision with ChartVersion = %q, %v, want 1.2.0", rev, ok)
+	}
+}
+
+func TestHelmChartRef(t *testing.T) {
+	hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"}}
+	hr.Spec.ChartRef = &helmv2.CrossNamespaceSourceReference{Kind: "HelmChart", Name: "web-chart"}
+	if ns, name, ok := helmChartRef(hr); ns != "apps" || name != "web-chart" || !ok {
+		t.Errorf("chartRef to a HelmChart = %s/%s, %v", ns, name, ok)
+	}
+	hr.Spec.ChartRef = &helmv2.CrossNamespaceSourceReference{Kind: "OCIRepository", Name: "web"}
+	if _, _, ok := helmChartRef(hr); ok {
+		t.Error("chartRef to an OCIRepository has no HelmChart")
+	}
+	hr.Spec.ChartRef = nil
+	hr.Status.HelmChart = "flux-system/apps-web"
+	if ns, name, ok := helmChartRef(hr); ns != "flux-system" || name != "apps-web" || !ok {
+		t.Errorf("chart from status = %s/%s, %v", ns, name, ok)
+	}
+}
+
+func TestKustomizationRevision(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(gitRepository("flux-system", "apps", "main@sha1:served")).Build()
+	r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+	kustomization := func(attempted, applied string) *kustomizev1.Kustomization {
+		ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"}}
+		ks.Spec.SourceRef = kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Namespace: "flux-system", Name: "apps"}
+		ks.Status.LastAttemptedRevision = attempted
+		ks.Status.LastAppliedRevision = applied
+		return ks
+	}
+	tests := []struct {
+		name               string
+		attempted, applied string
+		ready              bool
+		want               string
+	}{
+		{name: "last attempted revision", attempted: "main@sha1:bad", applied: "main@sha1:good", want: "main@sha1:bad"},
+		{name: "last applied revision", applied: "main@sha1:good", want: "main@sha1:good"},
+		{name: "failed before any revision, revision the source serves", want: "main@sha1:served"},
+		{name: "ready without a revision", ready: true, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.kustomizationRevision(context.Background(), kustomization(tt.attempted, tt.applied), tt.ready); got != tt.want {
+				t.Errorf("kustomizationRevision = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A source that can't be read leaves the revision empty.
+	ks := kustomization("", "")
+	ks.Spec.SourceRef.Name = "missing"
+	if got := r.kustomizationRevision(context.Background(), ks, false); got != "" {
+		t.Errorf("kustomizationRevision of a missing source = %q", got)
+	}
+}
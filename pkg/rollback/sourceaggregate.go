@@ -0,0 +1,150 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// sourceConsumer is a Kustomization or HelmRelease built from a GitRepository.
+type sourceConsumer struct {
+	Res      resourceRef
+	Revision string // source revision the consumer last attempted
+	Ready    bool
+}
+
+// kustomizationGitSource returns the GitRepository a Kustomization applies.
+func kustomizationGitSource(ks *kustomizev1.Kustomization) (types.NamespacedName, bool) {
+	ref := ks.Spec.SourceRef
+	if ref.Kind != "GitRepository" {
+		return types.NamespacedName{}, false
+	}
+	ns := ref.Namespace
+	if ns == "" {
+		ns = ks.Namespace
+	}
+	return types.NamespacedName{Namespace: ns, Name: ref.Name}, true
+}
+
+// helmReleaseGitSource returns the GitRepository a HelmRelease chart template
+// builds its chart from. Releases using chartRef are not aggregated.
+func helmReleaseGitSource(hr *helmv2.HelmRelease) (types.NamespacedName, bool) {
+	if hr.Spec.Chart == nil || hr.Spec.Chart.Spec.SourceRef.Kind != "GitRepository" {
+		return types.NamespacedName{}, false
+	}
+	ref := hr.Spec.Chart.Spec.SourceRef
+	ns := ref.Namespace
+	if ns == "" {
+		ns = hr.Namespace
+	}
+	return types.NamespacedName{Namespace: ns, Name: ref.Name}, true
+}
+
+// sourceConsumers lists the Kustomizations and HelmReleases built from src.
+func (r *RollbackController) sourceConsumers(ctx context.Context, src types.NamespacedName) ([]sourceConsumer, error) {
+	var out []sourceConsumer
+	kss, err := r.listConverted(ctx, "Kustomization", func() client.Object { return &kustomizev1.Kustomization{} })
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range kss {
+		ks := obj.(*kustomizev1.Kustomization)
+		if s, ok := kustomizationGitSource(ks); !ok || s != src {
+			continue
+		}
+		policy := r.policyFor(ctx, "Kustomization", ks.Namespace, ks.Name)
+		out = append(out, sourceConsumer{
+			Res:      resourceRef{Kind: "Kustomization", Namespace: ks.Namespace, Name: ks.Name},
+			Revision: ks.Status.LastAttemptedRevision,
+			Ready:    r.kustomizationReady(ks, policy),
+		})
+	}
+	hrs, err := r.listConverted(ctx, "HelmRelease", func() client.Object { return &helmv2.HelmRelease{} })
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range hrs {
+		hr := obj.(*helmv2.HelmRelease)
+		if s, ok := helmReleaseGitSource(hr); !ok || s != src {
+			continue
+		}
+		rev, _ := r.helmSourceRevision(ctx, hr)
+		out = append(out, sourceConsumer{
+			Res:      resourceRef{Kind: "HelmRelease", Namespace: hr.Namespace, Name: hr.Name},
+			Revision: rev,
+			Ready:    r.isReadyKind("HelmRelease", hr.Status.Conditions),
+		})
+	}
+	return out, nil
+}
+
+// sourceFailing counts the consumers failing on revision and reports whether
+// they are more than thresholdPercent of all consumers. Consumers that have
+// not attempted revision yet count towards the total only.
+func sourceFailing(consumers []sourceConsumer, revision string, thresholdPercent int) (failing int, bad bool) {
+	if revision == "" || len(consumers) == 0 {
+		return 0, false
+	}
+	for _, c := range consumers {
+		if !c.Ready && gitCommitSHA(c.Revision) == gitCommitSHA(revision) {
+			failing++
+		}
+	}
+	return failing, failing > 0 && failing*100 > thresholdPercent*len(consumers)
+}
+
+// reconcileSource runs the debounce for a GitRepository on the aggregate
+// health of its consumers, so a bad revision is reverted once for the source
+// instead of once per failing consumer, and returns the decision.
+func (r *RollbackController) reconcileSource(ctx context.Context, src types.NamespacedName) Decision {
+	rev := r.sourceArtifactRevision(ctx, "GitRepository", src.Namespace, src.Name)
+	consumers, err := r.sourceConsumers(ctx, src)
+	if err != nil {
+		return Decision{Err: fmt.Errorf("listing consumers of source %s: %w", src, err)}
+	}
+	failing, bad := sourceFailing(consumers, rev, r.SourceFailureThreshold)
+	if failing > 0 {
+		r.log.V(1).Info("Source consumers failing", "namespace", src.Namespace, "name", src.Name, "revision", rev,
+			"failing", failing, "consumers", len(consumers), "thresholdPercent", r.SourceFailureThreshold)
+	}
+	res := resourceRef{Kind: "GitRepository", Namespace: src.Namespace, Name: src.Name}
+	if requeue, ok := r.checkStabilization(res, src.String(), rev, !bad); ok {
+		return Decision{Action: DecisionSuppressed, RequeueAfter: requeue, Reason: "stabilizing"}
+	}
+	policy := r.policyFor(ctx, res.Kind, res.Namespace, res.Name)
+	// The revert may run on a provider worker after the reconcile returned.
+	revertCtx := context.WithoutCancel(ctx)
+	return r.remediate(ctx, res, rev, !bad, policy, r.stabilizeAfter(src.String(), func(sha string) {
+		r.withProject(revertCtx, res, sha, func(gl gitlabProject) {
+			r.revertCommit(revertCtx, gl, res, policy, sha)
+		})
+	}))
+}
+
+// sourceConsumerRequests maps a GitRepository event to reconciles of its
+// consumers, which re-evaluate the source aggregate.
+func (r *RollbackController) sourceConsumerRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	consumers, err := r.sourceConsumers(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()})
+	if err != nil {
+		r.log.Error(err, "cannot list consumers of source", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		return nil
+	}
+	reqs := make([]reconcile.Request, 0, len(consumers))
+	for _, c := range consumers {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: c.Res.Namespace, Name: c.Res.Name}})
+	}
+	return reqs
+}
+
+// recordStatus updates the dashboard entry of a resource without running the
+// per-resource debounce, for consumers handled at the source level.
+func (r *RollbackController) recordStatus(kind, name, namespace, sha string, ready bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setStatus(kind, name, namespace, sha, ready)
+}
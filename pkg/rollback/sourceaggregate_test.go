@@ -0,0 +1,62 @@
+package rollback
+
+import (
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSourceFailing(t *testing.T) {
+	consumers := []sourceConsumer{
+		{Revision: "main@sha1:bad", Ready: false},
+		{Revision: "main@sha1:bad", Ready: true},
+		{Revision: "main@sha1:old", Ready: false}, // failing on an older revision
+		{Revision: "main@sha1:bad", Ready: false},
+	}
+	for _, c := range []struct {
+		threshold   int
+		wantFailing int
+		wantBad     bool
+	}{
+		{0, 2, true},
+		{49, 2, true},
+		{50, 2, false},
+		{75, 2, false},
+	} {
+		failing, bad := sourceFailing(consumers, "main@sha1:bad", c.threshold)
+		if failing != c.wantFailing || bad != c.wantBad {
+			t.Errorf("threshold %d: got (%d, %v), want (%d, %v)", c.threshold, failing, bad, c.wantFailing, c.wantBad)
+		}
+	}
+	if _, bad := sourceFailing(consumers, "", 0); bad {
+		t.Error("empty revision must not be bad")
+	}
+	if _, bad := sourceFailing(nil, "main@sha1:bad", 0); bad {
+		t.Error("source without consumers must not be bad")
+	}
+}
+
+func TestGitSourceOfConsumers(t *testing.T) {
+	ks := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"}}
+	ks.Spec.SourceRef = kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "fleet"}
+	if src, ok := kustomizationGitSource(ks); !ok || src != (types.NamespacedName{Namespace: "apps", Name: "fleet"}) {
+		t.Errorf("kustomizationGitSource = %v, %v", src, ok)
+	}
+	ks.Spec.SourceRef.Kind = "OCIRepository"
+	if _, ok := kustomizationGitSource(ks); ok {
+		t.Error("OCIRepository must not be aggregated")
+	}
+
+	hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "api"}}
+	if _, ok := helmReleaseGitSource(hr); ok {
+		t.Error("release without chart template must not be aggregated")
+	}
+	hr.Spec.Chart = &helmv2.HelmChartTemplate{}
+	hr.Spec.Chart.Spec.SourceRef = helmv2.CrossNamespaceObjectReference{Kind: "GitRepository", Name: "fleet", Namespace: "flux-system"}
+	if src, ok := helmReleaseGitSource(hr); !ok || src != (types.NamespacedName{Namespace: "flux-system", Name: "fleet"}) {
+		t.Errorf("helmReleaseGitSource = %v, %v", src, ok)
+	}
+}
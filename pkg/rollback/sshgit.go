@@ -0,0 +1,222 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// providerSSH routes reverts to any Git remote reachable over SSH, for
+// forges without a usable revert API such as Google Cloud Source
+// Repositories or bare git servers.
+const providerSSH = "ssh"
+
+// revertAuthor signs commits the controller creates itself rather than
+// through a forge API.
+var revertAuthor = object.Signature{Name: "flux-rollback-agent", Email: "flux-rollback-agent@noreply"}
+
+// gitAuth returns the SSH auth for the project's remote (BaseURL) from its
+// deploy key and known_hosts, like a Flux GitRepository Secret. Non-SSH
+// remotes (file paths in tests) need no auth.
+func (g gitlabProject) gitAuth() (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(g.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if ep.Protocol != "ssh" {
+		return nil, nil
+	}
+	if g.KnownHosts == "" {
+		return nil, errors.New("known_hosts is required for SSH remotes")
+	}
+	user := ep.User
+	if user == "" {
+		user = "git"
+	}
+	auth, err := gitssh.NewPublicKeys(user, []byte(g.SSHKey), "")
+	if err != nil {
+		return nil, fmt.Errorf("parsing deploy key: %w", err)
+	}
+	f, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(g.KnownHosts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if auth.HostKeyCallback, err = gitssh.NewKnownHostsCallback(f.Name()); err != nil {
+		return nil, fmt.Errorf("parsing known_hosts: %w", err)
+	}
+	return auth, nil
+}
+
+// gitProxy returns the project's egress proxy for go-git. SSH connections
+// can only be tunneled through SOCKS5 proxies.
+func (g gitlabProject) gitProxy() transport.ProxyOptions {
+	return transport.ProxyOptions{URL: g.Proxy}
+}
+
+// createSSHRevert reverts the commit of badSHA by cloning the target branch
+// into memory, restoring every file the commit changed to its parent's
+// content and pushing the result. Without a forge API no MR can be opened,
+// so the MergeRequest strategy pushes the revert branch like Branch.
+func (r *RollbackController) createSSHRevert(ctx context.Context, gl gitlabProject, res resourceRef, policy *RollbackPolicy, badSHA string) {
+	sha := gitCommitSHA(badSHA)
+	strategy := policy.revertStrategy(RevertStrategyBranch)
+	branch := r.revertBranchName(branchNameData{Action: branchActionRevert, Strategy: strategy, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: sha},
+		fmt.Sprintf("%s-%s", r.RevertBranchPrefix, sha))
+	if dryRun() {
+		r.log.Info("ECHO: would push revert", "remote", gl.BaseURL, "sha", sha, "branch", branch, "strategy", strategy)
+		r.recordAction(gl, recordedAction{Action: "revert", Strategy: strategy, Branch: branch, SHA: sha, Namespace: res.Namespace, Name: res.Name})
+		return
+	}
+	if strategy == RevertStrategyMergeRequest {
+		r.log.Info("SSH remotes cannot open merge requests, pushing the revert branch only", "remote", gl.BaseURL)
+		strategy = RevertStrategyBranch
+	}
+	message := func(subject string) string { return r.revertMessage(res, sha, subject, "") }
+	pushed, err := pushRevert(ctx, gl, policy.targetBranch(), sha, branch, strategy, message, r.clock.Now())
+	if err != nil {
+		r.log.Error(err, "SSH revert failed", "remote", gl.BaseURL, "sha", sha, "strategy", strategy)
+		r.recordRevertFailure(res, badSHA, err)
+		return
+	}
+	r.log.Info("Revert commit pushed successfully", "remote", gl.BaseURL, "sha", sha, "strategy", strategy, "branch", pushed)
+}
+
+// pushRevert clones target (the remote's default branch if empty), commits
+// the revert of sha on it and pushes it to branch, or to target itself for
+// the Direct strategy, committing at now. message renders the commit message
+// from the bad commit's subject. It returns the branch pushed to.
+func pushRevert(ctx context.Context, gl gitlabProject, target, sha, branch, strategy string, message func(subject string) string, now time.Time) (string, error) {
+	auth, err := gl.gitAuth()
+	if err != nil {
+		return "", err
+	}
+	clone := &git.CloneOptions{URL: gl.BaseURL, Auth: auth, SingleBranch: true, ProxyOptions: gl.gitProxy()}
+	if target != "" {
+		clone.ReferenceName = plumbing.NewBranchReferenceName(target)
+	}
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), memfs.New(), clone)
+	if err != nil {
+		return "", fmt.Errorf("cloning: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	target = head.Name().Short()
+	bad, err := repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return "", fmt.Errorf("commit %s not found on %s: %w", sha, target, err)
+	}
+	if bad.NumParents() == 0 {
+		return "", errors.New("cannot revert a root commit")
+	}
+	parent, err := bad.Parent(0)
+	if err != nil {
+		return "", err
+	}
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return "", err
+	}
+	badTree, err := bad.Tree()
+	if err != nil {
+		return "", err
+	}
+	changes, err := object.DiffTreeWithOptions(ctx, parentTree, badTree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return "", err
+	}
+	var diffs []gitlabDiff
+	for _, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return "", err
+		}
+		diffs = append(diffs, gitlabDiff{
+			OldPath:     c.From.Name,
+			NewPath:     c.To.Name,
+			NewFile:     action == merkletrie.Insert,
+			DeletedFile: action == merkletrie.Delete,
+			RenamedFile: action == merkletrie.Modify && c.From.Name != c.To.Name,
+		})
+	}
+	actions, err := revertActions(diffs, func(path string) (string, error) {
+		f, err := parentTree.File(path)
+		if err != nil {
+			return "", err
+		}
+		return f.Contents()
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading files at parent commit %s: %w", parent.Hash, err)
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	for _, a := range actions {
+		if a.Action == "delete" {
+			_, err = wt.Remove(a.FilePath)
+		} else if err = util.WriteFile(wt.Filesystem, a.FilePath, []byte(a.Content), 0o644); err == nil {
+			_, err = wt.Add(a.FilePath)
+		}
+		if err != nil {
+			return "", fmt.Errorf("applying %s %s: %w", a.Action, a.FilePath, err)
+		}
+	}
+	author := revertAuthor
+	author.When = now
+	if _, err := wt.Commit(message(firstLine(bad.Message)), &git.CommitOptions{Author: &author}); err != nil {
+		return "", err
+	}
+
+	dest := branch
+	if strategy == RevertStrategyDirect {
+		dest = target
+	}
+	refspec := gitconfig.RefSpec(fmt.Sprintf("%s:%s", head.Name(), plumbing.NewBranchReferenceName(dest)))
+	if err := repo.PushContext(ctx, &git.PushOptions{Auth: auth, RefSpecs: []gitconfig.RefSpec{refspec}, ProxyOptions: gl.gitProxy()}); err != nil {
+		return "", fmt.Errorf("pushing %s: %w", dest, err)
+	}
+	return dest, nil
+}
+
+// gitCommitMessage returns the message of sha, looked up in a clone of the
+// remote's default branch without checkout.
+func gitCommitMessage(ctx context.Context, gl gitlabProject, sha string) (string, error) {
+	auth, err := gl.gitAuth()
+	if err != nil {
+		return "", err
+	}
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{URL: gl.BaseURL, Auth: auth, SingleBranch: true, NoCheckout: true, ProxyOptions: gl.gitProxy()})
+	if err != nil {
+		return "", fmt.Errorf("cloning: %w", err)
+	}
+	commit, err := repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return "", err
+	}
+	return commit.Message, nil
+}
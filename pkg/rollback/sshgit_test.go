@@ -0,0 +1,118 @@
+package rollback
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-logr/logr"
+)
+
+// bareRemote creates a bare repository whose main branch has a base commit
+// and a bad commit that edits a.yaml and adds b.yaml. It returns the remote
+// path and the bad commit.
+func bareRemote(t *testing.T) (string, string) {
+	t.Helper()
+	remote := filepath.Join(t.TempDir(), "fleet.git")
+	if _, err := git.PlainInitWithOptions(remote, &git.PlainInitOptions{Bare: true, InitOptions: git.InitOptions{DefaultBranch: plumbing.Main}}); err != nil {
+		t.Fatal(err)
+	}
+	work := t.TempDir()
+	repo, err := git.PlainInitWithOptions(work, &git.PlainInitOptions{InitOptions: git.InitOptions{DefaultBranch: plumbing.Main}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, _ := repo.Worktree()
+	sig := &object.Signature{Name: "dev", Email: "dev@example.com", When: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	commit := func(msg string, files map[string]string) plumbing.Hash {
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(work, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wt.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h, err := wt.Commit(msg, &git.CommitOptions{Author: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	commit("base", map[string]string{"a.yaml": "replicas: 1\n"})
+	bad := commit("Scale web\n\nmore", map[string]string{"a.yaml": "replicas: 0\n", "b.yaml": "new\n"})
+	if _, err := repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(&git.PushOptions{RefSpecs: []gitconfig.RefSpec{"refs/heads/main:refs/heads/main"}}); err != nil {
+		t.Fatal(err)
+	}
+	return remote, bad.String()
+}
+
+// branchFiles returns the files at the tip of branch in the bare remote.
+func branchFiles(t *testing.T, remote, branch string) (map[string]string, *object.Commit) {
+	t.Helper()
+	repo, err := git.PlainOpen(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatalf("branch %s: %v", branch, err)
+	}
+	commit, _ := repo.CommitObject(ref.Hash())
+	tree, _ := commit.Tree()
+	files := map[string]string{}
+	_ = tree.Files().ForEach(func(f *object.File) error {
+		files[f.Name], _ = f.Contents()
+		return nil
+	})
+	return files, commit
+}
+
+func TestPushRevert(t *testing.T) {
+	remote, bad := bareRemote(t)
+	gl := gitlabProject{Provider: providerSSH, BaseURL: remote}
+	now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	message := func(subject string) string {
+		return r.revertMessage(resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}, bad, subject, "")
+	}
+
+	pushed, err := pushRevert(context.Background(), gl, "", bad, "revert-"+bad, RevertStrategyBranch, message, now)
+	if err != nil || pushed != "revert-"+bad {
+		t.Fatalf("pushRevert = %q, %v", pushed, err)
+	}
+	files, commit := branchFiles(t, remote, "revert-"+bad)
+	if len(files) != 1 || files["a.yaml"] != "replicas: 1\n" {
+		t.Errorf("revert branch files %v, want only a.yaml restored", files)
+	}
+	if !strings.HasPrefix(commit.Message, "Revert \"Scale web\"\n\nThis reverts commit "+bad) || !isControllerRevert(commit.Message) || commit.Author.Name != revertAuthor.Name || !commit.Author.When.Equal(now) {
+		t.Errorf("unexpected revert commit %q by %v", commit.Message, commit.Author)
+	}
+	if files, _ := branchFiles(t, remote, "main"); files["b.yaml"] == "" {
+		t.Error("Branch strategy must not touch main")
+	}
+
+	if _, err := pushRevert(context.Background(), gl, "main", bad, "unused", RevertStrategyDirect, message, now); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := branchFiles(t, remote, "main"); len(files) != 1 || files["a.yaml"] != "replicas: 1\n" {
+		t.Errorf("main after direct revert %v", files)
+	}
+}
+
+func TestGitAuthRequiresKnownHosts(t *testing.T) {
+	gl := gitlabProject{Provider: providerSSH, BaseURL: "ssh://git@source.developers.google.com:2022/p/proj/r/fleet", SSHKey: "key"}
+	if _, err := gl.gitAuth(); err == nil || !strings.Contains(err.Error(), "known_hosts") {
+		t.Errorf("gitAuth without known_hosts: %v", err)
+	}
+}
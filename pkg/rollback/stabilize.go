@@ -0,0 +1,70 @@
+package rollback
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// sourceKey identifies a GitRepository in the stabilization state; "" if
+// the resource isn't built from one.
+func sourceKey(src types.NamespacedName, ok bool) string {
+	if !ok {
+		return ""
+	}
+	return src.String()
+}
+
+// stabilizeAfter wraps revert so that issuing it opens the post-revert
+// stabilization window of src (STABILIZATION_WINDOW_SECONDS).
+func (r *RollbackController) stabilizeAfter(src string, revert func(sha string)) func(sha string) {
+	if src == "" || r.StabilizationWindow <= 0 {
+		return revert
+	}
+	return func(sha string) {
+		revert(sha)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.stabilizing[src] = r.clock.Now().Add(r.StabilizationWindow)
+		r.log.Info("Revert issued, stabilizing source", "source", src, "window", r.StabilizationWindow)
+	}
+}
+
+// checkStabilization reports whether res fails on sha within the
+// stabilization window of its source, giving the revert time to reconcile.
+// The failure is recorded but not acted upon: no debounce window starts
+// until the stabilization window has ended. It returns how long the window
+// still lasts.
+func (r *RollbackController) checkStabilization(res resourceRef, src, sha string, ready bool) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := res.String()
+	until, ok := r.stabilizing[src]
+	now := r.clock.Now()
+	if ok && !now.Before(until) {
+		delete(r.stabilizing, src)
+		ok = false
+	}
+	if !ok || ready || sha == "" {
+		delete(r.settling, key)
+		return 0, false
+	}
+	r.setStatus(res.Kind, res.Name, res.Namespace, sha, ready)
+	if r.settling[key] != sha {
+		r.settling[key] = sha
+		r.log.Info("Failure within the post-revert stabilization window, not acting on it", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "source", src, "until", until)
+		r.recordAudit(auditStabilizing, res.Kind, res.Namespace, res.Name, sha, "source "+src+" stabilizing after a revert until "+until.UTC().Format(time.RFC3339))
+	}
+	return until.Sub(now), true
+}
+
+// expireStabilization drops stabilization windows that have ended.
+// Callers must hold r.mu.
+func (r *RollbackController) expireStabilization() {
+	now := r.clock.Now()
+	for src, until := range r.stabilizing {
+		if !now.Before(until) {
+			delete(r.stabilizing, src)
+		}
+	}
+}
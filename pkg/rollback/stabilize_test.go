@@ -0,0 +1,55 @@
+package rollback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestStabilizationWindow(t *testing.T) {
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 60)
+	r.StabilizationWindow = 10 * time.Minute
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	web := resourceRef{Kind: "Kustomization", Namespace: "apps", Name: "web"}
+	api := resourceRef{Kind: "HelmRelease", Namespace: "apps", Name: "api"}
+
+	if _, hold := r.checkStabilization(web, "flux-system/fleet", "main@sha1:bad", false); hold {
+		t.Fatal("held a failure before any revert")
+	}
+	reverted := 0
+	r.stabilizeAfter("flux-system/fleet", func(string) { reverted++ })("main@sha1:bad")
+	if reverted != 1 {
+		t.Fatalf("revert not called through: %d", reverted)
+	}
+
+	clk.SetTime(start.Add(4 * time.Minute))
+	for i := 0; i < 2; i++ {
+		if wait, hold := r.checkStabilization(api, "flux-system/fleet", "main@sha1:next", false); !hold || wait != 6*time.Minute {
+			t.Fatalf("failure within the window: wait %v, hold %v", wait, hold)
+		}
+	}
+	if _, hold := r.checkStabilization(web, "flux-system/other", "main@sha1:next", false); hold {
+		t.Error("held a failure on another source")
+	}
+	stabilizing := 0
+	for _, e := range r.auditLog {
+		if e.Event == auditStabilizing {
+			stabilizing++
+		}
+	}
+	if stabilizing != 1 || len(r.debounce.Pending()) != 0 {
+		t.Errorf("%d stabilizing entries, pending %+v; want one entry and no debounce", stabilizing, r.debounce.Pending())
+	}
+
+	clk.SetTime(start.Add(10 * time.Minute))
+	if _, hold := r.checkStabilization(api, "flux-system/fleet", "main@sha1:next", false); hold {
+		t.Error("held a failure after the window")
+	}
+	if len(r.stabilizing) != 0 || len(r.settling) != 0 {
+		t.Errorf("state left after the window: %v %v", r.stabilizing, r.settling)
+	}
+}
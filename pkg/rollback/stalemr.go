@@ -0,0 +1,108 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// checkStaleMR escalates the revert MR of sha once it has been open for the
+// policy's mergeRequest.staleAfter while the resource keeps failing: it pings
+// the reviewers, notifies again and/or sets the MR to auto-merge, as the
+// policy's staleActions say. Each MR is escalated once. It returns when to
+// check again if the MR is not stale yet, else 0.
+func (r *RollbackController) checkStaleMR(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy) time.Duration {
+	staleAfter, actions := policy.staleMergeRequests()
+	if staleAfter == 0 || ready || sha == "" {
+		return 0
+	}
+	r.mu.Lock()
+	var rec revertRecord
+	for i := len(r.reverts) - 1; i >= 0; i-- {
+		if r.reverts[i].SHA == sha && r.reverts[i].MRIID > 0 {
+			rec = r.reverts[i]
+			break
+		}
+	}
+	if rec.MRIID == 0 || r.staleEscalated[rec.URL] || r.mrSettled[rec.URL] {
+		r.mu.Unlock()
+		return 0
+	}
+	age := r.clock.Since(rec.Time)
+	r.mu.Unlock()
+	if age < staleAfter {
+		return r.capRequeue(staleAfter - age)
+	}
+
+	log := r.log.WithValues("kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "mr", rec.URL)
+	gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+	if gl.isGitLab() && !dryRun() {
+		mr, err := gl.mergeRequestState(rec.MRIID)
+		if err != nil {
+			log.Error(err, "failed to check stale revert MR")
+			return 0
+		}
+		if mr.State != "opened" {
+			r.markStaleEscalated(rec.URL)
+			return 0
+		}
+	}
+	r.markStaleEscalated(rec.URL)
+	log.Info("Revert MR still open while the resource keeps failing, escalating", "age", age.Round(time.Second), "actions", actions)
+	for _, action := range actions {
+		switch action {
+		case StaleMRPing:
+			r.pingReviewers(gl, rec.MRIID, age, policy)
+		case StaleMRNotify:
+			r.mu.Lock()
+			r.recordAudit(auditStale, res.Kind, res.Namespace, res.Name, sha, fmt.Sprintf("!%d open for %s", rec.MRIID, age.Round(time.Second)))
+			r.emitEvent(auditStale, res.Kind, res.Namespace, res.Name, sha)
+			r.mu.Unlock()
+		case StaleMRAutoMerge:
+			if err := r.autoMergeRevert(ctx, res, sha, rec.MRIID, policy); err != nil {
+				log.Error(err, "failed to merge stale revert MR")
+				continue
+			}
+			r.mu.Lock()
+			r.recordAudit(auditAutoMerged, res.Kind, res.Namespace, res.Name, sha, fmt.Sprintf("!%d", rec.MRIID))
+			r.emitEvent(auditAutoMerged, res.Kind, res.Namespace, res.Name, sha)
+			r.mu.Unlock()
+		default:
+			log.Error(nil, "unknown stale MR action, skipping", "action", action)
+		}
+	}
+	return 0
+}
+
+// pingReviewers comments on the GitLab revert MR iid, mentioning the
+// policy's reviewers, that it is still waiting for review.
+func (r *RollbackController) pingReviewers(gl gitlabProject, iid int, age time.Duration, policy *RollbackPolicy) {
+	if !gl.isGitLab() {
+		return
+	}
+	if dryRun() {
+		r.log.Info("ECHO: would ping reviewers of revert MR", "mr", iid, "project", gl.ProjectID)
+		return
+	}
+	var mentions []string
+	if policy != nil && policy.Spec.MergeRequest != nil {
+		for _, reviewer := range policy.Spec.MergeRequest.Reviewers {
+			mentions = append(mentions, "@"+reviewer)
+		}
+	}
+	note := fmt.Sprintf("This revert has been open for %s and the resource is still failing; please review.", age.Round(time.Minute))
+	if len(mentions) > 0 {
+		note = strings.Join(mentions, " ") + " " + note
+	}
+	if err := gl.addMergeRequestNote(iid, note); err != nil {
+		r.log.Error(err, "failed to ping reviewers of revert MR", "mr", iid)
+	}
+}
+
+// markStaleEscalated stops checking the revert MR at webURL for staleness.
+func (r *RollbackController) markStaleEscalated(webURL string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.staleEscalated[webURL] = true
+}
@@ -0,0 +1,97 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestCheckStaleMR(t *testing.T) {
+	var calls []string
+	var note string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		call := req.Method + " " + strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42")
+		calls = append(calls, call)
+		var body map[string]interface{}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		switch call {
+		case "GET /merge_requests/1":
+			_, _ = w.Write([]byte(`{"iid":1,"state":"opened"}`))
+		case "POST /merge_requests/1/notes":
+			note, _ = body["body"].(string)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRollbackController(nil, logr.Discard(), "", "42", srv.URL, "revert", 300)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r.setClock(clk)
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}
+	r.reverts = []revertRecord{{Time: start, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: "main@sha1:bad", URL: srv.URL + "/mr/1", MRIID: 1}}
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{
+		RevertStrategy: RevertStrategyMergeRequest,
+		MergeRequest: &MergeRequestSpec{
+			Reviewers:    []string{"alice", "bob"},
+			StaleAfter:   &metav1.Duration{Duration: 2 * time.Hour},
+			StaleActions: []string{StaleMRPing, StaleMRNotify, StaleMRAutoMerge},
+		},
+	}}
+	ctx := context.Background()
+
+	clk.Step(time.Hour)
+	if wait := r.checkStaleMR(ctx, res, "main@sha1:bad", false, policy); wait != time.Hour || len(calls) != 0 {
+		t.Fatalf("before staleAfter: wait %v, calls %q", wait, calls)
+	}
+	clk.Step(time.Hour)
+	if wait := r.checkStaleMR(ctx, res, "main@sha1:bad", true, policy); wait != 0 || len(calls) != 0 {
+		t.Fatalf("ready resource escalated: wait %v, calls %q", wait, calls)
+	}
+	r.checkStaleMR(ctx, res, "main@sha1:bad", false, policy)
+	want := []string{"GET /merge_requests/1", "POST /merge_requests/1/notes", "PUT /merge_requests/1/merge"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if !strings.HasPrefix(note, "@alice @bob ") {
+		t.Errorf("note does not ping the reviewers: %q", note)
+	}
+	var events []string
+	for _, e := range r.auditLog {
+		events = append(events, e.Event)
+	}
+	if !reflect.DeepEqual(events, []string{auditStale, auditAutoMerged}) {
+		t.Errorf("audit events = %q", events)
+	}
+
+	// Each MR is escalated once.
+	clk.Step(time.Hour)
+	r.checkStaleMR(ctx, res, "main@sha1:bad", false, policy)
+	if len(calls) != len(want) {
+		t.Errorf("escalated again: %q", calls)
+	}
+}
+
+func TestStaleMergeRequestsValidation(t *testing.T) {
+	p := &RollbackPolicy{Spec: RollbackPolicySpec{MergeRequest: &MergeRequestSpec{
+		StaleAfter: &metav1.Duration{Duration: time.Hour},
+	}}}
+	if after, actions := p.staleMergeRequests(); after != time.Hour || !reflect.DeepEqual(actions, []string{StaleMRPing, StaleMRNotify}) {
+		t.Errorf("defaults = %v %q", after, actions)
+	}
+	p.Spec.MergeRequest.StaleActions = []string{StaleMRAutoMerge, "Page"}
+	if err := p.validate(); err == nil || !strings.Contains(err.Error(), "AutoMerge requires") || !strings.Contains(err.Error(), `unknown action "Page"`) {
+		t.Errorf("validate() = %v", err)
+	}
+}
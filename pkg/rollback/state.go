@@ -0,0 +1,684 @@
+package rollback
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// persistedState is the debounce state shared through a stateStore.
+type persistedState struct {
+	Pending     map[string]time.Time `json:"pending,omitempty"`     // SHA -> time first seen failing
+	Completed   []string             `json:"completed,omitempty"`   // SHAs that already triggered a revert
+	CompletedAt map[string]time.Time `json:"completedAt,omitempty"` // SHA -> time it was completed, for compaction
+	Epoch       int64                `json:"epoch,omitempty"`       // fencing token of the leader that saved it
+}
+
+// errStateFenced is returned by Save when the store holds state saved by a
+// newer epoch, i.e. another leader took over since this one claimed it.
+var errStateFenced = errors.New("state store fenced by a newer leader")
+
+// checkEpoch returns errStateFenced if the stored data was saved by a newer
+// epoch than s. Unreadable data is overwritten as before.
+func checkEpoch(stored []byte, s persistedState) error {
+	cur, err := decodeState(stored)
+	if err != nil || cur.Epoch <= s.Epoch {
+		return nil
+	}
+	return fmt.Errorf("%w: stored epoch %d, saving epoch %d", errStateFenced, cur.Epoch, s.Epoch)
+}
+
+// stateStore persists the debounce state so it survives restarts and can be
+// shared by replicas. Load returns an empty state if nothing was saved yet.
+// Save fails with errStateFenced instead of overwriting state of a newer
+// Epoch, atomically where the backend allows it.
+type stateStore interface {
+	Load(ctx context.Context) (persistedState, error)
+	Save(ctx context.Context, s persistedState) error
+}
+
+// stateStoreEnv holds what store constructors need besides the URL.
+type stateStoreEnv struct {
+	Client    client.Client // uncached, so Load works before the manager starts
+	Namespace string
+	Clock     clock.PassiveClock
+	// HTTPClient sends the requests of remote stores (S3); nil uses the
+	// default provider client.
+	HTTPClient *http.Client
+}
+
+// stateStores maps STATE_STORE URL schemes to store constructors.
+var stateStores = map[string]func(u *url.URL, env stateStoreEnv) (stateStore, error){
+	"configmap":     newConfigMapStateStore,
+	"rollbackstate": newRollbackStateStore,
+	"redis":         newRedisStateStore,
+	"s3":            newS3StateStore,
+}
+
+// newStateStore returns the store for a STATE_STORE URL (see stateStores).
+func newStateStore(spec string, env stateStoreEnv) (stateStore, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	newStore, ok := stateStores[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported state store scheme %q (want configmap, rollbackstate, redis or s3)", u.Scheme)
+	}
+	return newStore(u, env)
+}
+
+// currentState returns the debounce state to persist, stamped with the
+// claimed epoch. Completion times are only included when compaction is
+// enabled; restored revisions keep their saved completion time, so their age
+// survives restarts.
+func (r *RollbackController) currentState() persistedState {
+	s := persistedState{Pending: map[string]time.Time{}, Completed: r.debounce.Completed()}
+	compact := r.StateMaxAge > 0 || r.StateMaxEntries > 0
+	if at := r.debounce.CompletedAt(); compact && len(at) > 0 {
+		s.CompletedAt = at
+	}
+	r.mu.Lock()
+	if r.fence != nil {
+		s.Epoch = r.fence.epoch
+	}
+	for sha, t := range r.completedAt {
+		if at, ok := s.CompletedAt[sha]; !ok && compact {
+			delete(r.completedAt, sha)
+		} else if ok && t.Before(at) {
+			s.CompletedAt[sha] = t
+		}
+	}
+	r.mu.Unlock()
+	for _, p := range r.debounce.Pending() {
+		s.Pending[p.Key] = p.FirstSeen
+	}
+	return s
+}
+
+// restoreState merges the saved state into the debouncer and claims the next
+// epoch by saving it right away, which fences off any previous leader still
+// writing (see fencing.go).
+func (r *RollbackController) restoreState(ctx context.Context, store stateStore) error {
+	s, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	r.debounce.Restore(s.Pending, s.Completed)
+	r.mu.Lock()
+	for sha, t := range s.CompletedAt {
+		r.completedAt[sha] = t
+	}
+	r.mu.Unlock()
+	claimed := r.currentState()
+	claimed.Epoch = s.Epoch + 1
+	if err := store.Save(ctx, claimed); err != nil {
+		return fmt.Errorf("claiming epoch %d: %w", claimed.Epoch, err)
+	}
+	r.mu.Lock()
+	if r.fence != nil {
+		r.fence.epoch = claimed.Epoch
+	}
+	r.mu.Unlock()
+	r.log.Info("Restored debounce state", "pending", len(s.Pending), "completed", len(s.Completed), "epoch", claimed.Epoch)
+	r.catchUpPending(s.Pending)
+	return nil
+}
+
+// catchUpPending requeues the resources already seen failing on a restored
+// pending revision. Their requeue was computed before the restore moved the
+// window's start back, so windows that expired while no controller ran
+// revert now instead of after a full window.
+func (r *RollbackController) catchUpPending(restored map[string]time.Time) {
+	now := r.clock.Now()
+	var targets []resourceRef
+	r.mu.Lock()
+	for _, p := range r.debounce.Pending() {
+		if _, ok := restored[p.Key]; !ok {
+			continue
+		}
+		if !p.Due.After(now) {
+			r.log.Info("Debounce window expired while the controller was down, acting now", "sha", p.Key, "firstSeen", p.FirstSeen, "overdue", now.Sub(p.Due))
+		}
+		for _, res := range r.resources {
+			if res.Revision == p.Key && !res.Ready {
+				targets = append(targets, resourceRef{Kind: res.Kind, Namespace: res.Namespace, Name: res.Name})
+			}
+		}
+	}
+	r.mu.Unlock()
+	for _, res := range targets {
+		r.requestReconcile(res.Namespace, res.Name)
+	}
+}
+
+// runStateSync restores the saved state, then saves the debounce state every
+// interval when it changed, and once more on shutdown, compacting it every
+// stateCompactInterval (see compactState). It runs only on the leader, so a
+// replica taking over picks up the state its predecessor saved.
+// Nothing is saved until the restore succeeded, so an unreachable store is
+// never overwritten with partial state. Once a newer leader claimed the store
+// it returns an error, stopping the manager of this stale leader.
+func (r *RollbackController) runStateSync(ctx context.Context, store stateStore, interval time.Duration) error {
+	r.mu.Lock()
+	r.fence = &stateFence{store: store}
+	r.mu.Unlock()
+	restored := false
+	restore := func() {
+		if err := r.restoreState(ctx, store); err != nil {
+			r.log.Error(err, "failed to restore debounce state, retrying")
+			return
+		}
+		restored = true
+	}
+	restore()
+	var last []byte
+	var compacted time.Time
+	save := func(ctx context.Context) error {
+		if r.stateFenced() {
+			return errStateFenced
+		}
+		if !restored {
+			return nil
+		}
+		if compacted.IsZero() || r.clock.Since(compacted) >= stateCompactInterval {
+			r.compactState()
+			compacted = r.clock.Now()
+		}
+		s := r.currentState()
+		data, _ := json.Marshal(s)
+		if bytes.Equal(data, last) {
+			return nil
+		}
+		if err := store.Save(ctx, s); err != nil {
+			if errors.Is(err, errStateFenced) {
+				r.fenceOff(err)
+				return err
+			}
+			r.log.Error(err, "failed to save debounce state")
+			return nil
+		}
+		observeStoredState(s, data)
+		last = data
+		return nil
+	}
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_ = save(shutdownCtx)
+			cancel()
+			return nil
+		case <-ticker.C():
+			if !restored {
+				restore()
+			}
+			if err := save(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// stateConfigKey is the ConfigMap key holding the JSON state.
+const stateConfigKey = "state.json"
+
+// configMapStateStore keeps the state in a ConfigMap of the controller
+// namespace: configmap://<name>.
+type configMapStateStore struct {
+	c   client.Client
+	key client.ObjectKey
+}
+
+func newConfigMapStateStore(u *url.URL, env stateStoreEnv) (stateStore, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("state store %s: ConfigMap name missing", u)
+	}
+	return &configMapStateStore{c: env.Client, key: client.ObjectKey{Namespace: env.Namespace, Name: u.Host}}, nil
+}
+
+func (s *configMapStateStore) Load(ctx context.Context) (persistedState, error) {
+	var cm corev1.ConfigMap
+	if err := s.c.Get(ctx, s.key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return persistedState{}, nil
+		}
+		return persistedState{}, err
+	}
+	return decodeState([]byte(cm.Data[stateConfigKey]))
+}
+
+func (s *configMapStateStore) Save(ctx context.Context, state persistedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var cm corev1.ConfigMap
+		err := s.c.Get(ctx, s.key, &cm)
+		if apierrors.IsNotFound(err) {
+			cm = corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: s.key.Namespace, Name: s.key.Name},
+				Data:       map[string]string{stateConfigKey: string(data)},
+			}
+			return s.c.Create(ctx, &cm)
+		}
+		if err != nil {
+			return err
+		}
+		// The update fails on conflict if another leader saved in between,
+		// so the epoch check and the write are atomic.
+		if err := checkEpoch([]byte(cm.Data[stateConfigKey]), state); err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[stateConfigKey] = string(data)
+		return s.c.Update(ctx, &cm)
+	})
+}
+
+// rollbackStateStore keeps the state in the status of a RollbackState object
+// (crds/rollbackstate.yaml) in the controller namespace: rollbackstate://<name>.
+type rollbackStateStore struct {
+	c     client.Client
+	key   client.ObjectKey
+	clock clock.PassiveClock
+}
+
+func newRollbackStateStore(u *url.URL, env stateStoreEnv) (stateStore, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("state store %s: RollbackState name missing", u)
+	}
+	return &rollbackStateStore{c: env.Client, key: client.ObjectKey{Namespace: env.Namespace, Name: u.Host}, clock: env.Clock}, nil
+}
+
+func (s *rollbackStateStore) get(ctx context.Context) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(policyGroupVersion.WithKind("RollbackState"))
+	return obj, s.c.Get(ctx, s.key, obj)
+}
+
+func (s *rollbackStateStore) Load(ctx context.Context) (persistedState, error) {
+	obj, err := s.get(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return persistedState{}, nil
+		}
+		return persistedState{}, err
+	}
+	status, _, _ := unstructured.NestedMap(obj.Object, "status")
+	data, err := json.Marshal(status)
+	if err != nil {
+		return persistedState{}, err
+	}
+	return decodeState(data)
+}
+
+func (s *rollbackStateStore) Save(ctx context.Context, state persistedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	var status map[string]interface{}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return err
+	}
+	status["updatedAt"] = s.clock.Now().UTC().Format(time.RFC3339)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := s.get(ctx)
+		if apierrors.IsNotFound(err) {
+			obj.SetNamespace(s.key.Namespace)
+			obj.SetName(s.key.Name)
+			err = s.c.Create(ctx, obj)
+		}
+		if err != nil {
+			return err
+		}
+		stored, _ := json.Marshal(obj.Object["status"])
+		if err := checkEpoch(stored, state); err != nil {
+			return err
+		}
+		obj.Object["status"] = status
+		return s.c.Status().Update(ctx, obj)
+	})
+}
+
+// redisStateStore keeps the state as a JSON string in Redis:
+// redis://[:password@]host[:port][/db][?key=name]. The key defaults to
+// "rollback-controller:state".
+type redisStateStore struct {
+	addr     string
+	user     string
+	password string
+	db       int
+	key      string
+}
+
+func newRedisStateStore(u *url.URL, _ stateStoreEnv) (stateStore, error) {
+	s := &redisStateStore{addr: u.Host, key: u.Query().Get("key")}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if s.key == "" {
+		s.key = "rollback-controller:state"
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("state store %s: invalid database %q", u.Redacted(), db)
+		}
+		s.db = n
+	}
+	return s, nil
+}
+
+// do runs commands on a fresh connection and returns the last reply. The state
+// is saved every few seconds at most, so connections are not pooled.
+func (s *redisStateStore) do(ctx context.Context, cmds ...[]string) (reply []byte, isNil bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	var setup [][]string
+	switch {
+	case s.user != "":
+		setup = append(setup, []string{"AUTH", s.user, s.password})
+	case s.password != "":
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	cmds = append(setup, cmds...)
+	var buf bytes.Buffer
+	for _, args := range cmds {
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, false, err
+	}
+	rd := bufio.NewReader(conn)
+	for range cmds {
+		if reply, isNil, err = readRedisReply(rd); err != nil {
+			return nil, false, err
+		}
+	}
+	return reply, isNil, nil
+}
+
+// readRedisReply reads one simple, error, integer or bulk string reply.
+func readRedisReply(rd *bufio.Reader) (reply []byte, isNil bool, err error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, false, fmt.Errorf("empty Redis reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), false, nil
+	case '-':
+		return nil, false, fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid Redis bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, true, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, false, err
+		}
+		return data[:n], false, nil
+	}
+	return nil, false, fmt.Errorf("unsupported Redis reply %q", line)
+}
+
+func (s *redisStateStore) Load(ctx context.Context) (persistedState, error) {
+	data, isNil, err := s.do(ctx, []string{"GET", s.key})
+	if err != nil || isNil {
+		return persistedState{}, err
+	}
+	return decodeState(data)
+}
+
+// redisFencedSet sets KEYS[1] to ARGV[1] unless it holds state of an epoch
+// newer than ARGV[2]; as a script the check and the write are atomic.
+const redisFencedSet = `local cur = redis.call('GET', KEYS[1])
+if cur then
+  local ok, state = pcall(cjson.decode, cur)
+  if ok and type(state) == 'table' and (tonumber(state.epoch) or 0) > tonumber(ARGV[2]) then
+    return redis.error_reply('FENCED stored epoch ' .. state.epoch)
+  end
+end
+return redis.call('SET', KEYS[1], ARGV[1])`
+
+func (s *redisStateStore) Save(ctx context.Context, state persistedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.do(ctx, []string{"EVAL", redisFencedSet, "1", s.key, string(data), strconv.FormatInt(state.Epoch, 10)})
+	if err != nil && strings.HasPrefix(err.Error(), "redis: FENCED") {
+		return fmt.Errorf("%w: %s", errStateFenced, strings.TrimPrefix(err.Error(), "redis: FENCED "))
+	}
+	return err
+}
+
+// s3StateStore keeps the state as an object in S3 or an S3-compatible store:
+// s3://bucket/path/state.json. Requests use path-style URLs against
+// S3_ENDPOINT (default https://s3.<AWS_REGION>.amazonaws.com), signed with
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type s3StateStore struct {
+	endpoint  string
+	bucket    string
+	key       string
+	region    string
+	accessKey string
+	secretKey string
+	clock     clock.PassiveClock
+	client    *http.Client
+}
+
+func newS3StateStore(u *url.URL, env stateStoreEnv) (stateStore, error) {
+	s := &s3StateStore{
+		endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+		bucket:    u.Host,
+		key:       strings.TrimPrefix(u.Path, "/"),
+		region:    os.Getenv("AWS_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		clock:     env.Clock,
+		client:    env.HTTPClient,
+	}
+	if s.client == nil {
+		s.client = defaultProviderAPI.client
+	}
+	if s.bucket == "" || s.key == "" {
+		return nil, fmt.Errorf("state store %s: want s3://bucket/key", u)
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("state store %s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", u)
+	}
+	return s, nil
+}
+
+func (s *s3StateStore) request(ctx context.Context, method string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+s.bucket+"/"+s.key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	signS3Request(req, body, s.region, s.accessKey, s.secretKey, s.clock.Now())
+	return s.client.Do(req)
+}
+
+// get returns the stored object and its ETag; both are empty if there is none.
+func (s *s3StateStore) get(ctx context.Context) (data []byte, etag string, err error) {
+	resp, err := s.request(ctx, http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if data, err = io.ReadAll(resp.Body); err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s/%s: status %d: %s", s.bucket, s.key, resp.StatusCode, data)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func (s *s3StateStore) Load(ctx context.Context) (persistedState, error) {
+	data, _, err := s.get(ctx)
+	if err != nil {
+		return persistedState{}, err
+	}
+	return decodeState(data)
+}
+
+// Save writes the state with a conditional PUT on the ETag it checked the
+// epoch of, retrying when another writer got in between.
+func (s *s3StateStore) Save(ctx context.Context, state persistedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		stored, etag, err := s.get(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkEpoch(stored, state); err != nil {
+			return err
+		}
+		header := http.Header{"If-None-Match": {"*"}}
+		if etag != "" {
+			header = http.Header{"If-Match": {etag}}
+		}
+		resp, err := s.request(ctx, http.MethodPut, data, header)
+		if err != nil {
+			return err
+		}
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return nil
+		case (resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict) && attempt < 2:
+			continue
+		}
+		return fmt.Errorf("PUT %s/%s: status %d: %s", s.bucket, s.key, resp.StatusCode, msg)
+	}
+}
+
+// signS3Request adds an AWS Signature Version 4 Authorization header for the
+// s3 service. The request must have no query string.
+func signS3Request(req *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + stamp + "\n",
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// decodeState parses saved state; empty data is an empty state.
+func decodeState(data []byte) (persistedState, error) {
+	var s persistedState
+	if len(bytes.TrimSpace(data)) == 0 {
+		return s, nil
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return persistedState{}, fmt.Errorf("decoding state: %w", err)
+	}
+	return s, nil
+}
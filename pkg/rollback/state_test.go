@@ -0,0 +1,360 @@
+package rollback
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testState = persistedState{
+	Pending:   map[string]time.Time{"main@sha1:abc": time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+	Completed: []string{"def"},
+}
+
+// roundTrip checks that a store starts empty and returns what was saved.
+func roundTrip(t *testing.T, store stateStore) {
+	t.Helper()
+	ctx := context.Background()
+	got, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("load empty: %v", err)
+	}
+	if len(got.Pending) != 0 || len(got.Completed) != 0 {
+		t.Fatalf("new store not empty: %+v", got)
+	}
+	for i := 0; i < 2; i++ { // the second save updates
+		if err := store.Save(ctx, testState); err != nil {
+			t.Fatalf("save %d: %v", i, err)
+		}
+	}
+	if got, err = store.Load(ctx); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(got, testState) {
+		t.Errorf("loaded %+v, want %+v", got, testState)
+	}
+}
+
+// rejectsOlderEpoch checks that a store refuses state of an older epoch than
+// the saved one.
+func rejectsOlderEpoch(t *testing.T, store stateStore) {
+	t.Helper()
+	ctx := context.Background()
+	newer := persistedState{Completed: []string{"def"}, Epoch: 2}
+	if err := store.Save(ctx, newer); err != nil {
+		t.Fatalf("save epoch 2: %v", err)
+	}
+	if err := store.Save(ctx, persistedState{Completed: []string{"stale"}, Epoch: 1}); !errors.Is(err, errStateFenced) {
+		t.Errorf("saving epoch 1 over epoch 2: got %v, want errStateFenced", err)
+	}
+	if got, err := store.Load(ctx); err != nil || !reflect.DeepEqual(got, newer) {
+		t.Errorf("loaded %+v, %v; want %+v", got, err, newer)
+	}
+}
+
+func TestKubernetesStateStores(t *testing.T) {
+	state := &unstructured.Unstructured{}
+	state.SetGroupVersionKind(policyGroupVersion.WithKind("RollbackState"))
+	env := stateStoreEnv{
+		Client:    fake.NewClientBuilder().WithScheme(newScheme()).WithStatusSubresource(state).Build(),
+		Namespace: "flux-system",
+		Clock:     clocktesting.NewFakePassiveClock(time.Now()),
+	}
+	for _, spec := range []string{"configmap://rollback-state", "rollbackstate://default"} {
+		t.Run(spec, func(t *testing.T) {
+			store, err := newStateStore(spec, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			roundTrip(t, store)
+			rejectsOlderEpoch(t, store)
+		})
+	}
+}
+
+func TestNewStateStoreErrors(t *testing.T) {
+	for _, spec := range []string{"etcd://x", "configmap://", "redis://redis/zero", "s3://bucket"} {
+		if _, err := newStateStore(spec, stateStoreEnv{}); err == nil {
+			t.Errorf("newStateStore(%q) succeeded", spec)
+		}
+	}
+}
+
+// fakeRedis serves GET, SET, AUTH and SELECT from a map.
+func fakeRedis(t *testing.T, password string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	data := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			rd := bufio.NewReader(conn)
+			authed := password == ""
+			for {
+				args, err := readRedisCommand(rd)
+				if err != nil {
+					conn.Close()
+					break
+				}
+				switch cmd := strings.ToUpper(args[0]); {
+				case cmd == "AUTH" && args[len(args)-1] == password:
+					authed = true
+					fmt.Fprint(conn, "+OK\r\n")
+				case !authed:
+					fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+				case cmd == "SELECT":
+					fmt.Fprint(conn, "+OK\r\n")
+				case cmd == "SET":
+					data[args[1]] = args[2]
+					fmt.Fprint(conn, "+OK\r\n")
+				case cmd == "EVAL" && args[1] == redisFencedSet:
+					var cur persistedState
+					_ = json.Unmarshal([]byte(data[args[3]]), &cur)
+					if epoch, _ := strconv.ParseInt(args[5], 10, 64); cur.Epoch > epoch {
+						fmt.Fprintf(conn, "-FENCED stored epoch %d\r\n", cur.Epoch)
+						break
+					}
+					data[args[3]] = args[4]
+					fmt.Fprint(conn, "+OK\r\n")
+				case cmd == "GET":
+					if v, ok := data[args[1]]; ok {
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+					} else {
+						fmt.Fprint(conn, "$-1\r\n")
+					}
+				default:
+					fmt.Fprintf(conn, "-ERR unknown command %s\r\n", cmd)
+				}
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func readRedisCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestRedisStateStore(t *testing.T) {
+	addr := fakeRedis(t, "secret")
+	store, err := newStateStore("redis://:secret@"+addr+"/2?key=cluster-a", stateStoreEnv{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, store)
+	rejectsOlderEpoch(t, store)
+
+	wrong, _ := newStateStore("redis://:wrong@"+addr, stateStoreEnv{})
+	if _, err := wrong.Load(context.Background()); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("expected auth error, got %v", err)
+	}
+}
+
+func TestS3StateStore(t *testing.T) {
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20240101/eu-central-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+			http.Error(w, "bad auth "+auth, http.StatusForbidden)
+			return
+		}
+		obj, ok := objects[req.URL.Path]
+		etag := fmt.Sprintf("%q", sha256Hex(obj))
+		switch req.Method {
+		case http.MethodPut:
+			if m := req.Header.Get("If-Match"); (m != "" && (!ok || m != etag)) || (req.Header.Get("If-None-Match") == "*" && ok) {
+				http.Error(w, "PreconditionFailed", http.StatusPreconditionFailed)
+				return
+			}
+			objects[req.URL.Path], _ = io.ReadAll(req.Body)
+		case http.MethodGet:
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			_, _ = w.Write(obj)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("S3_ENDPOINT", srv.URL)
+	t.Setenv("AWS_REGION", "eu-central-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	store, err := newStateStore("s3://rollback/clusters/a/state.json", stateStoreEnv{
+		Clock: clocktesting.NewFakePassiveClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, store)
+	rejectsOlderEpoch(t, store)
+	if _, ok := objects["/rollback/clusters/a/state.json"]; !ok {
+		t.Errorf("object not stored path-style: %v", objects)
+	}
+}
+
+func TestSignS3RequestDeterministic(t *testing.T) {
+	sign := func(secret string) string {
+		u, _ := url.Parse("https://s3.example.com/bucket/key")
+		req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}}
+		signS3Request(req, nil, "us-east-1", "AKID", secret, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		return req.Header.Get("Authorization")
+	}
+	if sign("a") != sign("a") || sign("a") == sign("b") {
+		t.Error("signature must depend only on the inputs")
+	}
+}
+
+// memoryStateStore is a stateStore for tests.
+type memoryStateStore struct {
+	state persistedState
+	saves int
+}
+
+func (m *memoryStateStore) Load(context.Context) (persistedState, error) { return m.state, nil }
+
+func (m *memoryStateStore) Save(_ context.Context, s persistedState) error {
+	stored, _ := json.Marshal(m.state)
+	if err := checkEpoch(stored, s); err != nil {
+		return err
+	}
+	m.state = s
+	m.saves++
+	return nil
+}
+
+func TestRunStateSync(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clk)
+	store := &memoryStateStore{state: persistedState{
+		Pending:   map[string]time.Time{"old": start.Add(-time.Hour)},
+		Completed: []string{"done"},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		_ = r.runStateSync(ctx, store, 10*time.Second)
+		close(stopped)
+	}()
+	for !clk.HasWaiters() {
+		time.Sleep(time.Millisecond)
+	}
+	var reverted []string
+	r.handleResource("Kustomization", "app", "ns", "old", false, func(sha string) { reverted = append(reverted, sha) })
+	if !reflect.DeepEqual(reverted, []string{"old"}) {
+		t.Errorf("restored expired timer should revert at once, reverted %v", reverted)
+	}
+	r.handleResource("Kustomization", "app", "ns", "new", false, func(string) {})
+	cancel()
+	<-stopped
+
+	if store.saves != 2 {
+		t.Errorf("expected the epoch claim and one save on shutdown, got %d saves", store.saves)
+	}
+	want := persistedState{
+		Pending:   map[string]time.Time{"new": start},
+		Completed: []string{"done", "old"},
+		Epoch:     1,
+	}
+	if !reflect.DeepEqual(store.state, want) {
+		t.Errorf("saved %+v, want %+v", store.state, want)
+	}
+}
+
+func TestCurrentStateStable(t *testing.T) {
+	for _, maxAge := range []time.Duration{0, 30 * 24 * time.Hour} {
+		r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+		r.StateMaxAge = maxAge
+		r.handleResource("Kustomization", "app", "ns", "bad", false, func(string) {})
+		r.debounce.Complete("bad")
+		r.handleResource("Kustomization", "other", "ns", "pending", false, func(string) {})
+
+		// Unchanged state must save as the same bytes, or runStateSync
+		// rewrites the store on every sync.
+		first, _ := json.Marshal(r.currentState())
+		time.Sleep(time.Millisecond)
+		second, _ := json.Marshal(r.currentState())
+		if string(first) != string(second) {
+			t.Errorf("maxAge %s: unchanged state marshals differently:\n%s\n%s", maxAge, first, second)
+		}
+		if got := r.currentState().CompletedAt; (maxAge > 0) != (len(got) > 0) {
+			t.Errorf("maxAge %s: completion times %v, want them only with compaction", maxAge, got)
+		}
+	}
+}
+
+func TestRestoreCatchesUpOverdueFailures(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(start))
+	var reverted []string
+	revert := func(sha string) { reverted = append(reverted, sha) }
+
+	// The resource is reconciled before the restore finished: its window
+	// starts now and it is requeued for a full window.
+	if d := r.handleResource("Kustomization", "app", "ns", "old", false, revert); d.RequeueAfter != 300*time.Second {
+		t.Fatalf("requeue before restore = %s", d.RequeueAfter)
+	}
+	store := &memoryStateStore{state: persistedState{Pending: map[string]time.Time{"old": start.Add(-time.Hour)}}}
+	if err := r.restoreState(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-r.enqueue:
+		if ev.Object.GetNamespace() != "ns" || ev.Object.GetName() != "app" {
+			t.Errorf("requeued %s/%s, want ns/app", ev.Object.GetNamespace(), ev.Object.GetName())
+		}
+	default:
+		t.Fatal("overdue failure not requeued after the restore")
+	}
+	r.handleResource("Kustomization", "app", "ns", "old", false, revert)
+	if !reflect.DeepEqual(reverted, []string{"old"}) {
+		t.Errorf("window that expired during downtime should revert at once, reverted %v", reverted)
+	}
+}
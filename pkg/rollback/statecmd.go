@@ -0,0 +1,167 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// runState implements the "state export" and "state import" subcommands,
+// which carry the debounce state of a STATE_STORE across cluster migrations
+// and reinstalls.
+func runState(args []string) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, "usage: rollback-controller state export|import [flags]")
+		return 2
+	}
+	fs := flag.NewFlagSet("state "+args[0], flag.ExitOnError)
+	spec := fs.String("store", os.Getenv("STATE_STORE"), "state store URL, as STATE_STORE")
+	file := fs.String("f", "-", "file to write (export) or read (import); - is stdout or stdin")
+	replace := fs.Bool("replace", false, "import: replace the stored state instead of merging into it")
+	_ = fs.Parse(args[1:])
+
+	err := func() error {
+		store, err := openStateStore(*spec)
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+		if args[0] == "export" {
+			w := io.Writer(os.Stdout)
+			if *file != "-" {
+				f, err := os.Create(*file)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			return exportState(ctx, store, w)
+		}
+		rd := io.Reader(os.Stdin)
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			rd = f
+		}
+		s, err := importState(ctx, store, rd, *replace)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "imported state: %d pending, %d completed\n", len(s.Pending), len(s.Completed))
+		return nil
+	}()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// openStateStore opens the store at spec like the controller does,
+// connecting to the cluster only for stores kept in it.
+func openStateStore(spec string) (stateStore, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("set STATE_STORE or -store")
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	env := stateStoreEnv{Namespace: controllerNamespace(), Clock: clock.RealClock{},
+		HTTPClient: newHTTPClient(httpClientOptionsFromEnv(os.Getenv))}
+	if u.Scheme == "configmap" || u.Scheme == "rollbackstate" {
+		cfg, err := ctrl.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		if env.Client, err = client.New(cfg, client.Options{Scheme: newScheme()}); err != nil {
+			return nil, err
+		}
+	}
+	return newStateStore(spec, env)
+}
+
+// exportState writes the stored state as indented JSON.
+func exportState(ctx context.Context, store stateStore, w io.Writer) error {
+	s, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// importState reads an exported state and saves it to store, merged into the
+// stored state unless replace is set. It returns the saved state. The stored
+// epoch is kept, so an import neither fences off the running leader nor is
+// rejected as stale.
+func importState(ctx context.Context, store stateStore, rd io.Reader, replace bool) (persistedState, error) {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return persistedState{}, err
+	}
+	s, err := decodeState(data)
+	if err != nil {
+		return persistedState{}, err
+	}
+	stored, err := store.Load(ctx)
+	if err != nil {
+		return persistedState{}, err
+	}
+	if !replace {
+		s = mergeStates(stored, s)
+	}
+	s.Epoch = stored.Epoch
+	return s, store.Save(ctx, s)
+}
+
+// mergeStates combines two states: a SHA reverted in either stays reverted,
+// and a pending failure keeps its earliest first-seen time unless it was
+// reverted. Completion times keep the earliest too.
+func mergeStates(a, b persistedState) persistedState {
+	completed := make(map[string]bool)
+	for _, sha := range append(append([]string(nil), a.Completed...), b.Completed...) {
+		completed[sha] = true
+	}
+	out := persistedState{Pending: map[string]time.Time{}}
+	for sha := range completed {
+		out.Completed = append(out.Completed, sha)
+	}
+	sort.Strings(out.Completed)
+	for _, pending := range []map[string]time.Time{a.Pending, b.Pending} {
+		for sha, first := range pending {
+			if completed[sha] {
+				continue
+			}
+			if t, ok := out.Pending[sha]; !ok || first.Before(t) {
+				out.Pending[sha] = first
+			}
+		}
+	}
+	for _, completedAt := range []map[string]time.Time{a.CompletedAt, b.CompletedAt} {
+		for sha, at := range completedAt {
+			if out.CompletedAt == nil {
+				out.CompletedAt = map[string]time.Time{}
+			}
+			if t, ok := out.CompletedAt[sha]; !ok || at.Before(t) {
+				out.CompletedAt[sha] = at
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+package rollback
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportState(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	old := &memoryStateStore{state: persistedState{
+		Pending:   map[string]time.Time{"aaa": t0, "bbb": t0},
+		Completed: []string{"ccc"},
+	}}
+	var exported bytes.Buffer
+	if err := exportState(ctx, old, &exported); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new cluster already reverted bbb and saw aaa failing later.
+	fresh := &memoryStateStore{state: persistedState{
+		Pending:   map[string]time.Time{"aaa": t0.Add(time.Hour)},
+		Completed: []string{"bbb"},
+	}}
+	got, err := importState(ctx, fresh, bytes.NewReader(exported.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := persistedState{Pending: map[string]time.Time{"aaa": t0}, Completed: []string{"bbb", "ccc"}}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(fresh.state, want) {
+		t.Errorf("merged import = %+v, stored %+v, want %+v", got, fresh.state, want)
+	}
+
+	if _, err := importState(ctx, fresh, bytes.NewReader(exported.Bytes()), true); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fresh.state.Completed, []string{"ccc"}) || len(fresh.state.Pending) != 2 {
+		t.Errorf("replacing import = %+v", fresh.state)
+	}
+
+	if _, err := importState(ctx, fresh, strings.NewReader("{not json"), false); err == nil {
+		t.Error("invalid input should fail")
+	}
+}
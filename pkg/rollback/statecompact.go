@@ -0,0 +1,135 @@
+package rollback
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// stateCompactInterval is how often runStateSync compacts the state.
+const stateCompactInterval = time.Hour
+
+var (
+	stateStoreBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rollback_state_store_bytes",
+		Help: "Size of the JSON debounce state last saved to STATE_STORE.",
+	})
+	stateStoreEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rollback_state_store_entries",
+		Help: "Revisions in the debounce state last saved to STATE_STORE, by type (pending, completed).",
+	}, []string{"type"})
+	stateCompactedEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rollback_state_compacted_entries_total",
+		Help: "Revisions dropped from the debounce state by compaction, by type and reason (age, cap).",
+	}, []string{"type", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(stateStoreBytes, stateStoreEntries, stateCompactedEntries)
+}
+
+// stateCompaction lists the revisions compactPersisted drops.
+type stateCompaction struct {
+	Pending []string // pending for longer than the maximum age
+	Expired []string // completed longer ago than the maximum age
+	Capped  []string // oldest completed beyond the maximum entries
+}
+
+// compactPersisted selects the revisions to drop from s at now: pending and
+// completed revisions older than maxAge, and the oldest completed revisions
+// beyond maxEntries; 0 disables either limit. Completed revisions without a
+// completion time count as completed now. Pending revisions are running
+// timers and never capped. Revisions in watched are still reported by a
+// watched resource and never dropped, but count towards maxEntries.
+func compactPersisted(s persistedState, now time.Time, maxAge time.Duration, maxEntries int, watched map[string]bool) stateCompaction {
+	var c stateCompaction
+	if maxAge > 0 {
+		for sha, first := range s.Pending {
+			if !watched[sha] && now.Sub(first) > maxAge {
+				c.Pending = append(c.Pending, sha)
+			}
+		}
+	}
+	completedAt := func(sha string) time.Time {
+		if t, ok := s.CompletedAt[sha]; ok {
+			return t
+		}
+		return now
+	}
+	kept := make([]string, 0, len(s.Completed))
+	protected := 0
+	for _, sha := range s.Completed {
+		switch {
+		case watched[sha]:
+			protected++
+		case maxAge > 0 && now.Sub(completedAt(sha)) > maxAge:
+			c.Expired = append(c.Expired, sha)
+		default:
+			kept = append(kept, sha)
+		}
+	}
+	if excess := len(kept) + protected - maxEntries; maxEntries > 0 && excess > 0 {
+		sort.SliceStable(kept, func(i, j int) bool { return completedAt(kept[i]).Before(completedAt(kept[j])) })
+		c.Capped = append(c.Capped, kept[:min(excess, len(kept))]...)
+	}
+	sort.Strings(c.Pending)
+	sort.Strings(c.Expired)
+	sort.Strings(c.Capped)
+	return c
+}
+
+// compactState drops the revisions beyond StateMaxAge and StateMaxEntries
+// from the debouncer, so the next save writes a compacted snapshot. A
+// dropped completed revision is no longer known as reverted: should a
+// resource still fail on it, it is debounced and reverted again, and the
+// existing revert MR or branch is found as after a restart without state.
+// Revisions a watched resource still reports are kept, however old.
+func (r *RollbackController) compactState() {
+	if r.StateMaxAge == 0 && r.StateMaxEntries == 0 {
+		return
+	}
+	c := compactPersisted(r.currentState(), r.clock.Now(), r.StateMaxAge, r.StateMaxEntries, r.watchedRevisions())
+	if len(c.Pending)+len(c.Expired)+len(c.Capped) == 0 {
+		return
+	}
+	for _, sha := range c.Pending {
+		r.debounce.Forget(sha)
+	}
+	for _, sha := range append(c.Expired, c.Capped...) {
+		r.debounce.ClearCompleted(sha)
+	}
+	r.mu.Lock()
+	for _, sha := range append(c.Expired, c.Capped...) {
+		delete(r.completedAt, sha)
+	}
+	r.mu.Unlock()
+	stateCompactedEntries.WithLabelValues("pending", "age").Add(float64(len(c.Pending)))
+	stateCompactedEntries.WithLabelValues("completed", "age").Add(float64(len(c.Expired)))
+	stateCompactedEntries.WithLabelValues("completed", "cap").Add(float64(len(c.Capped)))
+	r.log.Info("Compacted debounce state", "expiredPending", len(c.Pending), "expiredCompleted", len(c.Expired), "capped", len(c.Capped),
+		"maxAge", r.StateMaxAge, "maxEntries", r.StateMaxEntries)
+}
+
+// watchedRevisions returns the revisions the watched resources report, both
+// as reported and as the commit SHA of a Flux revision.
+func (r *RollbackController) watchedRevisions() map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	watched := make(map[string]bool, 2*len(r.resources))
+	for _, st := range r.resources {
+		if st.Revision != "" {
+			watched[st.Revision] = true
+			watched[gitCommitSHA(st.Revision)] = true
+		}
+	}
+	return watched
+}
+
+// observeStoredState exports the size of the state saved as data.
+func observeStoredState(s persistedState, data []byte) {
+	stateStoreBytes.Set(float64(len(data)))
+	stateStoreEntries.WithLabelValues("pending").Set(float64(len(s.Pending)))
+	stateStoreEntries.WithLabelValues("completed").Set(float64(len(s.Completed)))
+}
@@ -0,0 +1,101 @@
+package rollback
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestCompactPersisted(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	s := persistedState{
+		Pending:   map[string]time.Time{"stuck": now.Add(-40 * day), "live": now.Add(-time.Hour)},
+		Completed: []string{"ancient", "old", "recent", "unknown"},
+		CompletedAt: map[string]time.Time{
+			"ancient": now.Add(-100 * day),
+			"old":     now.Add(-20 * day),
+			"recent":  now.Add(-day),
+		},
+	}
+
+	got := compactPersisted(s, now, 30*day, 2, nil)
+	want := stateCompaction{Pending: []string{"stuck"}, Expired: []string{"ancient"}, Capped: []string{"old"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compactPersisted = %+v, want %+v", got, want)
+	}
+	if got := compactPersisted(s, now, 0, 0, nil); !reflect.DeepEqual(got, stateCompaction{}) {
+		t.Errorf("without limits = %+v, want nothing dropped", got)
+	}
+
+	// Revisions a watched resource still reports are neither expired nor
+	// capped, but take up room under the cap.
+	watched := map[string]bool{"stuck": true, "ancient": true}
+	got = compactPersisted(s, now, 30*day, 2, watched)
+	want = stateCompaction{Capped: []string{"old", "recent"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("with watched revisions = %+v, want %+v", got, want)
+	}
+}
+
+func TestCompactState(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(start))
+	r.StateMaxAge = 30 * 24 * time.Hour
+	store := &memoryStateStore{state: persistedState{
+		Completed:   []string{"expired", "kept"},
+		CompletedAt: map[string]time.Time{"expired": start.Add(-60 * 24 * time.Hour)},
+	}}
+	if err := r.restoreState(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	// The restore keeps the saved completion time instead of the restart's.
+	if got := store.state.CompletedAt["expired"]; !got.Equal(start.Add(-60 * 24 * time.Hour)) {
+		t.Errorf("claimed state completed expired at %s", got)
+	}
+
+	r.compactState()
+	s := r.currentState()
+	if !reflect.DeepEqual(s.Completed, []string{"kept"}) {
+		t.Errorf("completed after compaction = %v, want [kept]", s.Completed)
+	}
+	if _, ok := s.CompletedAt["expired"]; ok {
+		t.Error("compacted revision still has a completion time")
+	}
+}
+
+func TestCompactStateKeepsWatchedRevisions(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clocktesting.NewFakeClock(start))
+	r.StateMaxAge = 30 * 24 * time.Hour
+	store := &memoryStateStore{state: persistedState{
+		Completed: []string{"abc123", "def456"},
+		CompletedAt: map[string]time.Time{
+			"abc123": start.Add(-60 * 24 * time.Hour),
+			"def456": start.Add(-60 * 24 * time.Hour),
+		},
+	}}
+	if err := r.restoreState(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	// A Kustomization still reports the reverted revision, e.g. because the
+	// revert MR was never merged.
+	r.resources["Kustomization/flux-system/apps"] = &resourceStatus{
+		Kind: "Kustomization", Namespace: "flux-system", Name: "apps", Revision: "main@sha1:abc123",
+	}
+
+	r.compactState()
+	s := r.currentState()
+	if !reflect.DeepEqual(s.Completed, []string{"abc123"}) {
+		t.Errorf("completed after compaction = %v, want [abc123]", s.Completed)
+	}
+	if _, ok := s.CompletedAt["abc123"]; !ok {
+		t.Error("revision still reported by a watched resource lost its completion time")
+	}
+}
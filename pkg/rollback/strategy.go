@@ -0,0 +1,116 @@
+package rollback
+
+import "fmt"
+
+// targetBranch returns the branch changes land on: the policy's TargetBranch
+// or the project's default branch.
+func targetBranch(gl gitlabProject, policy *RollbackPolicy) (string, error) {
+	if t := policy.targetBranch(); t != "" {
+		return t, nil
+	}
+	return gl.defaultBranch()
+}
+
+// deliverChange lands a change in Git according to strategy. commit creates
+// the change on branch; start is the branch to create it from, or "" if
+// branch already exists. It returns the MR if the strategy opened one.
+func deliverChange(gl gitlabProject, strategy, branch, target string, mr gitlabMergeRequestOptions, commit func(branch, start string) error) (*gitlabMergeRequest, error) {
+	switch strategy {
+	case RevertStrategyDirect:
+		return nil, commit(target, "")
+	case RevertStrategyBranch:
+		return nil, commit(branch, target)
+	case RevertStrategyMergeRequest:
+		if err := commit(branch, target); err != nil {
+			return nil, err
+		}
+		created, err := gl.createMergeRequest(branch, target, mr)
+		if err != nil {
+			return nil, fmt.Errorf("creating merge request: %w", err)
+		}
+		return created, nil
+	}
+	return nil, fmt.Errorf("unknown revert strategy %q", strategy)
+}
+
+// commitFiles returns a deliverChange commit func applying actions.
+func commitFiles(gl gitlabProject, message string, actions []gitlabCommitAction) func(branch, start string) error {
+	return func(branch, start string) error {
+		return gl.commitActions(branch, start, message, actions)
+	}
+}
+
+// notifyAuthor assigns or mentions the author of sha on the MR, as
+// configured by the policy. Lookup failures are logged and leave the MR
+// unchanged.
+func (r *RollbackController) notifyAuthor(gl gitlabProject, policy *RollbackPolicy, sha string, mr *gitlabMergeRequestOptions) {
+	mode := policy.notifyAuthor()
+	if mode == NotifyAuthorNone || sha == "" {
+		return
+	}
+	name, email, err := gl.commitAuthor(sha)
+	if err != nil {
+		r.log.Error(err, "failed to get commit author", "sha", sha)
+		return
+	}
+	user, err := gl.userByEmail(email)
+	if err != nil {
+		r.log.Error(err, "failed to look up commit author", "sha", sha)
+	}
+	switch {
+	case user == nil:
+		// No GitLab account is visible for the email; name the author instead.
+		mr.Description += fmt.Sprintf("\n\nBad commit authored by %s <%s>.", name, email)
+	case mode == NotifyAuthorAssign:
+		mr.AssigneeIDs = append(mr.AssigneeIDs, user.ID)
+		mr.Description += fmt.Sprintf("\n\nBad commit authored by @%s.", user.Username)
+	default:
+		mr.Description += fmt.Sprintf("\n\n@%s, your commit %s broke the deployment and is being reverted.", user.Username, sha)
+	}
+}
+
+// prepareMergeRequest adds links to the failing resource, the other
+// resources failing on sha, the author
+// notification and the policy's MR settings to mr. sha is the bad commit, or
+// "" if there is none (chart pins). Names that cannot be resolved are logged
+// and skipped so the revert is never blocked by MR decoration.
+func (r *RollbackController) prepareMergeRequest(gl gitlabProject, res resourceRef, policy *RollbackPolicy, sha string, mr *gitlabMergeRequestOptions) {
+	mr.Description += r.resourceLinks(res, sha)
+	mr.Description += r.affectedResources(res, sha)
+	r.notifyAuthor(gl, policy, sha, mr)
+	if policy == nil || policy.Spec.MergeRequest == nil {
+		return
+	}
+	spec := policy.Spec.MergeRequest
+	mr.Draft = spec.Draft
+	mr.Labels = append(mr.Labels, spec.Labels...)
+	if spec.Milestone != "" {
+		id, err := gl.milestoneID(spec.Milestone)
+		if err != nil {
+			r.log.Error(err, "failed to resolve milestone", "milestone", spec.Milestone)
+		}
+		mr.MilestoneID = id
+	}
+	mr.ReviewerIDs = append(mr.ReviewerIDs, r.userIDs(gl, spec.Reviewers)...)
+	for _, rule := range spec.ApprovalRules {
+		mr.ApprovalRules = append(mr.ApprovalRules, gitlabApprovalRule{
+			Name:              rule.Name,
+			ApprovalsRequired: rule.ApprovalsRequired,
+			UserIDs:           r.userIDs(gl, rule.Usernames),
+		})
+	}
+}
+
+// userIDs resolves usernames to user IDs, skipping unknown users.
+func (r *RollbackController) userIDs(gl gitlabProject, usernames []string) []int {
+	var ids []int
+	for _, name := range usernames {
+		user, err := gl.userByUsername(name)
+		if err != nil {
+			r.log.Error(err, "failed to resolve GitLab user", "username", name)
+			continue
+		}
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
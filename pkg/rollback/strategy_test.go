@@ -0,0 +1,205 @@
+package rollback
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+// fakeGitLab records the API calls it receives and answers them with minimal
+// valid responses.
+func fakeGitLab(t *testing.T) (gitlabProject, *[]string) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, "/api/v4/projects/42")
+		var body map[string]interface{}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		call := req.Method + " " + path
+		if b, ok := body["branch"]; ok {
+			call += " branch=" + b.(string)
+		}
+		if s, ok := body["start_branch"]; ok {
+			call += " start=" + s.(string)
+		}
+		if s, ok := body["ref"]; ok {
+			call += " ref=" + s.(string)
+		}
+		calls = append(calls, call)
+		switch {
+		case path == "" && req.Method == "GET":
+			_, _ = w.Write([]byte(`{"default_branch":"main"}`))
+		case path == "/merge_requests":
+			_, _ = w.Write([]byte(`{"iid":1,"web_url":"https://gitlab/mr/1"}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return gitlabProject{BaseURL: srv.URL, ProjectID: "42"}, &calls
+}
+
+func TestCreateGitlabRevertMRStrategies(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	tests := []struct {
+		strategy string
+		wantURL  string
+		want     []string
+	}{
+		{"", "", []string{
+			"GET ",
+			"POST /repository/branches branch=revert-" + sha + " ref=main",
+			"POST /repository/commits/" + sha + "/revert branch=revert-" + sha,
+		}},
+		{RevertStrategyMergeRequest, "https://gitlab/mr/1", []string{
+			"GET ",
+			"POST /repository/branches branch=revert-" + sha + " ref=main",
+			"POST /repository/commits/" + sha + "/revert branch=revert-" + sha,
+			"POST /merge_requests",
+		}},
+		{RevertStrategyDirect, "", []string{
+			"GET ",
+			"POST /repository/commits/" + sha + "/revert branch=main",
+		}},
+	}
+	for _, tt := range tests {
+		gl, calls := fakeGitLab(t)
+		r := NewRollbackController(nil, logr.Discard(), "", "42", "", "revert", 300)
+		policy := &RollbackPolicy{Spec: RollbackPolicySpec{RevertStrategy: tt.strategy}}
+		if url := r.createGitlabRevertMR(gl, resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}, policy, "main@sha1:"+sha); url.webURL() != tt.wantURL {
+			t.Errorf("%q: url = %q, want %q", tt.strategy, url.webURL(), tt.wantURL)
+		}
+		if !reflect.DeepEqual(*calls, tt.want) {
+			t.Errorf("%q: calls = %q, want %q", tt.strategy, *calls, tt.want)
+		}
+	}
+}
+
+func TestDeliverChangeFiles(t *testing.T) {
+	gl, calls := fakeGitLab(t)
+	actions := []gitlabCommitAction{{Action: "update", FilePath: "values.yaml", Content: "x"}}
+	for _, strategy := range []string{RevertStrategyBranch, RevertStrategyDirect} {
+		if _, err := deliverChange(gl, strategy, "revert-x", "main", gitlabMergeRequestOptions{}, commitFiles(gl, "t", actions)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"POST /repository/commits branch=revert-x start=main",
+		"POST /repository/commits branch=main",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("calls = %q, want %q", *calls, want)
+	}
+	if _, err := deliverChange(gl, "Sideways", "b", "main", gitlabMergeRequestOptions{}, commitFiles(gl, "t", actions)); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestNotifyAuthor(t *testing.T) {
+	users := `[{"id":7,"username":"alice"}]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v4/projects/42/repository/commits/abc":
+			_, _ = w.Write([]byte(`{"author_name":"Alice","author_email":"alice@example.com"}`))
+		case "/api/v4/users":
+			if req.URL.Query().Get("search") != "alice@example.com" {
+				t.Errorf("unexpected user search %q", req.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(users))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	gl := gitlabProject{BaseURL: srv.URL, ProjectID: "42"}
+	r := NewRollbackController(nil, logr.Discard(), "", "42", "", "revert", 300)
+	policy := func(mode string) *RollbackPolicy {
+		return &RollbackPolicy{Spec: RollbackPolicySpec{NotifyAuthor: mode}}
+	}
+
+	var mr gitlabMergeRequestOptions
+	r.notifyAuthor(gl, nil, "abc", &mr)
+	if mr.Description != "" || mr.AssigneeIDs != nil {
+		t.Errorf("default must not notify: %+v", mr)
+	}
+
+	mr = gitlabMergeRequestOptions{}
+	r.notifyAuthor(gl, policy(NotifyAuthorAssign), "abc", &mr)
+	if !reflect.DeepEqual(mr.AssigneeIDs, []int{7}) || !strings.Contains(mr.Description, "@alice") {
+		t.Errorf("assign: %+v", mr)
+	}
+
+	mr = gitlabMergeRequestOptions{}
+	r.notifyAuthor(gl, policy(NotifyAuthorMention), "abc", &mr)
+	if mr.AssigneeIDs != nil || !strings.Contains(mr.Description, "@alice") {
+		t.Errorf("mention: %+v", mr)
+	}
+
+	users = `[]`
+	mr = gitlabMergeRequestOptions{}
+	r.notifyAuthor(gl, policy(NotifyAuthorAssign), "abc", &mr)
+	if mr.AssigneeIDs != nil || !strings.Contains(mr.Description, "Alice <alice@example.com>") {
+		t.Errorf("unknown user: %+v", mr)
+	}
+}
+
+func TestPrepareAndCreateMergeRequest(t *testing.T) {
+	var mrBody map[string]interface{}
+	var rules []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v4/projects/42/milestones":
+			_, _ = w.Write([]byte(`[{"id":3}]`))
+		case "/api/v4/users":
+			switch req.URL.Query().Get("username") {
+			case "bob":
+				_, _ = w.Write([]byte(`[{"id":11,"username":"bob"}]`))
+			case "carol":
+				_, _ = w.Write([]byte(`[{"id":12,"username":"carol"}]`))
+			default:
+				_, _ = w.Write([]byte(`[]`))
+			}
+		case "/api/v4/projects/42/merge_requests":
+			_ = json.NewDecoder(req.Body).Decode(&mrBody)
+			_, _ = w.Write([]byte(`{"iid":5,"web_url":"https://gitlab/mr/5"}`))
+		case "/api/v4/projects/42/merge_requests/5/approval_rules":
+			var rule map[string]interface{}
+			_ = json.NewDecoder(req.Body).Decode(&rule)
+			rules = append(rules, rule)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+	gl := gitlabProject{BaseURL: srv.URL, ProjectID: "42"}
+	r := NewRollbackController(nil, logr.Discard(), "", "42", "", "revert", 300)
+	policy := &RollbackPolicy{Spec: RollbackPolicySpec{MergeRequest: &MergeRequestSpec{
+		Labels:        []string{"auto-rollback", "incident"},
+		Milestone:     "Q3",
+		Reviewers:     []string{"bob", "nobody"},
+		ApprovalRules: []ApprovalRuleSpec{{Name: "sre", ApprovalsRequired: 1, Usernames: []string{"carol"}}},
+		Draft:         true,
+	}}}
+
+	mr := gitlabMergeRequestOptions{Title: "Revert abc"}
+	r.prepareMergeRequest(gl, resourceRef{Kind: "HelmRelease", Namespace: "apps", Name: "web"}, policy, "", &mr)
+	created, err := gl.createMergeRequest("revert-abc", "main", mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.WebURL != "https://gitlab/mr/5" {
+		t.Errorf("url = %q", created.WebURL)
+	}
+	if mrBody["title"] != "Draft: Revert abc" || mrBody["labels"] != "auto-rollback,incident" || mrBody["milestone_id"] != float64(3) ||
+		!reflect.DeepEqual(mrBody["reviewer_ids"], []interface{}{float64(11)}) {
+		t.Errorf("unexpected MR body %v", mrBody)
+	}
+	if len(rules) != 1 || rules[0]["name"] != "sre" || rules[0]["approvals_required"] != float64(1) ||
+		!reflect.DeepEqual(rules[0]["user_ids"], []interface{}{float64(12)}) {
+		t.Errorf("unexpected approval rules %v", rules)
+	}
+}
@@ -0,0 +1,154 @@
+package rollback
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timelineLabels are the audit events as they read in an incident timeline;
+// other events are shown by name.
+var timelineLabels = map[string]string{
+	auditDetected:           "Failure detected",
+	auditDebounced:          "Debounce window expired",
+	auditReverted:           "Revert issued",
+	auditMerged:             "Revert MR merged",
+	auditBranchDeleted:      "Revert branch deleted",
+	auditMRUpdated:          "Revert MR lists another resource",
+	auditMergeTrain:         "Revert MR on the merge train",
+	auditRefailed:           "Failing again for a new reason",
+	auditRecovered:          "Recovered",
+	auditSkipped:            "Revert skipped (skip marker)",
+	auditSuppressed:         "Suppressed, a dependency fails",
+	auditHeld:               "Revert held",
+	auditBudgetExhausted:    "Revert held, domain budget spent",
+	auditNotified:           "Escalation: notified",
+	auditSuspended:          "Escalation: suspended",
+	auditAutoMerged:         "Escalation: MR set to merge",
+	auditRevertFailed:       "Revert failed",
+	auditDeadLettered:       "Revert dead-lettered",
+	auditAlreadyRequested:   "Revert already requested elsewhere",
+	auditVerified:           "Revert verified",
+	auditVerificationFailed: "Revert verification failed",
+	auditAdmin:              "Admin action",
+}
+
+// sameIncident reports whether the audit key belongs to the incident on sha,
+// given as tracking key, commit SHA or abbreviated commit SHA (7+ chars).
+func sameIncident(key, sha string) bool {
+	want := gitCommitSHA(sha)
+	return key == sha || (len(want) >= 7 && strings.HasPrefix(gitCommitSHA(key), want))
+}
+
+// incidentTimeline returns the audit entries of the incident on sha, oldest
+// first, from entries newest first as in stateSnapshot.
+func incidentTimeline(entries []auditEntry, sha string) []auditEntry {
+	var out []auditEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if e := entries[i]; e.SHA != "" && sameIncident(e.SHA, sha) {
+			out = append(out, e)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+// markdownCell escapes s for a Markdown table cell.
+var markdownCell = strings.NewReplacer("|", `\|`, "\n", " ").Replace
+
+// writeTimeline renders a non-empty incident timeline as Markdown for
+// postmortems: a table of the entries, revert entries linked to their MR
+// from reverts, followed by the time to revert and to recovery.
+func writeTimeline(w io.Writer, sha string, timeline []auditEntry, reverts []revertRecord) error {
+	mrs := make(map[string]string)
+	for _, rec := range reverts {
+		if rec.URL != "" && sameIncident(rec.SHA, sha) {
+			mrs[rec.Kind+"/"+rec.Namespace+"/"+rec.Name] = rec.URL
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Incident timeline: `%s`\n\n", sha)
+	b.WriteString("| Time (UTC) | T+ | Event | Resource | Details |\n")
+	b.WriteString("|------------|----|-------|----------|---------|\n")
+	start := timeline[0].Time
+	var detected, reverted, recovered time.Time
+	for _, e := range timeline {
+		label, ok := timelineLabels[e.Event]
+		if !ok {
+			label = e.Event
+		}
+		var resource string
+		if e.Kind != "" {
+			resource = e.Kind + " " + e.Namespace + "/" + e.Name
+		}
+		details := markdownCell(e.Message)
+		if url := mrs[e.Kind+"/"+e.Namespace+"/"+e.Name]; url != "" && e.Event == auditReverted {
+			details = strings.TrimSpace(details + " [MR](" + url + ")")
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", e.Time.UTC().Format(time.DateTime), e.Time.Sub(start).Round(time.Second),
+			label, markdownCell(resource), details)
+		switch {
+		case e.Event == auditDetected && detected.IsZero():
+			detected = e.Time
+		case e.Event == auditReverted && reverted.IsZero():
+			reverted = e.Time
+		case e.Event == auditRecovered:
+			recovered = e.Time
+		}
+	}
+	if !detected.IsZero() && (!reverted.IsZero() || !recovered.IsZero()) {
+		b.WriteString("\n")
+		if !reverted.IsZero() {
+			fmt.Fprintf(&b, "- Time to revert: %s\n", reverted.Sub(detected).Round(time.Second))
+		}
+		if !recovered.IsZero() {
+			fmt.Fprintf(&b, "- Time to recovery: %s\n", recovered.Sub(detected).Round(time.Second))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// runTimeline implements the `timeline` subcommand: it renders the incident
+// timeline of a SHA from a dashboard state export (/api/state).
+func runTimeline(args []string) int {
+	fs := flag.NewFlagSet("timeline", flag.ExitOnError)
+	file := fs.String("f", "-", "dashboard state JSON (/api/state); - reads stdin")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: rollback-controller timeline [-f state.json] <sha>")
+		return 2
+	}
+	sha := fs.Arg(0)
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	var s stateSnapshot
+	if err := json.NewDecoder(in).Decode(&s); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	timeline := incidentTimeline(s.Audit, sha)
+	if len(timeline) == 0 {
+		fmt.Fprintf(os.Stderr, "no audit entries for %s\n", sha)
+		return 1
+	}
+	if err := writeTimeline(os.Stdout, sha, timeline, s.Reverts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package rollback
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestIncidentTimeline(t *testing.T) {
+	const sha = "main@sha1:0123456789abcdef0123456789abcdef01234567"
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clocktesting.NewFakeClock(start)
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.setClock(clk)
+	step := func(d time.Duration, event, message string) {
+		clk.Step(d)
+		r.mu.Lock()
+		r.recordAudit(event, "Kustomization", "apps", "web", sha, message)
+		r.mu.Unlock()
+	}
+	step(0, auditDetected, "")
+	step(0, auditDetected, "")
+	r.auditLog[1].SHA = "main@sha1:fedcba9876543210fedcba9876543210fedcba98" // another incident
+	step(5*time.Minute, auditDebounced, "")
+	step(0, auditReverted, "")
+	r.reverts = append(r.reverts, revertRecord{Kind: "Kustomization", Namespace: "apps", Name: "web", SHA: sha,
+		URL: "https://gitlab/apps/-/merge_requests/12", MRIID: 12})
+	step(10*time.Minute, auditMerged, "https://gitlab/apps/-/merge_requests/12")
+	step(2*time.Minute, auditRecovered, "")
+
+	srv := httptest.NewServer(r.adminHandler("secret"))
+	defer srv.Close()
+	get := func(sha string) (int, string) {
+		req, _ := http.NewRequest("GET", srv.URL+"/admin/timeline/"+sha, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var b strings.Builder
+		_, _ = io.Copy(&b, resp.Body)
+		return resp.StatusCode, b.String()
+	}
+	// Abbreviated commit SHAs find the incident too.
+	code, got := get("0123456")
+	want := "## Incident timeline: `0123456`\n\n" +
+		"| Time (UTC) | T+ | Event | Resource | Details |\n" +
+		"|------------|----|-------|----------|---------|\n" +
+		"| 2024-01-01 10:00:00 | 0s | Failure detected | Kustomization apps/web |  |\n" +
+		"| 2024-01-01 10:05:00 | 5m0s | Debounce window expired | Kustomization apps/web |  |\n" +
+		"| 2024-01-01 10:05:00 | 5m0s | Revert issued | Kustomization apps/web | [MR](https://gitlab/apps/-/merge_requests/12) |\n" +
+		"| 2024-01-01 10:15:00 | 15m0s | Revert MR merged | Kustomization apps/web | https://gitlab/apps/-/merge_requests/12 |\n" +
+		"| 2024-01-01 10:17:00 | 17m0s | Recovered | Kustomization apps/web |  |\n" +
+		"\n- Time to revert: 5m0s\n- Time to recovery: 17m0s\n"
+	if code != http.StatusOK || got != want {
+		t.Errorf("timeline (%d):\n%s\nwant:\n%s", code, got, want)
+	}
+	if code, _ := get("main@sha1:aaaaaaa"); code != http.StatusNotFound {
+		t.Errorf("unknown incident: %d, want 404", code)
+	}
+}
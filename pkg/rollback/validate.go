@@ -0,0 +1,477 @@
+package rollback
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Levels of validation findings; errors fail the validate subcommand.
+const (
+	validationOK      = "ok"
+	validationWarning = "warning"
+	validationError   = "error"
+)
+
+// gitlabDeveloperAccess is the GitLab access level needed to push revert
+// branches and open MRs.
+const gitlabDeveloperAccess = 30
+
+// validationFinding is one result of the validate subcommand.
+type validationFinding struct {
+	Level   string `json:"level"`
+	Subject string `json:"subject"` // e.g. "rules[2]" or "policy apps/web"
+	Message string `json:"message"`
+}
+
+// validationReport collects the findings of the validate subcommand.
+type validationReport []validationFinding
+
+func (v *validationReport) add(level, subject, format string, args ...interface{}) {
+	*v = append(*v, validationFinding{Level: level, Subject: subject, Message: fmt.Sprintf(format, args...)})
+}
+
+// failed reports whether the report has errors.
+func (v validationReport) failed() bool {
+	for _, f := range v {
+		if f.Level == validationError {
+			return true
+		}
+	}
+	return false
+}
+
+// readRoutingConfig parses routing rules, either the rules.yaml content of
+// ROUTING_CONFIGMAP or the ConfigMap manifest itself. Unknown fields are
+// errors, so typos don't silently drop a setting.
+func readRoutingConfig(data []byte) (*routingConfig, error) {
+	var cm struct {
+		Kind string            `json:"kind"`
+		Data map[string]string `json:"data"`
+	}
+	if err := yaml.Unmarshal(data, &cm); err == nil && cm.Kind == "ConfigMap" {
+		rules, ok := cm.Data[routingConfigKey]
+		if !ok {
+			return nil, fmt.Errorf("ConfigMap has no %s key", routingConfigKey)
+		}
+		data = []byte(rules)
+	}
+	var cfg routingConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validateRoutingConfig checks the rules of cfg the controller would only
+// trip over at revert time: bad glob patterns, unknown providers, missing
+// fields and rules no resource can reach because an earlier one matches
+// first.
+func validateRoutingConfig(cfg *routingConfig) validationReport {
+	var v validationReport
+	checkProxy := func(subject, proxy, secret, provider string) {
+		if proxy == "" {
+			if secret != "" {
+				v.add(validationError, subject, "proxySecret is set without proxy")
+			}
+			return
+		}
+		u, err := parseProxyURL(proxy)
+		switch {
+		case err != nil:
+			v.add(validationError, subject, "%v", err)
+		case u.User != nil:
+			v.add(validationWarning, subject, "proxy URL contains credentials, keep them in proxySecret")
+		}
+		if err == nil && provider == providerSSH && u.Scheme != "socks5" && u.Scheme != "socks5h" {
+			v.add(validationError, subject, "ssh remotes can only be reached through socks5 proxies")
+		}
+	}
+	check := func(subject string, rule routeRule) {
+		for _, pattern := range []string{rule.Match.Kind, rule.Match.Namespace, rule.Match.Name} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.add(validationError, subject, "match pattern %q is invalid: %v", pattern, err)
+			}
+		}
+		switch rule.Provider {
+		case "", providerGitLab, providerGerrit, providerCodeCommit, providerSSH:
+		default:
+			v.add(validationError, subject, "unknown provider %q (want gitlab, gerrit, codecommit or ssh)", rule.Provider)
+			return
+		}
+		if rule.ProjectID == "" && rule.Provider != providerSSH && subject != "default" {
+			v.add(validationError, subject, "projectID is required")
+		}
+		switch rule.Provider {
+		case providerGerrit:
+			if rule.URL == "" || rule.Username == "" || rule.TokenSecret == "" {
+				v.add(validationError, subject, "gerrit needs url, username and tokenSecret")
+			}
+		case providerCodeCommit:
+			if rule.Region == "" && os.Getenv("AWS_REGION") == "" {
+				v.add(validationWarning, subject, "no region and AWS_REGION is not set")
+			}
+		case providerSSH:
+			if rule.URL == "" {
+				v.add(validationError, subject, "ssh needs the Git remote in url")
+			}
+		}
+		if rule.URL != "" && rule.Provider != providerSSH {
+			if u, err := url.Parse(rule.URL); err != nil || u.Host == "" {
+				v.add(validationError, subject, "url %q is not an absolute URL", rule.URL)
+			}
+		}
+		checkProxy(subject, rule.Proxy, rule.ProxySecret, rule.Provider)
+	}
+	if cfg.Default != nil {
+		check("default", *cfg.Default)
+	}
+	for i, rule := range cfg.Rules {
+		subject := fmt.Sprintf("rules[%d]", i)
+		check(subject, rule)
+		for j := 0; j < i; j++ {
+			if covers(cfg.Rules[j].Match, rule.Match) {
+				v.add(validationWarning, subject, "unreachable, rules[%d] matches all its resources first", j)
+				break
+			}
+		}
+	}
+	for i, inst := range cfg.Instances {
+		subject := fmt.Sprintf("instances[%d]", i)
+		if u, err := url.Parse(inst.URL); err != nil || u.Host == "" {
+			v.add(validationError, subject, "url %q is not an absolute URL", inst.URL)
+		}
+		if inst.TokenSecret == "" {
+			v.add(validationError, subject, "tokenSecret is required")
+		}
+		checkProxy(subject, inst.Proxy, inst.ProxySecret, providerGitLab)
+	}
+	if len(v) == 0 {
+		v.add(validationOK, "routing", "%d rules, %d instances", len(cfg.Rules), len(cfg.Instances))
+	}
+	return v
+}
+
+// covers reports whether every resource m matches is matched by earlier, by
+// field: an empty pattern matches anything, otherwise the patterns must be
+// equal.
+func covers(earlier, m routeMatch) bool {
+	field := func(e, p string) bool { return e == "" || e == p }
+	return field(earlier.Kind, m.Kind) && field(earlier.Namespace, m.Namespace) && field(earlier.Name, m.Name)
+}
+
+// readPolicies reads the RollbackPolicies of a YAML or JSON stream of
+// manifests, converted to the hub version; other kinds are skipped.
+func readPolicies(in io.Reader) ([]RollbackPolicy, error) {
+	var policies []RollbackPolicy
+	dec := utilyaml.NewYAMLOrJSONDecoder(in, 4096)
+	for {
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); errors.Is(err, io.EOF) {
+			return policies, nil
+		} else if err != nil {
+			return nil, err
+		}
+		u := &unstructured.Unstructured{Object: obj}
+		if obj == nil || u.GetKind() != "RollbackPolicy" {
+			continue
+		}
+		var p RollbackPolicy
+		err := convertPolicy(u, policyHubVersion.Version)
+		if err == nil {
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RollbackPolicy %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+		}
+		policies = append(policies, p)
+	}
+}
+
+// validatePolicies runs the CRD's validation rules on policies client-side
+// and checks their targets: a policy without targets applies to nothing,
+// and a resource targeted by several policies only gets the first.
+func validatePolicies(policies []RollbackPolicy) validationReport {
+	var v validationReport
+	owner := make(map[string]string)
+	for _, p := range policies {
+		subject := "policy " + p.Namespace + "/" + p.Name
+		if p.Namespace == "" {
+			v.add(validationWarning, subject, "no namespace; targets without one refer to the namespace it is applied to")
+		}
+		if err := p.validate(); err != nil {
+			v.add(validationError, subject, "%v", err)
+			continue
+		}
+		if len(p.Spec.Targets) == 0 {
+			v.add(validationWarning, subject, "has no targets and applies to no resource")
+			continue
+		}
+		ok := true
+		for _, t := range p.Spec.Targets {
+			ns := t.Namespace
+			if ns == "" {
+				ns = p.Namespace
+			}
+			key := t.Kind + "/" + ns + "/" + t.Name
+			if other, found := owner[key]; found {
+				v.add(validationError, subject, "%s is also targeted by policy %s; only one of them applies", key, other)
+				ok = false
+				continue
+			}
+			owner[key] = p.Namespace + "/" + p.Name
+		}
+		if ok {
+			v.add(validationOK, subject, "%d targets", len(p.Spec.Targets))
+		}
+	}
+	return v
+}
+
+// checkProvider checks that a GitLab project is reachable with its token,
+// exists, and that the token has the api scope and Developer access. Other
+// providers are not checked.
+func checkProvider(subject string, gl gitlabProject) validationReport {
+	var v validationReport
+	if !gl.isGitLab() {
+		v.add(validationWarning, subject, "%s provider not checked", gl.Provider)
+		return v
+	}
+	project := gl.url("")
+	var info struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		Permissions       struct {
+			ProjectAccess *struct {
+				AccessLevel int `json:"access_level"`
+			} `json:"project_access"`
+			GroupAccess *struct {
+				AccessLevel int `json:"access_level"`
+			} `json:"group_access"`
+		} `json:"permissions"`
+	}
+	if err := gl.request("GET", "", nil, &info); err != nil {
+		var perr *providerError
+		if errors.As(err, &perr) && misconfigStatus("GET", true, perr.StatusCode) {
+			_, hint := misconfigHint(project, perr.StatusCode)
+			v.add(validationError, subject, "%s: %s", perr.Status, hint)
+		} else {
+			v.add(validationError, subject, "unreachable: %v", err)
+		}
+		return v
+	}
+	access := 0
+	if a := info.Permissions.ProjectAccess; a != nil {
+		access = a.AccessLevel
+	}
+	if a := info.Permissions.GroupAccess; a != nil && a.AccessLevel > access {
+		access = a.AccessLevel
+	}
+	if access > 0 && access < gitlabDeveloperAccess {
+		v.add(validationError, subject, "token has access level %d to %s; reverts need Developer (%d)", access, info.PathWithNamespace, gitlabDeveloperAccess)
+	}
+	// Token introspection needs GitLab 15.5; older servers are not checked.
+	var token struct {
+		Scopes []string `json:"scopes"`
+	}
+	if err := gl.requestURL("GET", gl.BaseURL+"/api/v4/personal_access_tokens/self", nil, &token); err != nil {
+		v.add(validationWarning, subject, "token scopes not checked: %v", err)
+	} else if !slices.Contains(token.Scopes, "api") {
+		v.add(validationError, subject, "token scopes %v lack api, which reverts and MRs need", token.Scopes)
+	}
+	if !v.failed() {
+		v.add(validationOK, subject, "project %s reachable", info.PathWithNamespace)
+	}
+	return v
+}
+
+// routedProjects returns the projects the environment and the routing rules
+// send reverts to, by subject. Tokens in Secrets are read from the cluster;
+// without a client, or if a token can't be read, the rule is reported
+// instead.
+func (r *RollbackController) routedProjects(ctx context.Context, cfg *routingConfig) (map[string]gitlabProject, validationReport) {
+	var v validationReport
+	out := make(map[string]gitlabProject)
+	if r.GitlabProjectID != "" {
+		out["environment"] = r.defaultProject()
+	}
+	token := func(subject, secret string) (string, bool) {
+		if secret == "" {
+			return r.GitlabToken, true
+		}
+		if r.Client == nil {
+			v.add(validationWarning, subject, "token in Secret %s not checked; run with -cluster", secret)
+			return "", false
+		}
+		tok, err := r.secretToken(ctx, secret)
+		if err != nil {
+			v.add(validationError, subject, "token Secret %s: %v", secret, err)
+			return "", false
+		}
+		return tok, true
+	}
+	if cfg == nil {
+		return out, v
+	}
+	rules := cfg.Rules
+	if cfg.Default != nil {
+		rules = append([]routeRule{*cfg.Default}, rules...)
+	}
+	for i, rule := range rules {
+		subject := fmt.Sprintf("rules[%d]", i)
+		if cfg.Default != nil {
+			subject = fmt.Sprintf("rules[%d]", i-1)
+			if i == 0 {
+				subject = "default"
+			}
+		}
+		gl := r.bind(gitlabProject{BaseURL: r.GitlabBaseURL, ProjectID: r.GitlabProjectID, Provider: rule.Provider})
+		if rule.URL != "" {
+			gl.BaseURL = rule.URL
+		}
+		if rule.ProjectID != "" {
+			gl.ProjectID = rule.ProjectID
+		}
+		if gl.isGitLab() {
+			secret := rule.TokenSecret
+			if inst := cfg.instance(hostname(rule.URL)); secret == "" && rule.URL != "" && inst != nil {
+				secret = inst.TokenSecret
+			}
+			var ok bool
+			if gl.Token, ok = token(subject, secret); !ok {
+				continue
+			}
+		}
+		if proxy, secret := cfg.proxy(&rule); proxy != "" {
+			if secret != "" && r.Client == nil {
+				v.add(validationWarning, subject, "proxy credentials in Secret %s not checked; run with -cluster", secret)
+				continue
+			}
+			var err error
+			if gl.Proxy, err = r.proxyURL(ctx, proxy, secret); err != nil {
+				v.add(validationError, subject, "proxy: %v", err)
+				continue
+			}
+		}
+		out[subject] = gl
+	}
+	return out, v
+}
+
+// writeValidation writes the findings as a table or as JSON or YAML.
+func writeValidation(w io.Writer, v validationReport, format string) error {
+	switch format {
+	case "json", "yaml":
+		return writeStructured(w, v, format)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "LEVEL\tSUBJECT\tMESSAGE")
+		for _, f := range v {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Level, f.Subject, f.Message)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q (want table, json or yaml)", format)
+}
+
+// runValidate implements the `validate` subcommand: it checks routing rules
+// and RollbackPolicy manifests before they are deployed, and that the
+// providers they route to accept the configured tokens. It exits 1 on
+// errors, for CI.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	config := fs.String("config", "", "routing rules (rules.yaml or the ConfigMap manifest)")
+	policies := fs.String("policies", "", "RollbackPolicy manifests (YAML or JSON, multiple documents); - reads stdin")
+	offline := fs.Bool("offline", false, "skip the provider checks")
+	cluster := fs.Bool("cluster", false, "read the token Secrets of routing rules from the cluster (POD_NAMESPACE)")
+	output := fs.String("o", "table", "output format: table, json or yaml")
+	_ = fs.Parse(args)
+
+	var v validationReport
+	var cfg *routingConfig
+	if *config != "" {
+		data, err := os.ReadFile(*config)
+		if err == nil {
+			cfg, err = readRoutingConfig(data)
+		}
+		if err != nil {
+			v.add(validationError, *config, "%v", err)
+		} else {
+			v = append(v, validateRoutingConfig(cfg)...)
+		}
+	}
+	if *policies != "" {
+		data, err := os.ReadFile(*policies)
+		if *policies == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		}
+		var list []RollbackPolicy
+		if err == nil {
+			list, err = readPolicies(bytes.NewReader(data))
+		}
+		if err != nil {
+			v.add(validationError, *policies, "%v", err)
+		} else {
+			v = append(v, validatePolicies(list)...)
+		}
+	}
+	if !*offline {
+		var c client.Client
+		if *cluster {
+			restConfig, err := ctrl.GetConfig()
+			if err == nil {
+				c, err = client.New(restConfig, client.Options{Scheme: newScheme()})
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		rollback, err := controllerFromEnv(c, ctrl.Log.WithName("validate"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		projects, found := rollback.routedProjects(context.Background(), cfg)
+		v = append(v, found...)
+		subjects := make([]string, 0, len(projects))
+		for subject := range projects {
+			subjects = append(subjects, subject)
+		}
+		sort.Strings(subjects)
+		checked := make(map[string]bool)
+		for _, subject := range subjects {
+			gl := projects[subject]
+			// Rules sharing a project and token are checked once.
+			key := gl.Provider + " " + gl.url("") + " " + gl.credentialID()
+			if checked[key] {
+				continue
+			}
+			checked[key] = true
+			v = append(v, checkProvider(subject, gl)...)
+		}
+	}
+	if err := writeValidation(os.Stdout, v, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if v.failed() {
+		return 1
+	}
+	return 0
+}
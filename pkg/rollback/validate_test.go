@@ -0,0 +1,140 @@
+package rollback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateRoutingConfig(t *testing.T) {
+	cfg, err := readRoutingConfig([]byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: rollback-routing}
+data:
+  rules.yaml: |
+    default:
+      projectID: "1"
+    rules:
+      - match: {namespace: team-a}
+        projectID: "42"
+      - match: {namespace: team-a, name: web}
+        projectID: "43"
+      - match: {name: "[web"}
+        projectID: "44"
+      - match: {namespace: legacy}
+        provider: bitbucket
+        projectID: fleet
+    instances:
+      - url: gitlab.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range validateRoutingConfig(cfg) {
+		got = append(got, f.Level+" "+f.Subject)
+	}
+	want := []string{"warning rules[1]", "error rules[2]", "error rules[3]", "error instances[0]", "error instances[0]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings %v, want %v", got, want)
+	}
+
+	if _, err := readRoutingConfig([]byte("rules:\n  - match: {namespace: a}\n    projetID: \"42\"\n")); err == nil {
+		t.Error("misspelled projectID accepted")
+	}
+
+	cfg, err = readRoutingConfig([]byte(`
+rules:
+  - match: {namespace: a}
+    projectID: "1"
+    proxy: http://user:pw@egress:3128
+  - match: {namespace: b}
+    provider: ssh
+    url: ssh://git@git.corp/fleet.git
+    proxy: http://egress:3128
+  - match: {namespace: c}
+    projectID: "3"
+    proxySecret: egress
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, f := range validateRoutingConfig(cfg) {
+		got = append(got, f.Level+" "+f.Subject)
+	}
+	want = []string{"warning rules[0]", "error rules[1]", "error rules[2]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("proxy findings %v, want %v", got, want)
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	policies, err := readPolicies(strings.NewReader(`
+apiVersion: toolkit.fluxcd.io/v1beta1
+kind: RollbackPolicy
+metadata: {namespace: apps, name: web}
+spec:
+  targets: [{kind: Kustomization, name: web}]
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {namespace: apps, name: unrelated}
+---
+apiVersion: toolkit.fluxcd.io/v1beta1
+kind: RollbackPolicy
+metadata: {namespace: apps, name: team}
+spec:
+  targets: [{kind: Kustomization, namespace: apps, name: web}]
+---
+apiVersion: toolkit.fluxcd.io/v1beta1
+kind: RollbackPolicy
+metadata: {namespace: apps, name: broken}
+spec:
+  targets: [{kind: GitRepository, name: apps}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 3 {
+		t.Fatalf("read %d policies, want 3", len(policies))
+	}
+	report := validatePolicies(policies)
+	var got []string
+	for _, f := range report {
+		got = append(got, f.Level+" "+f.Subject)
+	}
+	want := []string{"ok policy apps/web", "error policy apps/team", "error policy apps/broken"}
+	if !reflect.DeepEqual(got, want) || !report.failed() {
+		t.Errorf("findings %v, want %v", got, want)
+	}
+}
+
+func TestCheckProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v4/projects/42":
+			_, _ = w.Write([]byte(`{"path_with_namespace":"team/fleet","permissions":{"project_access":{"access_level":20}}}`))
+		case "/api/v4/personal_access_tokens/self":
+			_, _ = w.Write([]byte(`{"scopes":["read_api"]}`))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	report := checkProvider("rules[0]", gitlabProject{BaseURL: srv.URL, ProjectID: "42", Token: "t"})
+	if len(report) != 2 || !strings.Contains(report[0].Message, "Developer") || !strings.Contains(report[1].Message, "lack api") {
+		t.Errorf("findings %+v, want the access level and the scopes rejected", report)
+	}
+	report = checkProvider("default", gitlabProject{BaseURL: srv.URL, ProjectID: "7", Token: "t"})
+	if len(report) != 1 || report[0].Level != validationError || !strings.Contains(report[0].Message, "was not found") {
+		t.Errorf("findings %+v, want the missing project", report)
+	}
+	if report := checkProvider("rules[1]", gitlabProject{Provider: providerGerrit}); report.failed() {
+		t.Errorf("Gerrit is not checked, got %+v", report)
+	}
+}
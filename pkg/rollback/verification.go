@@ -0,0 +1,216 @@
+package rollback
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultVerificationTimeout bounds the wait for the reverted resource to
+	// become Ready and the run of the verification Job.
+	defaultVerificationTimeout = 15 * time.Minute
+	// verificationPollInterval is how often a running Job is checked.
+	verificationPollInterval = 30 * time.Second
+	// verificationJobTTL deletes finished Jobs a day after they finished,
+	// unless the template sets ttlSecondsAfterFinished.
+	verificationJobTTL int32 = 24 * 60 * 60
+	// verifiedRevertLabel marks verification Jobs with the reverted SHA.
+	verifiedRevertLabel = "rollback.eumel8.io/verifies"
+)
+
+// Verification results.
+const (
+	verificationSucceeded = "succeeded"
+	verificationFailed    = "failed"
+	verificationSkipped   = "skipped" // the revert MR was closed unmerged
+)
+
+// verification tracks the verification of one revert of a resource.
+type verification struct {
+	Since  time.Time        // when the resource left the reverted revision
+	Job    client.ObjectKey // the launched Job; empty until launched
+	Result string           // empty while pending or running
+}
+
+// checkVerification runs the policy's verification once the latest revert of
+// res has landed: the revert MR, if any, is merged and the resource is Ready
+// on a new revision. It then launches the Job from the policy's template and
+// records its result as a verified or verificationFailed audit entry, also
+// sent as Event, CloudEvent and incident notification. A resource that does
+// not become Ready within the timeout fails the verification without a Job.
+// It returns when to check again, or 0.
+func (r *RollbackController) checkVerification(ctx context.Context, res resourceRef, sha string, ready bool, policy *RollbackPolicy) time.Duration {
+	spec := policy.verification()
+	if spec == nil || sha == "" {
+		return 0
+	}
+	r.mu.Lock()
+	var rec revertRecord
+	for i := len(r.reverts) - 1; i >= 0; i-- {
+		if r.reverts[i].Kind == res.Kind && r.reverts[i].Namespace == res.Namespace && r.reverts[i].Name == res.Name {
+			rec = r.reverts[i]
+			break
+		}
+	}
+	key := res.String() + "@" + rec.SHA
+	v := r.verifications[key]
+	r.mu.Unlock()
+	// Still on the reverted revision: the revert has not been applied yet.
+	if rec.SHA == "" || gitCommitSHA(rec.SHA) == gitCommitSHA(sha) || (v != nil && v.Result != "") {
+		return 0
+	}
+	log := r.log.WithValues("kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", rec.SHA)
+	timeout := spec.timeout()
+
+	if v == nil {
+		if rec.MRIID > 0 {
+			gl := r.project(ctx, res.Kind, res.Namespace, res.Name)
+			if gl.isGitLab() && !dryRun() {
+				mr, err := gl.mergeRequestState(rec.MRIID)
+				if err != nil {
+					log.Error(err, "failed to check revert MR before verification")
+					return verificationPollInterval
+				}
+				switch mr.State {
+				case "merged":
+				case "opened", "locked":
+					// Another commit was applied; the revert is still under review.
+					return 0
+				default:
+					r.finishVerification(res, rec.SHA, key, &verification{}, verificationSkipped, fmt.Sprintf("revert MR !%d was %s without merging", rec.MRIID, mr.State))
+					return 0
+				}
+			}
+		}
+		v = &verification{Since: r.clock.Now()}
+		r.mu.Lock()
+		r.verifications[key] = v
+		r.mu.Unlock()
+	}
+
+	if v.Job.Name == "" {
+		if !ready {
+			waited := r.clock.Since(v.Since)
+			if waited < timeout {
+				return r.capRequeue(timeout - waited)
+			}
+			r.finishVerification(res, rec.SHA, key, v, verificationFailed, fmt.Sprintf("not Ready on %s within %s of the revert landing", sha, timeout))
+			return 0
+		}
+		job, err := r.launchVerificationJob(ctx, res, rec.SHA, sha, policy)
+		if err != nil {
+			log.Error(err, "failed to launch verification Job")
+			return verificationPollInterval
+		}
+		if job == nil { // dry run
+			r.finishVerification(res, rec.SHA, key, v, verificationSkipped, "dry run; no verification Job launched")
+			return 0
+		}
+		r.mu.Lock()
+		v.Job = client.ObjectKeyFromObject(job)
+		r.mu.Unlock()
+		log.Info("Launched verification Job", "job", v.Job.String())
+		return verificationPollInterval
+	}
+
+	var job batchv1.Job
+	if err := r.reader().Get(ctx, v.Job, &job); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.finishVerification(res, rec.SHA, key, v, verificationFailed, fmt.Sprintf("Job %s was deleted before it finished", v.Job))
+			return 0
+		}
+		log.Error(err, "failed to get verification Job", "job", v.Job.String())
+		return verificationPollInterval
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			r.finishVerification(res, rec.SHA, key, v, verificationSucceeded, fmt.Sprintf("Job %s succeeded", v.Job))
+			return 0
+		case batchv1.JobFailed:
+			r.finishVerification(res, rec.SHA, key, v, verificationFailed, fmt.Sprintf("Job %s failed: %s %s", v.Job, c.Reason, c.Message))
+			return 0
+		}
+	}
+	return verificationPollInterval
+}
+
+// launchVerificationJob creates the verification Job in the policy's
+// namespace. Its containers get ROLLBACK_KIND, ROLLBACK_NAMESPACE,
+// ROLLBACK_NAME, ROLLBACK_REVERTED_REVISION and ROLLBACK_REVISION, the
+// revision now applied. It returns nil in dry-run mode.
+func (r *RollbackController) launchVerificationJob(ctx context.Context, res resourceRef, reverted, revision string, policy *RollbackPolicy) (*batchv1.Job, error) {
+	spec := policy.verification()
+	job := &batchv1.Job{
+		ObjectMeta: *spec.JobTemplate.ObjectMeta.DeepCopy(),
+		Spec:       *spec.JobTemplate.Spec.DeepCopy(),
+	}
+	job.Namespace = policy.Namespace
+	job.Name = ""
+	job.GenerateName = "rollback-verify-"
+	if sha := gitCommitSHA(reverted); fullSHA.MatchString(sha) {
+		// Chart pins track chart versions, which may not fit names and labels.
+		job.GenerateName += sha[:7] + "-"
+		if job.Labels == nil {
+			job.Labels = map[string]string{}
+		}
+		job.Labels[verifiedRevertLabel] = sha
+	}
+	if job.Spec.ActiveDeadlineSeconds == nil {
+		deadline := int64(spec.timeout() / time.Second)
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	if job.Spec.TTLSecondsAfterFinished == nil {
+		ttl := verificationJobTTL
+		job.Spec.TTLSecondsAfterFinished = &ttl
+	}
+	env := []corev1.EnvVar{
+		{Name: "ROLLBACK_KIND", Value: res.Kind},
+		{Name: "ROLLBACK_NAMESPACE", Value: res.Namespace},
+		{Name: "ROLLBACK_NAME", Value: res.Name},
+		{Name: "ROLLBACK_REVERTED_REVISION", Value: reverted},
+		{Name: "ROLLBACK_REVISION", Value: revision},
+	}
+	for i := range job.Spec.Template.Spec.Containers {
+		c := &job.Spec.Template.Spec.Containers[i]
+		c.Env = append(c.Env, env...)
+	}
+	if dryRun() {
+		r.log.Info("ECHO: would launch verification Job", "namespace", job.Namespace, "generateName", job.GenerateName, "sha", reverted)
+		return nil, nil
+	}
+	if err := r.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// finishVerification records the result of a verification.
+func (r *RollbackController) finishVerification(res resourceRef, sha, key string, v *verification, result, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v.Result = result
+	r.verifications[key] = v
+	event := auditVerified
+	switch result {
+	case verificationFailed:
+		event = auditVerificationFailed
+		r.log.Info("Rollback verification failed", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "reason", message)
+	case verificationSkipped:
+		r.log.Info("Rollback verification skipped", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "reason", message)
+		return
+	default:
+		r.log.Info("Rollback verified", "kind", res.Kind, "namespace", res.Namespace, "name", res.Name, "sha", sha, "result", message)
+	}
+	r.recordAudit(event, res.Kind, res.Namespace, res.Name, sha, message)
+	r.emitEvent(event, res.Kind, res.Namespace, res.Name, sha)
+}
@@ -0,0 +1,104 @@
+package rollback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckVerification(t *testing.T) {
+	const reverted = "main@sha1:0123456789abcdef0123456789abcdef01234567"
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	res := resourceRef{Kind: "Kustomization", Namespace: "flux-system", Name: "shop"}
+	policy := &RollbackPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "shop"},
+		Spec: RollbackPolicySpec{Verification: &VerificationSpec{
+			JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "smoke", Image: "smoke:1"}},
+			}}}},
+		}},
+	}
+	setup := func() (*RollbackController, client.Client, *clocktesting.FakeClock) {
+		c := fake.NewClientBuilder().WithScheme(newScheme()).Build()
+		r := NewRollbackController(c, logr.Discard(), "", "", "", "revert", 300)
+		clk := clocktesting.NewFakeClock(start)
+		r.setClock(clk)
+		r.reverts = []revertRecord{{Time: start, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name, SHA: reverted}}
+		return r, c, clk
+	}
+
+	t.Run("succeeded", func(t *testing.T) {
+		r, c, _ := setup()
+		if wait := r.checkVerification(ctx, res, reverted, false, policy); wait != 0 {
+			t.Errorf("still on the reverted revision: requeue %s", wait)
+		}
+		if wait := r.checkVerification(ctx, res, "main@sha1:fixed", false, policy); wait != defaultVerificationTimeout {
+			t.Errorf("waiting for Ready: requeue %s, want %s", wait, defaultVerificationTimeout)
+		}
+		if wait := r.checkVerification(ctx, res, "main@sha1:fixed", true, policy); wait != verificationPollInterval {
+			t.Errorf("Job launched: requeue %s, want %s", wait, verificationPollInterval)
+		}
+		var jobs batchv1.JobList
+		if err := c.List(ctx, &jobs, client.InNamespace("shop")); err != nil || len(jobs.Items) != 1 {
+			t.Fatalf("jobs = %+v, %v", jobs.Items, err)
+		}
+		job := jobs.Items[0]
+		if job.Labels[verifiedRevertLabel] != gitCommitSHA(reverted) || *job.Spec.ActiveDeadlineSeconds != 900 {
+			t.Errorf("job = %+v", job.ObjectMeta)
+		}
+		env := map[string]string{}
+		for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+			env[e.Name] = e.Value
+		}
+		if env["ROLLBACK_REVERTED_REVISION"] != reverted || env["ROLLBACK_REVISION"] != "main@sha1:fixed" || env["ROLLBACK_NAME"] != "shop" {
+			t.Errorf("env = %v", env)
+		}
+
+		if wait := r.checkVerification(ctx, res, "main@sha1:fixed", true, policy); wait != verificationPollInterval {
+			t.Errorf("Job running: requeue %s", wait)
+		}
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		if err := c.Status().Update(ctx, &job); err != nil {
+			t.Fatal(err)
+		}
+		if wait := r.checkVerification(ctx, res, "main@sha1:fixed", true, policy); wait != 0 {
+			t.Errorf("Job complete: requeue %s", wait)
+		}
+		if n := len(r.auditLog); n == 0 || r.auditLog[n-1].Event != auditVerified || r.auditLog[n-1].SHA != reverted {
+			t.Errorf("audit log = %+v", r.auditLog)
+		}
+		if incs := r.incidents.list(); len(incs) != 1 || incs[0].Verification != verificationSucceeded {
+			t.Errorf("incidents = %+v", incs)
+		}
+		// Verified once.
+		r.checkVerification(ctx, res, "main@sha1:fixed", true, policy)
+		if err := c.List(ctx, &jobs, client.InNamespace("shop")); err != nil || len(jobs.Items) != 1 {
+			t.Errorf("jobs after verification = %d, %v", len(jobs.Items), err)
+		}
+	})
+
+	t.Run("not Ready in time", func(t *testing.T) {
+		r, c, clk := setup()
+		r.checkVerification(ctx, res, "main@sha1:fixed", false, policy)
+		clk.Step(defaultVerificationTimeout)
+		if wait := r.checkVerification(ctx, res, "main@sha1:fixed", false, policy); wait != 0 {
+			t.Errorf("requeue %s after the timeout", wait)
+		}
+		if n := len(r.auditLog); n == 0 || r.auditLog[n-1].Event != auditVerificationFailed {
+			t.Errorf("audit log = %+v", r.auditLog)
+		}
+		var jobs batchv1.JobList
+		if err := c.List(ctx, &jobs); err != nil || len(jobs.Items) != 0 {
+			t.Errorf("no Job expected, got %d, %v", len(jobs.Items), err)
+		}
+	})
+}
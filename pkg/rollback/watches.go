@@ -0,0 +1,120 @@
+package rollback
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// dynamicWatchRetry is how often kinds whose API was not served are
+// checked again.
+const dynamicWatchRetry = time.Minute
+
+// dynamicWatches adds the watches of target kinds at runtime, so a kind that
+// is not served at startup, e.g. HelmRelease before the helm-controller is
+// installed, is watched once its CRD appears without a restart. Only the
+// kinds the reconciler handles and RollbackPolicy targets may name,
+// Kustomization and HelmRelease, are watched.
+type dynamicWatches struct {
+	// controller is the rollback controller; Watch starts the source right
+	// away once the controller runs.
+	controller interface{ Watch(source.Source) error }
+	mapper     meta.RESTMapper
+	// sources returns the sources to watch kind with.
+	sources func(kind string) []source.Source
+
+	mu      sync.Mutex
+	watched map[string]bool
+	pending map[string]bool
+	added   map[string]int // pending kind -> sources already watched
+}
+
+// newDynamicWatches returns dynamic watches for controller; watched are the
+// kinds its builder already watches.
+func newDynamicWatches(controller interface{ Watch(source.Source) error }, mapper meta.RESTMapper, sources func(kind string) []source.Source, watched ...string) *dynamicWatches {
+	w := &dynamicWatches{controller: controller, mapper: mapper, sources: sources,
+		watched: make(map[string]bool), pending: make(map[string]bool), added: make(map[string]int)}
+	for _, kind := range watched {
+		w.watched[kind] = true
+	}
+	return w
+}
+
+// ensureWatch watches kind unless it is watched already. A kind whose API is
+// not served, or whose sources could not all be watched, is kept pending and
+// retried by runDynamicWatches; a retry only adds the sources not watched yet.
+// Other kinds are ignored.
+func (r *RollbackController) ensureWatch(kind string) {
+	w := r.watches
+	if w == nil || (kind != "Kustomization" && kind != "HelmRelease") {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watched[kind] {
+		return
+	}
+	gvk := r.APIs.gvkFor(kind)
+	if _, err := w.mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		if !w.pending[kind] {
+			r.log.Info("Kind is not served, watching it once its CRD is installed", "kind", kind, "version", gvk.GroupVersion().String())
+		}
+		w.pending[kind] = true
+		return
+	}
+	for _, src := range w.sources(kind)[w.added[kind]:] {
+		if err := w.controller.Watch(src); err != nil {
+			r.log.Error(err, "failed to watch kind", "kind", kind)
+			w.pending[kind] = true
+			return
+		}
+		w.added[kind]++
+	}
+	delete(w.pending, kind)
+	delete(w.added, kind)
+	w.watched[kind] = true
+	r.log.Info("Watching kind", "kind", kind, "version", gvk.GroupVersion().String())
+}
+
+// watchPolicyTargets watches the kinds the targets of a RollbackPolicy
+// refer to.
+func (r *RollbackController) watchPolicyTargets(obj client.Object) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	targets, _, _ := unstructured.NestedSlice(u.Object, "spec", "targets")
+	for _, t := range targets {
+		if target, ok := t.(map[string]interface{}); ok {
+			kind, _, _ := unstructured.NestedString(target, "kind")
+			r.ensureWatch(kind)
+		}
+	}
+}
+
+// runDynamicWatches retries the pending kinds every dynamicWatchRetry.
+func (r *RollbackController) runDynamicWatches(ctx context.Context) error {
+	ticker := r.clock.NewTicker(dynamicWatchRetry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			r.watches.mu.Lock()
+			var kinds []string
+			for kind := range r.watches.pending {
+				kinds = append(kinds, kind)
+			}
+			r.watches.mu.Unlock()
+			for _, kind := range kinds {
+				r.ensureWatch(kind)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// fakeWatcher records the sources a controller was asked to watch; the
+// failOn-th call fails if set.
+type fakeWatcher struct {
+	sources []source.Source
+	calls   int
+	failOn  int
+}
+
+func (f *fakeWatcher) Watch(src source.Source) error {
+	f.calls++
+	if f.calls == f.failOn {
+		return errors.New("informer not synced")
+	}
+	f.sources = append(f.sources, src)
+	return nil
+}
+
+func TestDynamicWatches(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gaFluxAPIs.Kustomization.GroupVersion()})
+	mapper.Add(gaFluxAPIs.Kustomization, meta.RESTScopeNamespace)
+	watcher := &fakeWatcher{}
+	var watched []string
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	r.watches = newDynamicWatches(watcher, mapper, func(kind string) []source.Source {
+		watched = append(watched, kind)
+		noop := source.Func(func(context.Context, workqueue.TypedRateLimitingInterface[reconcile.Request]) error { return nil })
+		return []source.Source{noop, noop}
+	}, "Kustomization")
+
+	policy := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{
+		"targets": []interface{}{
+			map[string]interface{}{"kind": "Kustomization", "name": "apps"},
+			map[string]interface{}{"kind": "HelmRelease", "name": "web"},
+			map[string]interface{}{"kind": "Application", "name": "argo"},
+		},
+	}}}
+	r.policyConfigRequests(context.Background(), policy)
+	if len(watcher.sources) != 0 || !r.watches.pending["HelmRelease"] {
+		t.Fatalf("HelmRelease is not served: sources %d, pending %v", len(watcher.sources), r.watches.pending)
+	}
+
+	// The helm-controller CRDs are installed.
+	mapper.Add(gaFluxAPIs.HelmRelease, meta.RESTScopeNamespace)
+	r.policyConfigRequests(context.Background(), policy)
+	r.ensureWatch("HelmRelease")
+	if len(watched) != 1 || watched[0] != "HelmRelease" || len(watcher.sources) != 2 || len(r.watches.pending) != 0 {
+		t.Errorf("watched %v with %d sources, pending %v; want HelmRelease once", watched, len(watcher.sources), r.watches.pending)
+	}
+}
+
+func TestDynamicWatchesPartialFailure(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gaFluxAPIs.HelmRelease.GroupVersion()})
+	mapper.Add(gaFluxAPIs.HelmRelease, meta.RESTScopeNamespace)
+	// The first source is watched, the second fails once.
+	watcher := &fakeWatcher{failOn: 2}
+	r := NewRollbackController(nil, logr.Discard(), "", "", "", "revert", 300)
+	noop := source.Func(func(context.Context, workqueue.TypedRateLimitingInterface[reconcile.Request]) error { return nil })
+	r.watches = newDynamicWatches(watcher, mapper, func(string) []source.Source {
+		return []source.Source{noop, noop}
+	}, "Kustomization")
+
+	r.ensureWatch("HelmRelease")
+	if len(watcher.sources) != 1 || !r.watches.pending["HelmRelease"] || r.watches.added["HelmRelease"] != 1 {
+		t.Fatalf("after a failed second source: %d sources, pending %v, added %v", len(watcher.sources), r.watches.pending, r.watches.added)
+	}
+	r.ensureWatch("HelmRelease")
+	if len(watcher.sources) != 2 || watcher.calls != 3 || r.watches.pending["HelmRelease"] || !r.watches.watched["HelmRelease"] {
+		t.Errorf("after the retry: %d sources, pending %v, watched %v; want each source once", len(watcher.sources), r.watches.pending, r.watches.watched)
+	}
+}